@@ -23,7 +23,7 @@ const (
 	ProtocFileFormat = "protoc-23.4-linux-%s.zip"
 
 	ProtocGenGoVersion     = "v1.31.0"
-	ProtocGenGoGrpcVersion = "v1.1.0"
+	ProtocGenGoGrpcVersion = "v1.2.0"
 )
 
 var supportedPlatforms = map[dagger.Platform]map[string]string{
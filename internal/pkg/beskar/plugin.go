@@ -7,6 +7,7 @@ import (
 	"bytes"
 	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"net"
@@ -268,9 +269,26 @@ func (p *plugin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	result, err := p.router.Load().Decision(r, p.registry)
 	if err != nil {
-		dcontext.GetLogger(r.Context()).Errorf("%s router decision error: %s", p.name, err)
+		var builtinErr *router.BuiltinError
+
+		if errors.As(err, &builtinErr) {
+			dcontext.GetLogger(r.Context()).Errorf("%s router decision %s builtin error: %s", p.name, builtinErr.Builtin, err)
+		} else if errors.Is(err, context.Canceled) {
+			// the client went away
+			dcontext.GetLogger(r.Context()).Debugf("%s router decision cancelled: %s", p.name, err)
+		} else {
+			dcontext.GetLogger(r.Context()).Errorf("%s router decision error: %s", p.name, err)
+		}
 		w.WriteHeader(http.StatusInternalServerError)
 		return
+	} else if result.Audited {
+		dcontext.GetLogger(r.Context()).Warnf("%s router decision denied %s %s in %s mode: %s", p.name, r.Method, r.URL.Path, result.Mode, result.Deny)
+	}
+
+	if result.Denied() {
+		dcontext.GetLogger(r.Context()).Infof("%s router decision denied %s %s: %s", p.name, r.Method, r.URL.Path, result.Deny)
+		w.WriteHeader(http.StatusForbidden)
+		return
 	} else if !result.Found {
 		w.WriteHeader(http.StatusNotFound)
 		return
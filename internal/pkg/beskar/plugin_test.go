@@ -0,0 +1,78 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package beskar
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.ciq.dev/beskar/internal/pkg/router"
+	"go.ciq.dev/beskar/internal/pkg/router/registrytest"
+)
+
+func TestPluginServeHTTPDeny(t *testing.T) {
+	tests := []struct {
+		name     string
+		mode     router.Mode
+		output   string
+		expected int
+	}{
+		{
+			name:     "enforce deny",
+			mode:     router.ModeEnforce,
+			output:   `"deny": "unsigned image"`,
+			expected: http.StatusForbidden,
+		},
+		{
+			name:     "audit deny",
+			mode:     router.ModeAudit,
+			output:   `"deny": "unsigned image"`,
+			expected: http.StatusMovedPermanently,
+		},
+		{
+			name:     "policy audit override",
+			mode:     router.ModeEnforce,
+			output:   `"deny": "unsigned image", "mode": "audit"`,
+			expected: http.StatusMovedPermanently,
+		},
+		{
+			name:     "policy enforce override",
+			mode:     router.ModeAudit,
+			output:   `"deny": "unsigned image", "mode": "enforce"`,
+			expected: http.StatusForbidden,
+		},
+		{
+			name:     "allow",
+			mode:     router.ModeEnforce,
+			expected: http.StatusMovedPermanently,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr, err := router.New("static", `
+package router
+
+output := {
+	"found": true,
+	"redirect_url": "https://mirror.example.com/file",
+	`+tt.output+`
+}
+`, router.WithMode(tt.mode))
+			require.NoError(t, err)
+
+			p := &plugin{
+				name:     "static",
+				registry: registrytest.New(),
+			}
+			p.router.Store(rr)
+
+			w := httptest.NewRecorder()
+			p.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/artifacts/static/file/sha256:2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae", nil))
+			require.Equal(t, tt.expected, w.Code)
+		})
+	}
+}
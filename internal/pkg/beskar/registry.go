@@ -58,12 +58,14 @@ type Registry struct {
 	logger         dcontext.Logger
 	wait           sighandler.WaitFunc
 	hashedHostname string
+	sequences      *eventv1.SequenceGenerator
 }
 
 func New(beskarConfig *config.BeskarConfig) (context.Context, *Registry, error) {
 	beskarRegistry := &Registry{
 		beskarConfig: beskarConfig,
 		errCh:        make(chan error, 1),
+		sequences:    eventv1.NewSequenceGenerator(),
 	}
 
 	ctx, waitFunc := sighandler.New(beskarRegistry.errCh, syscall.SIGINT)
@@ -500,6 +502,8 @@ func (br *Registry) sendEvent(ctx context.Context, event *eventv1.EventPayload)
 			event.Origin = eventv1.Origin_ORIGIN_PLUGIN
 		}
 
+		br.sequences.Stamp(event)
+
 		return plugin.sendEvent(ctx, event, nil)
 	default:
 	}
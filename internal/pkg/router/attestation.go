@@ -0,0 +1,33 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/opencontainers/go-digest"
+)
+
+// attestationUnknown is the verdict returned when the attestation
+// service can't be queried or doesn't return a verdict.
+const attestationUnknown = "unknown"
+
+// attestationResponse is the response returned by attestation services.
+type attestationResponse struct {
+	Verdict string `json:"verdict"`
+}
+
+// queryAttestation queries the attestation service for the verdict about the
+// manifest digest of the reference, the service must reply with a JSON object
+// containing a verdict field. Any failure returns the unknown verdict.
+func queryAttestation(ctx context.Context, client *http.Client, serviceURL, ref string, dgst digest.Digest) string {
+	response := new(attestationResponse)
+	if err := getServiceJSON(ctx, client, serviceURL, ref, dgst, response); err != nil {
+		return attestationUnknown
+	} else if response.Verdict == "" {
+		return attestationUnknown
+	}
+	return response.Verdict
+}
@@ -0,0 +1,63 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueryAttestation(t *testing.T) {
+	dgst := digest.FromString("manifest")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/verified":
+			if r.URL.Query().Get("digest") != dgst.String() || r.URL.Query().Get("reference") != "library/alpine:3" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			_, _ = w.Write([]byte(`{"verdict": "verified"}`))
+		case "/empty":
+			_, _ = w.Write([]byte(`{}`))
+		case "/garbage":
+			_, _ = w.Write([]byte(`verified`))
+		case "/slow":
+			time.Sleep(200 * time.Millisecond)
+			_, _ = w.Write([]byte(`{"verdict": "verified"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	client := &http.Client{Timeout: 50 * time.Millisecond}
+
+	tests := []struct {
+		path    string
+		verdict string
+	}{
+		{path: "/verified", verdict: "verified"},
+		{path: "/empty", verdict: attestationUnknown},
+		{path: "/garbage", verdict: attestationUnknown},
+		{path: "/slow", verdict: attestationUnknown},
+		{path: "/missing", verdict: attestationUnknown},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.path, func(t *testing.T) {
+			verdict := queryAttestation(context.Background(), client, server.URL+tc.path, "library/alpine:3", dgst)
+			require.Equal(t, tc.verdict, verdict)
+		})
+	}
+
+	verdict := queryAttestation(context.Background(), client, "http://127.0.0.1:0/closed", "library/alpine:3", dgst)
+	require.Equal(t, attestationUnknown, verdict)
+}
@@ -0,0 +1,181 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/util"
+)
+
+const (
+	// defaultRequestBodyMaxSize is the default maximum size
+	// of the request body read by request.body.
+	defaultRequestBodyMaxSize = 1 << 20
+	// defaultRequestBodyBufferSize is the default initial size
+	// of the small buffers used to read request bodies.
+	defaultRequestBodyBufferSize = 8192
+	// largeBufferFactor is the size ratio between the large
+	// and the small request body buffers.
+	largeBufferFactor = 8
+	// maxPooledBufferFactor bounds the capacity of the large buffers
+	// returned to the pool relatively to their initial size, larger
+	// buffers are dropped to not retain memory of oversized bodies.
+	maxPooledBufferFactor = 4
+)
+
+var errRequestBodyTooLarge = errors.New("request body too large")
+
+// bufferPool is a tiered pool of buffers used to read request bodies,
+// bodies larger than the small buffers are read in large buffers.
+type bufferPool struct {
+	smallSize int
+	largeSize int
+	small     sync.Pool
+	large     sync.Pool
+}
+
+func newBufferPool(size int) *bufferPool {
+	p := &bufferPool{
+		smallSize: size,
+		largeSize: size * largeBufferFactor,
+	}
+	p.small.New = func() interface{} {
+		return bytes.NewBuffer(make([]byte, 0, p.smallSize))
+	}
+	p.large.New = func() interface{} {
+		return bytes.NewBuffer(make([]byte, 0, p.largeSize))
+	}
+	return p
+}
+
+// get returns a buffer right-sized for the content length,
+// an unknown content length is negative.
+func (p *bufferPool) get(contentLength int64) *bytes.Buffer {
+	if contentLength > int64(p.smallSize) {
+		return p.large.Get().(*bytes.Buffer)
+	}
+	return p.small.Get().(*bytes.Buffer)
+}
+
+// put returns the buffer to the pool matching its capacity.
+func (p *bufferPool) put(buf *bytes.Buffer) {
+	buf.Reset()
+
+	switch c := buf.Cap(); {
+	case c <= p.smallSize:
+		p.small.Put(buf)
+	case c <= p.largeSize*maxPooledBufferFactor:
+		p.large.Put(buf)
+	}
+}
+
+// readBody reads a body of at most maxSize bytes with a pooled
+// buffer and returns a copy of the body. A body exceeding maxSize
+// is rejected with errRequestBodyTooLarge along with the bytes read.
+func (p *bufferPool) readBody(body io.Reader, contentLength, maxSize int64) ([]byte, error) {
+	buf := p.get(contentLength)
+	defer p.put(buf)
+
+	// read one more byte to detect bodies exceeding the maximum size
+	n, err := buf.ReadFrom(io.LimitReader(body, maxSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("while reading request body: %w", err)
+	} else if n > maxSize {
+		return bytes.Clone(buf.Bytes()), fmt.Errorf("%w: more than %d bytes", errRequestBodyTooLarge, maxSize)
+	}
+
+	return bytes.Clone(buf.Bytes()), nil
+}
+
+// readBody reads the request body and restores it for the next readers,
+// the returned body is nil if the request has no body. The body is read
+// once per evaluation, subsequent calls return the same body.
+func (fctx *funcContext) readBody() ([]byte, error) {
+	if fctx.bodyRead {
+		return fctx.body, nil
+	}
+
+	req := fctx.req
+
+	if req.Body == nil || req.Body == http.NoBody {
+		fctx.bodyRead = true
+		return nil, nil
+	}
+
+	body, err := fctx.router.bodyBuffers.readBody(req.Body, req.ContentLength, fctx.router.requestBodyMaxSize)
+	if errors.Is(err, errRequestBodyTooLarge) {
+		// the request may still be served, put back the bytes read
+		req.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), req.Body))
+		return nil, err
+	} else if err != nil {
+		return nil, err
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+
+	fctx.body = body
+	fctx.bodyRead = true
+
+	return body, nil
+}
+
+// maybeJSONBody returns true if a body with the content type may be a JSON
+// document, bodies without content type may be JSON documents too.
+func maybeJSONBody(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// parseBody parses the body according to its content type: JSON, YAML and
+// form data are converted to rego values while other content types are
+// returned as a string. Form fields are returned as arrays of values.
+func parseBody(contentType string, body []byte) (ast.Value, error) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ast.String(body), nil
+	}
+
+	switch {
+	case mediaType == "application/json", strings.HasSuffix(mediaType, "+json"):
+		return ast.ValueFromReader(bytes.NewReader(body))
+	case mediaType == "application/yaml", mediaType == "application/x-yaml",
+		mediaType == "text/yaml", strings.HasSuffix(mediaType, "+yaml"):
+		var v interface{}
+		if err := util.Unmarshal(body, &v); err != nil {
+			return nil, fmt.Errorf("bad YAML body: %w", err)
+		}
+		return ast.InterfaceToValue(v)
+	case mediaType == "application/x-www-form-urlencoded":
+		values, err := url.ParseQuery(string(body))
+		if err != nil {
+			return nil, fmt.Errorf("bad form body: %w", err)
+		}
+		items := make([][2]*ast.Term, 0, len(values))
+		for key, fieldValues := range values {
+			terms := make([]*ast.Term, 0, len(fieldValues))
+			for _, value := range fieldValues {
+				terms = append(terms, ast.StringTerm(value))
+			}
+			items = append(items, ast.Item(ast.StringTerm(key), ast.ArrayTerm(terms...)))
+		}
+		return ast.NewObject(items...), nil
+	}
+
+	return ast.String(body), nil
+}
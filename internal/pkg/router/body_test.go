@@ -0,0 +1,125 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBufferPool(t *testing.T) {
+	pool := newBufferPool(1024)
+
+	tests := []struct {
+		name          string
+		size          int
+		contentLength int64
+		maxSize       int64
+		expectedErr   error
+	}{
+		{
+			name:          "small body",
+			size:          512,
+			contentLength: 512,
+			maxSize:       4096,
+		},
+		{
+			name:          "large body",
+			size:          2048,
+			contentLength: 2048,
+			maxSize:       4096,
+		},
+		{
+			name:          "unknown content length",
+			size:          2048,
+			contentLength: -1,
+			maxSize:       4096,
+		},
+		{
+			name:          "body exceeding maximum size",
+			size:          8192,
+			contentLength: 8192,
+			maxSize:       4096,
+			expectedErr:   errRequestBodyTooLarge,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := bytes.Repeat([]byte("a"), tt.size)
+
+			body, err := pool.readBody(bytes.NewReader(data), tt.contentLength, tt.maxSize)
+			if tt.expectedErr != nil {
+				require.ErrorIs(t, err, tt.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, data, body)
+		})
+	}
+
+	require.Equal(t, 1024, pool.get(1024).Cap())
+	require.Equal(t, 8192, pool.get(1025).Cap())
+}
+
+func TestFuncContextReadBody(t *testing.T) {
+	router, err := New("test", requestBodyModule)
+	require.NoError(t, err)
+
+	body := []byte(`{"name":"beskar"}`)
+
+	fctx := &funcContext{
+		req:    httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body)),
+		router: router,
+	}
+
+	first, err := fctx.readBody()
+	require.NoError(t, err)
+	require.Equal(t, body, first)
+
+	// the body is restored once and not read again
+	restored := fctx.req.Body
+
+	second, err := fctx.readBody()
+	require.NoError(t, err)
+	require.Equal(t, body, second)
+	require.True(t, restored == fctx.req.Body)
+
+	data, err := io.ReadAll(fctx.req.Body)
+	require.NoError(t, err)
+	require.Equal(t, body, data)
+}
+
+func BenchmarkReadBody(b *testing.B) {
+	for _, size := range []int{1 << 10, 64 << 10} {
+		data := bytes.Repeat([]byte("a"), size)
+
+		b.Run(fmt.Sprintf("unpooled-%dKB", size>>10), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				buf := new(bytes.Buffer)
+				if _, err := buf.ReadFrom(io.LimitReader(bytes.NewReader(data), defaultRequestBodyMaxSize+1)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+
+		b.Run(fmt.Sprintf("pooled-%dKB", size>>10), func(b *testing.B) {
+			pool := newBufferPool(defaultRequestBodyBufferSize)
+
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := pool.readBody(bytes.NewReader(data), int64(size), defaultRequestBodyMaxSize); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -5,16 +5,19 @@ package router
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
+	"log/slog"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/distribution/distribution/v3"
-	"github.com/distribution/distribution/v3/reference"
-	v1 "github.com/google/go-containerregistry/pkg/v1"
 	regtypes "github.com/google/go-containerregistry/pkg/v1/types"
 	"github.com/open-policy-agent/opa/ast"
 	"github.com/open-policy-agent/opa/rego"
@@ -23,12 +26,302 @@ import (
 
 var funcContextKey uint8
 
+// BuiltinError is returned by a routing decision when
+// a builtin failed and cancelled the policy evaluation.
+type BuiltinError struct {
+	// Builtin is the name of the failing builtin.
+	Builtin string
+	// Err is the error returned by the builtin.
+	Err error
+
+	location string
+}
+
+func (e *BuiltinError) Error() string {
+	return fmt.Sprintf("%s builtin eval %s error: %s", e.location, e.Builtin, e.Err)
+}
+
+func (e *BuiltinError) Unwrap() error {
+	return e.Err
+}
+
+// funcContext is the state of a policy evaluation shared by the builtins.
+// The builtins of an evaluation are serialized: getFuncContext acquires
+// the function context lock which is released by the deferred
+// cancelOnError, so the builtins can access the fields below, including
+// replacing the request body, without further synchronization even if
+// they are evaluated concurrently. Once the evaluation is done, the
+// builtin error must be retrieved with err.
 type funcContext struct {
+	mu sync.Mutex
+
 	req        *http.Request
 	registry   distribution.Namespace
-	builtinErr error
+	router     *RegoRouter
+	external   map[string]interface{}
+	builtinErr *BuiltinError
+
+	// query is the request query parsed on first use by request.query
+	query url.Values
+	// body is the request body read on first use by the request.body
+	// builtins and bodyValue is the value returned by request.body
+	body      []byte
+	bodyRead  bool
+	bodyValue ast.Value
+
+	registryCalls  int
+	layerScanBytes int64
+	// builtinStart is the start time of the builtin being evaluated
+	builtinStart time.Time
+	// builtinCancel releases the timeout of the builtin being evaluated
+	builtinCancel context.CancelFunc
+	// reference is the reference resolved by the builtin being evaluated
+	reference string
+
+	// tags and manifests memoize the tag and manifest lookups
+	// performed during the evaluation, they are keyed by reference.
+	tags      map[string]*distribution.Descriptor
+	manifests map[string]cachedManifest
+	// repositorySizes memoizes the sizes computed by oci.repository_size
+	repositorySizes map[string]int64
+	// signatures memoizes the signature verifications performed by
+	// oci.signature_verified, they are keyed by manifest and public key.
+	signatures map[string]bool
+}
+
+// builtins returns the custom builtins available to rego policies.
+func builtins() []RegoOption {
+	return []RegoOption{
+		ociBlobDigestBuiltin,
+		ociBlobDigestsBuiltin,
+		ociManifestDigestBuiltin,
+		ociHasRequiredReferrersBuiltin,
+		ociAllLayersPresentBuiltin,
+		ociImageSizeBuiltin,
+		ociIndexSizeBuiltin,
+		ociReferenceMatchesBuiltin,
+		ociCompressionRatioBuiltin,
+		ociSourceMatchesTargetBuiltin,
+		ociArchitectureCountBuiltin,
+		requestInMaintenanceWindowBuiltin,
+		requestUploadKindBuiltin,
+		ociHasDeprecatedTypesBuiltin,
+		inputHasFactBuiltin,
+		ociAttestationStatusBuiltin,
+		ociIsFloatingTagBuiltin,
+		ociManifestWithinLimitBuiltin,
+		ociRepoBlobsBuiltin,
+		ociHasHealthcheckBuiltin,
+		ociPlatformDigestBuiltin,
+		ociRepoIsNewBuiltin,
+		ociEmptyLayerCountBuiltin,
+		ociValidRootFSBuiltin,
+		ociSameConfigBuiltin,
+		ociRequiredCapabilitiesBuiltin,
+		ociHostAllowedBuiltin,
+		ociProvenanceAuthorsBuiltin,
+		ociRequiresSubjectBuiltin,
+		ociNewestLayerAgeDaysBuiltin,
+		ociSizeDeclarationValidBuiltin,
+		ociScanResultBuiltin,
+		ociExpectedPinnedTagBuiltin,
+		ociEntrypointPresentBuiltin,
+		ociIsReservedTagBuiltin,
+		ociRepoDedupRatioBuiltin,
+		ociHasDescriptionBuiltin,
+		ociIndexCreatedConsistentBuiltin,
+		ociTotalRepositoriesBuiltin,
+		ociArtifactTypeRegisteredBuiltin,
+		ociLayerFileDigestBuiltin,
+		ociTagListBuiltin,
+		requestHeaderBuiltin,
+		ociBlobExistsBuiltin,
+		ociConfigMediaTypeBuiltin,
+		ociIndexManifestsBuiltin,
+		ociAnnotationsBuiltin,
+		ociLayerSizesBuiltin,
+		ociReferrersBuiltin,
+		ociManifestMediaTypeBuiltin,
+		requestQueryBuiltin,
+		requestBodyParsedBuiltin,
+		ociPlatformListBuiltin,
+		ociTagExistsBuiltin,
+		ociConfigBlobBuiltin,
+		ociLayerCountBuiltin,
+		ociRepositorySizeBuiltin,
+		ociManifestRawBuiltin,
+		ociCreatedBeforeBuiltin,
+		ociSignatureVerifiedBuiltin,
+		ociDigestAlgorithmBuiltin,
+		ociManifestLabelsBuiltin,
+		ociBlobDigestByTitleBuiltin,
+		ociMetadataBuiltin,
+		ociLicenseAllowedBuiltin,
+		requestBodyBuiltin,
+		requestPathBuiltin,
+		requestMethodBuiltin,
+		requestRemoteAddrBuiltin,
+		requestRouteTemplateBuiltin,
+	}
+}
+
+// getFuncContext returns the locked function context attached to the
+// builtin context, the evaluation is cancelled if there is none. The
+// builtin context is bounded by the builtin timeout of the router.
+// Builtins must defer cancelOnError to release the lock and the timeout.
+func getFuncContext(bctx *rego.BuiltinContext) (*funcContext, error) {
+	funcContext, ok := bctx.Context.Value(&funcContextKey).(*funcContext)
+	if !ok {
+		bctx.Cancel.Cancel()
+		return nil, fmt.Errorf("bad context")
+	}
+	funcContext.mu.Lock()
+	funcContext.builtinStart = time.Now()
+	funcContext.reference = ""
+	if timeout := funcContext.router.builtinTimeout; timeout > 0 {
+		bctx.Context, funcContext.builtinCancel = context.WithTimeoutCause(
+			bctx.Context, timeout, fmt.Errorf("%w after %s", errBuiltinTimeout, timeout),
+		)
+	}
+	return funcContext, nil
+}
+
+// cancelOnError records the error returned by the named builtin and
+// cancels the evaluation, it's intended to be deferred by builtins and
+// releases the lock acquired by getFuncContext. Only the first builtin
+// error is recorded. The builtin evaluation is also recorded when metrics
+// are enabled and the error is logged when a logger is set.
+func (fctx *funcContext) cancelOnError(bctx rego.BuiltinContext, name string, errFn *error) {
+	defer fctx.mu.Unlock()
+
+	if fctx.builtinCancel != nil {
+		if *errFn != nil && errors.Is(context.Cause(bctx.Context), errBuiltinTimeout) {
+			// registry errors may not tell why the call was interrupted
+			*errFn = fmt.Errorf("%w: %w", context.Cause(bctx.Context), *errFn)
+		}
+		fctx.builtinCancel()
+		fctx.builtinCancel = nil
+	}
+
+	if fctx.router.metrics != nil {
+		fctx.router.metrics.observe(fctx.router.name, name, time.Since(fctx.builtinStart), *errFn)
+	}
+	if *errFn != nil {
+		if logger := fctx.router.logger; logger != nil {
+			level := slog.LevelError
+			if isUnknown(*errFn) {
+				level = slog.LevelDebug
+			}
+			logger.LogAttrs(
+				bctx.Context, level, "builtin error",
+				slog.String("router", fctx.router.name),
+				slog.String("builtin", name),
+				slog.String("reference", fctx.reference),
+				slog.String("error", (*errFn).Error()),
+			)
+		}
+		if fctx.builtinErr == nil {
+			fctx.builtinErr = &BuiltinError{
+				Builtin:  name,
+				Err:      *errFn,
+				location: fmt.Sprint(bctx.Location),
+			}
+		}
+		bctx.Cancel.Cancel()
+	}
+}
+
+// err returns the first error recorded by the builtins, if any.
+func (fctx *funcContext) err() error {
+	fctx.mu.Lock()
+	defer fctx.mu.Unlock()
+
+	if fctx.builtinErr == nil {
+		return nil
+	}
+	return fctx.builtinErr
+}
+
+// evalTime returns the time of the evaluation, it falls back
+// to the current time if the evaluation time isn't set.
+func evalTime(bctx rego.BuiltinContext) time.Time {
+	if bctx.Time != nil {
+		if n, ok := bctx.Time.Value.(ast.Number); ok {
+			if ns, ok := n.Int64(); ok {
+				return time.Unix(0, ns)
+			}
+		}
+	}
+	return time.Now()
+}
+
+// int64Term returns a number term from an int64.
+func int64Term(n int64) *ast.Term {
+	return ast.NumberTerm(json.Number(strconv.FormatInt(n, 10)))
+}
+
+// float64Term returns a number term from a float64.
+func float64Term(f float64) *ast.Term {
+	return ast.NumberTerm(json.Number(strconv.FormatFloat(f, 'f', -1, 64)))
+}
+
+// stringMapTerm returns an object term from a string map.
+func stringMapTerm(m map[string]string) *ast.Term {
+	items := make([][2]*ast.Term, 0, len(m))
+	for k, v := range m {
+		items = append(items, ast.Item(ast.StringTerm(k), ast.StringTerm(v)))
+	}
+	return ast.ObjectTerm(items...)
 }
 
+// decodeValue decodes a rego value into v the same way
+// encoding/json would decode its JSON representation.
+func decodeValue(value ast.Value, v interface{}) error {
+	i, err := ast.JSON(value)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(i)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// stringsFromTerm returns the strings contained by an array or a set term.
+func stringsFromTerm(term *ast.Term) ([]string, error) {
+	var values []string
+
+	appendFn := func(t *ast.Term) error {
+		s, ok := t.Value.(ast.String)
+		if !ok {
+			return fmt.Errorf("%s is not a string", t)
+		}
+		values = append(values, string(s))
+		return nil
+	}
+
+	switch v := term.Value.(type) {
+	case *ast.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := appendFn(v.Elem(i)); err != nil {
+				return nil, err
+			}
+		}
+	case ast.Set:
+		if err := v.Iter(appendFn); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%s is not an array or a set", term)
+	}
+
+	return values, nil
+}
+
+// ociBlobDigestBuiltin returns the digest of the first image layer matching an
+// annotation or a media type, for an index the layers of the linux/amd64 image
+// manifest are searched, see oci.index_manifests to inspect other platforms.
 var ociBlobDigestBuiltin = rego.Function3(
 	&rego.Function{
 		Name:             "oci.blob_digest",
@@ -36,18 +329,11 @@ var ociBlobDigestBuiltin = rego.Function3(
 		Nondeterministic: true,
 	},
 	func(bctx rego.BuiltinContext, a, b, c *ast.Term) (term *ast.Term, errFn error) {
-		funcContext, ok := bctx.Context.Value(&funcContextKey).(*funcContext)
-		if !ok {
-			bctx.Cancel.Cancel()
-			return nil, fmt.Errorf("bad context")
+		funcContext, err := getFuncContext(&bctx)
+		if err != nil {
+			return nil, err
 		}
-
-		defer func() {
-			if errFn != nil {
-				funcContext.builtinErr = fmt.Errorf("%s builtin eval oci.blob_digest error: %w", bctx.Location, errFn)
-				bctx.Cancel.Cancel()
-			}
-		}()
+		defer funcContext.cancelOnError(bctx, "oci.blob_digest", &errFn)
 
 		astRef, ok := a.Value.(ast.String)
 		if !ok {
@@ -62,44 +348,18 @@ var ociBlobDigestBuiltin = rego.Function3(
 			return nil, fmt.Errorf("oci search value is not a string")
 		}
 
-		ref := string(astRef)
-
-		tagIndex := strings.LastIndexByte(ref, ':')
-		if tagIndex < 0 {
-			return nil, fmt.Errorf("reference without tag")
-		}
-		namedRef, err := reference.WithName(ref[:tagIndex])
-		if err != nil {
-			return nil, fmt.Errorf("bad reference name")
-		}
-
-		repository, err := funcContext.registry.Repository(bctx.Context, namedRef)
-		if err != nil {
-			return nil, fmt.Errorf("while getting repository %s: %w", namedRef, err)
-		}
-		tagDesc, err := repository.Tags(bctx.Context).Get(bctx.Context, ref[tagIndex+1:])
-		if err != nil {
-			var tagUnknown distribution.ErrTagUnknown
-			if errors.As(err, &tagUnknown) {
-				return ast.StringTerm(""), nil
-			}
-			return nil, fmt.Errorf("while getting tag %s: %w", ref[tagIndex+1:], err)
-		}
-		manifestService, err := repository.Manifests(bctx.Context)
-		if err != nil {
-			return nil, fmt.Errorf("while getting manifest service for %s: %w", namedRef, err)
-		}
-		registryManifest, err := manifestService.Get(bctx.Context, tagDesc.Digest)
-		if err != nil {
-			return nil, fmt.Errorf("while getting manifest for %s: %w", namedRef, err)
-		}
-		_, manifestPayload, err := registryManifest.Payload()
+		repository, tagDesc, err := funcContext.resolveTag(bctx.Context, string(astRef))
 		if err != nil {
 			return nil, err
+		} else if tagDesc == nil {
+			return ast.StringTerm(""), nil
 		}
-		manifest := new(v1.Manifest)
-		if err := json.Unmarshal(manifestPayload, manifest); err != nil {
+		// indexes are resolved to their linux/amd64 image manifest
+		manifest, err := funcContext.getPlatformManifest(bctx.Context, repository, tagDesc.Digest, &defaultPlatform)
+		if err != nil {
 			return nil, err
+		} else if manifest == nil {
+			return ast.StringTerm(""), nil
 		}
 
 		switch astSearchType {
@@ -128,6 +388,87 @@ var ociBlobDigestBuiltin = rego.Function3(
 	},
 )
 
+// ociBlobDigestsBuiltin returns the digests of all the image layers matching
+// a media type in layer order, indexes are resolved like oci.blob_digest.
+var ociBlobDigestsBuiltin = rego.Function2(
+	&rego.Function{
+		Name:             "oci.blob_digests",
+		Decl:             types.NewFunction(types.Args(types.S, types.S), types.NewArray(nil, types.S)),
+		Nondeterministic: true,
+	},
+	func(bctx rego.BuiltinContext, a, b *ast.Term) (_ *ast.Term, errFn error) {
+		funcContext, err := getFuncContext(&bctx)
+		if err != nil {
+			return nil, err
+		}
+		defer funcContext.cancelOnError(bctx, "oci.blob_digests", &errFn)
+
+		astRef, ok := a.Value.(ast.String)
+		if !ok {
+			return nil, fmt.Errorf("oci reference is not a string")
+		}
+		astMediaType, ok := b.Value.(ast.String)
+		if !ok {
+			return nil, fmt.Errorf("oci media type is not a string")
+		}
+
+		repository, tagDesc, err := funcContext.resolveTag(bctx.Context, string(astRef))
+		if err != nil {
+			return nil, err
+		} else if tagDesc == nil {
+			return ast.ArrayTerm(), nil
+		}
+		manifest, err := funcContext.getPlatformManifest(bctx.Context, repository, tagDesc.Digest, &defaultPlatform)
+		if err != nil {
+			return nil, err
+		} else if manifest == nil {
+			return ast.ArrayTerm(), nil
+		}
+
+		var terms []*ast.Term
+
+		mediaType := regtypes.MediaType(astMediaType)
+		for _, layer := range manifest.Layers {
+			if layer.MediaType == mediaType {
+				terms = append(terms, ast.StringTerm(layer.Digest.Hex))
+			}
+		}
+
+		return ast.ArrayTerm(terms...), nil
+	},
+)
+
+// ociManifestDigestBuiltin returns the hex encoded digest of the manifest
+// referenced by a tag, it returns an empty string if the tag is unknown.
+var ociManifestDigestBuiltin = rego.Function1(
+	&rego.Function{
+		Name:             "oci.manifest_digest",
+		Decl:             types.NewFunction(types.Args(types.S), types.S),
+		Nondeterministic: true,
+	},
+	func(bctx rego.BuiltinContext, a *ast.Term) (_ *ast.Term, errFn error) {
+		funcContext, err := getFuncContext(&bctx)
+		if err != nil {
+			return nil, err
+		}
+		defer funcContext.cancelOnError(bctx, "oci.manifest_digest", &errFn)
+
+		astRef, ok := a.Value.(ast.String)
+		if !ok {
+			return nil, fmt.Errorf("oci reference is not a string")
+		}
+
+		_, tagDesc, err := funcContext.resolveTag(bctx.Context, string(astRef))
+		if err != nil {
+			return nil, err
+		} else if tagDesc == nil {
+			return ast.StringTerm(""), nil
+		}
+
+		return ast.StringTerm(tagDesc.Digest.Hex()), nil
+	},
+)
+
 var requestBodyBuiltin = rego.FunctionDyn(
 	&rego.Function{
 		Name:             "request.body",
@@ -135,43 +476,78 @@ var requestBodyBuiltin = rego.FunctionDyn(
 		Nondeterministic: true,
 	},
 	func(bctx rego.BuiltinContext, _ []*ast.Term) (_ *ast.Term, errFn error) {
-		funcContext, ok := bctx.Context.Value(&funcContextKey).(*funcContext)
-		if !ok {
-			bctx.Cancel.Cancel()
-			return nil, fmt.Errorf("bad context")
+		funcContext, err := getFuncContext(&bctx)
+		if err != nil {
+			return nil, err
 		}
+		defer funcContext.cancelOnError(bctx, "request.body", &errFn)
 
-		defer func() {
-			if errFn != nil {
-				funcContext.builtinErr = fmt.Errorf("%s builtin eval request.body error: %w", bctx.Location, errFn)
-				bctx.Cancel.Cancel()
-			}
-		}()
+		if funcContext.bodyValue != nil {
+			return ast.NewTerm(funcContext.bodyValue), nil
+		}
 
-		if funcContext.req.Body != nil && funcContext.req.Body != http.NoBody {
-			buf := make([]byte, 8192)
+		// binary uploads like blob layers are neither read nor parsed
+		contentType := funcContext.req.Header.Get("Content-Type")
+		if !maybeJSONBody(contentType) {
+			funcContext.bodyValue = ast.Null{}
+			return ast.NullTerm(), nil
+		}
 
-			n, err := io.ReadAtLeast(funcContext.req.Body, buf, 1)
-			if err != nil {
-				return nil, fmt.Errorf("empty body request")
+		body, err := funcContext.readBody()
+		if err != nil {
+			// bodies without content type are more likely binary
+			// uploads than oversized JSON documents
+			if contentType == "" && errors.Is(err, errRequestBodyTooLarge) {
+				funcContext.bodyValue = ast.Null{}
+				return ast.NullTerm(), nil
 			}
+			return nil, err
+		} else if body != nil && len(body) == 0 {
+			if contentType == "" {
+				funcContext.bodyValue = ast.Null{}
+				return ast.NullTerm(), nil
+			}
+			return nil, fmt.Errorf("empty body request")
+		}
 
-			bodyReader := bytes.NewReader(buf[:n])
-
-			v, err := ast.ValueFromReader(bodyReader)
-			if err != nil {
-				return nil, err
+		var v ast.Value = ast.Null{}
+		if body != nil {
+			// bodies which aren't JSON documents are returned as null
+			if parsed, err := ast.ValueFromReader(bytes.NewReader(body)); err == nil {
+				v = parsed
 			}
+		}
+		funcContext.bodyValue = v
 
-			_, _ = bodyReader.Seek(0, io.SeekStart)
+		return ast.NewTerm(v), nil
+	},
+)
 
-			funcContext.req.Body = io.NopCloser(bodyReader)
+var requestBodyParsedBuiltin = rego.FunctionDyn(
+	&rego.Function{
+		Name:             "request.body_parsed",
+		Decl:             types.NewFunction(types.Args(), types.A),
+		Nondeterministic: true,
+	},
+	func(bctx rego.BuiltinContext, _ []*ast.Term) (_ *ast.Term, errFn error) {
+		funcContext, err := getFuncContext(&bctx)
+		if err != nil {
+			return nil, err
+		}
+		defer funcContext.cancelOnError(bctx, "request.body_parsed", &errFn)
 
-			return ast.NewTerm(v), nil
+		body, err := funcContext.readBody()
+		if err != nil {
+			return nil, err
+		} else if len(body) == 0 {
+			return ast.NullTerm(), nil
 		}
 
-		v, err := ast.InterfaceToValue(nil)
+		v, err := parseBody(funcContext.req.Header.Get("Content-Type"), body)
+		if err != nil {
+			return nil, err
+		}
 
-		return ast.NewTerm(v), err
+		return ast.NewTerm(v), nil
 	},
 )
@@ -0,0 +1,53 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"fmt"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/rego"
+	"github.com/open-policy-agent/opa/types"
+)
+
+// isEmptyFact returns true if an external fact is null or empty.
+func isEmptyFact(v interface{}) bool {
+	switch fact := v.(type) {
+	case nil:
+		return true
+	case string:
+		return fact == ""
+	case []interface{}:
+		return len(fact) == 0
+	case map[string]interface{}:
+		return len(fact) == 0
+	}
+	return false
+}
+
+// inputHasFactBuiltin returns true if the external input has a fact
+// for the key which is neither null nor empty.
+var inputHasFactBuiltin = rego.Function1(
+	&rego.Function{
+		Name:             "input.has_fact",
+		Decl:             types.NewFunction(types.Args(types.S), types.B),
+		Nondeterministic: true,
+	},
+	func(bctx rego.BuiltinContext, a *ast.Term) (_ *ast.Term, errFn error) {
+		funcContext, err := getFuncContext(&bctx)
+		if err != nil {
+			return nil, err
+		}
+		defer funcContext.cancelOnError(bctx, "input.has_fact", &errFn)
+
+		key, ok := a.Value.(ast.String)
+		if !ok {
+			return nil, fmt.Errorf("fact key is not a string")
+		}
+
+		fact, ok := funcContext.external[string(key)]
+
+		return ast.BooleanTerm(ok && !isEmptyFact(fact)), nil
+	},
+)
@@ -0,0 +1,2684 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/reference"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	regtypes "github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/rego"
+	"github.com/open-policy-agent/opa/types"
+	"github.com/opencontainers/go-digest"
+)
+
+var (
+	stringCollection = types.NewAny(types.NewArray(nil, types.S), types.NewSet(types.S))
+	anyObject        = types.NewObject(nil, types.NewDynamicProperty(types.S, types.A))
+	stringObject     = types.NewObject(nil, types.NewDynamicProperty(types.S, types.S))
+)
+
+// ociHasRequiredReferrersBuiltin returns true if an image has referrers of all the
+// required artifact types, it returns false if the tag is unknown.
+var ociHasRequiredReferrersBuiltin = rego.Function2(
+	&rego.Function{
+		Name:             "oci.has_required_referrers",
+		Decl:             types.NewFunction(types.Args(types.S, stringCollection), types.B),
+		Nondeterministic: true,
+	},
+	func(bctx rego.BuiltinContext, a, b *ast.Term) (_ *ast.Term, errFn error) {
+		funcContext, err := getFuncContext(&bctx)
+		if err != nil {
+			return nil, err
+		}
+		defer funcContext.cancelOnError(bctx, "oci.has_required_referrers", &errFn)
+
+		astRef, ok := a.Value.(ast.String)
+		if !ok {
+			return nil, fmt.Errorf("oci reference is not a string")
+		}
+		requiredTypes, err := stringsFromTerm(b)
+		if err != nil {
+			return nil, fmt.Errorf("bad required artifact types: %w", err)
+		}
+
+		repository, tagDesc, err := funcContext.resolveTag(bctx.Context, string(astRef))
+		if err != nil {
+			return nil, err
+		} else if tagDesc == nil {
+			return ast.BooleanTerm(false), nil
+		}
+
+		referrers, err := funcContext.getReferrers(bctx.Context, repository, tagDesc.Digest, "")
+		if err != nil {
+			return nil, err
+		}
+
+		artifactTypes := make(map[string]struct{}, len(referrers))
+		for _, referrer := range referrers {
+			artifactTypes[referrer.ArtifactType] = struct{}{}
+		}
+		for _, requiredType := range requiredTypes {
+			if _, ok := artifactTypes[requiredType]; !ok {
+				return ast.BooleanTerm(false), nil
+			}
+		}
+
+		return ast.BooleanTerm(true), nil
+	},
+)
+
+// ociAllLayersPresentBuiltin returns true if the config and layer blobs of an image
+// reference or of a manifest object exist, like oci.blob_digest an index is resolved
+// to its linux/amd64 image manifest. It returns false if the tag is unknown.
+var ociAllLayersPresentBuiltin = rego.Function1(
+	&rego.Function{
+		Name:             "oci.all_layers_present",
+		Decl:             types.NewFunction(types.Args(types.NewAny(types.S, anyObject)), types.B),
+		Nondeterministic: true,
+	},
+	func(bctx rego.BuiltinContext, a *ast.Term) (_ *ast.Term, errFn error) {
+		funcContext, err := getFuncContext(&bctx)
+		if err != nil {
+			return nil, err
+		}
+		defer funcContext.cancelOnError(bctx, "oci.all_layers_present", &errFn)
+
+		var (
+			statter  distribution.BlobStatter
+			manifest *ociManifest
+		)
+
+		switch v := a.Value.(type) {
+		case ast.String:
+			repository, tagDesc, err := funcContext.resolveTag(bctx.Context, string(v))
+			if err != nil {
+				return nil, err
+			} else if tagDesc == nil {
+				return ast.BooleanTerm(false), nil
+			}
+			manifest, err = funcContext.getPlatformManifest(bctx.Context, repository, tagDesc.Digest, &defaultPlatform)
+			if err != nil {
+				return nil, err
+			} else if manifest == nil {
+				return ast.BooleanTerm(false), nil
+			}
+			statter = repository.Blobs(bctx.Context)
+		case ast.Object:
+			manifest, err = manifestFromObject(v)
+			if err != nil {
+				return nil, err
+			}
+			// blobs are looked up in the repository targeted by the request
+			// and fallback to the whole registry otherwise
+			statter = funcContext.registry.BlobStatter()
+			if repository, err := funcContext.requestRepository(bctx.Context); err != nil {
+				return nil, err
+			} else if repository != nil {
+				statter = repository.Blobs(bctx.Context)
+			}
+		default:
+			return nil, fmt.Errorf("oci reference is not a string or a manifest object")
+		}
+
+		digests := make([]digest.Digest, 0, len(manifest.Layers)+1)
+		// artifact manifests may not reference a config blob
+		if manifest.Config.Digest != (v1.Hash{}) {
+			digests = append(digests, digest.Digest(manifest.Config.Digest.String()))
+		}
+		for _, layer := range manifest.Layers {
+			digests = append(digests, digest.Digest(layer.Digest.String()))
+		}
+
+		for _, dgst := range digests {
+			exists, err := funcContext.blobExists(bctx.Context, statter, dgst)
+			if err != nil {
+				return nil, err
+			} else if !exists {
+				return ast.BooleanTerm(false), nil
+			}
+		}
+
+		return ast.BooleanTerm(true), nil
+	},
+)
+
+// manifestFromObject converts a rego object into a manifest.
+func manifestFromObject(obj ast.Object) (*ociManifest, error) {
+	manifest := new(ociManifest)
+	if err := decodeValue(obj, manifest); err != nil {
+		return nil, fmt.Errorf("bad manifest object: %w", err)
+	}
+	return manifest, nil
+}
+
+// ociImageSizeBuiltin returns the size of an image manifest, its config and its
+// layers, like oci.blob_digest an index is resolved to its linux/amd64 image
+// manifest. It returns 0 if the tag is unknown.
+var ociImageSizeBuiltin = rego.Function1(
+	&rego.Function{
+		Name:             "oci.image_size",
+		Decl:             types.NewFunction(types.Args(types.S), types.N),
+		Nondeterministic: true,
+	},
+	func(bctx rego.BuiltinContext, a *ast.Term) (_ *ast.Term, errFn error) {
+		funcContext, err := getFuncContext(&bctx)
+		if err != nil {
+			return nil, err
+		}
+		defer funcContext.cancelOnError(bctx, "oci.image_size", &errFn)
+
+		astRef, ok := a.Value.(ast.String)
+		if !ok {
+			return nil, fmt.Errorf("oci reference is not a string")
+		}
+
+		repository, tagDesc, err := funcContext.resolveTag(bctx.Context, string(astRef))
+		if err != nil {
+			return nil, err
+		} else if tagDesc == nil {
+			return ast.IntNumberTerm(0), nil
+		}
+		size, err := funcContext.getImageSize(bctx.Context, repository, tagDesc.Digest)
+		if err != nil {
+			return nil, err
+		}
+
+		return int64Term(size), nil
+	},
+)
+
+// ociIndexSizeBuiltin returns the size of an index, its child manifests and their
+// blobs, shared blobs are accounted once. It returns the same size as oci.image_size
+// for an image manifest and 0 if the tag is unknown.
+var ociIndexSizeBuiltin = rego.Function1(
+	&rego.Function{
+		Name:             "oci.index_size",
+		Decl:             types.NewFunction(types.Args(types.S), types.N),
+		Nondeterministic: true,
+	},
+	func(bctx rego.BuiltinContext, a *ast.Term) (_ *ast.Term, errFn error) {
+		funcContext, err := getFuncContext(&bctx)
+		if err != nil {
+			return nil, err
+		}
+		defer funcContext.cancelOnError(bctx, "oci.index_size", &errFn)
+
+		astRef, ok := a.Value.(ast.String)
+		if !ok {
+			return nil, fmt.Errorf("oci reference is not a string")
+		}
+
+		repository, tagDesc, err := funcContext.resolveTag(bctx.Context, string(astRef))
+		if err != nil {
+			return nil, err
+		} else if tagDesc == nil {
+			return ast.IntNumberTerm(0), nil
+		}
+		mediaType, payload, err := funcContext.getManifestPayload(bctx.Context, repository, tagDesc.Digest)
+		if err != nil {
+			return nil, err
+		}
+
+		if !isIndexMediaType(mediaType) {
+			// plain manifest, same as oci.image_size
+			manifest := new(ociManifest)
+			if err := json.Unmarshal(payload, manifest); err != nil {
+				return nil, err
+			}
+			return int64Term(imageSize(int64(len(payload)), manifest)), nil
+		}
+
+		size, err := funcContext.getIndexSize(bctx.Context, repository, payload)
+		if err != nil {
+			return nil, err
+		}
+
+		return int64Term(size), nil
+	},
+)
+
+// ociReferenceMatchesBuiltin returns true if the repository name of a reference
+// matches the glob patterns, the last matching pattern wins.
+var ociReferenceMatchesBuiltin = rego.Function2(
+	&rego.Function{
+		Name: "oci.reference_matches",
+		Decl: types.NewFunction(types.Args(types.S, stringCollection), types.B),
+	},
+	func(bctx rego.BuiltinContext, a, b *ast.Term) (_ *ast.Term, errFn error) {
+		funcContext, err := getFuncContext(&bctx)
+		if err != nil {
+			return nil, err
+		}
+		defer funcContext.cancelOnError(bctx, "oci.reference_matches", &errFn)
+
+		astRef, ok := a.Value.(ast.String)
+		if !ok {
+			return nil, fmt.Errorf("oci reference is not a string")
+		}
+		patterns, err := stringsFromTerm(b)
+		if err != nil {
+			return nil, fmt.Errorf("bad patterns: %w", err)
+		}
+
+		ref, err := reference.Parse(string(astRef))
+		if err != nil {
+			return nil, fmt.Errorf("bad reference %s: %w", astRef, err)
+		}
+		named, ok := ref.(reference.Named)
+		if !ok {
+			return nil, fmt.Errorf("reference %s without name", astRef)
+		}
+
+		// patterns are evaluated in order and the last matching one wins
+		matched, err := matchGlobs(named.Name(), patterns)
+		if err != nil {
+			return nil, fmt.Errorf("bad patterns: %w", err)
+		}
+
+		return ast.BooleanTerm(matched), nil
+	},
+)
+
+// ociCompressionRatioBuiltin returns the ratio between the compressed and the
+// uncompressed size of the image layers, like oci.blob_digest an index is resolved
+// to its linux/amd64 image manifest. It returns -1 if the uncompressed size of a
+// layer is unknown, see getUncompressedLayerSize for the 4GiB limit of gzip layers.
+var ociCompressionRatioBuiltin = rego.Function1(
+	&rego.Function{
+		Name:             "oci.compression_ratio",
+		Decl:             types.NewFunction(types.Args(types.S), types.N),
+		Nondeterministic: true,
+	},
+	func(bctx rego.BuiltinContext, a *ast.Term) (_ *ast.Term, errFn error) {
+		funcContext, err := getFuncContext(&bctx)
+		if err != nil {
+			return nil, err
+		}
+		defer funcContext.cancelOnError(bctx, "oci.compression_ratio", &errFn)
+
+		astRef, ok := a.Value.(ast.String)
+		if !ok {
+			return nil, fmt.Errorf("oci reference is not a string")
+		}
+
+		repository, tagDesc, err := funcContext.resolveTag(bctx.Context, string(astRef))
+		if err != nil {
+			return nil, err
+		} else if tagDesc == nil {
+			return ast.IntNumberTerm(-1), nil
+		}
+		manifest, err := funcContext.getPlatformManifest(bctx.Context, repository, tagDesc.Digest, &defaultPlatform)
+		if err != nil {
+			return nil, err
+		} else if manifest == nil {
+			return ast.IntNumberTerm(-1), nil
+		}
+
+		compressedSize, uncompressedSize := int64(0), int64(0)
+
+		for _, layer := range manifest.Layers {
+			size, ok, err := funcContext.getUncompressedLayerSize(bctx.Context, repository, layer)
+			if err != nil {
+				return nil, err
+			} else if !ok {
+				return ast.IntNumberTerm(-1), nil
+			}
+			compressedSize += layer.Size
+			uncompressedSize += size
+		}
+
+		if uncompressedSize == 0 {
+			return ast.IntNumberTerm(-1), nil
+		}
+
+		return float64Term(float64(compressedSize) / float64(uncompressedSize)), nil
+	},
+)
+
+// ociSourceMatchesTargetBuiltin returns true if the source repository annotation of
+// an image maps to the repository targeted by the request, or to the reference
+// repository outside of registry requests. It returns false if the tag is unknown.
+var ociSourceMatchesTargetBuiltin = rego.Function1(
+	&rego.Function{
+		Name:             "oci.source_matches_target",
+		Decl:             types.NewFunction(types.Args(types.S), types.B),
+		Nondeterministic: true,
+	},
+	func(bctx rego.BuiltinContext, a *ast.Term) (_ *ast.Term, errFn error) {
+		funcContext, err := getFuncContext(&bctx)
+		if err != nil {
+			return nil, err
+		}
+		defer funcContext.cancelOnError(bctx, "oci.source_matches_target", &errFn)
+
+		astRef, ok := a.Value.(ast.String)
+		if !ok {
+			return nil, fmt.Errorf("oci reference is not a string")
+		}
+
+		repository, tagDesc, err := funcContext.resolveTag(bctx.Context, string(astRef))
+		if err != nil {
+			return nil, err
+		} else if tagDesc == nil {
+			return ast.BooleanTerm(false), nil
+		}
+		manifest, err := funcContext.getManifest(bctx.Context, repository, tagDesc.Digest)
+		if err != nil {
+			return nil, err
+		}
+
+		// the target is the repository pushed to, or the
+		// reference repository outside of registry requests
+		target := funcContext.requestRepositoryName()
+		if target == "" {
+			target = repository.Named().Name()
+		}
+
+		matched := sourceMatchesTarget(manifest.Annotations[sourceAnnotation], target, funcContext.router.sourceMappings)
+
+		return ast.BooleanTerm(matched), nil
+	},
+)
+
+// ociArchitectureCountBuiltin returns the number of distinct architectures of
+// an index, it returns 1 for an image manifest and 0 if the tag is unknown.
+var ociArchitectureCountBuiltin = rego.Function1(
+	&rego.Function{
+		Name:             "oci.architecture_count",
+		Decl:             types.NewFunction(types.Args(types.S), types.N),
+		Nondeterministic: true,
+	},
+	func(bctx rego.BuiltinContext, a *ast.Term) (_ *ast.Term, errFn error) {
+		funcContext, err := getFuncContext(&bctx)
+		if err != nil {
+			return nil, err
+		}
+		defer funcContext.cancelOnError(bctx, "oci.architecture_count", &errFn)
+
+		astRef, ok := a.Value.(ast.String)
+		if !ok {
+			return nil, fmt.Errorf("oci reference is not a string")
+		}
+
+		repository, tagDesc, err := funcContext.resolveTag(bctx.Context, string(astRef))
+		if err != nil {
+			return nil, err
+		} else if tagDesc == nil {
+			return ast.IntNumberTerm(0), nil
+		}
+		mediaType, payload, err := funcContext.getManifestPayload(bctx.Context, repository, tagDesc.Digest)
+		if err != nil {
+			return nil, err
+		} else if !isIndexMediaType(mediaType) {
+			return ast.IntNumberTerm(1), nil
+		}
+
+		index, err := parseIndex(payload)
+		if err != nil {
+			return nil, err
+		}
+
+		architectures := make(map[string]struct{})
+		for _, child := range index.Manifests {
+			if child.Platform == nil || child.Platform.Architecture == "" {
+				continue
+			}
+			architectures[child.Platform.Architecture] = struct{}{}
+		}
+
+		return ast.IntNumberTerm(len(architectures)), nil
+	},
+)
+
+// labelsBuiltin returns a builtin with the name returning the config labels of
+// an image merged with its manifest annotations, annotations win over labels
+// with the same key. For an index only the index annotations are returned.
+func labelsBuiltin(name string) func(*rego.Rego) {
+	return rego.Function1(
+		&rego.Function{
+			Name:             name,
+			Decl:             types.NewFunction(types.Args(types.S), stringObject),
+			Nondeterministic: true,
+		},
+		func(bctx rego.BuiltinContext, a *ast.Term) (_ *ast.Term, errFn error) {
+			funcContext, err := getFuncContext(&bctx)
+			if err != nil {
+				return nil, err
+			}
+			defer funcContext.cancelOnError(bctx, name, &errFn)
+
+			astRef, ok := a.Value.(ast.String)
+			if !ok {
+				return nil, fmt.Errorf("oci reference is not a string")
+			}
+
+			labels, err := funcContext.resolveLabels(bctx.Context, string(astRef))
+			if err != nil {
+				return nil, err
+			}
+
+			return stringMapTerm(labels), nil
+		},
+	)
+}
+
+var (
+	// ociMetadataBuiltin returns the labels and annotations of an image, see labelsBuiltin.
+	ociMetadataBuiltin = labelsBuiltin("oci.metadata")
+	// ociManifestLabelsBuiltin is an alias of oci.metadata.
+	ociManifestLabelsBuiltin = labelsBuiltin("oci.manifest_labels")
+)
+
+// ociLicenseAllowedBuiltin returns true if all the licenses of the SPDX expression
+// annotating an image are allowed, it returns false if the tag is unknown.
+var ociLicenseAllowedBuiltin = rego.Function2(
+	&rego.Function{
+		Name:             "oci.license_allowed",
+		Decl:             types.NewFunction(types.Args(types.S, stringCollection), types.B),
+		Nondeterministic: true,
+	},
+	func(bctx rego.BuiltinContext, a, b *ast.Term) (_ *ast.Term, errFn error) {
+		funcContext, err := getFuncContext(&bctx)
+		if err != nil {
+			return nil, err
+		}
+		defer funcContext.cancelOnError(bctx, "oci.license_allowed", &errFn)
+
+		astRef, ok := a.Value.(ast.String)
+		if !ok {
+			return nil, fmt.Errorf("oci reference is not a string")
+		}
+		allowed, err := stringsFromTerm(b)
+		if err != nil {
+			return nil, fmt.Errorf("bad allowed licenses: %w", err)
+		}
+
+		repository, tagDesc, err := funcContext.resolveTag(bctx.Context, string(astRef))
+		if err != nil {
+			return nil, err
+		} else if tagDesc == nil {
+			return ast.BooleanTerm(false), nil
+		}
+		manifest, err := funcContext.getManifest(bctx.Context, repository, tagDesc.Digest)
+		if err != nil {
+			return nil, err
+		}
+
+		return ast.BooleanTerm(licensesAllowed(manifest.Annotations[licensesAnnotation], allowed)), nil
+	},
+)
+
+// defaultDeprecatedTypes returns the media types deprecated by default.
+func defaultDeprecatedTypes() map[string]struct{} {
+	return map[string]struct{}{
+		string(regtypes.DockerManifestSchema1):       {},
+		string(regtypes.DockerManifestSchema1Signed): {},
+		string(regtypes.DockerForeignLayer):          {},
+	}
+}
+
+// ociHasDeprecatedTypesBuiltin returns true if the manifest, config or layer media
+// types of an image are deprecated, only the media type of an index is checked. It
+// returns false if the tag is unknown.
+var ociHasDeprecatedTypesBuiltin = rego.Function1(
+	&rego.Function{
+		Name:             "oci.has_deprecated_types",
+		Decl:             types.NewFunction(types.Args(types.S), types.B),
+		Nondeterministic: true,
+	},
+	func(bctx rego.BuiltinContext, a *ast.Term) (_ *ast.Term, errFn error) {
+		funcContext, err := getFuncContext(&bctx)
+		if err != nil {
+			return nil, err
+		}
+		defer funcContext.cancelOnError(bctx, "oci.has_deprecated_types", &errFn)
+
+		astRef, ok := a.Value.(ast.String)
+		if !ok {
+			return nil, fmt.Errorf("oci reference is not a string")
+		}
+
+		repository, tagDesc, err := funcContext.resolveTag(bctx.Context, string(astRef))
+		if err != nil {
+			return nil, err
+		} else if tagDesc == nil {
+			return ast.BooleanTerm(false), nil
+		}
+		mediaType, payload, err := funcContext.getManifestPayload(bctx.Context, repository, tagDesc.Digest)
+		if err != nil {
+			return nil, err
+		}
+
+		deprecatedTypes := funcContext.router.deprecatedTypes
+
+		if _, ok := deprecatedTypes[mediaType]; ok {
+			return ast.BooleanTerm(true), nil
+		} else if isIndexMediaType(mediaType) {
+			return ast.BooleanTerm(false), nil
+		}
+
+		manifest := new(ociManifest)
+		if err := json.Unmarshal(payload, manifest); err != nil {
+			return nil, err
+		}
+
+		if _, ok := deprecatedTypes[string(manifest.Config.MediaType)]; ok {
+			return ast.BooleanTerm(true), nil
+		}
+		for _, layer := range manifest.Layers {
+			if _, ok := deprecatedTypes[string(layer.MediaType)]; ok {
+				return ast.BooleanTerm(true), nil
+			}
+		}
+
+		return ast.BooleanTerm(false), nil
+	},
+)
+
+// ociAttestationStatusBuiltin returns the verdict of an allowlisted attestation
+// service about an image, it returns "unknown" if the tag is unknown or if the
+// service doesn't return a verdict.
+var ociAttestationStatusBuiltin = rego.Function2(
+	&rego.Function{
+		Name:             "oci.attestation_status",
+		Decl:             types.NewFunction(types.Args(types.S, types.S), types.S),
+		Nondeterministic: true,
+	},
+	func(bctx rego.BuiltinContext, a, b *ast.Term) (_ *ast.Term, errFn error) {
+		funcContext, err := getFuncContext(&bctx)
+		if err != nil {
+			return nil, err
+		}
+		defer funcContext.cancelOnError(bctx, "oci.attestation_status", &errFn)
+
+		astRef, ok := a.Value.(ast.String)
+		if !ok {
+			return nil, fmt.Errorf("oci reference is not a string")
+		}
+		astServiceURL, ok := b.Value.(ast.String)
+		if !ok {
+			return nil, fmt.Errorf("attestation service URL is not a string")
+		}
+
+		router := funcContext.router
+		if _, ok := router.attestationURLs[string(astServiceURL)]; !ok {
+			return nil, fmt.Errorf("attestation service URL %s is not allowed", astServiceURL)
+		}
+
+		_, tagDesc, err := funcContext.resolveTag(bctx.Context, string(astRef))
+		if err != nil {
+			return nil, err
+		} else if tagDesc == nil {
+			return ast.StringTerm(attestationUnknown), nil
+		}
+
+		verdict := queryAttestation(bctx.Context, router.attestationClient, string(astServiceURL), string(astRef), tagDesc.Digest)
+
+		return ast.StringTerm(verdict), nil
+	},
+)
+
+// ociIsFloatingTagBuiltin returns true if a reference points to a mutable tag,
+// see isFloatingTag for the tags considered floating.
+var ociIsFloatingTagBuiltin = rego.Function1(
+	&rego.Function{
+		Name: "oci.is_floating_tag",
+		Decl: types.NewFunction(types.Args(types.S), types.B),
+	},
+	func(bctx rego.BuiltinContext, a *ast.Term) (_ *ast.Term, errFn error) {
+		funcContext, err := getFuncContext(&bctx)
+		if err != nil {
+			return nil, err
+		}
+		defer funcContext.cancelOnError(bctx, "oci.is_floating_tag", &errFn)
+
+		astRef, ok := a.Value.(ast.String)
+		if !ok {
+			return nil, fmt.Errorf("oci reference is not a string")
+		}
+
+		floating, err := isFloatingTag(string(astRef), funcContext.router.floatingTags)
+		if err != nil {
+			return nil, err
+		}
+
+		return ast.BooleanTerm(floating), nil
+	},
+)
+
+// ociManifestWithinLimitBuiltin returns true if the manifest or index payload
+// of a reference is at most the limit in bytes, it returns true if the tag is
+// unknown.
+var ociManifestWithinLimitBuiltin = rego.Function2(
+	&rego.Function{
+		Name:             "oci.manifest_within_limit",
+		Decl:             types.NewFunction(types.Args(types.S, types.N), types.B),
+		Nondeterministic: true,
+	},
+	func(bctx rego.BuiltinContext, a, b *ast.Term) (_ *ast.Term, errFn error) {
+		funcContext, err := getFuncContext(&bctx)
+		if err != nil {
+			return nil, err
+		}
+		defer funcContext.cancelOnError(bctx, "oci.manifest_within_limit", &errFn)
+
+		astRef, ok := a.Value.(ast.String)
+		if !ok {
+			return nil, fmt.Errorf("oci reference is not a string")
+		}
+		astMaxBytes, ok := b.Value.(ast.Number)
+		if !ok {
+			return nil, fmt.Errorf("manifest size limit is not a number")
+		}
+		maxBytes, ok := astMaxBytes.Int64()
+		if !ok || maxBytes < 0 {
+			return nil, fmt.Errorf("manifest size limit is not a positive integer")
+		}
+
+		repository, tagDesc, err := funcContext.resolveTag(bctx.Context, string(astRef))
+		if err != nil {
+			return nil, err
+		} else if tagDesc == nil {
+			return ast.BooleanTerm(true), nil
+		}
+		_, payload, err := funcContext.getManifestPayload(bctx.Context, repository, tagDesc.Digest)
+		if err != nil {
+			return nil, err
+		}
+
+		return ast.BooleanTerm(int64(len(payload)) <= maxBytes), nil
+	},
+)
+
+// ociRepoBlobsBuiltin returns the sorted digests of the config and layer blobs
+// referenced by the manifests of a repository, it returns an empty array if the
+// repository is unknown.
+var ociRepoBlobsBuiltin = rego.Function1(
+	&rego.Function{
+		Name:             "oci.repo_blobs",
+		Decl:             types.NewFunction(types.Args(types.S), types.NewArray(nil, types.S)),
+		Nondeterministic: true,
+	},
+	func(bctx rego.BuiltinContext, a *ast.Term) (_ *ast.Term, errFn error) {
+		funcContext, err := getFuncContext(&bctx)
+		if err != nil {
+			return nil, err
+		}
+		defer funcContext.cancelOnError(bctx, "oci.repo_blobs", &errFn)
+
+		astRepository, ok := a.Value.(ast.String)
+		if !ok {
+			return nil, fmt.Errorf("repository is not a string")
+		}
+		namedRef, err := reference.WithName(string(astRepository))
+		if err != nil {
+			return nil, fmt.Errorf("bad repository name %s", astRepository)
+		}
+
+		repository, err := funcContext.registry.Repository(bctx.Context, namedRef)
+		if err != nil {
+			if isUnknown(err) {
+				return ast.ArrayTerm(), nil
+			}
+			return nil, fmt.Errorf("while getting repository %s: %w", namedRef, err)
+		}
+		blobs, err := funcContext.getRepositoryBlobs(bctx.Context, repository)
+		if err != nil {
+			return nil, err
+		}
+
+		terms := make([]*ast.Term, 0, len(blobs))
+		for _, blob := range blobs {
+			terms = append(terms, ast.StringTerm(blob))
+		}
+
+		return ast.ArrayTerm(terms...), nil
+	},
+)
+
+// ociHasHealthcheckBuiltin returns true if the config of an image defines a
+// healthcheck, a NONE healthcheck disables it. It returns false if the tag is
+// unknown or references an index.
+var ociHasHealthcheckBuiltin = rego.Function1(
+	&rego.Function{
+		Name:             "oci.has_healthcheck",
+		Decl:             types.NewFunction(types.Args(types.S), types.B),
+		Nondeterministic: true,
+	},
+	func(bctx rego.BuiltinContext, a *ast.Term) (_ *ast.Term, errFn error) {
+		funcContext, err := getFuncContext(&bctx)
+		if err != nil {
+			return nil, err
+		}
+		defer funcContext.cancelOnError(bctx, "oci.has_healthcheck", &errFn)
+
+		astRef, ok := a.Value.(ast.String)
+		if !ok {
+			return nil, fmt.Errorf("oci reference is not a string")
+		}
+
+		repository, tagDesc, err := funcContext.resolveTag(bctx.Context, string(astRef))
+		if err != nil {
+			return nil, err
+		} else if tagDesc == nil {
+			return ast.BooleanTerm(false), nil
+		}
+		manifest, err := funcContext.getImageManifest(bctx.Context, repository, tagDesc.Digest)
+		if err != nil {
+			return nil, err
+		} else if manifest == nil {
+			return ast.BooleanTerm(false), nil
+		}
+		config, err := funcContext.getConfig(bctx.Context, repository, manifest)
+		if err != nil {
+			return nil, err
+		} else if config == nil || config.Config.Healthcheck == nil {
+			return ast.BooleanTerm(false), nil
+		}
+
+		// a ["NONE"] test disables the healthcheck inherited from the base image
+		test := config.Config.Healthcheck.Test
+		if len(test) == 0 || test[0] == "NONE" {
+			return ast.BooleanTerm(false), nil
+		}
+
+		return ast.BooleanTerm(true), nil
+	},
+)
+
+// ociPlatformDigestBuiltin returns the digest of the index child manifest
+// satisfying the platform, it returns an empty string for an image manifest,
+// if the tag is unknown or if no child manifest satisfies the platform.
+var ociPlatformDigestBuiltin = rego.Function2(
+	&rego.Function{
+		Name:             "oci.platform_digest",
+		Decl:             types.NewFunction(types.Args(types.S, types.S), types.S),
+		Nondeterministic: true,
+	},
+	func(bctx rego.BuiltinContext, a, b *ast.Term) (_ *ast.Term, errFn error) {
+		funcContext, err := getFuncContext(&bctx)
+		if err != nil {
+			return nil, err
+		}
+		defer funcContext.cancelOnError(bctx, "oci.platform_digest", &errFn)
+
+		astRef, ok := a.Value.(ast.String)
+		if !ok {
+			return nil, fmt.Errorf("oci reference is not a string")
+		}
+		astPlatform, ok := b.Value.(ast.String)
+		if !ok {
+			return nil, fmt.Errorf("platform is not a string")
+		}
+		platform, err := v1.ParsePlatform(string(astPlatform))
+		if err != nil {
+			return nil, fmt.Errorf("bad platform %s: %w", astPlatform, err)
+		}
+
+		repository, tagDesc, err := funcContext.resolveTag(bctx.Context, string(astRef))
+		if err != nil {
+			return nil, err
+		} else if tagDesc == nil {
+			return ast.StringTerm(""), nil
+		}
+		mediaType, payload, err := funcContext.getManifestPayload(bctx.Context, repository, tagDesc.Digest)
+		if err != nil {
+			return nil, err
+		} else if !isIndexMediaType(mediaType) {
+			return ast.StringTerm(""), nil
+		}
+
+		index, err := parseIndex(payload)
+		if err != nil {
+			return nil, err
+		}
+		desc := findPlatformManifest(index, platform)
+		if desc == nil {
+			return ast.StringTerm(""), nil
+		}
+
+		return ast.StringTerm(desc.Digest.String()), nil
+	},
+)
+
+// ociRepoIsNewBuiltin returns true if a repository is unknown or has no tags.
+var ociRepoIsNewBuiltin = rego.Function1(
+	&rego.Function{
+		Name:             "oci.repo_is_new",
+		Decl:             types.NewFunction(types.Args(types.S), types.B),
+		Nondeterministic: true,
+	},
+	func(bctx rego.BuiltinContext, a *ast.Term) (_ *ast.Term, errFn error) {
+		funcContext, err := getFuncContext(&bctx)
+		if err != nil {
+			return nil, err
+		}
+		defer funcContext.cancelOnError(bctx, "oci.repo_is_new", &errFn)
+
+		astRepository, ok := a.Value.(ast.String)
+		if !ok {
+			return nil, fmt.Errorf("repository is not a string")
+		}
+		namedRef, err := reference.WithName(string(astRepository))
+		if err != nil {
+			return nil, fmt.Errorf("bad repository name %s", astRepository)
+		}
+
+		repository, err := funcContext.registry.Repository(bctx.Context, namedRef)
+		if err != nil {
+			if isUnknown(err) {
+				return ast.BooleanTerm(true), nil
+			}
+			return nil, fmt.Errorf("while getting repository %s: %w", namedRef, err)
+		}
+		empty, err := funcContext.repositoryIsEmpty(bctx.Context, repository)
+		if err != nil {
+			return nil, err
+		}
+
+		return ast.BooleanTerm(empty), nil
+	},
+)
+
+// ociEmptyLayerCountBuiltin returns the number of empty layer entries in the
+// config history of an image, it returns 0 if the tag is unknown, references
+// an index or if the config blob is unknown.
+var ociEmptyLayerCountBuiltin = rego.Function1(
+	&rego.Function{
+		Name:             "oci.empty_layer_count",
+		Decl:             types.NewFunction(types.Args(types.S), types.N),
+		Nondeterministic: true,
+	},
+	func(bctx rego.BuiltinContext, a *ast.Term) (_ *ast.Term, errFn error) {
+		funcContext, err := getFuncContext(&bctx)
+		if err != nil {
+			return nil, err
+		}
+		defer funcContext.cancelOnError(bctx, "oci.empty_layer_count", &errFn)
+
+		astRef, ok := a.Value.(ast.String)
+		if !ok {
+			return nil, fmt.Errorf("oci reference is not a string")
+		}
+
+		config, err := funcContext.resolveConfig(bctx.Context, string(astRef))
+		if err != nil {
+			return nil, err
+		} else if config == nil {
+			return ast.IntNumberTerm(0), nil
+		}
+
+		count := 0
+		for _, history := range config.History {
+			if history.EmptyLayer {
+				count++
+			}
+		}
+
+		return ast.IntNumberTerm(count), nil
+	},
+)
+
+// ociValidRootFSBuiltin returns true if the config of an image has a layers
+// rootfs with at least one diff ID, it returns false for a malformed config, if
+// the tag is unknown or references an index.
+var ociValidRootFSBuiltin = rego.Function1(
+	&rego.Function{
+		Name:             "oci.valid_rootfs",
+		Decl:             types.NewFunction(types.Args(types.S), types.B),
+		Nondeterministic: true,
+	},
+	func(bctx rego.BuiltinContext, a *ast.Term) (_ *ast.Term, errFn error) {
+		funcContext, err := getFuncContext(&bctx)
+		if err != nil {
+			return nil, err
+		}
+		defer funcContext.cancelOnError(bctx, "oci.valid_rootfs", &errFn)
+
+		astRef, ok := a.Value.(ast.String)
+		if !ok {
+			return nil, fmt.Errorf("oci reference is not a string")
+		}
+
+		config, err := funcContext.resolveConfig(bctx.Context, string(astRef))
+		if err != nil {
+			if errors.Is(err, errBadConfig) {
+				return ast.BooleanTerm(false), nil
+			}
+			return nil, err
+		} else if config == nil {
+			return ast.BooleanTerm(false), nil
+		}
+
+		valid := config.RootFS.Type == "layers" && len(config.RootFS.DiffIDs) > 0
+
+		return ast.BooleanTerm(valid), nil
+	},
+)
+
+// ociSameConfigBuiltin returns true if two images reference the same config
+// blob, it returns false if a tag is unknown or references an index.
+var ociSameConfigBuiltin = rego.Function2(
+	&rego.Function{
+		Name:             "oci.same_config",
+		Decl:             types.NewFunction(types.Args(types.S, types.S), types.B),
+		Nondeterministic: true,
+	},
+	func(bctx rego.BuiltinContext, a, b *ast.Term) (_ *ast.Term, errFn error) {
+		funcContext, err := getFuncContext(&bctx)
+		if err != nil {
+			return nil, err
+		}
+		defer funcContext.cancelOnError(bctx, "oci.same_config", &errFn)
+
+		var configDigests [2]v1.Hash
+
+		for i, term := range []*ast.Term{a, b} {
+			astRef, ok := term.Value.(ast.String)
+			if !ok {
+				return nil, fmt.Errorf("oci reference is not a string")
+			}
+
+			repository, tagDesc, err := funcContext.resolveTag(bctx.Context, string(astRef))
+			if err != nil {
+				return nil, err
+			} else if tagDesc == nil {
+				return ast.BooleanTerm(false), nil
+			}
+			manifest, err := funcContext.getImageManifest(bctx.Context, repository, tagDesc.Digest)
+			if err != nil {
+				return nil, err
+			} else if manifest == nil || manifest.Config.Digest.Hex == "" {
+				return ast.BooleanTerm(false), nil
+			}
+			configDigests[i] = manifest.Config.Digest
+		}
+
+		return ast.BooleanTerm(configDigests[0] == configDigests[1]), nil
+	},
+)
+
+// ociRequiredCapabilitiesBuiltin returns the capabilities listed by the
+// capabilities annotation of a manifest, it returns an empty array if a tag
+// is unknown.
+var ociRequiredCapabilitiesBuiltin = rego.Function1(
+	&rego.Function{
+		Name:             "oci.required_capabilities",
+		Decl:             types.NewFunction(types.Args(types.S), types.NewArray(nil, types.S)),
+		Nondeterministic: true,
+	},
+	func(bctx rego.BuiltinContext, a *ast.Term) (_ *ast.Term, errFn error) {
+		funcContext, err := getFuncContext(&bctx)
+		if err != nil {
+			return nil, err
+		}
+		defer funcContext.cancelOnError(bctx, "oci.required_capabilities", &errFn)
+
+		astRef, ok := a.Value.(ast.String)
+		if !ok {
+			return nil, fmt.Errorf("oci reference is not a string")
+		}
+
+		repository, tagDesc, err := funcContext.resolveTag(bctx.Context, string(astRef))
+		if err != nil {
+			return nil, err
+		} else if tagDesc == nil {
+			return ast.ArrayTerm(), nil
+		}
+		manifest, err := funcContext.getManifest(bctx.Context, repository, tagDesc.Digest)
+		if err != nil {
+			return nil, err
+		}
+
+		capabilities, err := parseCapabilities(manifest.Annotations[funcContext.router.capabilitiesKey])
+		if err != nil {
+			return nil, err
+		}
+
+		terms := make([]*ast.Term, 0, len(capabilities))
+		for _, capability := range capabilities {
+			terms = append(terms, ast.StringTerm(capability))
+		}
+
+		return ast.ArrayTerm(terms...), nil
+	},
+)
+
+// ociHostAllowedBuiltin returns true if the registry host of a reference is
+// one of the allowed hosts, see hostAllowed.
+var ociHostAllowedBuiltin = rego.Function2(
+	&rego.Function{
+		Name: "oci.host_allowed",
+		Decl: types.NewFunction(types.Args(types.S, stringCollection), types.B),
+	},
+	func(bctx rego.BuiltinContext, a, b *ast.Term) (_ *ast.Term, errFn error) {
+		funcContext, err := getFuncContext(&bctx)
+		if err != nil {
+			return nil, err
+		}
+		defer funcContext.cancelOnError(bctx, "oci.host_allowed", &errFn)
+
+		astRef, ok := a.Value.(ast.String)
+		if !ok {
+			return nil, fmt.Errorf("oci reference is not a string")
+		}
+		allowedHosts, err := stringsFromTerm(b)
+		if err != nil {
+			return nil, fmt.Errorf("bad allowed hosts: %w", err)
+		}
+
+		allowed, err := hostAllowed(string(astRef), allowedHosts)
+		if err != nil {
+			return nil, err
+		}
+
+		return ast.BooleanTerm(allowed), nil
+	},
+)
+
+// ociProvenanceAuthorsBuiltin returns the sorted builder identities of the
+// SLSA provenance attestations of an image, it returns an empty array if a
+// tag is unknown.
+var ociProvenanceAuthorsBuiltin = rego.Function1(
+	&rego.Function{
+		Name:             "oci.provenance_authors",
+		Decl:             types.NewFunction(types.Args(types.S), types.NewArray(nil, types.S)),
+		Nondeterministic: true,
+	},
+	func(bctx rego.BuiltinContext, a *ast.Term) (_ *ast.Term, errFn error) {
+		funcContext, err := getFuncContext(&bctx)
+		if err != nil {
+			return nil, err
+		}
+		defer funcContext.cancelOnError(bctx, "oci.provenance_authors", &errFn)
+
+		astRef, ok := a.Value.(ast.String)
+		if !ok {
+			return nil, fmt.Errorf("oci reference is not a string")
+		}
+
+		repository, tagDesc, err := funcContext.resolveTag(bctx.Context, string(astRef))
+		if err != nil {
+			return nil, err
+		} else if tagDesc == nil {
+			return ast.ArrayTerm(), nil
+		}
+		statements, err := funcContext.getAttestationStatements(bctx.Context, repository, tagDesc)
+		if err != nil {
+			return nil, err
+		}
+		builders, err := provenanceBuilders(statements)
+		if err != nil {
+			return nil, err
+		}
+
+		terms := make([]*ast.Term, 0, len(builders))
+		for _, builder := range builders {
+			terms = append(terms, ast.StringTerm(builder))
+		}
+
+		return ast.ArrayTerm(terms...), nil
+	},
+)
+
+// ociRequiresSubjectBuiltin returns true if the repository of a reference
+// matches one of the attestation-only patterns and its manifest or index has
+// no subject, it returns false if a tag is unknown.
+var ociRequiresSubjectBuiltin = rego.Function2(
+	&rego.Function{
+		Name:             "oci.requires_subject",
+		Decl:             types.NewFunction(types.Args(types.S, stringCollection), types.B),
+		Nondeterministic: true,
+	},
+	func(bctx rego.BuiltinContext, a, b *ast.Term) (_ *ast.Term, errFn error) {
+		funcContext, err := getFuncContext(&bctx)
+		if err != nil {
+			return nil, err
+		}
+		defer funcContext.cancelOnError(bctx, "oci.requires_subject", &errFn)
+
+		astRef, ok := a.Value.(ast.String)
+		if !ok {
+			return nil, fmt.Errorf("oci reference is not a string")
+		}
+		patterns, err := stringsFromTerm(b)
+		if err != nil {
+			return nil, fmt.Errorf("bad repository patterns: %w", err)
+		}
+
+		namedRef, _, err := parseRef(string(astRef))
+		if err != nil {
+			return nil, err
+		}
+		attestationOnly, err := matchGlobs(namedRef.Name(), patterns)
+		if err != nil {
+			return nil, fmt.Errorf("bad repository patterns: %w", err)
+		} else if !attestationOnly {
+			return ast.BooleanTerm(false), nil
+		}
+
+		repository, tagDesc, err := funcContext.resolveTag(bctx.Context, string(astRef))
+		if err != nil {
+			return nil, err
+		} else if tagDesc == nil {
+			return ast.BooleanTerm(false), nil
+		}
+		_, payload, err := funcContext.getManifestPayload(bctx.Context, repository, tagDesc.Digest)
+		if err != nil {
+			return nil, err
+		}
+
+		// both image manifests and indexes may have a subject
+		var manifest struct {
+			Subject *v1.Descriptor `json:"subject,omitempty"`
+		}
+		if err := json.Unmarshal(payload, &manifest); err != nil {
+			return nil, err
+		}
+
+		return ast.BooleanTerm(manifest.Subject == nil), nil
+	},
+)
+
+// ociNewestLayerAgeDaysBuiltin returns the age in days of the most recent
+// config history entry of an image, it returns -1 if a tag is unknown,
+// references an index or if the config has no history timestamps.
+var ociNewestLayerAgeDaysBuiltin = rego.Function1(
+	&rego.Function{
+		Name:             "oci.newest_layer_age_days",
+		Decl:             types.NewFunction(types.Args(types.S), types.N),
+		Nondeterministic: true,
+	},
+	func(bctx rego.BuiltinContext, a *ast.Term) (_ *ast.Term, errFn error) {
+		funcContext, err := getFuncContext(&bctx)
+		if err != nil {
+			return nil, err
+		}
+		defer funcContext.cancelOnError(bctx, "oci.newest_layer_age_days", &errFn)
+
+		astRef, ok := a.Value.(ast.String)
+		if !ok {
+			return nil, fmt.Errorf("oci reference is not a string")
+		}
+
+		config, err := funcContext.resolveConfig(bctx.Context, string(astRef))
+		if err != nil {
+			return nil, err
+		} else if config == nil {
+			return ast.IntNumberTerm(-1), nil
+		}
+
+		var newest time.Time
+
+		for _, history := range config.History {
+			if history.Created.After(newest) {
+				newest = history.Created.Time
+			}
+		}
+		if newest.IsZero() {
+			return ast.IntNumberTerm(-1), nil
+		}
+
+		age := evalTime(bctx).Sub(newest)
+		if age < 0 {
+			age = 0
+		}
+
+		return int64Term(int64(age / (24 * time.Hour))), nil
+	},
+)
+
+// ociSizeDeclarationValidBuiltin returns true if the stored sizes of the config
+// and layer blobs of an image, or of all the image manifests of an index, match
+// their declared sizes, it returns false if a tag or a blob is unknown.
+var ociSizeDeclarationValidBuiltin = rego.Function1(
+	&rego.Function{
+		Name:             "oci.size_declaration_valid",
+		Decl:             types.NewFunction(types.Args(types.S), types.B),
+		Nondeterministic: true,
+	},
+	func(bctx rego.BuiltinContext, a *ast.Term) (_ *ast.Term, errFn error) {
+		funcContext, err := getFuncContext(&bctx)
+		if err != nil {
+			return nil, err
+		}
+		defer funcContext.cancelOnError(bctx, "oci.size_declaration_valid", &errFn)
+
+		astRef, ok := a.Value.(ast.String)
+		if !ok {
+			return nil, fmt.Errorf("oci reference is not a string")
+		}
+
+		repository, tagDesc, err := funcContext.resolveTag(bctx.Context, string(astRef))
+		if err != nil {
+			return nil, err
+		} else if tagDesc == nil {
+			return ast.BooleanTerm(false), nil
+		}
+		mediaType, payload, err := funcContext.getManifestPayload(bctx.Context, repository, tagDesc.Digest)
+		if err != nil {
+			return nil, err
+		}
+
+		var manifests []*ociManifest
+
+		if isIndexMediaType(mediaType) {
+			index, err := parseIndex(payload)
+			if err != nil {
+				return nil, err
+			}
+			for _, child := range index.Manifests {
+				manifest, err := funcContext.getImageManifest(bctx.Context, repository, digest.Digest(child.Digest.String()))
+				if err != nil {
+					return nil, err
+				} else if manifest != nil {
+					manifests = append(manifests, manifest)
+				}
+			}
+		} else {
+			manifest := new(ociManifest)
+			if err := json.Unmarshal(payload, manifest); err != nil {
+				return nil, err
+			}
+			manifests = append(manifests, manifest)
+		}
+
+		for _, manifest := range manifests {
+			valid, err := funcContext.blobSizesValid(bctx.Context, repository, manifest)
+			if err != nil {
+				return nil, err
+			} else if !valid {
+				return ast.BooleanTerm(false), nil
+			}
+		}
+
+		return ast.BooleanTerm(true), nil
+	},
+)
+
+// ociScanResultBuiltin returns the vulnerability counts of an image reported
+// by an allowed scanner service URL, see queryScanResult. The status is
+// "unknown" if a tag is unknown.
+var ociScanResultBuiltin = rego.Function2(
+	&rego.Function{
+		Name:             "oci.scan_result",
+		Decl:             types.NewFunction(types.Args(types.S, types.S), anyObject),
+		Nondeterministic: true,
+	},
+	func(bctx rego.BuiltinContext, a, b *ast.Term) (_ *ast.Term, errFn error) {
+		funcContext, err := getFuncContext(&bctx)
+		if err != nil {
+			return nil, err
+		}
+		defer funcContext.cancelOnError(bctx, "oci.scan_result", &errFn)
+
+		astRef, ok := a.Value.(ast.String)
+		if !ok {
+			return nil, fmt.Errorf("oci reference is not a string")
+		}
+		astServiceURL, ok := b.Value.(ast.String)
+		if !ok {
+			return nil, fmt.Errorf("scanner service URL is not a string")
+		}
+
+		router := funcContext.router
+		if _, ok := router.scannerURLs[string(astServiceURL)]; !ok {
+			return nil, fmt.Errorf("scanner service URL %s is not allowed", astServiceURL)
+		}
+
+		_, tagDesc, err := funcContext.resolveTag(bctx.Context, string(astRef))
+		if err != nil {
+			return nil, err
+		}
+
+		result := map[string]interface{}{
+			scanStatusKey: scanStatusUnknown,
+		}
+		if tagDesc != nil {
+			result = queryScanResult(bctx.Context, router.scannerClient, string(astServiceURL), string(astRef), tagDesc.Digest)
+		}
+
+		v, err := ast.InterfaceToValue(result)
+		if err != nil {
+			return nil, err
+		}
+
+		return ast.NewTerm(v), nil
+	},
+)
+
+// ociExpectedPinnedTagBuiltin returns the digest pinned form of a reference,
+// a reference without tag resolves the latest tag. It returns an empty string
+// if a tag is unknown.
+var ociExpectedPinnedTagBuiltin = rego.Function1(
+	&rego.Function{
+		Name:             "oci.expected_pinned_tag",
+		Decl:             types.NewFunction(types.Args(types.S), types.S),
+		Nondeterministic: true,
+	},
+	func(bctx rego.BuiltinContext, a *ast.Term) (_ *ast.Term, errFn error) {
+		funcContext, err := getFuncContext(&bctx)
+		if err != nil {
+			return nil, err
+		}
+		defer funcContext.cancelOnError(bctx, "oci.expected_pinned_tag", &errFn)
+
+		astRef, ok := a.Value.(ast.String)
+		if !ok {
+			return nil, fmt.Errorf("oci reference is not a string")
+		}
+
+		ref, err := reference.Parse(string(astRef))
+		if err != nil {
+			return nil, fmt.Errorf("bad reference %s: %w", astRef, err)
+		}
+		named, ok := ref.(reference.Named)
+		if !ok {
+			return nil, fmt.Errorf("reference %s without name", astRef)
+		}
+
+		// already pinned references are returned without the tag
+		if digested, ok := ref.(reference.Digested); ok {
+			pinned, err := reference.WithDigest(reference.TrimNamed(named), digested.Digest())
+			if err != nil {
+				return nil, err
+			}
+			return ast.StringTerm(pinned.String()), nil
+		}
+
+		tag := "latest"
+		if tagged, ok := ref.(reference.Tagged); ok {
+			tag = tagged.Tag()
+		}
+
+		_, tagDesc, err := funcContext.resolveTag(bctx.Context, named.Name()+":"+tag)
+		if err != nil {
+			return nil, err
+		} else if tagDesc == nil {
+			return ast.StringTerm(""), nil
+		}
+
+		pinned, err := reference.WithDigest(reference.TrimNamed(named), tagDesc.Digest)
+		if err != nil {
+			return nil, err
+		}
+
+		return ast.StringTerm(pinned.String()), nil
+	},
+)
+
+// ociEntrypointPresentBuiltin returns true if the entrypoint or command binary
+// of an image exists in its layers, see entrypointCandidates. It returns false
+// if a tag or a blob is unknown or references an index.
+var ociEntrypointPresentBuiltin = rego.Function1(
+	&rego.Function{
+		Name:             "oci.entrypoint_present",
+		Decl:             types.NewFunction(types.Args(types.S), types.B),
+		Nondeterministic: true,
+	},
+	func(bctx rego.BuiltinContext, a *ast.Term) (_ *ast.Term, errFn error) {
+		funcContext, err := getFuncContext(&bctx)
+		if err != nil {
+			return nil, err
+		}
+		defer funcContext.cancelOnError(bctx, "oci.entrypoint_present", &errFn)
+
+		astRef, ok := a.Value.(ast.String)
+		if !ok {
+			return nil, fmt.Errorf("oci reference is not a string")
+		}
+
+		repository, tagDesc, err := funcContext.resolveTag(bctx.Context, string(astRef))
+		if err != nil {
+			return nil, err
+		} else if tagDesc == nil {
+			return ast.BooleanTerm(false), nil
+		}
+		manifest, err := funcContext.getImageManifest(bctx.Context, repository, tagDesc.Digest)
+		if err != nil {
+			return nil, err
+		} else if manifest == nil {
+			return ast.BooleanTerm(false), nil
+		}
+		config, err := funcContext.getConfig(bctx.Context, repository, manifest)
+		if err != nil {
+			return nil, err
+		} else if config == nil {
+			return ast.BooleanTerm(false), nil
+		}
+
+		candidates := entrypointCandidates(config.Config)
+		if len(candidates) == 0 {
+			return ast.BooleanTerm(false), nil
+		}
+
+		ctx, cancel := funcContext.layerScanContext(bctx.Context)
+		defer cancel()
+
+		fs, err := funcContext.getLayerFS(ctx, repository, manifest)
+		if err != nil {
+			return nil, err
+		} else if fs == nil {
+			return ast.BooleanTerm(false), nil
+		}
+
+		for _, candidate := range candidates {
+			if fs.fileExists(candidate) {
+				return ast.BooleanTerm(true), nil
+			}
+		}
+
+		return ast.BooleanTerm(false), nil
+	},
+)
+
+// ociIsReservedTagBuiltin returns true if the tag of a reference is one of the
+// reserved tags, it returns false for a reference without tag.
+var ociIsReservedTagBuiltin = rego.Function2(
+	&rego.Function{
+		Name: "oci.is_reserved_tag",
+		Decl: types.NewFunction(types.Args(types.S, stringCollection), types.B),
+	},
+	func(bctx rego.BuiltinContext, a, b *ast.Term) (_ *ast.Term, errFn error) {
+		funcContext, err := getFuncContext(&bctx)
+		if err != nil {
+			return nil, err
+		}
+		defer funcContext.cancelOnError(bctx, "oci.is_reserved_tag", &errFn)
+
+		astRef, ok := a.Value.(ast.String)
+		if !ok {
+			return nil, fmt.Errorf("oci reference is not a string")
+		}
+		reserved, err := stringsFromTerm(b)
+		if err != nil {
+			return nil, fmt.Errorf("bad reserved tags: %w", err)
+		}
+
+		tag, ok, err := referenceTag(string(astRef))
+		if err != nil {
+			return nil, err
+		} else if !ok {
+			return ast.BooleanTerm(false), nil
+		}
+
+		for _, reservedTag := range reserved {
+			if tag == reservedTag {
+				return ast.BooleanTerm(true), nil
+			}
+		}
+
+		return ast.BooleanTerm(false), nil
+	},
+)
+
+// ociRepoDedupRatioBuiltin returns the ratio of the blobs referenced by a
+// repository which are also referenced by another repository, it returns 0
+// if the repository is unknown or references no blobs.
+var ociRepoDedupRatioBuiltin = rego.Function1(
+	&rego.Function{
+		Name:             "oci.repo_dedup_ratio",
+		Decl:             types.NewFunction(types.Args(types.S), types.N),
+		Nondeterministic: true,
+	},
+	func(bctx rego.BuiltinContext, a *ast.Term) (_ *ast.Term, errFn error) {
+		funcContext, err := getFuncContext(&bctx)
+		if err != nil {
+			return nil, err
+		}
+		defer funcContext.cancelOnError(bctx, "oci.repo_dedup_ratio", &errFn)
+
+		astRepository, ok := a.Value.(ast.String)
+		if !ok {
+			return nil, fmt.Errorf("repository is not a string")
+		}
+		namedRef, err := reference.WithName(string(astRepository))
+		if err != nil {
+			return nil, fmt.Errorf("bad repository name %s", astRepository)
+		}
+
+		getBlobs := func(namedRef reference.Named) ([]string, error) {
+			repository, err := funcContext.registry.Repository(bctx.Context, namedRef)
+			if err != nil {
+				if isUnknown(err) {
+					return nil, nil
+				}
+				return nil, fmt.Errorf("while getting repository %s: %w", namedRef, err)
+			}
+			return funcContext.getRepositoryBlobs(bctx.Context, repository)
+		}
+
+		blobs, err := getBlobs(namedRef)
+		if err != nil {
+			return nil, err
+		} else if len(blobs) == 0 {
+			return ast.IntNumberTerm(0), nil
+		}
+
+		remaining := make(map[string]struct{}, len(blobs))
+		for _, blob := range blobs {
+			remaining[blob] = struct{}{}
+		}
+
+		repositories, err := funcContext.getRepositories(bctx.Context)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, name := range repositories {
+			if name == namedRef.Name() {
+				continue
+			} else if len(remaining) == 0 {
+				break
+			}
+			otherRef, err := reference.WithName(name)
+			if err != nil {
+				continue
+			}
+			otherBlobs, err := getBlobs(otherRef)
+			if err != nil {
+				return nil, err
+			}
+			for _, blob := range otherBlobs {
+				delete(remaining, blob)
+			}
+		}
+
+		shared := len(blobs) - len(remaining)
+
+		return float64Term(float64(shared) / float64(len(blobs))), nil
+	},
+)
+
+// descriptionAnnotation is the annotation holding the human-readable
+// description of an image.
+const descriptionAnnotation = "org.opencontainers.image.description"
+
+// ociHasDescriptionBuiltin returns true if the manifest or index of a reference
+// has a non blank description annotation, it returns false if a tag is unknown.
+var ociHasDescriptionBuiltin = rego.Function1(
+	&rego.Function{
+		Name:             "oci.has_description",
+		Decl:             types.NewFunction(types.Args(types.S), types.B),
+		Nondeterministic: true,
+	},
+	func(bctx rego.BuiltinContext, a *ast.Term) (_ *ast.Term, errFn error) {
+		funcContext, err := getFuncContext(&bctx)
+		if err != nil {
+			return nil, err
+		}
+		defer funcContext.cancelOnError(bctx, "oci.has_description", &errFn)
+
+		astRef, ok := a.Value.(ast.String)
+		if !ok {
+			return nil, fmt.Errorf("oci reference is not a string")
+		}
+
+		repository, tagDesc, err := funcContext.resolveTag(bctx.Context, string(astRef))
+		if err != nil {
+			return nil, err
+		} else if tagDesc == nil {
+			return ast.BooleanTerm(false), nil
+		}
+		manifest, err := funcContext.getManifest(bctx.Context, repository, tagDesc.Digest)
+		if err != nil {
+			return nil, err
+		}
+
+		description := strings.TrimSpace(manifest.Annotations[descriptionAnnotation])
+
+		return ast.BooleanTerm(description != ""), nil
+	},
+)
+
+// ociIndexCreatedConsistentBuiltin returns true if the config creation times of
+// the platform images of an index are within the tolerance in seconds, it returns
+// true for an image manifest and false if a tag is unknown or a creation time is
+// missing.
+var ociIndexCreatedConsistentBuiltin = rego.Function2(
+	&rego.Function{
+		Name:             "oci.index_created_consistent",
+		Decl:             types.NewFunction(types.Args(types.S, types.N), types.B),
+		Nondeterministic: true,
+	},
+	func(bctx rego.BuiltinContext, a, b *ast.Term) (_ *ast.Term, errFn error) {
+		funcContext, err := getFuncContext(&bctx)
+		if err != nil {
+			return nil, err
+		}
+		defer funcContext.cancelOnError(bctx, "oci.index_created_consistent", &errFn)
+
+		astRef, ok := a.Value.(ast.String)
+		if !ok {
+			return nil, fmt.Errorf("oci reference is not a string")
+		}
+		astTolerance, ok := b.Value.(ast.Number)
+		if !ok {
+			return nil, fmt.Errorf("tolerance is not a number")
+		}
+		toleranceSeconds, ok := astTolerance.Float64()
+		if !ok || toleranceSeconds < 0 {
+			return nil, fmt.Errorf("tolerance is not a positive number")
+		}
+		tolerance := time.Duration(toleranceSeconds * float64(time.Second))
+
+		repository, tagDesc, err := funcContext.resolveTag(bctx.Context, string(astRef))
+		if err != nil {
+			return nil, err
+		} else if tagDesc == nil {
+			return ast.BooleanTerm(false), nil
+		}
+		mediaType, payload, err := funcContext.getManifestPayload(bctx.Context, repository, tagDesc.Digest)
+		if err != nil {
+			return nil, err
+		} else if !isIndexMediaType(mediaType) {
+			return ast.BooleanTerm(true), nil
+		}
+
+		index, err := parseIndex(payload)
+		if err != nil {
+			return nil, err
+		}
+
+		var oldest, newest time.Time
+
+		for _, child := range index.Manifests {
+			// attestation manifests are not platform images
+			if child.Annotations[dockerReferenceTypeAnnotation] == dockerAttestationManifest {
+				continue
+			}
+			manifest, err := funcContext.getImageManifest(bctx.Context, repository, digest.Digest(child.Digest.String()))
+			if err != nil {
+				return nil, err
+			} else if manifest == nil {
+				continue
+			}
+			config, err := funcContext.getConfig(bctx.Context, repository, manifest)
+			if err != nil {
+				return nil, err
+			} else if config == nil || config.Created.IsZero() {
+				return ast.BooleanTerm(false), nil
+			}
+
+			created := config.Created.Time
+			if oldest.IsZero() || created.Before(oldest) {
+				oldest = created
+			}
+			if newest.IsZero() || created.After(newest) {
+				newest = created
+			}
+		}
+
+		return ast.BooleanTerm(newest.Sub(oldest) <= tolerance), nil
+	},
+)
+
+// ociTotalRepositoriesBuiltin returns the number of repositories of the
+// registry catalog.
+var ociTotalRepositoriesBuiltin = rego.FunctionDyn(
+	&rego.Function{
+		Name:             "oci.total_repositories",
+		Decl:             types.NewFunction(types.Args(), types.N),
+		Nondeterministic: true,
+	},
+	func(bctx rego.BuiltinContext, _ []*ast.Term) (_ *ast.Term, errFn error) {
+		funcContext, err := getFuncContext(&bctx)
+		if err != nil {
+			return nil, err
+		}
+		defer funcContext.cancelOnError(bctx, "oci.total_repositories", &errFn)
+
+		repositories, err := funcContext.getRepositories(bctx.Context)
+		if err != nil {
+			return nil, err
+		}
+
+		return ast.IntNumberTerm(len(repositories)), nil
+	},
+)
+
+// ociArtifactTypeRegisteredBuiltin returns true if the artifact type of a
+// manifest, or its config media type, is an approved artifact type and the
+// manifest sets the annotations it requires. It returns false if a tag is
+// unknown.
+var ociArtifactTypeRegisteredBuiltin = rego.Function1(
+	&rego.Function{
+		Name:             "oci.artifact_type_registered",
+		Decl:             types.NewFunction(types.Args(types.S), types.B),
+		Nondeterministic: true,
+	},
+	func(bctx rego.BuiltinContext, a *ast.Term) (_ *ast.Term, errFn error) {
+		funcContext, err := getFuncContext(&bctx)
+		if err != nil {
+			return nil, err
+		}
+		defer funcContext.cancelOnError(bctx, "oci.artifact_type_registered", &errFn)
+
+		astRef, ok := a.Value.(ast.String)
+		if !ok {
+			return nil, fmt.Errorf("oci reference is not a string")
+		}
+
+		repository, tagDesc, err := funcContext.resolveTag(bctx.Context, string(astRef))
+		if err != nil {
+			return nil, err
+		} else if tagDesc == nil {
+			return ast.BooleanTerm(false), nil
+		}
+		manifest, err := funcContext.getManifest(bctx.Context, repository, tagDesc.Digest)
+		if err != nil {
+			return nil, err
+		}
+
+		// the config media type is the artifact type of
+		// manifests without the artifactType field
+		mediaType := manifest.ArtifactType
+		if mediaType == "" {
+			mediaType = string(manifest.Config.MediaType)
+		}
+
+		artifactType, ok := funcContext.router.artifactTypes[mediaType]
+		if !ok || mediaType == "" {
+			return ast.BooleanTerm(false), nil
+		}
+		for _, annotation := range artifactType.RequiredAnnotations {
+			if manifest.Annotations[annotation] == "" {
+				return ast.BooleanTerm(false), nil
+			}
+		}
+
+		return ast.BooleanTerm(true), nil
+	},
+)
+
+// ociLayerFileDigestBuiltin returns the SHA256 hex digest of a file in the image
+// layers of a media type, upper layers take precedence. It returns an empty
+// string if a tag or the file is unknown or references an index.
+var ociLayerFileDigestBuiltin = rego.Function3(
+	&rego.Function{
+		Name:             "oci.layer_file_digest",
+		Decl:             types.NewFunction(types.Args(types.S, types.S, types.S), types.S),
+		Nondeterministic: true,
+	},
+	func(bctx rego.BuiltinContext, a, b, c *ast.Term) (_ *ast.Term, errFn error) {
+		funcContext, err := getFuncContext(&bctx)
+		if err != nil {
+			return nil, err
+		}
+		defer funcContext.cancelOnError(bctx, "oci.layer_file_digest", &errFn)
+
+		astRef, ok := a.Value.(ast.String)
+		if !ok {
+			return nil, fmt.Errorf("oci reference is not a string")
+		}
+		astMediaType, ok := b.Value.(ast.String)
+		if !ok {
+			return nil, fmt.Errorf("layer media type is not a string")
+		}
+		astPath, ok := c.Value.(ast.String)
+		if !ok {
+			return nil, fmt.Errorf("file path is not a string")
+		}
+
+		repository, tagDesc, err := funcContext.resolveTag(bctx.Context, string(astRef))
+		if err != nil {
+			return nil, err
+		} else if tagDesc == nil {
+			return ast.StringTerm(""), nil
+		}
+		manifest, err := funcContext.getImageManifest(bctx.Context, repository, tagDesc.Digest)
+		if err != nil {
+			return nil, err
+		} else if manifest == nil {
+			return ast.StringTerm(""), nil
+		}
+
+		ctx, cancel := funcContext.layerScanContext(bctx.Context)
+		defer cancel()
+
+		// upper layers take precedence over lower layers
+		for i := len(manifest.Layers) - 1; i >= 0; i-- {
+			layer := manifest.Layers[i]
+			if layer.MediaType != regtypes.MediaType(astMediaType) {
+				continue
+			}
+
+			tr, closer, err := funcContext.openLayer(ctx, repository, layer)
+			if err != nil {
+				return nil, err
+			} else if tr == nil {
+				continue
+			}
+			dgst, found, err := layerFileDigest(tr, string(astPath))
+			_ = closer.Close()
+			if err != nil {
+				return nil, fmt.Errorf("while reading layer %s: %w", layer.Digest, err)
+			} else if found {
+				return ast.StringTerm(dgst), nil
+			}
+		}
+
+		return ast.StringTerm(""), nil
+	},
+)
+
+// ociTagListBuiltin returns the sorted tags of a repository, it returns an
+// empty array if the repository is unknown.
+var ociTagListBuiltin = rego.Function1(
+	&rego.Function{
+		Name:             "oci.tag_list",
+		Decl:             types.NewFunction(types.Args(types.S), types.NewArray(nil, types.S)),
+		Nondeterministic: true,
+	},
+	func(bctx rego.BuiltinContext, a *ast.Term) (_ *ast.Term, errFn error) {
+		funcContext, err := getFuncContext(&bctx)
+		if err != nil {
+			return nil, err
+		}
+		defer funcContext.cancelOnError(bctx, "oci.tag_list", &errFn)
+
+		astRepository, ok := a.Value.(ast.String)
+		if !ok {
+			return nil, fmt.Errorf("repository is not a string")
+		}
+		namedRef, err := reference.WithName(string(astRepository))
+		if err != nil {
+			return nil, fmt.Errorf("bad repository name %s", astRepository)
+		}
+
+		repository, err := funcContext.registry.Repository(bctx.Context, namedRef)
+		if err != nil {
+			if isUnknown(err) {
+				return ast.ArrayTerm(), nil
+			}
+			return nil, fmt.Errorf("while getting repository %s: %w", namedRef, err)
+		}
+		tags, err := funcContext.getTags(bctx.Context, repository)
+		if err != nil {
+			return nil, err
+		}
+
+		terms := make([]*ast.Term, 0, len(tags))
+		for _, tag := range tags {
+			terms = append(terms, ast.StringTerm(tag))
+		}
+
+		return ast.ArrayTerm(terms...), nil
+	},
+)
+
+// ociBlobExistsBuiltin returns true if a repository blob exists, it returns
+// false if the repository or the blob is unknown.
+var ociBlobExistsBuiltin = rego.Function2(
+	&rego.Function{
+		Name:             "oci.blob_exists",
+		Decl:             types.NewFunction(types.Args(types.S, types.S), types.B),
+		Nondeterministic: true,
+	},
+	func(bctx rego.BuiltinContext, a, b *ast.Term) (_ *ast.Term, errFn error) {
+		funcContext, err := getFuncContext(&bctx)
+		if err != nil {
+			return nil, err
+		}
+		defer funcContext.cancelOnError(bctx, "oci.blob_exists", &errFn)
+
+		astRepository, ok := a.Value.(ast.String)
+		if !ok {
+			return nil, fmt.Errorf("repository is not a string")
+		}
+		astDigest, ok := b.Value.(ast.String)
+		if !ok {
+			return nil, fmt.Errorf("blob digest is not a string")
+		}
+		namedRef, err := reference.WithName(string(astRepository))
+		if err != nil {
+			return nil, fmt.Errorf("bad repository name %s", astRepository)
+		}
+		dgst, err := digest.Parse(string(astDigest))
+		if err != nil {
+			return nil, fmt.Errorf("bad blob digest %s: %w", astDigest, err)
+		}
+
+		repository, err := funcContext.registry.Repository(bctx.Context, namedRef)
+		if err != nil {
+			if isUnknown(err) {
+				return ast.BooleanTerm(false), nil
+			}
+			return nil, fmt.Errorf("while getting repository %s: %w", namedRef, err)
+		}
+		exists, err := funcContext.blobExists(bctx.Context, repository.Blobs(bctx.Context), dgst)
+		if err != nil {
+			return nil, err
+		}
+
+		return ast.BooleanTerm(exists), nil
+	},
+)
+
+// ociConfigMediaTypeBuiltin returns the config media type of an image manifest, it returns
+// an empty string if the tag is unknown or references an index.
+var ociConfigMediaTypeBuiltin = rego.Function1(
+	&rego.Function{
+		Name:             "oci.config_mediatype",
+		Decl:             types.NewFunction(types.Args(types.S), types.S),
+		Nondeterministic: true,
+	},
+	func(bctx rego.BuiltinContext, a *ast.Term) (_ *ast.Term, errFn error) {
+		funcContext, err := getFuncContext(&bctx)
+		if err != nil {
+			return nil, err
+		}
+		defer funcContext.cancelOnError(bctx, "oci.config_mediatype", &errFn)
+
+		astRef, ok := a.Value.(ast.String)
+		if !ok {
+			return nil, fmt.Errorf("oci reference is not a string")
+		}
+
+		repository, tagDesc, err := funcContext.resolveTag(bctx.Context, string(astRef))
+		if err != nil {
+			return nil, err
+		} else if tagDesc == nil {
+			return ast.StringTerm(""), nil
+		}
+		manifest, err := funcContext.getImageManifest(bctx.Context, repository, tagDesc.Digest)
+		if err != nil {
+			return nil, err
+		} else if manifest == nil {
+			// indexes don't have a config
+			return ast.StringTerm(""), nil
+		}
+
+		return ast.StringTerm(string(manifest.Config.MediaType)), nil
+	},
+)
+
+// ociIndexManifestsBuiltin returns the digests of the child manifests
+// of an index, it returns an empty array for an image manifest.
+var ociIndexManifestsBuiltin = rego.Function1(
+	&rego.Function{
+		Name:             "oci.index_manifests",
+		Decl:             types.NewFunction(types.Args(types.S), types.NewArray(nil, types.S)),
+		Nondeterministic: true,
+	},
+	func(bctx rego.BuiltinContext, a *ast.Term) (_ *ast.Term, errFn error) {
+		funcContext, err := getFuncContext(&bctx)
+		if err != nil {
+			return nil, err
+		}
+		defer funcContext.cancelOnError(bctx, "oci.index_manifests", &errFn)
+
+		astRef, ok := a.Value.(ast.String)
+		if !ok {
+			return nil, fmt.Errorf("oci reference is not a string")
+		}
+
+		repository, tagDesc, err := funcContext.resolveTag(bctx.Context, string(astRef))
+		if err != nil {
+			return nil, err
+		} else if tagDesc == nil {
+			return ast.ArrayTerm(), nil
+		}
+		mediaType, payload, err := funcContext.getManifestPayload(bctx.Context, repository, tagDesc.Digest)
+		if err != nil {
+			return nil, err
+		} else if !isIndexMediaType(mediaType) {
+			return ast.ArrayTerm(), nil
+		}
+
+		index, err := parseIndex(payload)
+		if err != nil {
+			return nil, err
+		}
+
+		terms := make([]*ast.Term, 0, len(index.Manifests))
+		for _, child := range index.Manifests {
+			terms = append(terms, ast.StringTerm(child.Digest.String()))
+		}
+
+		return ast.ArrayTerm(terms...), nil
+	},
+)
+
+// ociAnnotationsBuiltin returns the annotations of an image
+// manifest or of an index.
+var ociAnnotationsBuiltin = rego.Function1(
+	&rego.Function{
+		Name:             "oci.annotations",
+		Decl:             types.NewFunction(types.Args(types.S), stringObject),
+		Nondeterministic: true,
+	},
+	func(bctx rego.BuiltinContext, a *ast.Term) (_ *ast.Term, errFn error) {
+		funcContext, err := getFuncContext(&bctx)
+		if err != nil {
+			return nil, err
+		}
+		defer funcContext.cancelOnError(bctx, "oci.annotations", &errFn)
+
+		astRef, ok := a.Value.(ast.String)
+		if !ok {
+			return nil, fmt.Errorf("oci reference is not a string")
+		}
+
+		repository, tagDesc, err := funcContext.resolveTag(bctx.Context, string(astRef))
+		if err != nil {
+			return nil, err
+		} else if tagDesc == nil {
+			return ast.ObjectTerm(), nil
+		}
+		manifest, err := funcContext.getManifest(bctx.Context, repository, tagDesc.Digest)
+		if err != nil {
+			return nil, err
+		}
+
+		return stringMapTerm(manifest.Annotations), nil
+	},
+)
+
+// ociLayerSizesBuiltin returns the layer sizes of an image in layer order, like
+// oci.blob_digest an index is resolved to its linux/amd64 image manifest.
+var ociLayerSizesBuiltin = rego.Function1(
+	&rego.Function{
+		Name:             "oci.layer_sizes",
+		Decl:             types.NewFunction(types.Args(types.S), types.NewArray(nil, types.N)),
+		Nondeterministic: true,
+	},
+	func(bctx rego.BuiltinContext, a *ast.Term) (_ *ast.Term, errFn error) {
+		funcContext, err := getFuncContext(&bctx)
+		if err != nil {
+			return nil, err
+		}
+		defer funcContext.cancelOnError(bctx, "oci.layer_sizes", &errFn)
+
+		astRef, ok := a.Value.(ast.String)
+		if !ok {
+			return nil, fmt.Errorf("oci reference is not a string")
+		}
+
+		repository, tagDesc, err := funcContext.resolveTag(bctx.Context, string(astRef))
+		if err != nil {
+			return nil, err
+		} else if tagDesc == nil {
+			return ast.ArrayTerm(), nil
+		}
+		manifest, err := funcContext.getPlatformManifest(bctx.Context, repository, tagDesc.Digest, &defaultPlatform)
+		if err != nil {
+			return nil, err
+		} else if manifest == nil {
+			return ast.ArrayTerm(), nil
+		}
+
+		terms := make([]*ast.Term, 0, len(manifest.Layers))
+		for _, layer := range manifest.Layers {
+			terms = append(terms, int64Term(layer.Size))
+		}
+
+		return ast.ArrayTerm(terms...), nil
+	},
+)
+
+// ociReferrersBuiltin returns the digests of the manifests referring to an
+// image with the artifact type, an empty artifact type matches all referrers.
+var ociReferrersBuiltin = rego.Function2(
+	&rego.Function{
+		Name:             "oci.referrers",
+		Decl:             types.NewFunction(types.Args(types.S, types.S), types.NewArray(nil, types.S)),
+		Nondeterministic: true,
+	},
+	func(bctx rego.BuiltinContext, a, b *ast.Term) (_ *ast.Term, errFn error) {
+		funcContext, err := getFuncContext(&bctx)
+		if err != nil {
+			return nil, err
+		}
+		defer funcContext.cancelOnError(bctx, "oci.referrers", &errFn)
+
+		astRef, ok := a.Value.(ast.String)
+		if !ok {
+			return nil, fmt.Errorf("oci reference is not a string")
+		}
+		astArtifactType, ok := b.Value.(ast.String)
+		if !ok {
+			return nil, fmt.Errorf("artifact type is not a string")
+		}
+
+		repository, tagDesc, err := funcContext.resolveTag(bctx.Context, string(astRef))
+		if err != nil {
+			return nil, err
+		} else if tagDesc == nil {
+			return ast.ArrayTerm(), nil
+		}
+		referrers, err := funcContext.getReferrers(bctx.Context, repository, tagDesc.Digest, string(astArtifactType))
+		if err != nil {
+			return nil, err
+		}
+
+		terms := make([]*ast.Term, 0, len(referrers))
+		for _, referrer := range referrers {
+			terms = append(terms, ast.StringTerm(referrer.Digest.String()))
+		}
+
+		return ast.ArrayTerm(terms...), nil
+	},
+)
+
+// ociManifestMediaTypeBuiltin returns the media type reported by the manifest of
+// a tag, indexes included. It returns an empty string if the tag is unknown.
+var ociManifestMediaTypeBuiltin = rego.Function1(
+	&rego.Function{
+		Name:             "oci.manifest_mediatype",
+		Decl:             types.NewFunction(types.Args(types.S), types.S),
+		Nondeterministic: true,
+	},
+	func(bctx rego.BuiltinContext, a *ast.Term) (_ *ast.Term, errFn error) {
+		funcContext, err := getFuncContext(&bctx)
+		if err != nil {
+			return nil, err
+		}
+		defer funcContext.cancelOnError(bctx, "oci.manifest_mediatype", &errFn)
+
+		astRef, ok := a.Value.(ast.String)
+		if !ok {
+			return nil, fmt.Errorf("oci reference is not a string")
+		}
+
+		repository, tagDesc, err := funcContext.resolveTag(bctx.Context, string(astRef))
+		if err != nil {
+			return nil, err
+		} else if tagDesc == nil {
+			return ast.StringTerm(""), nil
+		}
+		// tag descriptors don't carry the media type, it's
+		// reported by the manifest itself
+		mediaType, _, err := funcContext.getManifestPayload(bctx.Context, repository, tagDesc.Digest)
+		if err != nil {
+			return nil, err
+		}
+
+		return ast.StringTerm(mediaType), nil
+	},
+)
+
+// platformTerm returns a platform object term.
+func platformTerm(os, architecture, variant string) *ast.Term {
+	return ast.ObjectTerm(
+		ast.Item(ast.StringTerm("os"), ast.StringTerm(os)),
+		ast.Item(ast.StringTerm("architecture"), ast.StringTerm(architecture)),
+		ast.Item(ast.StringTerm("variant"), ast.StringTerm(variant)),
+	)
+}
+
+// ociPlatformListBuiltin returns the platforms advertised by an index, for
+// an image manifest the platform of the image configuration is returned.
+var ociPlatformListBuiltin = rego.Function1(
+	&rego.Function{
+		Name:             "oci.platform_list",
+		Decl:             types.NewFunction(types.Args(types.S), types.NewArray(nil, stringObject)),
+		Nondeterministic: true,
+	},
+	func(bctx rego.BuiltinContext, a *ast.Term) (_ *ast.Term, errFn error) {
+		funcContext, err := getFuncContext(&bctx)
+		if err != nil {
+			return nil, err
+		}
+		defer funcContext.cancelOnError(bctx, "oci.platform_list", &errFn)
+
+		astRef, ok := a.Value.(ast.String)
+		if !ok {
+			return nil, fmt.Errorf("oci reference is not a string")
+		}
+
+		repository, tagDesc, err := funcContext.resolveTag(bctx.Context, string(astRef))
+		if err != nil {
+			return nil, err
+		} else if tagDesc == nil {
+			return ast.ArrayTerm(), nil
+		}
+		mediaType, payload, err := funcContext.getManifestPayload(bctx.Context, repository, tagDesc.Digest)
+		if err != nil {
+			return nil, err
+		}
+
+		if isIndexMediaType(mediaType) {
+			index, err := parseIndex(payload)
+			if err != nil {
+				return nil, err
+			}
+			terms := make([]*ast.Term, 0, len(index.Manifests))
+			for _, child := range index.Manifests {
+				if child.Platform == nil {
+					continue
+				}
+				terms = append(terms, platformTerm(child.Platform.OS, child.Platform.Architecture, child.Platform.Variant))
+			}
+			return ast.ArrayTerm(terms...), nil
+		}
+
+		manifest := new(ociManifest)
+		if err := json.Unmarshal(payload, manifest); err != nil {
+			return nil, err
+		}
+		// artifacts configurations don't describe a platform
+		switch manifest.Config.MediaType {
+		case regtypes.OCIConfigJSON, regtypes.DockerConfigJSON:
+		default:
+			return ast.ArrayTerm(), nil
+		}
+
+		config, err := funcContext.getConfig(bctx.Context, repository, manifest)
+		if err != nil {
+			return nil, err
+		} else if config == nil || config.OS == "" {
+			return ast.ArrayTerm(), nil
+		}
+
+		return ast.ArrayTerm(platformTerm(config.OS, config.Architecture, config.Variant)), nil
+	},
+)
+
+// ociTagExistsBuiltin returns true if the tag exists in the repository, it
+// returns false if the repository or the tag is unknown.
+var ociTagExistsBuiltin = rego.Function2(
+	&rego.Function{
+		Name:             "oci.tag_exists",
+		Decl:             types.NewFunction(types.Args(types.S, types.S), types.B),
+		Nondeterministic: true,
+	},
+	func(bctx rego.BuiltinContext, a, b *ast.Term) (_ *ast.Term, errFn error) {
+		funcContext, err := getFuncContext(&bctx)
+		if err != nil {
+			return nil, err
+		}
+		defer funcContext.cancelOnError(bctx, "oci.tag_exists", &errFn)
+
+		astRepository, ok := a.Value.(ast.String)
+		if !ok {
+			return nil, fmt.Errorf("repository is not a string")
+		}
+		astTag, ok := b.Value.(ast.String)
+		if !ok {
+			return nil, fmt.Errorf("tag is not a string")
+		} else if astTag == "" {
+			return nil, fmt.Errorf("empty tag")
+		}
+		namedRef, err := reference.WithName(string(astRepository))
+		if err != nil {
+			return nil, fmt.Errorf("bad repository name %s", astRepository)
+		}
+
+		repository, err := funcContext.registry.Repository(bctx.Context, namedRef)
+		if err != nil {
+			if isUnknown(err) {
+				return ast.BooleanTerm(false), nil
+			}
+			return nil, fmt.Errorf("while getting repository %s: %w", namedRef, err)
+		}
+		// unknown tags are reported as a nil descriptor
+		desc, err := funcContext.getTag(bctx.Context, repository, string(astTag))
+		if err != nil {
+			return nil, err
+		}
+
+		return ast.BooleanTerm(desc != nil), nil
+	},
+)
+
+// ociConfigBlobBuiltin returns the image config blob as an object, like
+// oci.blob_digest an index is resolved to its linux/amd64 image manifest. Config
+// blobs larger than 4MB are rejected, it returns an empty object if the tag is
+// unknown.
+var ociConfigBlobBuiltin = rego.Function1(
+	&rego.Function{
+		Name:             "oci.config_blob",
+		Decl:             types.NewFunction(types.Args(types.S), anyObject),
+		Nondeterministic: true,
+	},
+	func(bctx rego.BuiltinContext, a *ast.Term) (_ *ast.Term, errFn error) {
+		funcContext, err := getFuncContext(&bctx)
+		if err != nil {
+			return nil, err
+		}
+		defer funcContext.cancelOnError(bctx, "oci.config_blob", &errFn)
+
+		astRef, ok := a.Value.(ast.String)
+		if !ok {
+			return nil, fmt.Errorf("oci reference is not a string")
+		}
+
+		repository, tagDesc, err := funcContext.resolveTag(bctx.Context, string(astRef))
+		if err != nil {
+			return nil, err
+		} else if tagDesc == nil {
+			return ast.ObjectTerm(), nil
+		}
+		manifest, err := funcContext.getPlatformManifest(bctx.Context, repository, tagDesc.Digest, &defaultPlatform)
+		if err != nil {
+			return nil, err
+		} else if manifest == nil {
+			return ast.ObjectTerm(), nil
+		}
+
+		data, err := funcContext.getConfigBlob(bctx.Context, repository, manifest)
+		if err != nil {
+			return nil, err
+		} else if data == nil {
+			return ast.ObjectTerm(), nil
+		}
+
+		v, err := ast.ValueFromReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("%w %s: %s", errBadConfig, manifest.Config.Digest, err)
+		} else if _, ok := v.(ast.Object); !ok {
+			return nil, fmt.Errorf("%w %s: not a JSON object", errBadConfig, manifest.Config.Digest)
+		}
+
+		return ast.NewTerm(v), nil
+	},
+)
+
+// ociLayerCountBuiltin returns the number of layers of an image, like
+// oci.blob_digest an index is resolved to its linux/amd64 image manifest.
+// It returns 0 if the tag is unknown.
+var ociLayerCountBuiltin = rego.Function1(
+	&rego.Function{
+		Name:             "oci.layer_count",
+		Decl:             types.NewFunction(types.Args(types.S), types.N),
+		Nondeterministic: true,
+	},
+	func(bctx rego.BuiltinContext, a *ast.Term) (_ *ast.Term, errFn error) {
+		funcContext, err := getFuncContext(&bctx)
+		if err != nil {
+			return nil, err
+		}
+		defer funcContext.cancelOnError(bctx, "oci.layer_count", &errFn)
+
+		astRef, ok := a.Value.(ast.String)
+		if !ok {
+			return nil, fmt.Errorf("oci reference is not a string")
+		}
+
+		repository, tagDesc, err := funcContext.resolveTag(bctx.Context, string(astRef))
+		if err != nil {
+			return nil, err
+		} else if tagDesc == nil {
+			return ast.IntNumberTerm(0), nil
+		}
+		manifest, err := funcContext.getPlatformManifest(bctx.Context, repository, tagDesc.Digest, &defaultPlatform)
+		if err != nil {
+			return nil, err
+		} else if manifest == nil {
+			return ast.IntNumberTerm(0), nil
+		}
+
+		return ast.IntNumberTerm(len(manifest.Layers)), nil
+	},
+)
+
+// ociRepositorySizeBuiltin returns the total size in bytes of the blobs
+// referenced by the repository manifests, blobs shared by several manifests
+// are counted once. It returns 0 if the repository is unknown.
+var ociRepositorySizeBuiltin = rego.Function1(
+	&rego.Function{
+		Name:             "oci.repository_size",
+		Decl:             types.NewFunction(types.Args(types.S), types.N),
+		Nondeterministic: true,
+	},
+	func(bctx rego.BuiltinContext, a *ast.Term) (_ *ast.Term, errFn error) {
+		funcContext, err := getFuncContext(&bctx)
+		if err != nil {
+			return nil, err
+		}
+		defer funcContext.cancelOnError(bctx, "oci.repository_size", &errFn)
+
+		astRepository, ok := a.Value.(ast.String)
+		if !ok {
+			return nil, fmt.Errorf("repository is not a string")
+		}
+		namedRef, err := reference.WithName(string(astRepository))
+		if err != nil {
+			return nil, fmt.Errorf("bad repository name %s", astRepository)
+		}
+
+		repository, err := funcContext.registry.Repository(bctx.Context, namedRef)
+		if err != nil {
+			if isUnknown(err) {
+				return ast.IntNumberTerm(0), nil
+			}
+			return nil, fmt.Errorf("while getting repository %s: %w", namedRef, err)
+		}
+		size, err := funcContext.getRepositorySize(bctx.Context, repository)
+		if err != nil {
+			return nil, err
+		}
+
+		return int64Term(size), nil
+	},
+)
+
+// ociManifestRawBuiltin returns the manifest of a tag as stored by the registry,
+// indexes included. It returns an empty string if the tag is unknown.
+var ociManifestRawBuiltin = rego.Function1(
+	&rego.Function{
+		Name:             "oci.manifest_raw",
+		Decl:             types.NewFunction(types.Args(types.S), types.S),
+		Nondeterministic: true,
+	},
+	func(bctx rego.BuiltinContext, a *ast.Term) (_ *ast.Term, errFn error) {
+		funcContext, err := getFuncContext(&bctx)
+		if err != nil {
+			return nil, err
+		}
+		defer funcContext.cancelOnError(bctx, "oci.manifest_raw", &errFn)
+
+		astRef, ok := a.Value.(ast.String)
+		if !ok {
+			return nil, fmt.Errorf("oci reference is not a string")
+		}
+
+		repository, tagDesc, err := funcContext.resolveTag(bctx.Context, string(astRef))
+		if err != nil {
+			return nil, err
+		} else if tagDesc == nil {
+			return ast.StringTerm(""), nil
+		}
+		// the payload is returned as stored so that its digest can be checked
+		_, payload, err := funcContext.getManifestPayload(bctx.Context, repository, tagDesc.Digest)
+		if err != nil {
+			return nil, err
+		}
+
+		return ast.StringTerm(string(payload)), nil
+	},
+)
+
+// ociCreatedBeforeBuiltin returns true if the image was created before the
+// RFC 3339 time. It's undefined if the tag is unknown, references an index or
+// if the image config has no created field.
+var ociCreatedBeforeBuiltin = rego.Function2(
+	&rego.Function{
+		Name:             "oci.created_before",
+		Decl:             types.NewFunction(types.Args(types.S, types.S), types.B),
+		Nondeterministic: true,
+	},
+	func(bctx rego.BuiltinContext, a, b *ast.Term) (_ *ast.Term, errFn error) {
+		funcContext, err := getFuncContext(&bctx)
+		if err != nil {
+			return nil, err
+		}
+		defer funcContext.cancelOnError(bctx, "oci.created_before", &errFn)
+
+		astRef, ok := a.Value.(ast.String)
+		if !ok {
+			return nil, fmt.Errorf("oci reference is not a string")
+		}
+		astTime, ok := b.Value.(ast.String)
+		if !ok {
+			return nil, fmt.Errorf("time is not a string")
+		}
+		t, err := time.Parse(time.RFC3339, string(astTime))
+		if err != nil {
+			return nil, fmt.Errorf("bad RFC 3339 time %s: %w", astTime, err)
+		}
+
+		config, err := funcContext.resolveConfig(bctx.Context, string(astRef))
+		if err != nil {
+			return nil, err
+		} else if config == nil || config.Created.IsZero() {
+			// undefined without creation time
+			return nil, nil
+		}
+
+		return ast.BooleanTerm(config.Created.Before(t)), nil
+	},
+)
+
+// ociSignatureVerifiedBuiltin returns true if the image has at least one
+// cosign signature valid for the PEM encoded public key.
+var ociSignatureVerifiedBuiltin = rego.Function2(
+	&rego.Function{
+		Name:             "oci.signature_verified",
+		Decl:             types.NewFunction(types.Args(types.S, types.S), types.B),
+		Nondeterministic: true,
+	},
+	func(bctx rego.BuiltinContext, a, b *ast.Term) (_ *ast.Term, errFn error) {
+		funcContext, err := getFuncContext(&bctx)
+		if err != nil {
+			return nil, err
+		}
+		defer funcContext.cancelOnError(bctx, "oci.signature_verified", &errFn)
+
+		if !funcContext.router.signatures {
+			return nil, errSignatureVerificationDisabled
+		}
+
+		astRef, ok := a.Value.(ast.String)
+		if !ok {
+			return nil, fmt.Errorf("oci reference is not a string")
+		}
+		astKey, ok := b.Value.(ast.String)
+		if !ok {
+			return nil, fmt.Errorf("public key is not a string")
+		}
+		key, err := parsePublicKey(string(astKey))
+		if err != nil {
+			return nil, err
+		}
+
+		repository, tagDesc, err := funcContext.resolveTag(bctx.Context, string(astRef))
+		if err != nil {
+			return nil, err
+		} else if tagDesc == nil {
+			return ast.BooleanTerm(false), nil
+		}
+
+		verified, err := funcContext.signatureVerified(bctx.Context, repository, tagDesc.Digest, key)
+		if err != nil {
+			return nil, err
+		}
+
+		return ast.BooleanTerm(verified), nil
+	},
+)
+
+// ociDigestAlgorithmBuiltin returns the algorithm of the manifest digest
+// referenced by a tag or a digest reference, an empty string is returned
+// for an unknown tag. For example, a policy denying the images whose
+// manifest isn't addressed by a sha256 digest:
+//
+//	package router
+//
+//	default output := {"found": false}
+//
+//	output := {"found": false, "deny": "manifest digest is not sha256"} {
+//		algorithm := oci.digest_algorithm("library/alpine:latest")
+//		algorithm != ""
+//		algorithm != "sha256"
+//	}
+var ociDigestAlgorithmBuiltin = rego.Function1(
+	&rego.Function{
+		Name:             "oci.digest_algorithm",
+		Decl:             types.NewFunction(types.Args(types.S), types.S),
+		Nondeterministic: true,
+	},
+	func(bctx rego.BuiltinContext, a *ast.Term) (_ *ast.Term, errFn error) {
+		funcContext, err := getFuncContext(&bctx)
+		if err != nil {
+			return nil, err
+		}
+		defer funcContext.cancelOnError(bctx, "oci.digest_algorithm", &errFn)
+
+		astRef, ok := a.Value.(ast.String)
+		if !ok {
+			return nil, fmt.Errorf("oci reference is not a string")
+		}
+
+		_, tagDesc, err := funcContext.resolveTag(bctx.Context, string(astRef))
+		if err != nil {
+			return nil, err
+		} else if tagDesc == nil {
+			return ast.StringTerm(""), nil
+		}
+
+		return ast.StringTerm(tagDesc.Digest.Algorithm().String()), nil
+	},
+)
+
+// titleAnnotation is the layer annotation holding the file name of an artifact layer.
+const titleAnnotation = "org.opencontainers.image.title"
+
+// ociBlobDigestByTitleBuiltin returns the hex digest of the first layer whose
+// title annotation is the file name, like oci.blob_digest an empty string is
+// returned if there is no such layer.
+var ociBlobDigestByTitleBuiltin = rego.Function2(
+	&rego.Function{
+		Name:             "oci.blob_digest_by_title",
+		Decl:             types.NewFunction(types.Args(types.S, types.S), types.S),
+		Nondeterministic: true,
+	},
+	func(bctx rego.BuiltinContext, a, b *ast.Term) (_ *ast.Term, errFn error) {
+		funcContext, err := getFuncContext(&bctx)
+		if err != nil {
+			return nil, err
+		}
+		defer funcContext.cancelOnError(bctx, "oci.blob_digest_by_title", &errFn)
+
+		astRef, ok := a.Value.(ast.String)
+		if !ok {
+			return nil, fmt.Errorf("oci reference is not a string")
+		}
+		astFilename, ok := b.Value.(ast.String)
+		if !ok {
+			return nil, fmt.Errorf("filename is not a string")
+		}
+
+		repository, tagDesc, err := funcContext.resolveTag(bctx.Context, string(astRef))
+		if err != nil {
+			return nil, err
+		} else if tagDesc == nil {
+			return ast.StringTerm(""), nil
+		}
+		manifest, err := funcContext.getPlatformManifest(bctx.Context, repository, tagDesc.Digest, &defaultPlatform)
+		if err != nil {
+			return nil, err
+		} else if manifest == nil {
+			return ast.StringTerm(""), nil
+		}
+
+		for _, layer := range manifest.Layers {
+			if title, ok := layer.Annotations[titleAnnotation]; ok && title == string(astFilename) {
+				return ast.StringTerm(layer.Digest.Hex), nil
+			}
+		}
+
+		return ast.StringTerm(""), nil
+	},
+)
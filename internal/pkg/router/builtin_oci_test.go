@@ -0,0 +1,1726 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/require"
+	"go.ciq.dev/beskar/internal/pkg/router/registrytest"
+)
+
+// evalBuiltin evaluates the rego expression through a routing decision for
+// the request, a GET / request if nil, and returns the JSON encoded value of
+// the expression or "undefined" if the expression is undefined.
+func evalBuiltin(t *testing.T, registry distribution.Namespace, req *http.Request, expr string, options ...RegoRouterOption) (string, error) {
+	router, err := New("test", fmt.Sprintf(`
+package router
+
+default value := "undefined"
+
+value := json.marshal(%s)
+
+output := {
+	"repository": value,
+	"found": true,
+}
+`, expr), options...)
+	require.NoError(t, err)
+
+	if req == nil {
+		req = httptest.NewRequest(http.MethodGet, "/", nil)
+	}
+	result, err := router.Decision(req, registry)
+	if err != nil {
+		return "", err
+	}
+	return result.Repository, nil
+}
+
+// configImage tags a library/alpine image manifest referencing the
+// config blob and returns the manifest digest.
+func configImage(registry *registrytest.Registry, tag, config string) digest.Digest {
+	configDigest := registry.AddBlob("library/alpine", config)
+	return registry.TagManifest("library/alpine", tag, "application/vnd.oci.image.manifest.v1+json", fmt.Sprintf(`{
+		"schemaVersion": 2,
+		"mediaType": "application/vnd.oci.image.manifest.v1+json",
+		"config": {
+			"mediaType": "application/vnd.oci.image.config.v1+json",
+			"digest": "%s",
+			"size": %d
+		},
+		"layers": [
+			{
+				"mediaType": "application/vnd.oci.image.layer.v1.tar+gzip",
+				"digest": "sha256:2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae",
+				"size": 3
+			}
+		]
+	}`, configDigest, len(config)))
+}
+
+func TestAllLayersPresent(t *testing.T) {
+	registry, armDigest, _ := newIndexRegistry()
+	registry.AddBlob("library/alpine", "{}")
+	registry.AddBlob("library/alpine", "foo")
+	registry.TagManifest("library/alpine", "arm64", "application/vnd.oci.image.index.v1+json", fmt.Sprintf(`{
+		"schemaVersion": 2,
+		"mediaType": "application/vnd.oci.image.index.v1+json",
+		"manifests": [
+			{
+				"mediaType": "application/vnd.oci.image.manifest.v1+json",
+				"digest": "%s",
+				"size": 2,
+				"platform": {"os": "linux", "architecture": "arm64"}
+			}
+		]
+	}`, armDigest))
+
+	tests := []struct {
+		name     string
+		expr     string
+		expected string
+	}{
+		{
+			name:     "all blobs present",
+			expr:     `oci.all_layers_present("library/alpine:latest")`,
+			expected: "true",
+		},
+		{
+			name:     "missing layer",
+			expr:     fmt.Sprintf(`oci.all_layers_present("library/alpine@%s")`, armDigest),
+			expected: "false",
+		},
+		{
+			name:     "index platform manifest",
+			expr:     `oci.all_layers_present("library/alpine:index")`,
+			expected: "true",
+		},
+		{
+			name:     "index without platform manifest",
+			expr:     `oci.all_layers_present("library/alpine:arm64")`,
+			expected: "false",
+		},
+		{
+			name:     "unknown tag",
+			expr:     `oci.all_layers_present("library/alpine:unknown")`,
+			expected: "false",
+		},
+		{
+			name:     "unknown repository",
+			expr:     `oci.all_layers_present("library/unknown:latest")`,
+			expected: "false",
+		},
+		{
+			name: "manifest object without config",
+			expr: `oci.all_layers_present({
+				"schemaVersion": 2,
+				"layers": [{
+					"mediaType": "application/vnd.oci.image.layer.v1.tar+gzip",
+					"digest": "sha256:2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae",
+					"size": 3
+				}]
+			})`,
+			expected: "true",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, err := evalBuiltin(t, registry, nil, tt.expr)
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, value)
+		})
+	}
+}
+
+func TestImageSize(t *testing.T) {
+	registry, armDigest, amd64Digest := newIndexRegistry()
+	registry.TagManifest("library/alpine", "arm64", "application/vnd.oci.image.index.v1+json", fmt.Sprintf(`{
+		"schemaVersion": 2,
+		"mediaType": "application/vnd.oci.image.index.v1+json",
+		"manifests": [
+			{
+				"mediaType": "application/vnd.oci.image.manifest.v1+json",
+				"digest": "%s",
+				"size": 2,
+				"platform": {"os": "linux", "architecture": "arm64"}
+			}
+		]
+	}`, armDigest))
+
+	// manifest, config and layer sizes
+	imageSize := strconv.Itoa(len(sameTagManifest) + 2 + 3)
+
+	tests := []struct {
+		name     string
+		expr     string
+		expected string
+	}{
+		{
+			name:     "tag",
+			expr:     `oci.image_size("library/alpine:latest")`,
+			expected: imageSize,
+		},
+		{
+			name:     "digest reference",
+			expr:     fmt.Sprintf(`oci.image_size("library/alpine@%s")`, amd64Digest),
+			expected: imageSize,
+		},
+		{
+			name:     "same as index size",
+			expr:     `oci.image_size("library/alpine:latest") == oci.index_size("library/alpine:latest")`,
+			expected: "true",
+		},
+		{
+			name:     "index platform manifest",
+			expr:     `oci.image_size("library/alpine:index")`,
+			expected: imageSize,
+		},
+		{
+			name:     "index without platform manifest",
+			expr:     `oci.image_size("library/alpine:arm64")`,
+			expected: "0",
+		},
+		{
+			name:     "unknown tag",
+			expr:     `oci.image_size("library/alpine:unknown")`,
+			expected: "0",
+		},
+		{
+			name:     "unknown repository",
+			expr:     `oci.image_size("library/unknown:latest")`,
+			expected: "0",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, err := evalBuiltin(t, registry, nil, tt.expr)
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, value)
+		})
+	}
+}
+
+func TestIndexSizeRegistryCallBudget(t *testing.T) {
+	registry := newTestRegistry()
+
+	// an index with more children than the default registry call budget
+	children := make([]string, 0, defaultRegistryCallBudget)
+	for i := 0; i < defaultRegistryCallBudget; i++ {
+		manifest := strings.Replace(sameTagManifest, `"size": 3`, fmt.Sprintf(`"size": %d`, i), 1)
+		dgst := registry.AddManifest("library/alpine", "application/vnd.oci.image.manifest.v1+json", manifest)
+		children = append(children, fmt.Sprintf(`{
+			"mediaType": "application/vnd.oci.image.manifest.v1+json",
+			"digest": "%s",
+			"size": %d
+		}`, dgst, len(manifest)))
+	}
+	registry.TagManifest("library/alpine", "large", "application/vnd.oci.image.index.v1+json", fmt.Sprintf(`{
+		"schemaVersion": 2,
+		"mediaType": "application/vnd.oci.image.index.v1+json",
+		"manifests": [%s]
+	}`, strings.Join(children, ",")))
+
+	_, err := evalBuiltin(t, registry, nil, `oci.index_size("library/alpine:large")`)
+	require.ErrorIs(t, err, errRegistryCallBudget)
+
+	// tag and manifest lookups
+	_, err = evalBuiltin(t, registry, nil, `oci.image_size("library/alpine:latest")`, WithRegistryCallBudget(1))
+	require.ErrorIs(t, err, errRegistryCallBudget)
+
+	value, err := evalBuiltin(t, registry, nil, `oci.image_size("library/alpine:latest")`, WithRegistryCallBudget(2))
+	require.NoError(t, err)
+	require.Equal(t, strconv.Itoa(len(sameTagManifest)+2+3), value)
+
+	// no budget
+	_, err = evalBuiltin(t, registry, nil, `oci.index_size("library/alpine:large")`, WithRegistryCallBudget(0))
+	require.NoError(t, err)
+}
+
+func TestCompressionRatio(t *testing.T) {
+	registry, _, _ := newIndexRegistry()
+
+	data := strings.Repeat("beskar", 1000)
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	_, err := gw.Write([]byte(data))
+	require.NoError(t, err)
+	require.NoError(t, gw.Close())
+
+	compressed := buf.String()
+	layerDigest := registry.AddBlob("library/alpine", compressed)
+
+	layerManifest := func(mediaType string, size int) string {
+		return fmt.Sprintf(`{
+			"schemaVersion": 2,
+			"mediaType": "application/vnd.oci.image.manifest.v1+json",
+			"config": {
+				"mediaType": "application/vnd.oci.image.config.v1+json",
+				"digest": "sha256:44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a",
+				"size": 2
+			},
+			"layers": [
+				{
+					"mediaType": "%s",
+					"digest": "%s",
+					"size": %d
+				}
+			]
+		}`, mediaType, layerDigest, size)
+	}
+	gzipManifest := layerManifest("application/vnd.oci.image.layer.v1.tar+gzip", len(compressed))
+	gzipDigest := registry.TagManifest("library/alpine", "gzip", "application/vnd.oci.image.manifest.v1+json", gzipManifest)
+	registry.TagManifest("library/alpine", "gzip-index", "application/vnd.oci.image.index.v1+json", fmt.Sprintf(`{
+		"schemaVersion": 2,
+		"mediaType": "application/vnd.oci.image.index.v1+json",
+		"manifests": [
+			{
+				"mediaType": "application/vnd.oci.image.manifest.v1+json",
+				"digest": "%s",
+				"size": %d,
+				"platform": {"os": "linux", "architecture": "amd64"}
+			}
+		]
+	}`, gzipDigest, len(gzipManifest)))
+	ratio := strconv.Itoa(int(math.Round(float64(len(compressed)) / float64(len(data)) * 1000)))
+	registry.TagManifest("library/alpine", "tar", "application/vnd.oci.image.manifest.v1+json", layerManifest("application/vnd.oci.image.layer.v1.tar", len(compressed)))
+	registry.TagManifest("library/alpine", "zstd", "application/vnd.oci.image.manifest.v1+json", layerManifest("application/vnd.oci.image.layer.v1.tar+zstd", len(compressed)))
+	registry.TagManifest("library/alpine", "4gib", "application/vnd.oci.image.manifest.v1+json", layerManifest("application/vnd.oci.image.layer.v1.tar+gzip", 1<<32))
+
+	tests := []struct {
+		name     string
+		expr     string
+		expected string
+	}{
+		{
+			name:     "gzip layer",
+			expr:     `round(oci.compression_ratio("library/alpine:gzip") * 1000)`,
+			expected: ratio,
+		},
+		{
+			name:     "uncompressed layer",
+			expr:     `oci.compression_ratio("library/alpine:tar")`,
+			expected: "1",
+		},
+		{
+			name:     "unsupported compression",
+			expr:     `oci.compression_ratio("library/alpine:zstd")`,
+			expected: "-1",
+		},
+		{
+			name:     "gzip layer of 4GiB",
+			expr:     `oci.compression_ratio("library/alpine:4gib")`,
+			expected: "-1",
+		},
+		{
+			name:     "unknown layer blob",
+			expr:     `oci.compression_ratio("library/alpine:latest")`,
+			expected: "-1",
+		},
+		{
+			name:     "index platform manifest",
+			expr:     `round(oci.compression_ratio("library/alpine:gzip-index") * 1000)`,
+			expected: ratio,
+		},
+		{
+			name:     "unknown tag",
+			expr:     `oci.compression_ratio("library/alpine:unknown")`,
+			expected: "-1",
+		},
+		{
+			name:     "unknown repository",
+			expr:     `oci.compression_ratio("library/unknown:latest")`,
+			expected: "-1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, err := evalBuiltin(t, registry, nil, tt.expr)
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, value)
+		})
+	}
+}
+
+func TestHasRequiredReferrers(t *testing.T) {
+	registry, _, _ := newIndexRegistry()
+
+	registry.TagManifest("library/alpine", referrersTag(digest.FromString(sameTagManifest)), "application/vnd.oci.image.index.v1+json", `{
+		"schemaVersion": 2,
+		"mediaType": "application/vnd.oci.image.index.v1+json",
+		"manifests": [
+			{
+				"mediaType": "application/vnd.oci.image.manifest.v1+json",
+				"digest": "sha256:44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a",
+				"size": 2,
+				"artifactType": "application/vnd.dev.cosign.artifact.sig.v1+json"
+			},
+			{
+				"mediaType": "application/vnd.oci.image.manifest.v1+json",
+				"digest": "sha256:fcde2b2edba56bf408601fb721fe9b5c338d10ee429ea04fae5511b68fbf8fb9",
+				"size": 2,
+				"artifactType": "application/spdx+json"
+			}
+		]
+	}`)
+
+	tests := []struct {
+		name     string
+		expr     string
+		expected string
+	}{
+		{
+			name:     "required referrer",
+			expr:     `oci.has_required_referrers("library/alpine:latest", ["application/spdx+json"])`,
+			expected: "true",
+		},
+		{
+			name:     "all required referrers",
+			expr:     `oci.has_required_referrers("library/alpine:latest", {"application/spdx+json", "application/vnd.dev.cosign.artifact.sig.v1+json"})`,
+			expected: "true",
+		},
+		{
+			name:     "missing referrer",
+			expr:     `oci.has_required_referrers("library/alpine:latest", ["application/spdx+json", "application/vnd.in-toto+json"])`,
+			expected: "false",
+		},
+		{
+			name:     "no required referrer",
+			expr:     `oci.has_required_referrers("library/alpine:latest", [])`,
+			expected: "true",
+		},
+		{
+			name:     "index without referrers",
+			expr:     `oci.has_required_referrers("library/alpine:index", ["application/spdx+json"])`,
+			expected: "false",
+		},
+		{
+			name:     "unknown tag",
+			expr:     `oci.has_required_referrers("library/alpine:unknown", [])`,
+			expected: "false",
+		},
+		{
+			name:     "unknown repository",
+			expr:     `oci.has_required_referrers("library/unknown:latest", [])`,
+			expected: "false",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, err := evalBuiltin(t, registry, nil, tt.expr)
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, value)
+		})
+	}
+}
+
+func TestArchitectureCount(t *testing.T) {
+	registry, armDigest, amd64Digest := newIndexRegistry()
+	registry.TagManifest("library/alpine", "variants", "application/vnd.oci.image.index.v1+json", fmt.Sprintf(`{
+		"schemaVersion": 2,
+		"mediaType": "application/vnd.oci.image.index.v1+json",
+		"manifests": [
+			{
+				"mediaType": "application/vnd.oci.image.manifest.v1+json",
+				"digest": "%s",
+				"size": 2,
+				"platform": {"os": "linux", "architecture": "arm", "variant": "v6"}
+			},
+			{
+				"mediaType": "application/vnd.oci.image.manifest.v1+json",
+				"digest": "%s",
+				"size": 2,
+				"platform": {"os": "linux", "architecture": "arm", "variant": "v7"}
+			},
+			{
+				"mediaType": "application/vnd.oci.image.manifest.v1+json",
+				"digest": "%s",
+				"size": 2,
+				"annotations": {"vnd.docker.reference.type": "attestation-manifest"}
+			}
+		]
+	}`, armDigest, armDigest, amd64Digest))
+
+	tests := []struct {
+		name     string
+		ref      string
+		expected string
+	}{
+		{
+			name:     "index",
+			ref:      "library/alpine:index",
+			expected: "2",
+		},
+		{
+			name:     "index variants",
+			ref:      "library/alpine:variants",
+			expected: "1",
+		},
+		{
+			name:     "image manifest",
+			ref:      "library/alpine:latest",
+			expected: "1",
+		},
+		{
+			name:     "unknown tag",
+			ref:      "library/alpine:unknown",
+			expected: "0",
+		},
+		{
+			name:     "unknown repository",
+			ref:      "library/unknown:latest",
+			expected: "0",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, err := evalBuiltin(t, registry, nil, fmt.Sprintf(`oci.architecture_count(%q)`, tt.ref))
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, value)
+		})
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	registry, armDigest, _ := newIndexRegistry()
+
+	configData := `{"architecture": "amd64", "os": "linux", "config": {"Labels": {"team": "platform", "tier": "1"}}}`
+	configDigest := registry.AddBlob("library/alpine", configData)
+	registry.TagManifest("library/alpine", "labeled", "application/vnd.oci.image.manifest.v1+json", fmt.Sprintf(`{
+		"schemaVersion": 2,
+		"mediaType": "application/vnd.oci.image.manifest.v1+json",
+		"config": {
+			"mediaType": "application/vnd.oci.image.config.v1+json",
+			"digest": "%s",
+			"size": %d
+		},
+		"layers": [],
+		"annotations": {"team": "security"}
+	}`, configDigest, len(configData)))
+	registry.TagManifest("library/alpine", "annotated-index", "application/vnd.oci.image.index.v1+json", fmt.Sprintf(`{
+		"schemaVersion": 2,
+		"mediaType": "application/vnd.oci.image.index.v1+json",
+		"manifests": [
+			{
+				"mediaType": "application/vnd.oci.image.manifest.v1+json",
+				"digest": "%s",
+				"size": 2,
+				"platform": {"os": "linux", "architecture": "arm64"}
+			}
+		],
+		"annotations": {"org.opencontainers.image.authors": "infra@ciq.com"}
+	}`, armDigest))
+
+	tests := []struct {
+		name     string
+		ref      string
+		expected string
+	}{
+		{
+			name:     "labels and annotations",
+			ref:      "library/alpine:labeled",
+			expected: `{"team":"security","tier":"1"}`,
+		},
+		{
+			name:     "unknown config blob",
+			ref:      "library/alpine:latest",
+			expected: `{}`,
+		},
+		{
+			name:     "index annotations",
+			ref:      "library/alpine:annotated-index",
+			expected: `{"org.opencontainers.image.authors":"infra@ciq.com"}`,
+		},
+		{
+			name:     "index without annotations",
+			ref:      "library/alpine:index",
+			expected: `{}`,
+		},
+		{
+			name:     "unknown tag",
+			ref:      "library/alpine:unknown",
+			expected: `{}`,
+		},
+		{
+			name:     "unknown repository",
+			ref:      "library/unknown:latest",
+			expected: `{}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, err := evalBuiltin(t, registry, nil, fmt.Sprintf(`oci.metadata(%q)`, tt.ref))
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, value)
+		})
+	}
+
+	// blob lookup failures aren't unknown config blobs
+	storageErr := errors.New("storage unavailable")
+	registry.FailWith(storageErr, registrytest.BlobLookup)
+
+	_, err := evalBuiltin(t, registry, nil, `oci.metadata("library/alpine:labeled")`)
+	require.ErrorIs(t, err, storageErr)
+
+	// indexes don't reference any config blob
+	value, err := evalBuiltin(t, registry, nil, `oci.metadata("library/alpine:annotated-index")`)
+	require.NoError(t, err)
+	require.Equal(t, `{"org.opencontainers.image.authors":"infra@ciq.com"}`, value)
+}
+
+func TestHasDeprecatedTypes(t *testing.T) {
+	registry, _, _ := newIndexRegistry()
+	registry.TagManifest("library/alpine", "schema1", "application/vnd.docker.distribution.manifest.v1+prettyjws", `{"schemaVersion": 1}`)
+	registry.TagManifest("library/alpine", "foreign", "application/vnd.oci.image.manifest.v1+json", strings.Replace(sameTagManifest, "application/vnd.oci.image.layer.v1.tar+gzip", "application/vnd.docker.image.rootfs.foreign.diff.tar.gzip", 1))
+	registry.TagManifest("library/alpine", "docker", "application/vnd.docker.distribution.manifest.v2+json", strings.Replace(sameTagManifest, "application/vnd.oci.image.config.v1+json", "application/vnd.docker.container.image.v1+json", 1))
+
+	tests := []struct {
+		name     string
+		ref      string
+		options  []RegoRouterOption
+		expected string
+	}{
+		{
+			name:     "signed schema1 manifest",
+			ref:      "library/alpine:schema1",
+			expected: "true",
+		},
+		{
+			name:     "foreign layer",
+			ref:      "library/alpine:foreign",
+			expected: "true",
+		},
+		{
+			name:     "image manifest",
+			ref:      "library/alpine:latest",
+			expected: "false",
+		},
+		{
+			name:     "index",
+			ref:      "library/alpine:index",
+			expected: "false",
+		},
+		{
+			name:     "deprecated config media type",
+			ref:      "library/alpine:docker",
+			options:  []RegoRouterOption{WithDeprecatedMediaTypes("application/vnd.docker.container.image.v1+json")},
+			expected: "true",
+		},
+		{
+			name:     "deprecated media types replaced",
+			ref:      "library/alpine:foreign",
+			options:  []RegoRouterOption{WithDeprecatedMediaTypes("application/vnd.docker.container.image.v1+json")},
+			expected: "false",
+		},
+		{
+			name:     "unknown tag",
+			ref:      "library/alpine:unknown",
+			expected: "false",
+		},
+		{
+			name:     "unknown repository",
+			ref:      "library/unknown:latest",
+			expected: "false",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, err := evalBuiltin(t, registry, nil, fmt.Sprintf(`oci.has_deprecated_types(%q)`, tt.ref), tt.options...)
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, value)
+		})
+	}
+}
+
+func TestManifestWithinLimit(t *testing.T) {
+	registry, _, amd64Digest := newIndexRegistry()
+
+	tests := []struct {
+		name     string
+		expr     string
+		expected string
+	}{
+		{
+			name:     "within limit",
+			expr:     fmt.Sprintf(`oci.manifest_within_limit("library/alpine:latest", %d)`, len(sameTagManifest)),
+			expected: "true",
+		},
+		{
+			name:     "above limit",
+			expr:     fmt.Sprintf(`oci.manifest_within_limit("library/alpine:latest", %d)`, len(sameTagManifest)-1),
+			expected: "false",
+		},
+		{
+			name:     "digest reference",
+			expr:     fmt.Sprintf(`oci.manifest_within_limit("library/alpine@%s", %d)`, amd64Digest, len(sameTagManifest)-1),
+			expected: "false",
+		},
+		{
+			name:     "index within limit",
+			expr:     `oci.manifest_within_limit("library/alpine:index", count(oci.manifest_raw("library/alpine:index")))`,
+			expected: "true",
+		},
+		{
+			name:     "index above limit",
+			expr:     `oci.manifest_within_limit("library/alpine:index", count(oci.manifest_raw("library/alpine:index")) - 1)`,
+			expected: "false",
+		},
+		{
+			name:     "unknown tag",
+			expr:     `oci.manifest_within_limit("library/alpine:unknown", 0)`,
+			expected: "true",
+		},
+		{
+			name:     "unknown repository",
+			expr:     `oci.manifest_within_limit("library/unknown:latest", 0)`,
+			expected: "true",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, err := evalBuiltin(t, registry, nil, tt.expr)
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, value)
+		})
+	}
+
+	_, err := evalBuiltin(t, registry, nil, `oci.manifest_within_limit("library/alpine:latest", -1)`)
+	require.ErrorContains(t, err, "manifest size limit is not a positive integer")
+}
+
+func TestRepoBlobs(t *testing.T) {
+	registry, _, _ := newIndexRegistry()
+	registry.TagManifest("library/busybox", "latest", "application/vnd.oci.image.manifest.v1+json", sameTagManifest)
+	registry.AddManifest("library/empty", "application/vnd.oci.image.manifest.v1+json", sameTagManifest)
+
+	tests := []struct {
+		name       string
+		repository string
+		expected   []string
+	}{
+		{
+			name:       "index and image manifests",
+			repository: "library/alpine",
+			expected: []string{
+				"sha256:2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae",
+				"sha256:44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a",
+				"sha256:fcde2b2edba56bf408601fb721fe9b5c338d10ee429ea04fae5511b68fbf8fb9",
+			},
+		},
+		{
+			name:       "image manifest",
+			repository: "library/busybox",
+			expected: []string{
+				"sha256:2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae",
+				"sha256:44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a",
+			},
+		},
+		{
+			name:       "untagged manifests",
+			repository: "library/empty",
+			expected:   []string{},
+		},
+		{
+			name:       "unknown repository",
+			repository: "library/unknown",
+			expected:   []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, err := evalBuiltin(t, registry, nil, fmt.Sprintf(`oci.repo_blobs(%q)`, tt.repository))
+			require.NoError(t, err)
+
+			expected, err := json.Marshal(tt.expected)
+			require.NoError(t, err)
+			require.Equal(t, string(expected), value)
+		})
+	}
+
+	storageErr := errors.New("storage unavailable")
+	registry.FailWith(storageErr, registrytest.ManifestLookup)
+
+	_, err := evalBuiltin(t, registry, nil, `oci.repo_blobs("library/alpine")`)
+	require.ErrorIs(t, err, storageErr)
+}
+
+func TestHasHealthcheck(t *testing.T) {
+	registry, _, _ := newIndexRegistry()
+	configImage(registry, "healthcheck", `{"config": {"Healthcheck": {"Test": ["CMD-SHELL", "curl -f http://localhost/"]}}}`)
+	configImage(registry, "disabled", `{"config": {"Healthcheck": {"Test": ["NONE"]}}}`)
+	configImage(registry, "none", `{"config": {"Cmd": ["/bin/sh"]}}`)
+
+	tests := []struct {
+		name     string
+		ref      string
+		expected string
+	}{
+		{
+			name:     "healthcheck",
+			ref:      "library/alpine:healthcheck",
+			expected: "true",
+		},
+		{
+			name:     "disabled healthcheck",
+			ref:      "library/alpine:disabled",
+			expected: "false",
+		},
+		{
+			name:     "no healthcheck",
+			ref:      "library/alpine:none",
+			expected: "false",
+		},
+		{
+			name:     "unknown config blob",
+			ref:      "library/alpine:latest",
+			expected: "false",
+		},
+		{
+			name:     "index",
+			ref:      "library/alpine:index",
+			expected: "false",
+		},
+		{
+			name:     "unknown tag",
+			ref:      "library/alpine:unknown",
+			expected: "false",
+		},
+		{
+			name:     "unknown repository",
+			ref:      "library/unknown:latest",
+			expected: "false",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, err := evalBuiltin(t, registry, nil, fmt.Sprintf(`oci.has_healthcheck(%q)`, tt.ref))
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, value)
+		})
+	}
+}
+
+func TestRepoIsNew(t *testing.T) {
+	registry := newTestRegistry()
+	registry.AddManifest("library/untagged", "application/vnd.oci.image.manifest.v1+json", sameTagManifest)
+
+	tests := []struct {
+		name       string
+		repository string
+		expected   string
+	}{
+		{
+			name:       "tagged repository",
+			repository: "library/alpine",
+			expected:   "false",
+		},
+		{
+			name:       "untagged repository",
+			repository: "library/untagged",
+			expected:   "true",
+		},
+		{
+			name:       "unknown repository",
+			repository: "library/unknown",
+			expected:   "true",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, err := evalBuiltin(t, registry, nil, fmt.Sprintf(`oci.repo_is_new(%q)`, tt.repository))
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, value)
+		})
+	}
+
+	_, err := evalBuiltin(t, registry, nil, `oci.repo_is_new("Library/Alpine")`)
+	require.ErrorContains(t, err, "bad repository name")
+
+	storageErr := errors.New("storage unavailable")
+	registry.FailWith(storageErr, registrytest.TagLookup)
+
+	_, err = evalBuiltin(t, registry, nil, `oci.repo_is_new("library/alpine")`)
+	require.ErrorIs(t, err, storageErr)
+}
+
+func TestEmptyLayerCount(t *testing.T) {
+	registry, _, _ := newIndexRegistry()
+	configImage(registry, "history", `{"history": [
+		{"created_by": "ADD rootfs.tar.gz /"},
+		{"created_by": "ENV PATH=/usr/bin", "empty_layer": true},
+		{"created_by": "RUN apk add curl"},
+		{"created_by": "CMD [\"/bin/sh\"]", "empty_layer": true}
+	]}`)
+	configImage(registry, "no-history", `{"architecture": "amd64", "os": "linux"}`)
+
+	tests := []struct {
+		name     string
+		ref      string
+		expected string
+	}{
+		{
+			name:     "empty layers",
+			ref:      "library/alpine:history",
+			expected: "2",
+		},
+		{
+			name:     "no history",
+			ref:      "library/alpine:no-history",
+			expected: "0",
+		},
+		{
+			name:     "unknown config blob",
+			ref:      "library/alpine:latest",
+			expected: "0",
+		},
+		{
+			name:     "index",
+			ref:      "library/alpine:index",
+			expected: "0",
+		},
+		{
+			name:     "unknown tag",
+			ref:      "library/alpine:unknown",
+			expected: "0",
+		},
+		{
+			name:     "unknown repository",
+			ref:      "library/unknown:latest",
+			expected: "0",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, err := evalBuiltin(t, registry, nil, fmt.Sprintf(`oci.empty_layer_count(%q)`, tt.ref))
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, value)
+		})
+	}
+}
+
+func TestValidRootFS(t *testing.T) {
+	registry, _, _ := newIndexRegistry()
+	configImage(registry, "valid", `{"rootfs": {"type": "layers", "diff_ids": ["sha256:2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae"]}}`)
+	configImage(registry, "no-diff-ids", `{"rootfs": {"type": "layers", "diff_ids": []}}`)
+	configImage(registry, "bad-type", `{"rootfs": {"type": "tarball", "diff_ids": ["sha256:2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae"]}}`)
+	configImage(registry, "malformed", `{"rootfs": "layers"}`)
+
+	tests := []struct {
+		name     string
+		ref      string
+		expected string
+	}{
+		{
+			name:     "valid rootfs",
+			ref:      "library/alpine:valid",
+			expected: "true",
+		},
+		{
+			name:     "no diff IDs",
+			ref:      "library/alpine:no-diff-ids",
+			expected: "false",
+		},
+		{
+			name:     "bad rootfs type",
+			ref:      "library/alpine:bad-type",
+			expected: "false",
+		},
+		{
+			name:     "malformed config",
+			ref:      "library/alpine:malformed",
+			expected: "false",
+		},
+		{
+			name:     "unknown config blob",
+			ref:      "library/alpine:latest",
+			expected: "false",
+		},
+		{
+			name:     "index",
+			ref:      "library/alpine:index",
+			expected: "false",
+		},
+		{
+			name:     "unknown tag",
+			ref:      "library/alpine:unknown",
+			expected: "false",
+		},
+		{
+			name:     "unknown repository",
+			ref:      "library/unknown:latest",
+			expected: "false",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, err := evalBuiltin(t, registry, nil, fmt.Sprintf(`oci.valid_rootfs(%q)`, tt.ref))
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, value)
+		})
+	}
+}
+
+func TestSameConfig(t *testing.T) {
+	registry, armDigest, _ := newIndexRegistry()
+	registry.Tag("library/alpine", "arm64", armDigest)
+	registry.TagManifest("library/busybox", "latest", "application/vnd.oci.image.manifest.v1+json", sameTagManifest)
+	configImage(registry, "other", `{"architecture": "amd64", "os": "linux"}`)
+
+	tests := []struct {
+		name     string
+		a, b     string
+		expected string
+	}{
+		{
+			name:     "same manifest",
+			a:        "library/alpine:latest",
+			b:        "library/busybox:latest",
+			expected: "true",
+		},
+		{
+			name:     "other layers",
+			a:        "library/alpine:latest",
+			b:        "library/alpine:arm64",
+			expected: "true",
+		},
+		{
+			name:     "other config",
+			a:        "library/alpine:latest",
+			b:        "library/alpine:other",
+			expected: "false",
+		},
+		{
+			name:     "index",
+			a:        "library/alpine:latest",
+			b:        "library/alpine:index",
+			expected: "false",
+		},
+		{
+			name:     "unknown tag",
+			a:        "library/alpine:unknown",
+			b:        "library/alpine:latest",
+			expected: "false",
+		},
+		{
+			name:     "unknown repository",
+			a:        "library/alpine:latest",
+			b:        "library/unknown:latest",
+			expected: "false",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, err := evalBuiltin(t, registry, nil, fmt.Sprintf(`oci.same_config(%q, %q)`, tt.a, tt.b))
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, value)
+		})
+	}
+}
+
+func TestRequiresSubject(t *testing.T) {
+	registry, _, _ := newIndexRegistry()
+	registry.TagManifest("library/alpine", "attestation", "application/vnd.oci.image.manifest.v1+json", `{
+		"schemaVersion": 2,
+		"mediaType": "application/vnd.oci.image.manifest.v1+json",
+		"config": {
+			"mediaType": "application/vnd.oci.empty.v1+json",
+			"digest": "sha256:44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a",
+			"size": 2
+		},
+		"layers": [],
+		"subject": {
+			"mediaType": "application/vnd.oci.image.manifest.v1+json",
+			"digest": "sha256:2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae",
+			"size": 3
+		}
+	}`)
+
+	tests := []struct {
+		name      string
+		reference string
+		patterns  string
+		expected  string
+	}{
+		{
+			name:      "standalone image",
+			reference: "library/alpine:latest",
+			patterns:  `["library/*"]`,
+			expected:  "true",
+		},
+		{
+			name:      "standalone index",
+			reference: "library/alpine:index",
+			patterns:  `["library/*"]`,
+			expected:  "true",
+		},
+		{
+			name:      "subject",
+			reference: "library/alpine:attestation",
+			patterns:  `["library/*"]`,
+			expected:  "false",
+		},
+		{
+			name:      "repository not matching",
+			reference: "library/alpine:latest",
+			patterns:  `["attestations/*"]`,
+			expected:  "false",
+		},
+		{
+			name:      "unknown tag",
+			reference: "library/alpine:unknown",
+			patterns:  `["library/*"]`,
+			expected:  "false",
+		},
+		{
+			name:      "unknown repository",
+			reference: "library/unknown:latest",
+			patterns:  `["library/*"]`,
+			expected:  "false",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, err := evalBuiltin(t, registry, nil, fmt.Sprintf(`oci.requires_subject(%q, %s)`, tt.reference, tt.patterns))
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, value)
+		})
+	}
+
+	storageErr := errors.New("storage unavailable")
+	registry.FailWith(storageErr, registrytest.ManifestLookup)
+
+	_, err := evalBuiltin(t, registry, nil, `oci.requires_subject("library/alpine:latest", ["library/*"])`)
+	require.ErrorIs(t, err, storageErr)
+}
+
+func TestNewestLayerAgeDays(t *testing.T) {
+	now := time.Now().UTC()
+	created := func(age time.Duration) string {
+		return now.Add(-age).Format(time.RFC3339)
+	}
+
+	registry, _, _ := newIndexRegistry()
+	configImage(registry, "old", fmt.Sprintf(`{"history": [{"created": %q}, {"created": %q}]}`, created(30*24*time.Hour), created(10*24*time.Hour+time.Hour)))
+	configImage(registry, "future", fmt.Sprintf(`{"history": [{"created": %q}]}`, created(-24*time.Hour)))
+	configImage(registry, "no-history", `{"architecture": "amd64", "os": "linux"}`)
+
+	tests := []struct {
+		name     string
+		ref      string
+		expected string
+	}{
+		{
+			name:     "newest history entry",
+			ref:      "library/alpine:old",
+			expected: "10",
+		},
+		{
+			name:     "future history entry",
+			ref:      "library/alpine:future",
+			expected: "0",
+		},
+		{
+			name:     "no history",
+			ref:      "library/alpine:no-history",
+			expected: "-1",
+		},
+		{
+			name:     "index",
+			ref:      "library/alpine:index",
+			expected: "-1",
+		},
+		{
+			name:     "unknown tag",
+			ref:      "library/alpine:unknown",
+			expected: "-1",
+		},
+		{
+			name:     "unknown repository",
+			ref:      "library/unknown:latest",
+			expected: "-1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, err := evalBuiltin(t, registry, nil, fmt.Sprintf(`oci.newest_layer_age_days(%q)`, tt.ref))
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, value)
+		})
+	}
+}
+
+func TestSizeDeclarationValid(t *testing.T) {
+	registry, _, _ := newIndexRegistry()
+	registry.AddBlob("library/alpine", "{}")
+	registry.AddBlob("library/alpine", "foo")
+	registry.TagManifest("library/alpine", "lying", "application/vnd.oci.image.manifest.v1+json", strings.Replace(sameTagManifest, `"size": 3`, `"size": 4`, 1))
+
+	tests := []struct {
+		name     string
+		ref      string
+		expected string
+	}{
+		{
+			name:     "valid sizes",
+			ref:      "library/alpine:latest",
+			expected: "true",
+		},
+		{
+			name:     "size mismatch",
+			ref:      "library/alpine:lying",
+			expected: "false",
+		},
+		{
+			name:     "index with unknown layer blob",
+			ref:      "library/alpine:index",
+			expected: "false",
+		},
+		{
+			name:     "unknown tag",
+			ref:      "library/alpine:unknown",
+			expected: "false",
+		},
+		{
+			name:     "unknown repository",
+			ref:      "library/unknown:latest",
+			expected: "false",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, err := evalBuiltin(t, registry, nil, fmt.Sprintf(`oci.size_declaration_valid(%q)`, tt.ref))
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, value)
+		})
+	}
+
+	registry.AddBlob("library/alpine", "bar")
+
+	value, err := evalBuiltin(t, registry, nil, `oci.size_declaration_valid("library/alpine:index")`)
+	require.NoError(t, err)
+	require.Equal(t, "true", value)
+
+	// tag and manifest lookups and two blob stats
+	_, err = evalBuiltin(t, registry, nil, `oci.size_declaration_valid("library/alpine:latest")`, WithRegistryCallBudget(3))
+	require.ErrorIs(t, err, errRegistryCallBudget)
+
+	value, err = evalBuiltin(t, registry, nil, `oci.size_declaration_valid("library/alpine:latest")`, WithRegistryCallBudget(4))
+	require.NoError(t, err)
+	require.Equal(t, "true", value)
+
+	storageErr := errors.New("storage unavailable")
+	registry.FailWith(storageErr, registrytest.BlobLookup)
+
+	_, err = evalBuiltin(t, registry, nil, `oci.size_declaration_valid("library/alpine:latest")`)
+	require.ErrorIs(t, err, storageErr)
+}
+
+func TestExpectedPinnedTag(t *testing.T) {
+	registry, _, amd64Digest := newIndexRegistry()
+	indexDigest, ok := registry.TagDigest("library/alpine", "index")
+	require.True(t, ok)
+
+	tests := []struct {
+		name     string
+		ref      string
+		expected string
+	}{
+		{
+			name:     "tag",
+			ref:      "library/alpine:latest",
+			expected: "library/alpine@" + amd64Digest.String(),
+		},
+		{
+			name:     "default tag",
+			ref:      "library/alpine",
+			expected: "library/alpine@" + amd64Digest.String(),
+		},
+		{
+			name:     "index",
+			ref:      "library/alpine:index",
+			expected: "library/alpine@" + indexDigest.String(),
+		},
+		{
+			name:     "pinned reference",
+			ref:      "library/alpine:index@" + amd64Digest.String(),
+			expected: "library/alpine@" + amd64Digest.String(),
+		},
+		{
+			name:     "unknown tag",
+			ref:      "library/alpine:unknown",
+			expected: "",
+		},
+		{
+			name:     "unknown repository",
+			ref:      "library/unknown:latest",
+			expected: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, err := evalBuiltin(t, registry, nil, fmt.Sprintf(`oci.expected_pinned_tag(%q)`, tt.ref))
+			require.NoError(t, err)
+			require.Equal(t, strconv.Quote(tt.expected), value)
+		})
+	}
+
+	_, err := evalBuiltin(t, registry, nil, `oci.expected_pinned_tag("Library/Alpine:latest")`)
+	require.ErrorContains(t, err, "bad reference")
+}
+
+func TestRepoDedupRatio(t *testing.T) {
+	const (
+		configDigest = "sha256:44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a"
+		layerDigest  = "sha256:2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae"
+	)
+
+	registry := newTestRegistry()
+	// busybox shares the layer blob of library/alpine:latest
+	busybox := strings.Replace(sameTagManifest, configDigest, digest.FromString("busybox").String(), 1)
+	registry.TagManifest("library/busybox", "latest", "application/vnd.oci.image.manifest.v1+json", busybox)
+	unique := strings.Replace(sameTagManifest, configDigest, digest.FromString("unique config").String(), 1)
+	unique = strings.Replace(unique, layerDigest, digest.FromString("unique layer").String(), 1)
+	registry.TagManifest("library/unique", "latest", "application/vnd.oci.image.manifest.v1+json", unique)
+
+	tests := []struct {
+		name       string
+		repository string
+		expected   string
+	}{
+		{
+			name:       "shared layer",
+			repository: "library/alpine",
+			expected:   "0.5",
+		},
+		{
+			name:       "shared layer with unique config",
+			repository: "library/busybox",
+			expected:   "0.5",
+		},
+		{
+			name:       "unique blobs",
+			repository: "library/unique",
+			expected:   "0",
+		},
+		{
+			name:       "unknown repository",
+			repository: "library/unknown",
+			expected:   "0",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, err := evalBuiltin(t, registry, nil, fmt.Sprintf(`oci.repo_dedup_ratio(%q)`, tt.repository))
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, value)
+		})
+	}
+
+	_, err := evalBuiltin(t, registry, nil, `oci.repo_dedup_ratio("Library/Alpine")`)
+	require.ErrorContains(t, err, "bad repository name")
+
+	storageErr := errors.New("storage unavailable")
+	registry.FailWith(storageErr, registrytest.ManifestLookup)
+
+	_, err = evalBuiltin(t, registry, nil, `oci.repo_dedup_ratio("library/alpine")`)
+	require.ErrorIs(t, err, storageErr)
+}
+
+func TestHasDescription(t *testing.T) {
+	registry, armDigest, _ := newIndexRegistry()
+	annotated := func(tag, description string) {
+		manifest := strings.Replace(sameTagManifest, `"layers"`, fmt.Sprintf(`"annotations": {%q: %q},
+	"layers"`, descriptionAnnotation, description), 1)
+		registry.TagManifest("library/alpine", tag, "application/vnd.oci.image.manifest.v1+json", manifest)
+	}
+	annotated("described", "Alpine Linux base image")
+	annotated("blank", "  ")
+	registry.TagManifest("library/alpine", "described-index", "application/vnd.oci.image.index.v1+json", fmt.Sprintf(`{
+		"schemaVersion": 2,
+		"mediaType": "application/vnd.oci.image.index.v1+json",
+		"manifests": [
+			{
+				"mediaType": "application/vnd.oci.image.manifest.v1+json",
+				"digest": "%s",
+				"size": 2,
+				"platform": {"os": "linux", "architecture": "arm64"}
+			}
+		],
+		"annotations": {%q: "Alpine Linux base image"}
+	}`, armDigest, descriptionAnnotation))
+
+	tests := []struct {
+		name     string
+		ref      string
+		expected string
+	}{
+		{
+			name:     "description",
+			ref:      "library/alpine:described",
+			expected: "true",
+		},
+		{
+			name:     "blank description",
+			ref:      "library/alpine:blank",
+			expected: "false",
+		},
+		{
+			name:     "no description",
+			ref:      "library/alpine:latest",
+			expected: "false",
+		},
+		{
+			name:     "index description",
+			ref:      "library/alpine:described-index",
+			expected: "true",
+		},
+		{
+			name:     "index without description",
+			ref:      "library/alpine:index",
+			expected: "false",
+		},
+		{
+			name:     "unknown tag",
+			ref:      "library/alpine:unknown",
+			expected: "false",
+		},
+		{
+			name:     "unknown repository",
+			ref:      "library/unknown:latest",
+			expected: "false",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, err := evalBuiltin(t, registry, nil, fmt.Sprintf(`oci.has_description(%q)`, tt.ref))
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, value)
+		})
+	}
+}
+
+func TestIndexCreatedConsistent(t *testing.T) {
+	registry := newTestRegistry()
+
+	child := func(tag, config, annotations string) string {
+		dgst := configImage(registry, tag, config)
+		return fmt.Sprintf(`{
+			"mediaType": "application/vnd.oci.image.manifest.v1+json",
+			"digest": "%s",
+			"size": 2,
+			"annotations": {%s}
+		}`, dgst, annotations)
+	}
+	index := func(tag string, children ...string) {
+		registry.TagManifest("library/alpine", tag, "application/vnd.oci.image.index.v1+json", fmt.Sprintf(`{
+			"schemaVersion": 2,
+			"mediaType": "application/vnd.oci.image.index.v1+json",
+			"manifests": [%s]
+		}`, strings.Join(children, ",")))
+	}
+
+	amd64 := child("amd64", `{"architecture": "amd64", "created": "2023-06-01T10:00:00Z"}`, "")
+	arm64 := child("arm64", `{"architecture": "arm64", "created": "2023-06-01T10:30:00Z"}`, "")
+	uncreated := child("uncreated", `{"architecture": "arm64"}`, "")
+	attestation := child("attestation", `{"created": "2020-01-01T00:00:00Z"}`, fmt.Sprintf(`%q: %q`, dockerReferenceTypeAnnotation, dockerAttestationManifest))
+
+	index("consistent", amd64, arm64, attestation)
+	index("uncreated", amd64, uncreated)
+
+	tests := []struct {
+		name      string
+		ref       string
+		tolerance int
+		expected  string
+	}{
+		{
+			name:      "within tolerance",
+			ref:       "library/alpine:consistent",
+			tolerance: 3600,
+			expected:  "true",
+		},
+		{
+			name:      "beyond tolerance",
+			ref:       "library/alpine:consistent",
+			tolerance: 60,
+			expected:  "false",
+		},
+		{
+			name:      "missing creation time",
+			ref:       "library/alpine:uncreated",
+			tolerance: 3600,
+			expected:  "false",
+		},
+		{
+			name:      "image manifest",
+			ref:       "library/alpine:amd64",
+			tolerance: 0,
+			expected:  "true",
+		},
+		{
+			name:      "unknown tag",
+			ref:       "library/alpine:unknown",
+			tolerance: 3600,
+			expected:  "false",
+		},
+		{
+			name:      "unknown repository",
+			ref:       "library/unknown:latest",
+			tolerance: 3600,
+			expected:  "false",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, err := evalBuiltin(t, registry, nil, fmt.Sprintf(`oci.index_created_consistent(%q, %d)`, tt.ref, tt.tolerance))
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, value)
+		})
+	}
+
+	_, err := evalBuiltin(t, registry, nil, `oci.index_created_consistent("library/alpine:consistent", -1)`)
+	require.ErrorContains(t, err, "tolerance is not a positive number")
+}
+
+func TestTotalRepositories(t *testing.T) {
+	registry := registrytest.New()
+
+	value, err := evalBuiltin(t, registry, nil, `oci.total_repositories()`)
+	require.NoError(t, err)
+	require.Equal(t, "0", value)
+
+	// more repositories than a catalog page
+	repositories := 2*catalogPageSize + 1
+	for i := 0; i < repositories; i++ {
+		registry.AddBlob(fmt.Sprintf("library/repository%d", i), "{}")
+	}
+
+	value, err = evalBuiltin(t, registry, nil, `oci.total_repositories()`)
+	require.NoError(t, err)
+	require.Equal(t, strconv.Itoa(repositories), value)
+
+	// one catalog call per page
+	_, err = evalBuiltin(t, registry, nil, `oci.total_repositories()`, WithRegistryCallBudget(2))
+	require.ErrorIs(t, err, errRegistryCallBudget)
+
+	value, err = evalBuiltin(t, registry, nil, `oci.total_repositories()`, WithRegistryCallBudget(3))
+	require.NoError(t, err)
+	require.Equal(t, strconv.Itoa(repositories), value)
+}
+
+func TestArtifactTypeRegistered(t *testing.T) {
+	const sbomType = "application/vnd.example.sbom+json"
+
+	registry, _, _ := newIndexRegistry()
+	artifact := func(tag, artifactType, annotations string) {
+		registry.TagManifest("library/alpine", tag, "application/vnd.oci.image.manifest.v1+json", fmt.Sprintf(`{
+			"schemaVersion": 2,
+			"mediaType": "application/vnd.oci.image.manifest.v1+json",
+			"artifactType": %q,
+			"config": {
+				"mediaType": "application/vnd.oci.empty.v1+json",
+				"digest": "sha256:44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a",
+				"size": 2
+			},
+			"layers": [],
+			"annotations": {%s}
+		}`, artifactType, annotations))
+	}
+	artifact("sbom", sbomType, `"org.opencontainers.image.source": "https://github.com/ctrliq/beskar"`)
+	artifact("sbom-unannotated", sbomType, "")
+	artifact("unregistered", "application/vnd.example.unregistered+json", "")
+
+	options := []RegoRouterOption{
+		WithArtifactTypes(
+			ArtifactType{
+				MediaType:           sbomType,
+				RequiredAnnotations: []string{"org.opencontainers.image.source"},
+			},
+			ArtifactType{
+				MediaType: "application/vnd.oci.image.config.v1+json",
+			},
+		),
+	}
+
+	tests := []struct {
+		name     string
+		ref      string
+		expected string
+	}{
+		{
+			name:     "registered artifact type",
+			ref:      "library/alpine:sbom",
+			expected: "true",
+		},
+		{
+			name:     "missing required annotation",
+			ref:      "library/alpine:sbom-unannotated",
+			expected: "false",
+		},
+		{
+			name:     "unregistered artifact type",
+			ref:      "library/alpine:unregistered",
+			expected: "false",
+		},
+		{
+			name:     "config media type",
+			ref:      "library/alpine:latest",
+			expected: "true",
+		},
+		{
+			name:     "index",
+			ref:      "library/alpine:index",
+			expected: "false",
+		},
+		{
+			name:     "unknown tag",
+			ref:      "library/alpine:unknown",
+			expected: "false",
+		},
+		{
+			name:     "unknown repository",
+			ref:      "library/unknown:latest",
+			expected: "false",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, err := evalBuiltin(t, registry, nil, fmt.Sprintf(`oci.artifact_type_registered(%q)`, tt.ref), options...)
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, value)
+		})
+	}
+
+	// no approved artifact types
+	value, err := evalBuiltin(t, registry, nil, `oci.artifact_type_registered("library/alpine:sbom")`)
+	require.NoError(t, err)
+	require.Equal(t, "false", value)
+}
+
+func TestTagList(t *testing.T) {
+	registry, _, _ := newIndexRegistry()
+	registry.AddBlob("library/untagged", "{}")
+
+	tests := []struct {
+		name       string
+		repository string
+		expected   string
+	}{
+		{
+			name:       "tags",
+			repository: "library/alpine",
+			expected:   `["index","latest"]`,
+		},
+		{
+			name:       "untagged repository",
+			repository: "library/untagged",
+			expected:   `[]`,
+		},
+		{
+			name:       "unknown repository",
+			repository: "library/unknown",
+			expected:   `[]`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, err := evalBuiltin(t, registry, nil, fmt.Sprintf(`oci.tag_list(%q)`, tt.repository))
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, value)
+		})
+	}
+
+	_, err := evalBuiltin(t, registry, nil, `oci.tag_list("library/alpine:latest")`)
+	require.ErrorContains(t, err, "bad repository name")
+
+	storageErr := errors.New("storage unavailable")
+	registry.FailWith(storageErr, registrytest.TagLookup)
+
+	_, err = evalBuiltin(t, registry, nil, `oci.tag_list("library/alpine")`)
+	require.ErrorIs(t, err, storageErr)
+}
+
+func TestBlobExists(t *testing.T) {
+	registry := newTestRegistry()
+	blobDigest := registry.AddBlob("library/alpine", "foo")
+
+	tests := []struct {
+		name       string
+		repository string
+		digest     string
+		expected   string
+	}{
+		{
+			name:       "present blob",
+			repository: "library/alpine",
+			digest:     blobDigest.String(),
+			expected:   "true",
+		},
+		{
+			name:       "absent blob",
+			repository: "library/alpine",
+			digest:     digest.FromString("bar").String(),
+			expected:   "false",
+		},
+		{
+			name:       "unknown repository",
+			repository: "library/unknown",
+			digest:     blobDigest.String(),
+			expected:   "false",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, err := evalBuiltin(t, registry, nil, fmt.Sprintf(`oci.blob_exists(%q, %q)`, tt.repository, tt.digest))
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, value)
+		})
+	}
+
+	_, err := evalBuiltin(t, registry, nil, `oci.blob_exists("library/alpine", "sha256:foo")`)
+	require.ErrorContains(t, err, "bad blob digest")
+
+	storageErr := errors.New("storage unavailable")
+	registry.FailWith(storageErr, registrytest.BlobLookup)
+
+	_, err = evalBuiltin(t, registry, nil, fmt.Sprintf(`oci.blob_exists("library/alpine", %q)`, blobDigest))
+	require.ErrorIs(t, err, storageErr)
+}
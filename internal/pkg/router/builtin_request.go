@@ -0,0 +1,227 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/rego"
+	"github.com/open-policy-agent/opa/types"
+)
+
+// requestPathBuiltin returns the URL path of the request.
+var requestPathBuiltin = rego.FunctionDyn(
+	&rego.Function{
+		Name:             "request.path",
+		Decl:             types.NewFunction(types.Args(), types.S),
+		Nondeterministic: true,
+	},
+	func(bctx rego.BuiltinContext, _ []*ast.Term) (_ *ast.Term, errFn error) {
+		funcContext, err := getFuncContext(&bctx)
+		if err != nil {
+			return nil, err
+		}
+		defer funcContext.cancelOnError(bctx, "request.path", &errFn)
+
+		return ast.StringTerm(funcContext.req.URL.Path), nil
+	},
+)
+
+// requestMethodBuiltin returns the HTTP method of the request.
+var requestMethodBuiltin = rego.FunctionDyn(
+	&rego.Function{
+		Name:             "request.method",
+		Decl:             types.NewFunction(types.Args(), types.S),
+		Nondeterministic: true,
+	},
+	func(bctx rego.BuiltinContext, _ []*ast.Term) (_ *ast.Term, errFn error) {
+		funcContext, err := getFuncContext(&bctx)
+		if err != nil {
+			return nil, err
+		}
+		defer funcContext.cancelOnError(bctx, "request.method", &errFn)
+
+		return ast.StringTerm(funcContext.req.Method), nil
+	},
+)
+
+// requestRouteTemplateBuiltin returns the template of the registry API route
+// matching the request path, it returns an empty string if there is none.
+var requestRouteTemplateBuiltin = rego.FunctionDyn(
+	&rego.Function{
+		Name:             "request.route_template",
+		Decl:             types.NewFunction(types.Args(), types.S),
+		Nondeterministic: true,
+	},
+	func(bctx rego.BuiltinContext, _ []*ast.Term) (_ *ast.Term, errFn error) {
+		funcContext, err := getFuncContext(&bctx)
+		if err != nil {
+			return nil, err
+		}
+		defer funcContext.cancelOnError(bctx, "request.route_template", &errFn)
+
+		route, _ := matchV2Route(funcContext.req.URL.Path)
+		if route == nil {
+			return ast.StringTerm(""), nil
+		}
+
+		return ast.StringTerm(route.template), nil
+	},
+)
+
+// requestInMaintenanceWindowBuiltin returns true if the request targets a
+// repository during one of the maintenance windows, the router maintenance
+// windows are used if the array of windows is empty.
+var requestInMaintenanceWindowBuiltin = rego.Function1(
+	&rego.Function{
+		Name:             "request.in_maintenance_window",
+		Decl:             types.NewFunction(types.Args(types.NewArray(nil, anyObject)), types.B),
+		Nondeterministic: true,
+	},
+	func(bctx rego.BuiltinContext, a *ast.Term) (_ *ast.Term, errFn error) {
+		funcContext, err := getFuncContext(&bctx)
+		if err != nil {
+			return nil, err
+		}
+		defer funcContext.cancelOnError(bctx, "request.in_maintenance_window", &errFn)
+
+		var windows []MaintenanceWindow
+
+		if err := decodeValue(a.Value, &windows); err != nil {
+			return nil, fmt.Errorf("bad maintenance windows: %w", err)
+		} else if len(windows) == 0 {
+			// fallback to the router maintenance windows
+			windows = funcContext.router.maintenanceWindows
+		}
+
+		in, err := inMaintenanceWindow(evalTime(bctx), funcContext.requestRepositoryName(), windows)
+		if err != nil {
+			return nil, fmt.Errorf("bad maintenance windows: %w", err)
+		}
+
+		return ast.BooleanTerm(in), nil
+	},
+)
+
+// requestUploadKindBuiltin returns the kind of blob upload of the request,
+// see uploadKind for the returned kinds.
+var requestUploadKindBuiltin = rego.FunctionDyn(
+	&rego.Function{
+		Name:             "request.upload_kind",
+		Decl:             types.NewFunction(types.Args(), types.S),
+		Nondeterministic: true,
+	},
+	func(bctx rego.BuiltinContext, _ []*ast.Term) (_ *ast.Term, errFn error) {
+		funcContext, err := getFuncContext(&bctx)
+		if err != nil {
+			return nil, err
+		}
+		defer funcContext.cancelOnError(bctx, "request.upload_kind", &errFn)
+
+		return ast.StringTerm(uploadKind(funcContext.req)), nil
+	},
+)
+
+// requestHeaderBuiltin returns the first value of a request header, the header
+// name is case-insensitive. It returns an empty string if the header is absent.
+var requestHeaderBuiltin = rego.Function1(
+	&rego.Function{
+		Name:             "request.header",
+		Decl:             types.NewFunction(types.Args(types.S), types.S),
+		Nondeterministic: true,
+	},
+	func(bctx rego.BuiltinContext, a *ast.Term) (_ *ast.Term, errFn error) {
+		funcContext, err := getFuncContext(&bctx)
+		if err != nil {
+			return nil, err
+		}
+		defer funcContext.cancelOnError(bctx, "request.header", &errFn)
+
+		astName, ok := a.Value.(ast.String)
+		if !ok {
+			return nil, fmt.Errorf("header name is not a string")
+		}
+
+		// Header.Get canonicalizes the header name
+		return ast.StringTerm(funcContext.req.Header.Get(string(astName))), nil
+	},
+)
+
+// requestQueryBuiltin returns the first value of a query parameter, it returns
+// an empty string if the parameter is absent.
+var requestQueryBuiltin = rego.Function1(
+	&rego.Function{
+		Name:             "request.query",
+		Decl:             types.NewFunction(types.Args(types.S), types.S),
+		Nondeterministic: true,
+	},
+	func(bctx rego.BuiltinContext, a *ast.Term) (_ *ast.Term, errFn error) {
+		funcContext, err := getFuncContext(&bctx)
+		if err != nil {
+			return nil, err
+		}
+		defer funcContext.cancelOnError(bctx, "request.query", &errFn)
+
+		astName, ok := a.Value.(ast.String)
+		if !ok {
+			return nil, fmt.Errorf("query parameter name is not a string")
+		}
+
+		// the query is parsed once per evaluation and only if needed
+		if funcContext.query == nil {
+			funcContext.query = funcContext.req.URL.Query()
+		}
+
+		return ast.StringTerm(funcContext.query.Get(string(astName))), nil
+	},
+)
+
+// requestRemoteAddrBuiltin returns the client IP address of the request, see remoteAddr.
+var requestRemoteAddrBuiltin = rego.FunctionDyn(
+	&rego.Function{
+		Name:             "request.remote_addr",
+		Decl:             types.NewFunction(types.Args(), types.S),
+		Nondeterministic: true,
+	},
+	func(bctx rego.BuiltinContext, _ []*ast.Term) (_ *ast.Term, errFn error) {
+		funcContext, err := getFuncContext(&bctx)
+		if err != nil {
+			return nil, err
+		}
+		defer funcContext.cancelOnError(bctx, "request.remote_addr", &errFn)
+
+		return ast.StringTerm(remoteAddr(funcContext.req, funcContext.router.trustedProxy)), nil
+	},
+)
+
+// remoteAddr returns the client IP address of the request, it returns an
+// empty string if the address can't be parsed. When the request comes from
+// a trusted proxy, the address is the last X-Forwarded-For address which is
+// the one appended by the proxy.
+func remoteAddr(req *http.Request, trustedProxy bool) string {
+	if trustedProxy {
+		if values := req.Header.Values("X-Forwarded-For"); len(values) > 0 {
+			addresses := strings.Split(values[len(values)-1], ",")
+			addr, err := netip.ParseAddr(strings.TrimSpace(addresses[len(addresses)-1]))
+			if err == nil {
+				return addr.Unmap().String()
+			}
+		}
+	}
+
+	addrPort, err := netip.ParseAddrPort(req.RemoteAddr)
+	if err == nil {
+		return addrPort.Addr().Unmap().String()
+	}
+	// remote address without port
+	addr, err := netip.ParseAddr(req.RemoteAddr)
+	if err != nil {
+		return ""
+	}
+	return addr.Unmap().String()
+}
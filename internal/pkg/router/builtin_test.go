@@ -0,0 +1,1750 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/require"
+	"go.ciq.dev/beskar/internal/pkg/router/registrytest"
+)
+
+const requestBodyModule = `
+package router
+
+output := {
+	"repository": json.marshal(request.body()),
+	"found": true,
+}
+`
+
+// jsonBody returns a JSON object of at least size bytes.
+func jsonBody(t *testing.T, size int) []byte {
+	body := make(map[string]string)
+	for i := 0; i*64 < size; i++ {
+		body[fmt.Sprintf("key%05d", i)] = strings.Repeat("v", 64)
+	}
+	data, err := json.Marshal(body)
+	require.NoError(t, err)
+	return data
+}
+
+func TestRequestBody(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        []byte
+		contentType string
+		options     []RegoRouterOption
+		expectedErr error
+	}{
+		{
+			name: "small body",
+			body: []byte(`{"name":"beskar"}`),
+		},
+		{
+			name: "32KB body",
+			body: jsonBody(t, 32<<10),
+		},
+		{
+			name:        "body exceeding maximum size",
+			body:        jsonBody(t, 32<<10),
+			contentType: "application/json",
+			options: []RegoRouterOption{
+				WithRequestBodyMaxSize(16 << 10),
+			},
+			expectedErr: errRequestBodyTooLarge,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router, err := New("test", requestBodyModule, tt.options...)
+			require.NoError(t, err)
+
+			req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(tt.body))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+
+			result, err := router.Decision(req, nil)
+			if tt.expectedErr != nil {
+				require.ErrorIs(t, err, tt.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			require.JSONEq(t, string(tt.body), result.Repository)
+
+			// the body is still readable after the evaluation
+			body, err := io.ReadAll(req.Body)
+			require.NoError(t, err)
+			require.Equal(t, tt.body, body)
+		})
+	}
+}
+
+func TestRequestBodyReadOnce(t *testing.T) {
+	router, err := New("test", `
+package router
+
+name := request.body().name
+
+kind := request.body().kind
+
+output := {
+	"repository": name,
+	"redirect_url": kind,
+	"found": request.body_parsed() == request.body(),
+}
+`)
+	require.NoError(t, err)
+
+	body := []byte(`{"name":"beskar","kind":"registry"}`)
+
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	result, err := router.Decision(req, nil)
+	require.NoError(t, err)
+	require.Equal(t, "beskar", result.Repository)
+	require.Equal(t, "registry", result.RedirectURL)
+	require.True(t, result.Found)
+
+	// the body is restored once for the downstream handlers
+	restored, err := io.ReadAll(req.Body)
+	require.NoError(t, err)
+	require.Equal(t, body, restored)
+}
+
+func TestRequestBodyBinary(t *testing.T) {
+	module := `
+package router
+
+default found := true
+
+found := false {
+	request.body().name == "beskar"
+}
+
+output := {
+	"repository": json.marshal(request.body()),
+	"found": found,
+}
+`
+
+	// gzipLayer returns a gzip compressed layer of the content.
+	gzipLayer := func(content []byte) []byte {
+		layer := new(bytes.Buffer)
+		gw := gzip.NewWriter(layer)
+		_, err := gw.Write(content)
+		require.NoError(t, err)
+		require.NoError(t, gw.Close())
+		return layer.Bytes()
+	}
+
+	// random content isn't compressible
+	content := make([]byte, 32<<10)
+	_, err := rand.Read(content)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name        string
+		layer       []byte
+		contentType string
+		options     []RegoRouterOption
+	}{
+		{
+			name:        "octet-stream layer",
+			layer:       gzipLayer([]byte("layer content")),
+			contentType: "application/octet-stream",
+		},
+		{
+			name:  "layer without content type",
+			layer: gzipLayer([]byte("layer content")),
+		},
+		{
+			name:  "layer without content type exceeding maximum size",
+			layer: gzipLayer(content),
+			options: []RegoRouterOption{
+				WithRequestBodyMaxSize(16 << 10),
+			},
+		},
+		{
+			name:  "empty body without content type",
+			layer: []byte{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router, err := New("test", module, tt.options...)
+			require.NoError(t, err)
+
+			req := httptest.NewRequest(http.MethodPut, "/v2/library/alpine/blobs/uploads/a6fb8d6c-0a3b-4dda-9d4d-4a4d5e3d6e6f", bytes.NewReader(tt.layer))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+
+			result, err := router.Decision(req, nil)
+			require.NoError(t, err)
+			require.Equal(t, "null", result.Repository)
+			require.True(t, result.Found)
+
+			// the layer is still readable by the downstream handlers
+			body, err := io.ReadAll(req.Body)
+			require.NoError(t, err)
+			require.Equal(t, tt.layer, body)
+		})
+	}
+}
+
+func TestRequestQuery(t *testing.T) {
+	router, err := New("test", `
+package router
+
+output := {
+	"repository": request.query("from"),
+	"redirect_url": request.query("mount"),
+	"found": request.query("digest") == "",
+}
+`)
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodPost, "/v2/library/alpine/blobs/uploads/?mount=sha256:2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae&from=library/busybox&from=library/other", nil)
+	result, err := router.Decision(req, nil)
+	require.NoError(t, err)
+	require.Equal(t, "library/busybox", result.Repository)
+	require.Equal(t, "sha256:2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae", result.RedirectURL)
+	require.True(t, result.Found)
+}
+
+func TestConfigMediaType(t *testing.T) {
+	router, err := New("test", `
+package router
+
+output := {
+	"repository": oci.config_mediatype("library/alpine:latest"),
+	"found": true,
+}
+`)
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	result, err := router.Decision(req, newTestRegistry())
+	require.NoError(t, err)
+	require.Equal(t, "application/vnd.oci.image.config.v1+json", result.Repository)
+}
+
+// newIndexRegistry returns a registry with a linux/arm64 and linux/amd64
+// index tagged index, the amd64 image is the image tagged latest.
+func newIndexRegistry() (registry *registrytest.Registry, armDigest, amd64Digest digest.Digest) {
+	registry = newTestRegistry()
+
+	armManifest := strings.Replace(sameTagManifest, "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae", "fcde2b2edba56bf408601fb721fe9b5c338d10ee429ea04fae5511b68fbf8fb9", 1)
+	armDigest = registry.AddManifest("library/alpine", "application/vnd.oci.image.manifest.v1+json", armManifest)
+	amd64Digest = digest.FromString(sameTagManifest)
+
+	registry.TagManifest("library/alpine", "index", "application/vnd.oci.image.index.v1+json", fmt.Sprintf(`{
+		"schemaVersion": 2,
+		"mediaType": "application/vnd.oci.image.index.v1+json",
+		"manifests": [
+			{
+				"mediaType": "application/vnd.oci.image.manifest.v1+json",
+				"digest": "%s",
+				"size": %d,
+				"platform": {"os": "linux", "architecture": "arm64"}
+			},
+			{
+				"mediaType": "application/vnd.oci.image.manifest.v1+json",
+				"digest": "%s",
+				"size": %d,
+				"platform": {"os": "linux", "architecture": "amd64"}
+			}
+		]
+	}`, armDigest, len(armManifest), amd64Digest, len(sameTagManifest)))
+
+	return registry, armDigest, amd64Digest
+}
+
+func TestIndexManifests(t *testing.T) {
+	registry, armDigest, amd64Digest := newIndexRegistry()
+
+	router, err := New("test", `
+package router
+
+output := {
+	"repository": oci.blob_digest("library/alpine:index", "mediatype", "application/vnd.oci.image.layer.v1.tar+gzip"),
+	"redirect_url": concat(",", oci.index_manifests("library/alpine:index")),
+	"found": count(oci.index_manifests("library/alpine:latest")) == 0,
+}
+`)
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	result, err := router.Decision(req, registry)
+	require.NoError(t, err)
+
+	// the linux/amd64 layer is returned for an index
+	require.Equal(t, "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae", result.Repository)
+	require.Equal(t, armDigest.String()+","+amd64Digest.String(), result.RedirectURL)
+	require.True(t, result.Found)
+}
+
+func TestAnnotations(t *testing.T) {
+	registry := newTestRegistry()
+	registry.TagManifest("library/alpine", "annotated", "application/vnd.oci.image.manifest.v1+json", `{
+		"schemaVersion": 2,
+		"mediaType": "application/vnd.oci.image.manifest.v1+json",
+		"config": {
+			"mediaType": "application/vnd.oci.image.config.v1+json",
+			"digest": "sha256:44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a",
+			"size": 2
+		},
+		"layers": [],
+		"annotations": {
+			"org.opencontainers.image.source": "https://github.com/ctrliq/beskar",
+			"org.opencontainers.image.licenses": "Apache-2.0"
+		}
+	}`)
+
+	router, err := New("test", `
+package router
+
+annotations := oci.annotations("library/alpine:annotated")
+
+output := {
+	"repository": annotations["org.opencontainers.image.source"],
+	"redirect_url": annotations["org.opencontainers.image.licenses"],
+	"found": count(oci.annotations("library/alpine:latest")) + count(oci.annotations("library/alpine:unknown")) == 0,
+}
+`)
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	result, err := router.Decision(req, registry)
+	require.NoError(t, err)
+	require.Equal(t, "https://github.com/ctrliq/beskar", result.Repository)
+	require.Equal(t, "Apache-2.0", result.RedirectURL)
+	require.True(t, result.Found)
+}
+
+func TestRequestMethodAndPath(t *testing.T) {
+	router, err := New("test", `
+package router
+
+output := {
+	"repository": request.path(),
+	"redirect_url": request.method(),
+	"found": true,
+}
+`)
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodDelete, "/v2/library/alpine/manifests/latest", nil)
+	result, err := router.Decision(req, nil)
+	require.NoError(t, err)
+	require.Equal(t, "/v2/library/alpine/manifests/latest", result.Repository)
+	require.Equal(t, http.MethodDelete, result.RedirectURL)
+}
+
+func TestRequestRemoteAddr(t *testing.T) {
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor []string
+		options      []RegoRouterOption
+		expectedAddr string
+	}{
+		{
+			name:         "direct",
+			remoteAddr:   "192.0.2.1:1234",
+			expectedAddr: "192.0.2.1",
+		},
+		{
+			name:         "direct IPv6",
+			remoteAddr:   "[2001:db8::1]:1234",
+			expectedAddr: "2001:db8::1",
+		},
+		{
+			name:         "forwarded without trusted proxy",
+			remoteAddr:   "10.0.0.1:1234",
+			forwardedFor: []string{"198.51.100.7"},
+			expectedAddr: "10.0.0.1",
+		},
+		{
+			name:         "forwarded",
+			remoteAddr:   "10.0.0.1:1234",
+			forwardedFor: []string{"203.0.113.9, 198.51.100.7"},
+			options:      []RegoRouterOption{WithTrustedProxy()},
+			expectedAddr: "198.51.100.7",
+		},
+		{
+			name:         "forwarded multiple headers",
+			remoteAddr:   "10.0.0.1:1234",
+			forwardedFor: []string{"203.0.113.9", "198.51.100.7"},
+			options:      []RegoRouterOption{WithTrustedProxy()},
+			expectedAddr: "198.51.100.7",
+		},
+		{
+			name:         "bad forwarded address",
+			remoteAddr:   "10.0.0.1:1234",
+			forwardedFor: []string{"unknown"},
+			options:      []RegoRouterOption{WithTrustedProxy()},
+			expectedAddr: "10.0.0.1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router, err := New("test", `
+package router
+
+output := {
+	"repository": request.remote_addr(),
+	"found": net.cidr_contains("198.51.100.0/24", request.remote_addr()),
+}
+`, tt.options...)
+			require.NoError(t, err)
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for _, value := range tt.forwardedFor {
+				req.Header.Add("X-Forwarded-For", value)
+			}
+
+			result, err := router.Decision(req, nil)
+			require.NoError(t, err)
+			require.Equal(t, tt.expectedAddr, result.Repository)
+			require.Equal(t, tt.expectedAddr == "198.51.100.7", result.Found)
+		})
+	}
+}
+
+func TestLayerSizes(t *testing.T) {
+	router, err := New("test", `
+package router
+
+output := {
+	"repository": json.marshal(oci.layer_sizes("library/alpine:latest")),
+	"redirect_url": json.marshal(oci.layer_sizes("library/alpine:unknown")),
+	"found": true,
+}
+`)
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	result, err := router.Decision(req, newTestRegistry())
+	require.NoError(t, err)
+	require.Equal(t, "[3]", result.Repository)
+	require.Equal(t, "[]", result.RedirectURL)
+}
+
+func TestReferrers(t *testing.T) {
+	registry := newTestRegistry()
+
+	subject := digest.FromString(sameTagManifest)
+	registry.TagManifest("library/alpine", referrersTag(subject), "application/vnd.oci.image.index.v1+json", `{
+		"schemaVersion": 2,
+		"mediaType": "application/vnd.oci.image.index.v1+json",
+		"manifests": [
+			{
+				"mediaType": "application/vnd.oci.image.manifest.v1+json",
+				"digest": "sha256:44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a",
+				"size": 2,
+				"artifactType": "application/vnd.dev.cosign.artifact.sig.v1+json"
+			},
+			{
+				"mediaType": "application/vnd.oci.image.manifest.v1+json",
+				"digest": "sha256:fcde2b2edba56bf408601fb721fe9b5c338d10ee429ea04fae5511b68fbf8fb9",
+				"size": 2,
+				"artifactType": "application/spdx+json"
+			}
+		]
+	}`)
+	registry.TagManifest("library/alpine", "unsigned", "application/vnd.oci.image.manifest.v1+json", strings.Replace(sameTagManifest, `"size": 3`, `"size": 4`, 1))
+
+	router, err := New("test", `
+package router
+
+output := {
+	"repository": concat(",", oci.referrers("library/alpine:latest", "application/vnd.dev.cosign.artifact.sig.v1+json")),
+	"redirect_url": concat(",", oci.referrers("library/alpine:latest", "")),
+	"found": count(oci.referrers("library/alpine:unsigned", "")) == 0,
+}
+`)
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	result, err := router.Decision(req, registry)
+	require.NoError(t, err)
+	require.Equal(t, "sha256:44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a", result.Repository)
+	require.Equal(t, "sha256:44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a,sha256:fcde2b2edba56bf408601fb721fe9b5c338d10ee429ea04fae5511b68fbf8fb9", result.RedirectURL)
+	require.True(t, result.Found)
+}
+
+func TestManifestMediaType(t *testing.T) {
+	registry, _, _ := newIndexRegistry()
+
+	router, err := New("test", `
+package router
+
+output := {
+	"repository": oci.manifest_mediatype("library/alpine:index"),
+	"redirect_url": oci.manifest_mediatype("library/alpine:latest"),
+	"found": oci.manifest_mediatype("library/alpine:unknown") == "",
+}
+`)
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	result, err := router.Decision(req, registry)
+	require.NoError(t, err)
+	require.Equal(t, "application/vnd.oci.image.index.v1+json", result.Repository)
+	require.Equal(t, "application/vnd.oci.image.manifest.v1+json", result.RedirectURL)
+	require.True(t, result.Found)
+}
+
+func TestRequestBodyParsed(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		expected    string
+	}{
+		{
+			name:        "json",
+			contentType: "application/json",
+			body:        `{"name": "beskar", "replicas": 3}`,
+			expected:    `{"name": "beskar", "replicas": 3}`,
+		},
+		{
+			name:        "yaml",
+			contentType: "application/yaml",
+			body:        "name: beskar\nimage:\n  tags:\n  - latest\n  - 1.0.0\n",
+			expected:    `{"name": "beskar", "image": {"tags": ["latest", "1.0.0"]}}`,
+		},
+		{
+			name:        "form",
+			contentType: "application/x-www-form-urlencoded; charset=utf-8",
+			body:        "name=beskar&tag=latest&tag=1.0.0",
+			expected:    `{"name": ["beskar"], "tag": ["latest", "1.0.0"]}`,
+		},
+		{
+			name:        "unknown content type",
+			contentType: "application/octet-stream",
+			body:        "raw content",
+			expected:    `"raw content"`,
+		},
+		{
+			name:     "empty body",
+			expected: `null`,
+		},
+	}
+
+	router, err := New("test", `
+package router
+
+output := {
+	"repository": json.marshal(request.body_parsed()),
+	"found": true,
+}
+`)
+	require.NoError(t, err)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPut, "/", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+
+			result, err := router.Decision(req, nil)
+			require.NoError(t, err)
+			require.JSONEq(t, tt.expected, result.Repository)
+		})
+	}
+}
+
+func TestBlobDigests(t *testing.T) {
+	registry := newTestRegistry()
+	registry.TagManifest("library/alpine", "rpms", "application/vnd.oci.image.manifest.v1+json", `{
+		"schemaVersion": 2,
+		"mediaType": "application/vnd.oci.image.manifest.v1+json",
+		"config": {
+			"mediaType": "application/vnd.ciq.rpm.package.v1.config+json",
+			"digest": "sha256:44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a",
+			"size": 2
+		},
+		"layers": [
+			{
+				"mediaType": "application/vnd.ciq.rpm.package.v1.rpm",
+				"digest": "sha256:2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae",
+				"size": 3
+			},
+			{
+				"mediaType": "application/vnd.ciq.rpm.package.v1.signature",
+				"digest": "sha256:486ea46224d1bb4fb680f34f7c9ad96a8f24ec88be73ea8e5a6c65260e9cb8a7",
+				"size": 5
+			},
+			{
+				"mediaType": "application/vnd.ciq.rpm.package.v1.rpm",
+				"digest": "sha256:fcde2b2edba56bf408601fb721fe9b5c338d10ee429ea04fae5511b68fbf8fb9",
+				"size": 3
+			},
+			{
+				"mediaType": "application/vnd.ciq.rpm.package.v1.rpm",
+				"digest": "sha256:baa5a0964d3320fbc0c6a922140453c8513ea24ab8fd0577034804a967248096",
+				"size": 3
+			}
+		]
+	}`)
+
+	router, err := New("test", `
+package router
+
+output := {
+	"repository": concat(",", oci.blob_digests("library/alpine:rpms", "application/vnd.ciq.rpm.package.v1.rpm")),
+	"redirect_url": oci.blob_digest("library/alpine:rpms", "mediatype", "application/vnd.ciq.rpm.package.v1.rpm"),
+	"found": count(oci.blob_digests("library/alpine:unknown", "application/vnd.ciq.rpm.package.v1.rpm")) == 0,
+}
+`)
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	result, err := router.Decision(req, registry)
+	require.NoError(t, err)
+	require.Equal(t, strings.Join([]string{
+		"2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae",
+		"fcde2b2edba56bf408601fb721fe9b5c338d10ee429ea04fae5511b68fbf8fb9",
+		"baa5a0964d3320fbc0c6a922140453c8513ea24ab8fd0577034804a967248096",
+	}, ","), result.Repository)
+	require.Equal(t, "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae", result.RedirectURL)
+	require.True(t, result.Found)
+}
+
+func TestPlatformList(t *testing.T) {
+	registry, _, _ := newIndexRegistry()
+
+	configDigest := registry.AddBlob("library/alpine", `{"architecture": "arm", "os": "linux", "variant": "v7"}`)
+	registry.TagManifest("library/alpine", "armv7", "application/vnd.oci.image.manifest.v1+json", fmt.Sprintf(`{
+		"schemaVersion": 2,
+		"mediaType": "application/vnd.oci.image.manifest.v1+json",
+		"config": {
+			"mediaType": "application/vnd.oci.image.config.v1+json",
+			"digest": "%s",
+			"size": 55
+		},
+		"layers": []
+	}`, configDigest))
+
+	router, err := New("test", `
+package router
+
+output := {
+	"repository": json.marshal(oci.platform_list("library/alpine:index")),
+	"redirect_url": json.marshal(oci.platform_list("library/alpine:armv7")),
+	"found": count(oci.platform_list("library/alpine:unknown")) == 0,
+}
+`)
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	result, err := router.Decision(req, registry)
+	require.NoError(t, err)
+	require.JSONEq(t, `[
+		{"os": "linux", "architecture": "arm64", "variant": ""},
+		{"os": "linux", "architecture": "amd64", "variant": ""}
+	]`, result.Repository)
+	require.JSONEq(t, `[{"os": "linux", "architecture": "arm", "variant": "v7"}]`, result.RedirectURL)
+	require.True(t, result.Found)
+}
+
+func TestTagExists(t *testing.T) {
+	router, err := New("test", `
+package router
+
+output := {
+	"repository": json.marshal([
+		oci.tag_exists("library/alpine", "latest"),
+		oci.tag_exists("library/alpine", "unknown"),
+		oci.tag_exists("library/busybox", "latest"),
+	]),
+	"found": true,
+}
+`)
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	result, err := router.Decision(req, newTestRegistry())
+	require.NoError(t, err)
+	require.JSONEq(t, `[true, false, false]`, result.Repository)
+
+	storageErr := errors.New("storage unavailable")
+
+	registry := newTestRegistry()
+	registry.FailWith(storageErr, registrytest.TagLookup)
+
+	_, err = router.Decision(req, registry)
+	require.ErrorIs(t, err, storageErr)
+
+	var builtinErr *BuiltinError
+	require.ErrorAs(t, err, &builtinErr)
+	require.Equal(t, "oci.tag_exists", builtinErr.Builtin)
+}
+
+func TestConfigBlob(t *testing.T) {
+	registry := newTestRegistry()
+
+	config := `{"architecture": "amd64", "os": "linux", "config": {"Labels": {"maintainer": "ciq"}, "Entrypoint": ["/bin/sh"]}}`
+	configDigest := registry.AddBlob("library/alpine", config)
+	registry.TagManifest("library/alpine", "labeled", "application/vnd.oci.image.manifest.v1+json", fmt.Sprintf(`{
+		"schemaVersion": 2,
+		"mediaType": "application/vnd.oci.image.manifest.v1+json",
+		"config": {
+			"mediaType": "application/vnd.oci.image.config.v1+json",
+			"digest": "%s",
+			"size": %d
+		},
+		"layers": []
+	}`, configDigest, len(config)))
+	registry.TagManifest("library/alpine", "huge", "application/vnd.oci.image.manifest.v1+json", fmt.Sprintf(`{
+		"schemaVersion": 2,
+		"mediaType": "application/vnd.oci.image.manifest.v1+json",
+		"config": {
+			"mediaType": "application/vnd.oci.image.config.v1+json",
+			"digest": "%s",
+			"size": %d
+		},
+		"layers": []
+	}`, configDigest, maxConfigBlobSize+1))
+
+	router, err := New("test", `
+package router
+
+config := oci.config_blob("library/alpine:labeled")
+
+output := {
+	"repository": config.config.Labels.maintainer,
+	"redirect_url": config.config.Entrypoint[0],
+	"found": count(oci.config_blob("library/alpine:unknown")) == 0,
+}
+`)
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	result, err := router.Decision(req, registry)
+	require.NoError(t, err)
+	require.Equal(t, "ciq", result.Repository)
+	require.Equal(t, "/bin/sh", result.RedirectURL)
+	require.True(t, result.Found)
+
+	router, err = New("test", `
+package router
+
+output := {
+	"repository": json.marshal(oci.config_blob("library/alpine:huge")),
+	"found": true,
+}
+`)
+	require.NoError(t, err)
+
+	_, err = router.Decision(req, registry)
+	require.ErrorIs(t, err, errConfigTooLarge)
+
+	// builtins parsing the config share the size limit
+	router, err = New("test", `
+package router
+
+output := {
+	"repository": format_int(oci.empty_layer_count("library/alpine:huge"), 10),
+	"found": true,
+}
+`)
+	require.NoError(t, err)
+
+	_, err = router.Decision(req, registry)
+	require.ErrorIs(t, err, errConfigTooLarge)
+}
+
+func TestLayerCount(t *testing.T) {
+	registry, _, _ := newIndexRegistry()
+
+	registry.TagManifest("library/alpine", "arm-only", "application/vnd.oci.image.index.v1+json", `{
+		"schemaVersion": 2,
+		"mediaType": "application/vnd.oci.image.index.v1+json",
+		"manifests": [
+			{
+				"mediaType": "application/vnd.oci.image.manifest.v1+json",
+				"digest": "sha256:fcde2b2edba56bf408601fb721fe9b5c338d10ee429ea04fae5511b68fbf8fb9",
+				"size": 3,
+				"platform": {"os": "linux", "architecture": "arm64"}
+			}
+		]
+	}`)
+	registry.TagManifest("library/alpine", "layered", "application/vnd.oci.image.manifest.v1+json", `{
+		"schemaVersion": 2,
+		"mediaType": "application/vnd.oci.image.manifest.v1+json",
+		"config": {
+			"mediaType": "application/vnd.oci.image.config.v1+json",
+			"digest": "sha256:44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a",
+			"size": 2
+		},
+		"layers": [
+			{
+				"mediaType": "application/vnd.oci.image.layer.v1.tar+gzip",
+				"digest": "sha256:2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae",
+				"size": 3
+			},
+			{
+				"mediaType": "application/vnd.oci.image.layer.v1.tar+gzip",
+				"digest": "sha256:fcde2b2edba56bf408601fb721fe9b5c338d10ee429ea04fae5511b68fbf8fb9",
+				"size": 3
+			}
+		]
+	}`)
+
+	tests := []struct {
+		name     string
+		ref      string
+		expected string
+	}{
+		{
+			name:     "image",
+			ref:      "library/alpine:latest",
+			expected: "1",
+		},
+		{
+			name:     "multiple layers",
+			ref:      "library/alpine:layered",
+			expected: "2",
+		},
+		{
+			name:     "index",
+			ref:      "library/alpine:index",
+			expected: "1",
+		},
+		{
+			name:     "index without platform match",
+			ref:      "library/alpine:arm-only",
+			expected: "0",
+		},
+		{
+			name:     "unknown tag",
+			ref:      "library/alpine:unknown",
+			expected: "0",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router, err := New("test", fmt.Sprintf(`
+package router
+
+output := {
+	"repository": json.marshal(oci.layer_count(%q)),
+	"found": true,
+}
+`, tt.ref))
+			require.NoError(t, err)
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			result, err := router.Decision(req, registry)
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, result.Repository)
+		})
+	}
+}
+
+func TestBlobDigestReference(t *testing.T) {
+	registry, _, _ := newIndexRegistry()
+	indexDigest, ok := registry.TagDigest("library/alpine", "index")
+	require.True(t, ok)
+
+	// digest references don't look up tags
+	registry.FailWith(errors.New("tag lookups disabled"), registrytest.TagLookup)
+
+	router, err := New("test", fmt.Sprintf(`
+package router
+
+output := {
+	"repository": oci.blob_digest("library/alpine@%s", "mediatype", "application/vnd.oci.image.layer.v1.tar+gzip"),
+	"redirect_url": oci.blob_digest("library/alpine@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef", "mediatype", "application/vnd.oci.image.layer.v1.tar+gzip"),
+	"found": oci.blob_digest("library/alpine@%s", "mediatype", "application/vnd.oci.image.layer.v1.tar+gzip") == "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae",
+}
+`, digest.FromString(sameTagManifest), indexDigest))
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	result, err := router.Decision(req, registry)
+	require.NoError(t, err)
+	require.Equal(t, "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae", result.Repository)
+	require.Empty(t, result.RedirectURL)
+	require.True(t, result.Found)
+
+	router, err = New("test", `
+package router
+
+output := {
+	"repository": oci.blob_digest("library/alpine", "mediatype", "application/vnd.oci.image.layer.v1.tar+gzip"),
+	"found": true,
+}
+`)
+	require.NoError(t, err)
+
+	_, err = router.Decision(req, newTestRegistry())
+	require.ErrorContains(t, err, "without tag")
+}
+
+func TestRepositorySize(t *testing.T) {
+	registry, _, _ := newIndexRegistry()
+
+	// the config blob is shared with the images of the index
+	registry.TagManifest("library/alpine", "rpms", "application/vnd.oci.image.manifest.v1+json", `{
+		"schemaVersion": 2,
+		"mediaType": "application/vnd.oci.image.manifest.v1+json",
+		"config": {
+			"mediaType": "application/vnd.oci.image.config.v1+json",
+			"digest": "sha256:44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a",
+			"size": 2
+		},
+		"layers": [
+			{
+				"mediaType": "application/vnd.ciq.rpm.package.v1.rpm",
+				"digest": "sha256:486ea46224d1bb4fb680f34f7c9ad96a8f24ec88be73ea8e5a6c65260e9cb8a7",
+				"size": 100
+			}
+		]
+	}`)
+
+	router, err := New("test", `
+package router
+
+output := {
+	"repository": json.marshal(oci.repository_size("library/alpine")),
+	"found": true,
+}
+`)
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	result, err := router.Decision(req, registry)
+	require.NoError(t, err)
+	// config (2) + amd64 layer (3) + arm64 layer (3) + rpm (100)
+	require.Equal(t, "108", result.Repository)
+
+	calls := registry.Calls()
+
+	router, err = New("test", `
+package router
+
+output := {
+	"repository": json.marshal([
+		oci.repository_size("library/alpine"),
+		oci.repository_size("library/alpine"),
+	]),
+	"redirect_url": json.marshal(oci.repository_size("library/busybox")),
+	"found": true,
+}
+`)
+	require.NoError(t, err)
+
+	result, err = router.Decision(req, registry)
+	require.NoError(t, err)
+	require.JSONEq(t, `[108, 108]`, result.Repository)
+	require.Equal(t, "0", result.RedirectURL)
+	// the repository size is memoized, the second call doesn't perform
+	// any lookup and the unknown repository tags are listed once
+	require.Equal(t, 2*calls+1, registry.Calls())
+}
+
+func TestManifestRaw(t *testing.T) {
+	router, err := New("test", `
+package router
+
+manifest := oci.manifest_raw("library/alpine:latest")
+
+output := {
+	"repository": manifest,
+	"redirect_url": crypto.sha256(manifest),
+	"found": oci.manifest_raw("library/alpine:unknown") == "",
+}
+`)
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	result, err := router.Decision(req, newTestRegistry())
+	require.NoError(t, err)
+	// the payload isn't re-serialized
+	require.Equal(t, sameTagManifest, result.Repository)
+	require.Equal(t, digest.FromString(sameTagManifest).Hex(), result.RedirectURL)
+	require.True(t, result.Found)
+}
+
+func TestCreatedBefore(t *testing.T) {
+	registry := newTestRegistry()
+
+	for tag, config := range map[string]string{
+		"created": `{"architecture": "amd64", "os": "linux", "created": "2023-06-15T10:00:00Z"}`,
+		"undated": `{"architecture": "amd64", "os": "linux"}`,
+	} {
+		configDigest := registry.AddBlob("library/alpine", config)
+		registry.TagManifest("library/alpine", tag, "application/vnd.oci.image.manifest.v1+json", fmt.Sprintf(`{
+			"schemaVersion": 2,
+			"mediaType": "application/vnd.oci.image.manifest.v1+json",
+			"config": {
+				"mediaType": "application/vnd.oci.image.config.v1+json",
+				"digest": "%s",
+				"size": %d
+			},
+			"layers": []
+		}`, configDigest, len(config)))
+	}
+
+	tests := []struct {
+		name     string
+		ref      string
+		time     string
+		expected string
+	}{
+		{
+			name:     "created before",
+			ref:      "library/alpine:created",
+			time:     "2023-07-01T00:00:00Z",
+			expected: "true",
+		},
+		{
+			name:     "created after",
+			ref:      "library/alpine:created",
+			time:     "2023-06-15T09:00:00Z",
+			expected: "false",
+		},
+		{
+			name:     "time zone",
+			ref:      "library/alpine:created",
+			time:     "2023-06-15T11:30:00+02:00",
+			expected: "false",
+		},
+		{
+			name:     "missing created field",
+			ref:      "library/alpine:undated",
+			time:     "2023-07-01T00:00:00Z",
+			expected: "undefined",
+		},
+		{
+			name:     "unknown tag",
+			ref:      "library/alpine:unknown",
+			time:     "2023-07-01T00:00:00Z",
+			expected: "undefined",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router, err := New("test", fmt.Sprintf(`
+package router
+
+default created_before := "undefined"
+
+created_before := json.marshal(oci.created_before(%q, %q))
+
+output := {
+	"repository": created_before,
+	"found": true,
+}
+`, tt.ref, tt.time))
+			require.NoError(t, err)
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			result, err := router.Decision(req, registry)
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, result.Repository)
+		})
+	}
+}
+
+// signImage stores a cosign signature of the subject manifest digest,
+// either with the cosign tag scheme or as an OCI referrer.
+func signImage(t *testing.T, registry *registrytest.Registry, key *ecdsa.PrivateKey, subject digest.Digest, referrer bool) {
+	payload := fmt.Sprintf(`{"critical":{"identity":{"docker-reference":"library/alpine"},"image":{"docker-manifest-digest":"%s"},"type":"cosign container image signature"}}`, subject)
+	sum := sha256.Sum256([]byte(payload))
+	sig, err := ecdsa.SignASN1(rand.Reader, key, sum[:])
+	require.NoError(t, err)
+
+	payloadDigest := registry.AddBlob("library/alpine", payload)
+	configDigest := registry.AddBlob("library/alpine", "{}")
+	manifest := fmt.Sprintf(`{
+		"schemaVersion": 2,
+		"mediaType": "application/vnd.oci.image.manifest.v1+json",
+		"config": {
+			"mediaType": "application/vnd.oci.image.config.v1+json",
+			"digest": "%s",
+			"size": 2
+		},
+		"layers": [
+			{
+				"mediaType": "application/vnd.dev.cosign.simplesigning.v1+json",
+				"digest": "%s",
+				"size": %d,
+				"annotations": {
+					"dev.cosignproject.cosign/signature": "%s"
+				}
+			}
+		]
+	}`, configDigest, payloadDigest, len(payload), base64.StdEncoding.EncodeToString(sig))
+
+	if !referrer {
+		registry.TagManifest("library/alpine", cosignSignatureTag(subject), "application/vnd.oci.image.manifest.v1+json", manifest)
+		return
+	}
+
+	manifestDigest := registry.AddManifest("library/alpine", "application/vnd.oci.image.manifest.v1+json", manifest)
+	registry.TagManifest("library/alpine", referrersTag(subject), "application/vnd.oci.image.index.v1+json", fmt.Sprintf(`{
+		"schemaVersion": 2,
+		"mediaType": "application/vnd.oci.image.index.v1+json",
+		"manifests": [
+			{
+				"mediaType": "application/vnd.oci.image.manifest.v1+json",
+				"digest": "%s",
+				"size": %d,
+				"artifactType": "application/vnd.dev.cosign.artifact.sig.v1+json"
+			}
+		]
+	}`, manifestDigest, len(manifest)))
+}
+
+func TestSignatureVerified(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	registry := newTestRegistry()
+	signImage(t, registry, key, digest.FromString(sameTagManifest), false)
+
+	referrerManifest := strings.Replace(sameTagManifest, `"size": 3`, `"size": 4`, 1)
+	referrerDigest := registry.TagManifest("library/alpine", "referrer", "application/vnd.oci.image.manifest.v1+json", referrerManifest)
+	signImage(t, registry, key, referrerDigest, true)
+
+	// signature of another manifest copied under the signature tag
+	copiedManifest := strings.Replace(sameTagManifest, `"size": 3`, `"size": 5`, 1)
+	copiedDigest := registry.TagManifest("library/alpine", "copied", "application/vnd.oci.image.manifest.v1+json", copiedManifest)
+	signatureDigest, ok := registry.TagDigest("library/alpine", cosignSignatureTag(digest.FromString(sameTagManifest)))
+	require.True(t, ok)
+	registry.Tag("library/alpine", cosignSignatureTag(copiedDigest), signatureDigest)
+
+	registry.TagManifest("library/alpine", "unsigned", "application/vnd.oci.image.manifest.v1+json", strings.Replace(sameTagManifest, `"size": 3`, `"size": 6`, 1))
+
+	tests := []struct {
+		name     string
+		ref      string
+		key      string
+		expected bool
+	}{
+		{
+			name:     "signature tag",
+			ref:      "library/alpine:latest",
+			key:      publicKeyPEM(t, &key.PublicKey),
+			expected: true,
+		},
+		{
+			name:     "signature referrer",
+			ref:      "library/alpine:referrer",
+			key:      publicKeyPEM(t, &key.PublicKey),
+			expected: true,
+		},
+		{
+			name:     "digest reference",
+			ref:      "library/alpine@" + digest.FromString(sameTagManifest).String(),
+			key:      publicKeyPEM(t, &key.PublicKey),
+			expected: true,
+		},
+		{
+			name:     "other key",
+			ref:      "library/alpine:latest",
+			key:      publicKeyPEM(t, &otherKey.PublicKey),
+			expected: false,
+		},
+		{
+			name:     "copied signature",
+			ref:      "library/alpine:copied",
+			key:      publicKeyPEM(t, &key.PublicKey),
+			expected: false,
+		},
+		{
+			name:     "unsigned",
+			ref:      "library/alpine:unsigned",
+			key:      publicKeyPEM(t, &key.PublicKey),
+			expected: false,
+		},
+		{
+			name:     "unknown tag",
+			ref:      "library/alpine:unknown",
+			key:      publicKeyPEM(t, &key.PublicKey),
+			expected: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router, err := New("test", fmt.Sprintf(`
+package router
+
+output := {
+	"found": oci.signature_verified(%q, %q),
+}
+`, tt.ref, tt.key), WithSignatureVerification())
+			require.NoError(t, err)
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			result, err := router.Decision(req, registry)
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, result.Found)
+		})
+	}
+}
+
+func TestSignatureVerifiedErrors(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	registry := newTestRegistry()
+	signImage(t, registry, key, digest.FromString(sameTagManifest), false)
+
+	module := func(key string) string {
+		return fmt.Sprintf(`
+package router
+
+output := {
+	"found": oci.signature_verified("library/alpine:latest", %q),
+}
+`, key)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	// disabled by default
+	router, err := New("test", module(publicKeyPEM(t, &key.PublicKey)))
+	require.NoError(t, err)
+	_, err = router.Decision(req, registry)
+	require.ErrorIs(t, err, errSignatureVerificationDisabled)
+
+	// malformed key
+	router, err = New("test", module("not a key"), WithSignatureVerification())
+	require.NoError(t, err)
+	_, err = router.Decision(req, registry)
+	var builtinErr *BuiltinError
+	require.ErrorAs(t, err, &builtinErr)
+	require.Equal(t, "oci.signature_verified", builtinErr.Builtin)
+
+	// verifications are memoized per manifest and key, the key
+	// is the same regardless of the PEM trailing new line
+	pemKey := publicKeyPEM(t, &key.PublicKey)
+
+	router, err = New("test", module(pemKey), WithSignatureVerification())
+	require.NoError(t, err)
+	result, err := router.Decision(req, registry)
+	require.NoError(t, err)
+	require.True(t, result.Found)
+	calls := registry.Calls()
+
+	router, err = New("test", fmt.Sprintf(`
+package router
+
+output := {
+	"found": oci.signature_verified("library/alpine:latest", %q),
+	"repository": json.marshal(oci.signature_verified("library/alpine:latest", %q)),
+}
+`, pemKey, strings.TrimSpace(pemKey)), WithSignatureVerification())
+	require.NoError(t, err)
+	result, err = router.Decision(req, registry)
+	require.NoError(t, err)
+	require.True(t, result.Found)
+	require.Equal(t, "true", result.Repository)
+	require.Equal(t, calls, registry.Calls()-calls)
+
+	// signed payloads larger than the declared size are read up to the
+	// maximum signature payload size and are invalid signatures
+	oversizedDigest := registry.TagManifest("library/alpine", "oversized", "application/vnd.oci.image.manifest.v1+json", strings.Replace(sameTagManifest, `"size": 3`, `"size": 4`, 1))
+	payload := fmt.Sprintf(`{"critical":{"image":{"docker-manifest-digest":"%s"}},"optional":{"padding":"%s"}}`, oversizedDigest, strings.Repeat("x", maxSignaturePayloadSize))
+	sum := sha256.Sum256([]byte(payload))
+	sig, err := ecdsa.SignASN1(rand.Reader, key, sum[:])
+	require.NoError(t, err)
+	registry.TagManifest("library/alpine", cosignSignatureTag(oversizedDigest), "application/vnd.oci.image.manifest.v1+json", fmt.Sprintf(`{
+		"schemaVersion": 2,
+		"mediaType": "application/vnd.oci.image.manifest.v1+json",
+		"config": {
+			"mediaType": "application/vnd.oci.image.config.v1+json",
+			"digest": "sha256:44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a",
+			"size": 2
+		},
+		"layers": [
+			{
+				"mediaType": "application/vnd.dev.cosign.simplesigning.v1+json",
+				"digest": "%s",
+				"size": 128,
+				"annotations": {
+					"dev.cosignproject.cosign/signature": "%s"
+				}
+			}
+		]
+	}`, registry.AddBlob("library/alpine", payload), base64.StdEncoding.EncodeToString(sig)))
+
+	router, err = New("test", fmt.Sprintf(`
+package router
+
+output := {
+	"found": oci.signature_verified("library/alpine:oversized", %q),
+}
+`, pemKey), WithSignatureVerification())
+	require.NoError(t, err)
+	result, err = router.Decision(req, registry)
+	require.NoError(t, err)
+	require.False(t, result.Found)
+}
+
+func TestDigestAlgorithm(t *testing.T) {
+	registry := newTestRegistry()
+	registry.Tag("library/alpine", "sha512", digest.SHA512.FromString(sameTagManifest))
+
+	tests := []struct {
+		name      string
+		ref       string
+		algorithm string
+	}{
+		{
+			name:      "sha256",
+			ref:       "library/alpine:latest",
+			algorithm: "sha256",
+		},
+		{
+			name:      "sha512",
+			ref:       "library/alpine:sha512",
+			algorithm: "sha512",
+		},
+		{
+			name:      "digest reference",
+			ref:       "library/alpine@" + digest.FromString(sameTagManifest).String(),
+			algorithm: "sha256",
+		},
+		{
+			name:      "unknown tag",
+			ref:       "library/alpine:unknown",
+			algorithm: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router, err := New("test", fmt.Sprintf(`
+package router
+
+output := {
+	"repository": oci.digest_algorithm(%q),
+	"found": true,
+}
+`, tt.ref))
+			require.NoError(t, err)
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			result, err := router.Decision(req, registry)
+			require.NoError(t, err)
+			require.Equal(t, tt.algorithm, result.Repository)
+		})
+	}
+}
+
+func TestDigestAlgorithmPolicy(t *testing.T) {
+	registry := newTestRegistry()
+	registry.Tag("library/alpine", "sha512", digest.SHA512.FromString(sameTagManifest))
+
+	// the example policy of oci.digest_algorithm
+	for tag, deny := range map[string]string{"latest": "", "sha512": "manifest digest is not sha256", "unknown": ""} {
+		router, err := New("test", fmt.Sprintf(`
+package router
+
+default output := {"found": false}
+
+output := {"found": false, "deny": "manifest digest is not sha256"} {
+	algorithm := oci.digest_algorithm("library/alpine:%s")
+	algorithm != ""
+	algorithm != "sha256"
+}
+`, tag))
+		require.NoError(t, err)
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		result, err := router.Decision(req, registry)
+		require.NoError(t, err)
+		require.Equal(t, deny, result.Deny, tag)
+		require.Equal(t, deny != "", result.Denied(), tag)
+	}
+}
+
+func TestManifestLabels(t *testing.T) {
+	registry := newTestRegistry()
+
+	// labeledImage tags an image with the config labels and manifest annotations
+	labeledImage := func(tag string, labels, annotations map[string]string) {
+		configData, err := json.Marshal(map[string]interface{}{
+			"architecture": "amd64",
+			"os":           "linux",
+			"config":       map[string]interface{}{"Labels": labels},
+		})
+		require.NoError(t, err)
+		annotationsData, err := json.Marshal(annotations)
+		require.NoError(t, err)
+
+		configDigest := registry.AddBlob("library/alpine", string(configData))
+		registry.TagManifest("library/alpine", tag, "application/vnd.oci.image.manifest.v1+json", fmt.Sprintf(`{
+			"schemaVersion": 2,
+			"mediaType": "application/vnd.oci.image.manifest.v1+json",
+			"config": {
+				"mediaType": "application/vnd.oci.image.config.v1+json",
+				"digest": "%s",
+				"size": %d
+			},
+			"layers": [],
+			"annotations": %s
+		}`, configDigest, len(configData), annotationsData))
+	}
+
+	labeledImage("labels", map[string]string{"team": "platform"}, nil)
+	labeledImage("annotations", nil, map[string]string{"org.opencontainers.image.authors": "infra@ciq.com"})
+	labeledImage("conflict", map[string]string{"team": "platform", "tier": "1"}, map[string]string{"team": "security"})
+
+	tests := []struct {
+		name     string
+		ref      string
+		expected map[string]interface{}
+	}{
+		{
+			name:     "labels only",
+			ref:      "library/alpine:labels",
+			expected: map[string]interface{}{"team": "platform"},
+		},
+		{
+			name:     "annotations only",
+			ref:      "library/alpine:annotations",
+			expected: map[string]interface{}{"org.opencontainers.image.authors": "infra@ciq.com"},
+		},
+		{
+			name:     "annotations win",
+			ref:      "library/alpine:conflict",
+			expected: map[string]interface{}{"team": "security", "tier": "1"},
+		},
+		{
+			name:     "unknown tag",
+			ref:      "library/alpine:unknown",
+			expected: map[string]interface{}{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router, err := New("test", fmt.Sprintf(`
+package router
+
+labels := oci.manifest_labels(%[1]q)
+
+output := {
+	"repository": json.marshal(labels),
+	"found": labels == oci.metadata(%[1]q),
+}
+`, tt.ref))
+			require.NoError(t, err)
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			result, err := router.Decision(req, registry)
+			require.NoError(t, err)
+			require.True(t, result.Found)
+
+			var labels map[string]interface{}
+			require.NoError(t, json.Unmarshal([]byte(result.Repository), &labels))
+			require.Equal(t, tt.expected, labels)
+		})
+	}
+}
+
+func TestBlobDigestByTitle(t *testing.T) {
+	registry := newTestRegistry()
+
+	readme := digest.FromString("readme")
+	archive := digest.FromString("archive")
+
+	registry.TagManifest("artifacts/static/files", "v1", "application/vnd.oci.image.manifest.v1+json", fmt.Sprintf(`{
+		"schemaVersion": 2,
+		"mediaType": "application/vnd.oci.image.manifest.v1+json",
+		"artifactType": "application/vnd.ciq.beskar.static.file.v1",
+		"config": {
+			"mediaType": "application/vnd.oci.empty.v1+json",
+			"digest": "sha256:44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a",
+			"size": 2
+		},
+		"layers": [
+			{
+				"mediaType": "application/octet-stream",
+				"digest": "%s",
+				"size": 6,
+				"annotations": {
+					"org.opencontainers.image.title": "README.md"
+				}
+			},
+			{
+				"mediaType": "application/octet-stream",
+				"digest": "%s",
+				"size": 7,
+				"annotations": {
+					"org.opencontainers.image.title": "dist/archive.tar.gz"
+				}
+			}
+		]
+	}`, readme, archive))
+
+	tests := []struct {
+		name     string
+		ref      string
+		filename string
+		expected string
+	}{
+		{
+			name:     "first layer",
+			ref:      "artifacts/static/files:v1",
+			filename: "README.md",
+			expected: readme.Hex(),
+		},
+		{
+			name:     "second layer",
+			ref:      "artifacts/static/files:v1",
+			filename: "dist/archive.tar.gz",
+			expected: archive.Hex(),
+		},
+		{
+			name:     "no matching title",
+			ref:      "artifacts/static/files:v1",
+			filename: "archive.tar.gz",
+			expected: "",
+		},
+		{
+			name:     "untitled layers",
+			ref:      "library/alpine:latest",
+			filename: "",
+			expected: "",
+		},
+		{
+			name:     "unknown tag",
+			ref:      "artifacts/static/files:unknown",
+			filename: "README.md",
+			expected: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router, err := New("test", fmt.Sprintf(`
+package router
+
+output := {
+	"repository": oci.blob_digest_by_title(%q, %q),
+	"found": true,
+}
+`, tt.ref, tt.filename))
+			require.NoError(t, err)
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			result, err := router.Decision(req, registry)
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, result.Repository)
+		})
+	}
+}
+
+func TestProvenanceAuthors(t *testing.T) {
+	registry := newTestRegistry()
+
+	// the declared size of the oversized statement lies
+	oversized := strings.Replace(slsaV02Statement, `"predicate"`, `"padding": "`+strings.Repeat("x", maxAttestationBlobSize)+`", "predicate"`, 1)
+	oversized = strings.Replace(oversized, "github.com/actions/runner", "oversized.example.com", 1)
+
+	layers := make([]string, 0, 3)
+	for _, statement := range []string{slsaV1Statement, oversized} {
+		layers = append(layers, fmt.Sprintf(`{
+			"mediaType": "application/vnd.in-toto+json",
+			"digest": "%s",
+			"size": %d
+		}`, registry.AddBlob("library/alpine", statement), len(slsaV1Statement)))
+	}
+	layers = append(layers, fmt.Sprintf(`{
+		"mediaType": "application/vnd.in-toto+json",
+		"digest": "%s",
+		"size": %d
+	}`, registry.AddBlob("library/alpine", slsaV02Statement), maxAttestationBlobSize+1))
+
+	attestation := registry.AddManifest("library/alpine", "application/vnd.oci.image.manifest.v1+json", fmt.Sprintf(`{
+		"schemaVersion": 2,
+		"mediaType": "application/vnd.oci.image.manifest.v1+json",
+		"config": {
+			"mediaType": "application/vnd.oci.image.config.v1+json",
+			"digest": "sha256:44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a",
+			"size": 2
+		},
+		"layers": [%s]
+	}`, strings.Join(layers, ",")))
+	registry.TagManifest("library/alpine", referrersTag(digest.FromString(sameTagManifest)), "application/vnd.oci.image.index.v1+json", fmt.Sprintf(`{
+		"schemaVersion": 2,
+		"mediaType": "application/vnd.oci.image.index.v1+json",
+		"manifests": [
+			{
+				"mediaType": "application/vnd.oci.image.manifest.v1+json",
+				"digest": "%s",
+				"size": 2,
+				"artifactType": "application/vnd.in-toto+json"
+			}
+		]
+	}`, attestation))
+
+	tests := []struct {
+		name     string
+		ref      string
+		expected string
+	}{
+		{
+			name:     "oversized statements ignored",
+			ref:      "library/alpine:latest",
+			expected: `["https://buildkite.com/agent"]`,
+		},
+		{
+			name:     "unknown tag",
+			ref:      "library/alpine:unknown",
+			expected: `[]`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router, err := New("test", fmt.Sprintf(`
+package router
+
+output := {
+	"repository": json.marshal(oci.provenance_authors(%q)),
+	"found": true,
+}
+`, tt.ref))
+			require.NoError(t, err)
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			result, err := router.Decision(req, registry)
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, result.Repository)
+		})
+	}
+}
+
+func TestInputHasFact(t *testing.T) {
+	external := WithExternalInput(func(*http.Request) (map[string]interface{}, error) {
+		return map[string]interface{}{
+			"ticket":   "OPS-1234",
+			"empty":    "",
+			"null":     nil,
+			"approved": false,
+			"owners":   []interface{}{"infra"},
+			"none":     []interface{}{},
+			"labels":   map[string]interface{}{"team": "platform"},
+			"nothing":  map[string]interface{}{},
+		}, nil
+	})
+
+	tests := []struct {
+		key      string
+		expected string
+	}{
+		{key: "ticket", expected: "true"},
+		{key: "empty", expected: "false"},
+		{key: "null", expected: "false"},
+		{key: "approved", expected: "true"},
+		{key: "owners", expected: "true"},
+		{key: "none", expected: "false"},
+		{key: "labels", expected: "true"},
+		{key: "nothing", expected: "false"},
+		{key: "unknown", expected: "false"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			value, err := evalBuiltin(t, newTestRegistry(), nil, fmt.Sprintf(`input.has_fact(%q)`, tt.key), external)
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, value)
+		})
+	}
+
+	// without external input
+	value, err := evalBuiltin(t, newTestRegistry(), nil, `input.has_fact("ticket")`)
+	require.NoError(t, err)
+	require.Equal(t, "false", value)
+
+	providerErr := errors.New("facts unavailable")
+	_, err = evalBuiltin(t, newTestRegistry(), nil, `input.has_fact("ticket")`, WithExternalInput(func(*http.Request) (map[string]interface{}, error) {
+		return nil, providerErr
+	}))
+	require.ErrorIs(t, err, providerErr)
+}
+
+func TestRequestHeader(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("User-Agent", "containerd/v1.7.0")
+	req.Header.Add("X-Forwarded-For", "10.0.0.1")
+	req.Header.Add("X-Forwarded-For", "10.0.0.2")
+
+	tests := []struct {
+		name     string
+		header   string
+		expected string
+	}{
+		{
+			name:     "canonical name",
+			header:   "User-Agent",
+			expected: "containerd/v1.7.0",
+		},
+		{
+			name:     "lower case name",
+			header:   "user-agent",
+			expected: "containerd/v1.7.0",
+		},
+		{
+			name:     "upper case name",
+			header:   "USER-AGENT",
+			expected: "containerd/v1.7.0",
+		},
+		{
+			name:     "first value",
+			header:   "x-forwarded-for",
+			expected: "10.0.0.1",
+		},
+		{
+			name:     "absent header",
+			header:   "Authorization",
+			expected: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, err := evalBuiltin(t, newTestRegistry(), req, fmt.Sprintf(`request.header(%q)`, tt.header))
+			require.NoError(t, err)
+			require.Equal(t, strconv.Quote(tt.expected), value)
+		})
+	}
+}
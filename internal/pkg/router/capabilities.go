@@ -0,0 +1,47 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// defaultCapabilitiesAnnotation is the default annotation holding
+// the capabilities required by an image.
+const defaultCapabilitiesAnnotation = "dev.ciq.beskar.capabilities"
+
+// parseCapabilities parses a capabilities annotation value, the value is
+// either a JSON array of strings or a comma-separated list.
+func parseCapabilities(value string) ([]string, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return []string{}, nil
+	}
+
+	if strings.HasPrefix(value, "[") {
+		var capabilities []string
+		if err := json.Unmarshal([]byte(value), &capabilities); err != nil {
+			return nil, fmt.Errorf("bad capabilities list: %w", err)
+		}
+		result := make([]string, 0, len(capabilities))
+		for _, capability := range capabilities {
+			if capability = strings.TrimSpace(capability); capability != "" {
+				result = append(result, capability)
+			}
+		}
+		return result, nil
+	}
+
+	fields := strings.Split(value, ",")
+	capabilities := make([]string, 0, len(fields))
+	for _, field := range fields {
+		if capability := strings.TrimSpace(field); capability != "" {
+			capabilities = append(capabilities, capability)
+		}
+	}
+
+	return capabilities, nil
+}
@@ -0,0 +1,39 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCapabilities(t *testing.T) {
+	tests := []struct {
+		value        string
+		capabilities []string
+		err          bool
+	}{
+		{value: "", capabilities: []string{}},
+		{value: "  ", capabilities: []string{}},
+		{value: "NET_ADMIN", capabilities: []string{"NET_ADMIN"}},
+		{value: "NET_ADMIN, SYS_ADMIN,,", capabilities: []string{"NET_ADMIN", "SYS_ADMIN"}},
+		{value: `["NET_ADMIN", " SYS_TIME ", ""]`, capabilities: []string{"NET_ADMIN", "SYS_TIME"}},
+		{value: `[]`, capabilities: []string{}},
+		{value: `["NET_ADMIN"`, err: true},
+		{value: `[1, 2]`, err: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.value, func(t *testing.T) {
+			capabilities, err := parseCapabilities(tc.value)
+			if tc.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.capabilities, capabilities)
+		})
+	}
+}
@@ -0,0 +1,54 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"path"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// defaultPath is the PATH used by container runtimes when the image doesn't set one.
+const defaultPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+// entrypointCandidates returns the absolute paths the image entrypoint binary
+// may be located at, the command is used when the entrypoint isn't set.
+// Binaries without a "/" are looked up in the PATH environment variable
+// directories while relative ones are relative to the working directory.
+func entrypointCandidates(config v1.Config) []string {
+	args := config.Entrypoint
+	if len(args) == 0 {
+		args = config.Cmd
+	}
+	if len(args) == 0 || args[0] == "" {
+		return nil
+	}
+
+	binary := args[0]
+
+	if path.IsAbs(binary) {
+		return []string{path.Clean(binary)}
+	} else if strings.Contains(binary, "/") {
+		return []string{path.Join("/", config.WorkingDir, binary)}
+	}
+
+	pathEnv := defaultPath
+	for _, env := range config.Env {
+		if value, ok := strings.CutPrefix(env, "PATH="); ok {
+			pathEnv = value
+		}
+	}
+
+	var candidates []string
+
+	for _, dir := range strings.Split(pathEnv, ":") {
+		if !path.IsAbs(dir) {
+			continue
+		}
+		candidates = append(candidates, path.Join(dir, binary))
+	}
+
+	return candidates
+}
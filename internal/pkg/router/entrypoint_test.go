@@ -0,0 +1,76 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEntrypointCandidates(t *testing.T) {
+	tests := []struct {
+		name       string
+		config     v1.Config
+		candidates []string
+	}{
+		{
+			name:       "no entrypoint",
+			config:     v1.Config{},
+			candidates: nil,
+		},
+		{
+			name: "absolute entrypoint",
+			config: v1.Config{
+				Entrypoint: []string{"/usr/bin/app", "--serve"},
+				Cmd:        []string{"/bin/sh"},
+			},
+			candidates: []string{"/usr/bin/app"},
+		},
+		{
+			name: "command",
+			config: v1.Config{
+				Cmd: []string{"/bin/sh", "-c", "true"},
+			},
+			candidates: []string{"/bin/sh"},
+		},
+		{
+			name: "relative entrypoint",
+			config: v1.Config{
+				Entrypoint: []string{"./bin/app"},
+				WorkingDir: "/srv",
+			},
+			candidates: []string{"/srv/bin/app"},
+		},
+		{
+			name: "default path",
+			config: v1.Config{
+				Entrypoint: []string{"app"},
+			},
+			candidates: []string{
+				"/usr/local/sbin/app",
+				"/usr/local/bin/app",
+				"/usr/sbin/app",
+				"/usr/bin/app",
+				"/sbin/app",
+				"/bin/app",
+			},
+		},
+		{
+			name: "image path",
+			config: v1.Config{
+				Entrypoint: []string{"app"},
+				Env:        []string{"HOME=/root", "PATH=/opt/app/bin:relative:/bin"},
+			},
+			candidates: []string{"/opt/app/bin/app", "/bin/app"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.candidates, entrypointCandidates(tc.config))
+		})
+	}
+}
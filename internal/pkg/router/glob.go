@@ -0,0 +1,74 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"regexp"
+	"strings"
+)
+
+// globPattern is a repository glob pattern, a "*" matches any sequence
+// of characters except "/" while a "**" matches any sequence of characters,
+// a leading "!" negates the pattern.
+type globPattern struct {
+	negate bool
+	regexp *regexp.Regexp
+}
+
+func compileGlob(pattern string) (*globPattern, error) {
+	gp := &globPattern{}
+
+	if strings.HasPrefix(pattern, "!") {
+		gp.negate = true
+		pattern = pattern[1:]
+	}
+
+	var sb strings.Builder
+
+	sb.WriteByte('^')
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			if i+1 < len(pattern) && pattern[i+1] == '*' {
+				sb.WriteString(".*")
+				i++
+			} else {
+				sb.WriteString("[^/]*")
+			}
+		case '?':
+			sb.WriteString("[^/]")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	sb.WriteByte('$')
+
+	re, err := regexp.Compile(sb.String())
+	if err != nil {
+		return nil, err
+	}
+	gp.regexp = re
+
+	return gp, nil
+}
+
+// matchGlobs matches the name against the patterns with a last match wins
+// semantic: patterns are evaluated in order and the last matching pattern
+// decides, a name is matched by a pattern and is excluded by a negated
+// pattern. A name not matching any pattern is not matched.
+func matchGlobs(name string, patterns []string) (bool, error) {
+	matched := false
+
+	for _, pattern := range patterns {
+		gp, err := compileGlob(pattern)
+		if err != nil {
+			return false, err
+		}
+		if gp.regexp.MatchString(name) {
+			matched = !gp.negate
+		}
+	}
+
+	return matched, nil
+}
@@ -0,0 +1,38 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatchGlobs(t *testing.T) {
+	patterns := []string{"teamA/*", "!teamA/secret-*", "teamB/**"}
+
+	tests := []struct {
+		name     string
+		expected bool
+	}{
+		{name: "teamA/app", expected: true},
+		{name: "teamA/secret-app", expected: false},
+		{name: "teamA/sub/app", expected: false},
+		{name: "teamB/sub/app", expected: true},
+		{name: "teamC/app", expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matched, err := matchGlobs(tt.name, patterns)
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, matched)
+		})
+	}
+
+	// last match wins
+	matched, err := matchGlobs("teamA/secret-app", []string{"!teamA/secret-*", "teamA/*"})
+	require.NoError(t, err)
+	require.True(t, matched)
+}
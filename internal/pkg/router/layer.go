@@ -0,0 +1,140 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	regtypes "github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/opencontainers/go-digest"
+)
+
+const (
+	// defaultLayerScanMaxBytes is the default maximum number of compressed
+	// layer bytes a single policy evaluation is allowed to stream.
+	defaultLayerScanMaxBytes = 1 << 30
+	// defaultLayerScanTimeout is the default maximum duration of a layer scan.
+	defaultLayerScanTimeout = 30 * time.Second
+)
+
+var errLayerScanBudget = errors.New("layer scan budget exceeded")
+
+// decompressLayer returns a reader of the uncompressed layer tar.
+func decompressLayer(mediaType regtypes.MediaType, r io.Reader) (io.ReadCloser, error) {
+	switch mediaType {
+	case regtypes.OCIUncompressedLayer, regtypes.OCIUncompressedRestrictedLayer, regtypes.DockerUncompressedLayer:
+		return io.NopCloser(r), nil
+	case regtypes.OCILayer, regtypes.OCIRestrictedLayer, regtypes.DockerLayer, regtypes.DockerForeignLayer:
+		return gzip.NewReader(r)
+	case regtypes.OCILayerZStd:
+		decoder, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	}
+	return nil, fmt.Errorf("unsupported layer media type %s", mediaType)
+}
+
+// budgetReader accounts for the compressed layer bytes read during
+// a policy evaluation and stops reading once the layer scan budget
+// of the evaluation is exhausted or once the context is done.
+type budgetReader struct {
+	ctx  context.Context
+	r    io.Reader
+	fctx *funcContext
+}
+
+func (br *budgetReader) Read(p []byte) (int, error) {
+	if err := br.ctx.Err(); err != nil {
+		return 0, fmt.Errorf("%w: %s", errLayerScanBudget, err)
+	}
+
+	n, err := br.r.Read(p)
+
+	maxBytes := br.fctx.router.layerScanMaxBytes
+
+	br.fctx.layerScanBytes += int64(n)
+	if maxBytes > 0 && br.fctx.layerScanBytes > maxBytes {
+		return n, fmt.Errorf("%w: more than %d bytes", errLayerScanBudget, maxBytes)
+	}
+
+	return n, err
+}
+
+// layerScanContext returns a context bounded by the layer scan timeout.
+func (fctx *funcContext) layerScanContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if timeout := fctx.router.layerScanTimeout; timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// openLayer returns a tar reader of the layer blob, it returns
+// a nil reader if the layer blob is unknown.
+func (fctx *funcContext) openLayer(ctx context.Context, repository distribution.Repository, layer v1.Descriptor) (*tar.Reader, io.Closer, error) {
+	if err := fctx.registryCall(); err != nil {
+		return nil, nil, err
+	}
+
+	rc, err := repository.Blobs(ctx).Open(ctx, digest.Digest(layer.Digest.String()))
+	if err != nil {
+		if errors.Is(err, distribution.ErrBlobUnknown) {
+			return nil, nil, nil
+		}
+		return nil, nil, fmt.Errorf("while opening blob %s: %w", layer.Digest, err)
+	}
+
+	lr, err := decompressLayer(layer.MediaType, &budgetReader{
+		ctx:  ctx,
+		r:    rc,
+		fctx: fctx,
+	})
+	if err != nil {
+		_ = rc.Close()
+		return nil, nil, fmt.Errorf("while reading layer %s: %w", layer.Digest, err)
+	}
+
+	return tar.NewReader(lr), closerFunc(func() error {
+		_ = lr.Close()
+		return rc.Close()
+	}), nil
+}
+
+type closerFunc func() error
+
+func (fn closerFunc) Close() error {
+	return fn()
+}
+
+// getLayerFS returns the filesystem index built from the manifest layers, the
+// returned filesystem is nil if one of the layer blobs is unknown.
+func (fctx *funcContext) getLayerFS(ctx context.Context, repository distribution.Repository, manifest *ociManifest) (*layerFS, error) {
+	fs := newLayerFS()
+
+	for _, layer := range manifest.Layers {
+		tr, closer, err := fctx.openLayer(ctx, repository, layer)
+		if err != nil {
+			return nil, err
+		} else if tr == nil {
+			return nil, nil
+		}
+		err = fs.applyLayer(tr)
+		_ = closer.Close()
+		if err != nil {
+			return nil, fmt.Errorf("while reading layer %s: %w", layer.Digest, err)
+		}
+	}
+
+	return fs, nil
+}
@@ -0,0 +1,170 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"path"
+	"strings"
+)
+
+const (
+	whiteoutPrefix = ".wh."
+	whiteoutOpaque = ".wh..wh..opq"
+
+	// maxSymlinkHops bounds the number of symlinks followed during a path resolution.
+	maxSymlinkHops = 40
+)
+
+// cleanLayerPath returns the absolute clean path of a layer tar entry.
+func cleanLayerPath(name string) string {
+	return path.Clean("/" + name)
+}
+
+type layerFSEntry struct {
+	typeflag byte
+	linkname string
+}
+
+// layerFS indexes the paths of an image filesystem built by applying
+// image layers in order, whiteout entries are honored.
+type layerFS struct {
+	entries map[string]layerFSEntry
+}
+
+func newLayerFS() *layerFS {
+	return &layerFS{
+		entries: make(map[string]layerFSEntry),
+	}
+}
+
+// removeLower removes the entry and its children not added by the current layer.
+func (fs *layerFS) removeLower(p string, added map[string]struct{}, children bool) {
+	prefix := p + "/"
+	if p == "/" {
+		prefix = "/"
+	}
+	for entry := range fs.entries {
+		if _, ok := added[entry]; ok {
+			continue
+		} else if (entry == p && !children) || strings.HasPrefix(entry, prefix) {
+			delete(fs.entries, entry)
+		}
+	}
+}
+
+// applyLayer applies the layer tar entries on top of the filesystem.
+func (fs *layerFS) applyLayer(tr *tar.Reader) error {
+	added := make(map[string]struct{})
+
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+
+		p := cleanLayerPath(hdr.Name)
+		dir, base := path.Split(p)
+		dir = path.Clean(dir)
+
+		switch {
+		case base == whiteoutOpaque:
+			fs.removeLower(dir, added, true)
+		case strings.HasPrefix(base, whiteoutPrefix):
+			fs.removeLower(path.Join(dir, strings.TrimPrefix(base, whiteoutPrefix)), added, false)
+		default:
+			fs.entries[p] = layerFSEntry{
+				typeflag: hdr.Typeflag,
+				linkname: hdr.Linkname,
+			}
+			added[p] = struct{}{}
+		}
+	}
+}
+
+// resolve returns the path resolved by following symlinks, the
+// returned boolean is false if the path can't be resolved.
+func (fs *layerFS) resolve(p string) (string, bool) {
+	remaining := strings.Split(strings.TrimPrefix(cleanLayerPath(p), "/"), "/")
+	current := "/"
+	hops := 0
+
+	for len(remaining) > 0 {
+		component := remaining[0]
+		remaining = remaining[1:]
+
+		if component == "" || component == "." {
+			continue
+		} else if component == ".." {
+			current = path.Dir(current)
+			continue
+		}
+
+		next := path.Join(current, component)
+
+		entry, ok := fs.entries[next]
+		if !ok || entry.typeflag != tar.TypeSymlink {
+			current = next
+			continue
+		}
+
+		hops++
+		if hops > maxSymlinkHops {
+			return "", false
+		}
+
+		target := entry.linkname
+		if !path.IsAbs(target) {
+			target = path.Join(current, target)
+		}
+		remaining = append(strings.Split(strings.TrimPrefix(path.Clean(target), "/"), "/"), remaining...)
+		current = "/"
+	}
+
+	return current, true
+}
+
+// fileExists returns true if the path, once symlinks resolved, references
+// a non-directory entry of the filesystem.
+func (fs *layerFS) fileExists(p string) bool {
+	resolved, ok := fs.resolve(p)
+	if !ok {
+		return false
+	}
+	entry, ok := fs.entries[resolved]
+	return ok && entry.typeflag != tar.TypeDir
+}
+
+// layerFileDigest returns the SHA256 hex digest of the regular file located at
+// path in the layer tar, the returned boolean is false if there is no such file.
+func layerFileDigest(tr *tar.Reader, p string) (string, bool, error) {
+	p = cleanLayerPath(p)
+
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return "", false, nil
+			}
+			return "", false, err
+		} else if cleanLayerPath(hdr.Name) != p {
+			continue
+		} else if !hdr.FileInfo().Mode().IsRegular() {
+			return "", false, nil
+		}
+
+		h := sha256.New()
+		if _, err := io.Copy(h, tr); err != nil {
+			return "", false, err
+		}
+		return hex.EncodeToString(h.Sum(nil)), true, nil
+	}
+}
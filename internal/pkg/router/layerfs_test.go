@@ -0,0 +1,137 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"archive/tar"
+	"bytes"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/require"
+)
+
+type tarEntry struct {
+	name     string
+	typeflag byte
+	linkname string
+	content  string
+}
+
+func buildTar(t *testing.T, entries ...tarEntry) *bytes.Buffer {
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+
+	for _, entry := range entries {
+		typeflag := entry.typeflag
+		if typeflag == 0 {
+			typeflag = tar.TypeReg
+		}
+		err := tw.WriteHeader(&tar.Header{
+			Name:     entry.name,
+			Typeflag: typeflag,
+			Linkname: entry.linkname,
+			Size:     int64(len(entry.content)),
+			Mode:     0o755,
+		})
+		require.NoError(t, err)
+		_, err = tw.Write([]byte(entry.content))
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, tw.Close())
+
+	return buf
+}
+
+func TestLayerFS(t *testing.T) {
+	layers := [][]tarEntry{
+		{
+			{name: "usr/", typeflag: tar.TypeDir},
+			{name: "usr/bin/", typeflag: tar.TypeDir},
+			{name: "usr/bin/sh", content: "sh"},
+			{name: "usr/bin/busybox", content: "busybox"},
+			{name: "bin", typeflag: tar.TypeSymlink, linkname: "usr/bin"},
+			{name: "usr/bin/ls", typeflag: tar.TypeSymlink, linkname: "busybox"},
+			{name: "usr/bin/loop", typeflag: tar.TypeSymlink, linkname: "loop"},
+			{name: "./opt/app/run", content: "run"},
+			{name: "./opt/app/config", content: "config"},
+			{name: "etc/removed", content: "removed"},
+		},
+		{
+			{name: "etc/.wh.removed"},
+			{name: "opt/app/.wh..wh..opq"},
+			{name: "opt/app/new", content: "new"},
+			{name: "srv/entrypoint", typeflag: tar.TypeSymlink, linkname: "/opt/app/new"},
+			{name: "srv/dangling", typeflag: tar.TypeSymlink, linkname: "../opt/app/run"},
+		},
+	}
+
+	fs := newLayerFS()
+	for _, layer := range layers {
+		require.NoError(t, fs.applyLayer(tar.NewReader(buildTar(t, layer...))))
+	}
+
+	tests := []struct {
+		path   string
+		exists bool
+	}{
+		{path: "/usr/bin/sh", exists: true},
+		{path: "/bin/sh", exists: true},
+		{path: "/bin/ls", exists: true},
+		{path: "/bin/../bin/sh", exists: true},
+		{path: "/usr/bin", exists: false},
+		{path: "/usr/bin/loop", exists: false},
+		{path: "/etc/removed", exists: false},
+		{path: "/opt/app/run", exists: false},
+		{path: "/opt/app/config", exists: false},
+		{path: "/opt/app/new", exists: true},
+		{path: "/srv/entrypoint", exists: true},
+		{path: "/srv/dangling", exists: false},
+		{path: "/missing", exists: false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.path, func(t *testing.T) {
+			require.Equal(t, tc.exists, fs.fileExists(tc.path))
+		})
+	}
+}
+
+func TestLayerFileDigest(t *testing.T) {
+	layer := buildTar(t,
+		tarEntry{name: "usr/share/licenses/", typeflag: tar.TypeDir},
+		tarEntry{name: "./usr/share/licenses/LICENSE", content: "Apache-2.0"},
+		tarEntry{name: "usr/share/licenses/COPYING", typeflag: tar.TypeSymlink, linkname: "LICENSE"},
+	)
+
+	tests := []struct {
+		path   string
+		digest string
+		found  bool
+	}{
+		{
+			path:   "/usr/share/licenses/LICENSE",
+			digest: digest.FromString("Apache-2.0").Hex(),
+			found:  true,
+		},
+		{
+			path:   "usr/share/licenses/LICENSE",
+			digest: digest.FromString("Apache-2.0").Hex(),
+			found:  true,
+		},
+		{path: "/usr/share/licenses/COPYING", found: false},
+		{path: "/usr/share/licenses", found: false},
+		{path: "/missing", found: false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.path, func(t *testing.T) {
+			dgst, found, err := layerFileDigest(tar.NewReader(bytes.NewReader(layer.Bytes())), tc.path)
+			require.NoError(t, err)
+			require.Equal(t, tc.found, found)
+			require.Equal(t, tc.digest, dgst)
+		})
+	}
+}
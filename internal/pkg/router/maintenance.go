@@ -0,0 +1,137 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// MaintenanceWindow is a weekly recurring time window during which
+// changes are allowed.
+type MaintenanceWindow struct {
+	// Repositories are the glob patterns of repositories the
+	// window applies to, it applies to all repositories if empty.
+	Repositories []string `json:"repositories,omitempty"`
+	// Days are the week days the window starts on (eg: Mon or Monday),
+	// the window starts every day if empty.
+	Days []string `json:"days,omitempty"`
+	// Start is the window start time with the 15:04 format.
+	Start string `json:"start"`
+	// End is the window end time with the 15:04 format, a window
+	// ending before its start time ends the next day.
+	End string `json:"end"`
+	// Timezone is the IANA timezone name of the window start and
+	// end times, UTC is used if empty.
+	Timezone string `json:"timezone,omitempty"`
+}
+
+type maintenanceWindow struct {
+	repositories []string
+	days         map[time.Weekday]struct{}
+	start        time.Duration
+	end          time.Duration
+	location     *time.Location
+}
+
+func parseWeekday(day string) (time.Weekday, error) {
+	for wd := time.Sunday; wd <= time.Saturday; wd++ {
+		name := wd.String()
+		if strings.EqualFold(day, name) || strings.EqualFold(day, name[:3]) {
+			return wd, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown week day %q", day)
+}
+
+func parseClock(clock string) (time.Duration, error) {
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		return 0, fmt.Errorf("bad time %q: %w", clock, err)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+func (mw MaintenanceWindow) compile() (*maintenanceWindow, error) {
+	var err error
+
+	w := &maintenanceWindow{
+		repositories: mw.Repositories,
+		location:     time.UTC,
+	}
+
+	if len(mw.Days) > 0 {
+		w.days = make(map[time.Weekday]struct{}, len(mw.Days))
+		for _, day := range mw.Days {
+			wd, err := parseWeekday(day)
+			if err != nil {
+				return nil, err
+			}
+			w.days[wd] = struct{}{}
+		}
+	}
+	if w.start, err = parseClock(mw.Start); err != nil {
+		return nil, err
+	}
+	if w.end, err = parseClock(mw.End); err != nil {
+		return nil, err
+	}
+	if mw.Timezone != "" {
+		if w.location, err = time.LoadLocation(mw.Timezone); err != nil {
+			return nil, fmt.Errorf("bad timezone %q: %w", mw.Timezone, err)
+		}
+	}
+
+	return w, nil
+}
+
+func (w *maintenanceWindow) startsOn(day time.Weekday) bool {
+	if w.days == nil {
+		return true
+	}
+	_, ok := w.days[day]
+	return ok
+}
+
+func (w *maintenanceWindow) contains(now time.Time) bool {
+	now = now.In(w.location)
+	clock := time.Duration(now.Hour())*time.Hour + time.Duration(now.Minute())*time.Minute
+
+	if w.start <= w.end {
+		return w.startsOn(now.Weekday()) && clock >= w.start && clock < w.end
+	}
+
+	// the window ends the day after its start
+	yesterday := now.AddDate(0, 0, -1).Weekday()
+
+	return (w.startsOn(now.Weekday()) && clock >= w.start) || (w.startsOn(yesterday) && clock < w.end)
+}
+
+// inMaintenanceWindow returns true if the time falls inside one of the
+// windows applying to the repository. Windows restricted to a set of
+// repositories don't apply when the repository is empty.
+func inMaintenanceWindow(now time.Time, repository string, windows []MaintenanceWindow) (bool, error) {
+	for _, mw := range windows {
+		w, err := mw.compile()
+		if err != nil {
+			return false, err
+		}
+		if len(w.repositories) > 0 {
+			if repository == "" {
+				continue
+			}
+			matched, err := matchGlobs(repository, w.repositories)
+			if err != nil {
+				return false, err
+			} else if !matched {
+				continue
+			}
+		}
+		if w.contains(now) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
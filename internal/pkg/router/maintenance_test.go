@@ -0,0 +1,98 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestInMaintenanceWindow(t *testing.T) {
+	windows := []MaintenanceWindow{
+		{
+			Repositories: []string{"team/**"},
+			Days:         []string{"Sat"},
+			Start:        "22:00",
+			End:          "02:00",
+			Timezone:     "Europe/Paris",
+		},
+		{
+			Repositories: []string{"infra/*"},
+			Days:         []string{"monday", "Tuesday"},
+			Start:        "09:00",
+			End:          "12:00",
+		},
+	}
+
+	tests := []struct {
+		name       string
+		now        string
+		repository string
+		expected   bool
+	}{
+		{
+			name:       "saturday night",
+			now:        "2023-10-07T20:30:00Z",
+			repository: "team/app",
+			expected:   true,
+		},
+		{
+			name:       "sunday early morning",
+			now:        "2023-10-07T23:30:00Z",
+			repository: "team/app",
+			expected:   true,
+		},
+		{
+			name:       "sunday morning",
+			now:        "2023-10-08T08:00:00Z",
+			repository: "team/app",
+			expected:   false,
+		},
+		{
+			name:       "saturday before window",
+			now:        "2023-10-07T19:00:00Z",
+			repository: "team/app",
+			expected:   false,
+		},
+		{
+			name:       "other repository",
+			now:        "2023-10-07T20:30:00Z",
+			repository: "other/app",
+			expected:   false,
+		},
+		{
+			name:       "monday",
+			now:        "2023-10-09T10:00:00Z",
+			repository: "infra/app",
+			expected:   true,
+		},
+		{
+			name:       "wednesday",
+			now:        "2023-10-11T10:00:00Z",
+			repository: "infra/app",
+			expected:   false,
+		},
+		{
+			name:     "no repository",
+			now:      "2023-10-09T10:00:00Z",
+			expected: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now, err := time.Parse(time.RFC3339, tt.now)
+			require.NoError(t, err)
+
+			in, err := inMaintenanceWindow(now, tt.repository, windows)
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, in)
+		})
+	}
+
+	_, err := inMaintenanceWindow(time.Now(), "", []MaintenanceWindow{{Start: "25:00", End: "01:00"}})
+	require.Error(t, err)
+}
@@ -0,0 +1,71 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// builtinMetrics records the builtin evaluations.
+type builtinMetrics struct {
+	duration *prometheus.HistogramVec
+	errors   *prometheus.CounterVec
+}
+
+func newBuiltinMetrics(registerer prometheus.Registerer) (*builtinMetrics, error) {
+	duration, err := registerCollector(registerer, prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "beskar",
+			Subsystem: "router",
+			Name:      "builtin_duration_seconds",
+			Help:      "Duration of the rego builtin evaluations.",
+			Buckets:   prometheus.DefBuckets,
+		},
+		[]string{"router", "builtin"},
+	))
+	if err != nil {
+		return nil, err
+	}
+	errs, err := registerCollector(registerer, prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "beskar",
+			Subsystem: "router",
+			Name:      "builtin_errors_total",
+			Help:      "Number of rego builtin evaluation errors.",
+		},
+		[]string{"router", "builtin"},
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	return &builtinMetrics{
+		duration: duration.(*prometheus.HistogramVec),
+		errors:   errs.(*prometheus.CounterVec),
+	}, nil
+}
+
+// registerCollector registers the collector and returns it, the collector
+// already registered is returned if routers share the same registerer.
+func registerCollector(registerer prometheus.Registerer, collector prometheus.Collector) (prometheus.Collector, error) {
+	if err := registerer.Register(collector); err != nil {
+		var alreadyRegistered prometheus.AlreadyRegisteredError
+		if errors.As(err, &alreadyRegistered) {
+			return alreadyRegistered.ExistingCollector, nil
+		}
+		return nil, err
+	}
+	return collector, nil
+}
+
+// observe records the evaluation of a router builtin.
+func (m *builtinMetrics) observe(router, builtin string, duration time.Duration, err error) {
+	m.duration.WithLabelValues(router, builtin).Observe(duration.Seconds())
+	if err != nil {
+		m.errors.WithLabelValues(router, builtin).Inc()
+	}
+}
@@ -0,0 +1,67 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"go.ciq.dev/beskar/internal/pkg/router/registrytest"
+)
+
+// builtinEvaluations returns the number of evaluations of the builtin.
+func builtinEvaluations(t *testing.T, registry *prometheus.Registry, builtin string) uint64 {
+	families, err := registry.Gather()
+	require.NoError(t, err)
+
+	for _, family := range families {
+		if family.GetName() != "beskar_router_builtin_duration_seconds" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "builtin" && label.GetValue() == builtin {
+					return metric.GetHistogram().GetSampleCount()
+				}
+			}
+		}
+	}
+
+	return 0
+}
+
+func TestBuiltinMetrics(t *testing.T) {
+	registry := prometheus.NewRegistry()
+
+	router, err := New("test", sameTagModule, WithMetrics(registry))
+	require.NoError(t, err)
+
+	// routers sharing a registerer share the collectors
+	_, err = New("other", sameTagModule, WithMetrics(registry))
+	require.NoError(t, err)
+
+	testRegistry := newTestRegistry()
+	req := httptest.NewRequest(http.MethodGet, "/v2/library/alpine/manifests/latest", nil)
+
+	_, err = router.Decision(req, testRegistry)
+	require.NoError(t, err)
+
+	require.EqualValues(t, 1, builtinEvaluations(t, registry, "oci.manifest_digest"))
+	require.EqualValues(t, 1, builtinEvaluations(t, registry, "oci.blob_digest"))
+	require.Equal(t, 0, testutil.CollectAndCount(router.metrics.errors))
+
+	testRegistry = newTestRegistry()
+	testRegistry.FailWith(errors.New("storage unavailable"), registrytest.ManifestLookup)
+
+	_, err = router.Decision(req, testRegistry)
+	require.Error(t, err)
+
+	require.EqualValues(t, 2, builtinEvaluations(t, registry, "oci.blob_digest"))
+	require.Equal(t, 1.0, testutil.ToFloat64(router.metrics.errors.WithLabelValues("test", "oci.blob_digest")))
+}
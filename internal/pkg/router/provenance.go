@@ -0,0 +1,113 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	inTotoMediaType = "application/vnd.in-toto+json"
+	dsseMediaType   = "application/vnd.dsse.envelope.v1+json"
+
+	// dockerReferenceTypeAnnotation identifies the attestation manifests
+	// stored by buildx within image indexes.
+	dockerReferenceTypeAnnotation = "vnd.docker.reference.type"
+	dockerReferenceDigest         = "vnd.docker.reference.digest"
+	dockerAttestationManifest     = "attestation-manifest"
+
+	slsaProvenancePrefix = "https://slsa.dev/provenance/"
+)
+
+// inTotoStatement is an in-toto attestation statement.
+type inTotoStatement struct {
+	Type          string          `json:"_type"`
+	PredicateType string          `json:"predicateType"`
+	Predicate     json.RawMessage `json:"predicate"`
+}
+
+// dsseEnvelope is a DSSE envelope wrapping an in-toto statement.
+type dsseEnvelope struct {
+	PayloadType string `json:"payloadType"`
+	Payload     string `json:"payload"`
+}
+
+// slsaProvenance holds the builder identities of SLSA v0.2 and v1 provenances.
+type slsaProvenance struct {
+	// SLSA v0.2
+	Builder struct {
+		ID string `json:"id"`
+	} `json:"builder"`
+	// SLSA v1
+	RunDetails struct {
+		Builder struct {
+			ID string `json:"id"`
+		} `json:"builder"`
+	} `json:"runDetails"`
+}
+
+// isAttestationLayer returns true if the layer media type may hold an in-toto statement.
+func isAttestationLayer(mediaType string) bool {
+	return mediaType == inTotoMediaType || mediaType == dsseMediaType
+}
+
+// parseInTotoStatement parses an in-toto statement either raw or wrapped in a DSSE envelope.
+func parseInTotoStatement(mediaType string, data []byte) (*inTotoStatement, error) {
+	if mediaType == dsseMediaType {
+		envelope := new(dsseEnvelope)
+		if err := json.Unmarshal(data, envelope); err != nil {
+			return nil, fmt.Errorf("while parsing DSSE envelope: %w", err)
+		} else if envelope.PayloadType != inTotoMediaType {
+			return nil, fmt.Errorf("unsupported DSSE payload type %q", envelope.PayloadType)
+		}
+		payload, err := base64.StdEncoding.DecodeString(envelope.Payload)
+		if err != nil {
+			return nil, fmt.Errorf("while decoding DSSE payload: %w", err)
+		}
+		data = payload
+	}
+
+	statement := new(inTotoStatement)
+	if err := json.Unmarshal(data, statement); err != nil {
+		return nil, fmt.Errorf("while parsing in-toto statement: %w", err)
+	}
+	return statement, nil
+}
+
+// isProvenance returns true if the statement is a SLSA provenance.
+func (s *inTotoStatement) isProvenance() bool {
+	return strings.HasPrefix(s.PredicateType, slsaProvenancePrefix)
+}
+
+// provenanceBuilders returns the sorted set of builder identities of SLSA provenance statements.
+func provenanceBuilders(statements []*inTotoStatement) ([]string, error) {
+	builders := make(map[string]struct{})
+
+	for _, statement := range statements {
+		if !statement.isProvenance() {
+			continue
+		}
+		provenance := new(slsaProvenance)
+		if err := json.Unmarshal(statement.Predicate, provenance); err != nil {
+			return nil, fmt.Errorf("while parsing %s predicate: %w", statement.PredicateType, err)
+		}
+		for _, id := range []string{provenance.Builder.ID, provenance.RunDetails.Builder.ID} {
+			if id != "" {
+				builders[id] = struct{}{}
+			}
+		}
+	}
+
+	result := make([]string, 0, len(builders))
+	for builder := range builders {
+		result = append(result, builder)
+	}
+	sort.Strings(result)
+
+	return result, nil
+}
@@ -0,0 +1,103 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	slsaV02Statement = `{
+		"_type": "https://in-toto.io/Statement/v0.1",
+		"predicateType": "https://slsa.dev/provenance/v0.2",
+		"predicate": {"builder": {"id": "https://github.com/actions/runner"}}
+	}`
+	slsaV1Statement = `{
+		"_type": "https://in-toto.io/Statement/v1",
+		"predicateType": "https://slsa.dev/provenance/v1",
+		"predicate": {"runDetails": {"builder": {"id": "https://buildkite.com/agent"}}}
+	}`
+	sbomStatement = `{
+		"_type": "https://in-toto.io/Statement/v0.1",
+		"predicateType": "https://spdx.dev/Document",
+		"predicate": {"builder": {"id": "https://sbom.example.com"}}
+	}`
+)
+
+func TestParseInTotoStatement(t *testing.T) {
+	envelope := `{"payloadType": "application/vnd.in-toto+json", "payload": "` +
+		base64.StdEncoding.EncodeToString([]byte(slsaV1Statement)) + `"}`
+
+	tests := []struct {
+		name          string
+		mediaType     string
+		data          string
+		predicateType string
+		err           bool
+	}{
+		{
+			name:          "raw statement",
+			mediaType:     inTotoMediaType,
+			data:          slsaV02Statement,
+			predicateType: "https://slsa.dev/provenance/v0.2",
+		},
+		{
+			name:          "dsse envelope",
+			mediaType:     dsseMediaType,
+			data:          envelope,
+			predicateType: "https://slsa.dev/provenance/v1",
+		},
+		{
+			name:      "dsse bad payload type",
+			mediaType: dsseMediaType,
+			data:      `{"payloadType": "text/plain", "payload": ""}`,
+			err:       true,
+		},
+		{
+			name:      "dsse bad payload",
+			mediaType: dsseMediaType,
+			data:      `{"payloadType": "application/vnd.in-toto+json", "payload": "%%%"}`,
+			err:       true,
+		},
+		{
+			name:      "bad statement",
+			mediaType: inTotoMediaType,
+			data:      `{`,
+			err:       true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			statement, err := parseInTotoStatement(tc.mediaType, []byte(tc.data))
+			if tc.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.predicateType, statement.PredicateType)
+		})
+	}
+}
+
+func TestProvenanceBuilders(t *testing.T) {
+	var statements []*inTotoStatement
+
+	for _, data := range []string{slsaV02Statement, slsaV1Statement, sbomStatement, slsaV02Statement} {
+		statement, err := parseInTotoStatement(inTotoMediaType, []byte(data))
+		require.NoError(t, err)
+		statements = append(statements, statement)
+	}
+
+	builders, err := provenanceBuilders(statements)
+	require.NoError(t, err)
+	require.Equal(t, []string{"https://buildkite.com/agent", "https://github.com/actions/runner"}, builders)
+
+	builders, err = provenanceBuilders(nil)
+	require.NoError(t, err)
+	require.Empty(t, builders)
+}
@@ -0,0 +1,95 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/distribution/distribution/v3/reference"
+)
+
+// parseRef parses a reference and returns its name and either its digest,
+// when the reference is a digest reference, or its tag. The returned tag
+// or digest is empty if the reference has neither a tag nor a digest. The
+// name includes the registry host and port if the reference has one.
+func parseRef(ref string) (reference.Named, string, error) {
+	parsedRef, err := reference.Parse(ref)
+	if err != nil {
+		return nil, "", fmt.Errorf("bad reference %s: %w", ref, err)
+	}
+	named, ok := parsedRef.(reference.Named)
+	if !ok {
+		return nil, "", fmt.Errorf("reference %s without name", ref)
+	}
+
+	if digested, ok := parsedRef.(reference.Digested); ok {
+		return reference.TrimNamed(named), digested.Digest().String(), nil
+	} else if tagged, ok := parsedRef.(reference.Tagged); ok {
+		return reference.TrimNamed(named), tagged.Tag(), nil
+	}
+
+	return named, "", nil
+}
+
+// referenceHost returns the registry host of a reference including its port,
+// it returns an empty string when the reference doesn't specify a host. Like
+// docker, the first path component is considered as a host only if it
+// contains a "." or a ":" or if it's equal to "localhost".
+func referenceHost(ref string) (string, error) {
+	parsedRef, err := reference.Parse(ref)
+	if err != nil {
+		return "", fmt.Errorf("bad reference %s: %w", ref, err)
+	}
+	named, ok := parsedRef.(reference.Named)
+	if !ok {
+		return "", fmt.Errorf("reference %s without name", ref)
+	}
+
+	host, _, found := strings.Cut(named.Name(), "/")
+	if !found {
+		return "", nil
+	} else if !strings.ContainsAny(host, ".:") && host != "localhost" {
+		return "", nil
+	}
+
+	return host, nil
+}
+
+// hostAllowed returns true if the registry host of the reference is one of
+// the allowed hosts, references without host are always allowed.
+func hostAllowed(ref string, allowedHosts []string) (bool, error) {
+	host, err := referenceHost(ref)
+	if err != nil {
+		return false, err
+	} else if host == "" {
+		return true, nil
+	}
+
+	for _, allowedHost := range allowedHosts {
+		if strings.EqualFold(host, allowedHost) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// referenceTag returns the tag of a reference, references without tag
+// implicitly reference the latest tag. The returned boolean is false for
+// digest references.
+func referenceTag(ref string) (string, bool, error) {
+	parsedRef, err := reference.Parse(ref)
+	if err != nil {
+		return "", false, fmt.Errorf("bad reference %s: %w", ref, err)
+	}
+
+	if _, ok := parsedRef.(reference.Digested); ok {
+		return "", false, nil
+	} else if tagged, ok := parsedRef.(reference.Tagged); ok {
+		return tagged.Tag(), true, nil
+	}
+
+	return "latest", true, nil
+}
@@ -0,0 +1,114 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHostAllowed(t *testing.T) {
+	allowedHosts := []string{"registry.example.com", "localhost:5000", "Mirror.Example.com"}
+
+	tests := []struct {
+		ref     string
+		host    string
+		allowed bool
+		err     bool
+	}{
+		{ref: "alpine", host: "", allowed: true},
+		{ref: "library/alpine:3", host: "", allowed: true},
+		{ref: "registry.example.com/library/alpine:3", host: "registry.example.com", allowed: true},
+		{ref: "registry.example.com:443/library/alpine:3", host: "registry.example.com:443", allowed: false},
+		{ref: "localhost:5000/alpine", host: "localhost:5000", allowed: true},
+		{ref: "localhost/alpine", host: "localhost", allowed: false},
+		{ref: "localhost:5001/alpine", host: "localhost:5001", allowed: false},
+		{ref: "mirror.example.com/alpine@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef", host: "mirror.example.com", allowed: true},
+		{ref: "evil.example.com/registry.example.com/alpine", host: "evil.example.com", allowed: false},
+		{ref: "registry.example.com.evil.com/alpine", host: "registry.example.com.evil.com", allowed: false},
+		{ref: "Registry.example.com/alpine", host: "Registry.example.com", allowed: true},
+		{ref: "registry.example.com/Alpine", err: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.ref, func(t *testing.T) {
+			allowed, err := hostAllowed(tc.ref, allowedHosts)
+			if tc.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.allowed, allowed)
+
+			host, err := referenceHost(tc.ref)
+			require.NoError(t, err)
+			require.Equal(t, tc.host, host)
+		})
+	}
+}
+
+func TestReferenceTag(t *testing.T) {
+	tests := []struct {
+		ref    string
+		tag    string
+		tagged bool
+		err    bool
+	}{
+		{ref: "alpine", tag: "latest", tagged: true},
+		{ref: "library/alpine:3.18", tag: "3.18", tagged: true},
+		{ref: "localhost:5000/alpine", tag: "latest", tagged: true},
+		{ref: "localhost:5000/alpine:prod", tag: "prod", tagged: true},
+		{ref: "alpine@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef", tagged: false},
+		{ref: "alpine:prod@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef", tagged: false},
+		{ref: "alpine:", err: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.ref, func(t *testing.T) {
+			tag, tagged, err := referenceTag(tc.ref)
+			if tc.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.tagged, tagged)
+			require.Equal(t, tc.tag, tag)
+		})
+	}
+}
+
+func TestParseRef(t *testing.T) {
+	const dgst = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+	tests := []struct {
+		ref         string
+		name        string
+		tagOrDigest string
+		err         bool
+	}{
+		{ref: "library/alpine:3.18", name: "library/alpine", tagOrDigest: "3.18"},
+		{ref: "localhost:5000/foo:bar", name: "localhost:5000/foo", tagOrDigest: "bar"},
+		{ref: "localhost:5000/foo", name: "localhost:5000/foo"},
+		{ref: "library/alpine", name: "library/alpine"},
+		{ref: "library/alpine@" + dgst, name: "library/alpine", tagOrDigest: dgst},
+		{ref: "localhost:5000/foo:bar@" + dgst, name: "localhost:5000/foo", tagOrDigest: dgst},
+		{ref: "library/alpine@sha256:0123", err: true},
+		{ref: "library/Alpine:3.18", err: true},
+		{ref: "", err: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.ref, func(t *testing.T) {
+			named, tagOrDigest, err := parseRef(tc.ref)
+			if tc.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.name, named.String())
+			require.Equal(t, tc.tagOrDigest, tagOrDigest)
+		})
+	}
+}
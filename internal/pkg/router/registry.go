@@ -0,0 +1,894 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/reference"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	regtypes "github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/opencontainers/go-digest"
+)
+
+// defaultRegistryCallBudget is the default maximum number of registry
+// calls a single policy evaluation is allowed to perform.
+const defaultRegistryCallBudget = 256
+
+// maxConfigBlobSize is the maximum size of a config blob read by builtins.
+const maxConfigBlobSize = 4 << 20
+
+// maxAttestationBlobSize is the maximum size of an attestation blob read by builtins.
+const maxAttestationBlobSize = 4 << 20
+
+// defaultPlatform is the platform used to resolve the index child
+// manifest inspected by builtins expecting an image manifest.
+var defaultPlatform = v1.Platform{
+	OS:           "linux",
+	Architecture: "amd64",
+}
+
+var (
+	errRegistryCallBudget = errors.New("registry call budget exceeded")
+	errBadConfig          = errors.New("malformed config blob")
+	errConfigTooLarge     = errors.New("config blob too large")
+	errBlobTooLarge       = errors.New("blob too large")
+)
+
+// ociManifest is an OCI image manifest with the artifactType
+// field not yet supported by v1.Manifest.
+type ociManifest struct {
+	v1.Manifest
+	ArtifactType string `json:"artifactType,omitempty"`
+}
+
+// cachedManifest is a manifest payload memoized by getManifestPayload.
+type cachedManifest struct {
+	mediaType string
+	payload   []byte
+}
+
+// registryCall accounts for a registry call and returns an error
+// once the registry call budget of the evaluation is exhausted.
+func (fctx *funcContext) registryCall() error {
+	budget := fctx.router.registryCallBudget
+
+	fctx.registryCalls++
+	if budget > 0 && fctx.registryCalls > budget {
+		return fmt.Errorf("%w: more than %d calls", errRegistryCallBudget, budget)
+	}
+	return nil
+}
+
+// isIndexMediaType returns true if the media type is an image index
+// or a manifest list media type.
+func isIndexMediaType(mediaType string) bool {
+	switch regtypes.MediaType(mediaType) {
+	case regtypes.OCIImageIndex, regtypes.DockerManifestList:
+		return true
+	}
+	return false
+}
+
+// isUnknown returns true if the error reports an unknown
+// repository, tag or manifest.
+func isUnknown(err error) bool {
+	var (
+		tagUnknown        distribution.ErrTagUnknown
+		repositoryUnknown distribution.ErrRepositoryUnknown
+		manifestUnknown   distribution.ErrManifestUnknown
+		revisionUnknown   distribution.ErrManifestUnknownRevision
+	)
+	return errors.As(err, &tagUnknown) ||
+		errors.As(err, &repositoryUnknown) ||
+		errors.As(err, &manifestUnknown) ||
+		errors.As(err, &revisionUnknown)
+}
+
+// resolveTag returns the repository and the manifest descriptor referenced
+// by a name:tag or a name@digest reference, the returned descriptor is nil
+// if the tag or the manifest is unknown.
+func (fctx *funcContext) resolveTag(ctx context.Context, ref string) (distribution.Repository, *distribution.Descriptor, error) {
+	fctx.reference = ref
+
+	namedRef, tagOrDigest, err := parseRef(ref)
+	if err != nil {
+		return nil, nil, err
+	} else if tagOrDigest == "" {
+		return nil, nil, fmt.Errorf("reference %s without tag", ref)
+	}
+
+	repository, err := fctx.registry.Repository(ctx, namedRef)
+	if err != nil {
+		return nil, nil, fmt.Errorf("while getting repository %s: %w", namedRef, err)
+	}
+
+	if dgst, err := digest.Parse(tagOrDigest); err == nil {
+		// the payload is memoized for the next manifest lookup
+		_, _, err := fctx.getManifestPayload(ctx, repository, dgst)
+		if err != nil {
+			if isUnknown(err) {
+				return repository, nil, nil
+			}
+			return nil, nil, err
+		}
+		return repository, &distribution.Descriptor{Digest: dgst}, nil
+	}
+
+	desc, err := fctx.getTag(ctx, repository, tagOrDigest)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return repository, desc, nil
+}
+
+// getTag returns the descriptor associated to the repository tag, the
+// returned descriptor is nil if the tag is unknown. Lookups are memoized
+// for the lifetime of the evaluation.
+func (fctx *funcContext) getTag(ctx context.Context, repository distribution.Repository, tag string) (*distribution.Descriptor, error) {
+	key := repository.Named().Name() + ":" + tag
+	if fctx.reference == "" {
+		fctx.reference = key
+	}
+	if desc, ok := fctx.tags[key]; ok {
+		return desc, nil
+	}
+
+	if err := fctx.registryCall(); err != nil {
+		return nil, err
+	}
+	desc, err := repository.Tags(ctx).Get(ctx, tag)
+	if err != nil {
+		if !isUnknown(err) {
+			return nil, fmt.Errorf("while getting tag %s: %w", tag, err)
+		}
+		// unknown tags are memoized too
+		fctx.cacheTag(key, nil)
+		return nil, nil
+	}
+	fctx.cacheTag(key, &desc)
+	return &desc, nil
+}
+
+// cacheTag memoizes the descriptor associated to a name:tag reference.
+func (fctx *funcContext) cacheTag(key string, desc *distribution.Descriptor) {
+	if fctx.tags == nil {
+		fctx.tags = make(map[string]*distribution.Descriptor)
+	}
+	fctx.tags[key] = desc
+}
+
+// getManifestPayload returns the media type and the raw payload of the
+// repository manifest identified by its digest. Payloads are memoized
+// for the lifetime of the evaluation.
+func (fctx *funcContext) getManifestPayload(ctx context.Context, repository distribution.Repository, dgst digest.Digest) (string, []byte, error) {
+	key := repository.Named().Name() + "@" + dgst.String()
+	if fctx.reference == "" {
+		fctx.reference = key
+	}
+	if cached, ok := fctx.manifests[key]; ok {
+		return cached.mediaType, cached.payload, nil
+	}
+
+	if err := fctx.registryCall(); err != nil {
+		return "", nil, err
+	}
+	manifestService, err := repository.Manifests(ctx)
+	if err != nil {
+		return "", nil, fmt.Errorf("while getting manifest service for %s: %w", repository.Named(), err)
+	}
+	registryManifest, err := manifestService.Get(ctx, dgst)
+	if err != nil {
+		return "", nil, fmt.Errorf("while getting manifest for %s: %w", repository.Named(), err)
+	}
+	mediaType, payload, err := registryManifest.Payload()
+	if err != nil {
+		return "", nil, err
+	}
+
+	if fctx.manifests == nil {
+		fctx.manifests = make(map[string]cachedManifest)
+	}
+	fctx.manifests[key] = cachedManifest{
+		mediaType: mediaType,
+		payload:   payload,
+	}
+
+	return mediaType, payload, nil
+}
+
+// getManifest returns the parsed repository manifest identified by its digest.
+func (fctx *funcContext) getManifest(ctx context.Context, repository distribution.Repository, dgst digest.Digest) (*ociManifest, error) {
+	_, payload, err := fctx.getManifestPayload(ctx, repository, dgst)
+	if err != nil {
+		return nil, err
+	}
+	manifest := new(ociManifest)
+	if err := json.Unmarshal(payload, manifest); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// getImageManifest returns the parsed repository image manifest identified by
+// its digest, the returned manifest is nil if the digest references an index.
+func (fctx *funcContext) getImageManifest(ctx context.Context, repository distribution.Repository, dgst digest.Digest) (*ociManifest, error) {
+	mediaType, payload, err := fctx.getManifestPayload(ctx, repository, dgst)
+	if err != nil {
+		return nil, err
+	} else if isIndexMediaType(mediaType) {
+		return nil, nil
+	}
+	manifest := new(ociManifest)
+	if err := json.Unmarshal(payload, manifest); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// referrersTag returns the tag used by the OCI referrers tag schema
+// to reference the index listing the referrers of a manifest digest.
+func referrersTag(dgst digest.Digest) string {
+	return fmt.Sprintf("%s-%s", dgst.Algorithm(), dgst.Hex())
+}
+
+// getReferrers returns the descriptors of the manifests referring to the subject
+// manifest digest. As the registry doesn't implement the referrers API, referrers
+// are looked up with the referrers tag schema described by the OCI distribution
+// specification. When artifactType is not empty, only referrers matching this
+// artifact type are returned.
+func (fctx *funcContext) getReferrers(ctx context.Context, repository distribution.Repository, subject digest.Digest, artifactType string) ([]v1.Descriptor, error) {
+	desc, err := fctx.getTag(ctx, repository, referrersTag(subject))
+	if err != nil {
+		return nil, err
+	} else if desc == nil {
+		return nil, nil
+	}
+
+	_, payload, err := fctx.getManifestPayload(ctx, repository, desc.Digest)
+	if err != nil {
+		if isUnknown(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	index := new(v1.IndexManifest)
+	if err := json.Unmarshal(payload, index); err != nil {
+		return nil, fmt.Errorf("while parsing referrers index: %w", err)
+	}
+
+	referrers := make([]v1.Descriptor, 0, len(index.Manifests))
+	for _, referrer := range index.Manifests {
+		if artifactType != "" && referrer.ArtifactType != artifactType {
+			continue
+		}
+		referrers = append(referrers, referrer)
+	}
+
+	return referrers, nil
+}
+
+// blobExists returns true if the blob identified by its digest is known by the blob statter.
+func (fctx *funcContext) blobExists(ctx context.Context, statter distribution.BlobStatter, dgst digest.Digest) (bool, error) {
+	if err := fctx.registryCall(); err != nil {
+		return false, err
+	}
+	if _, err := statter.Stat(ctx, dgst); err != nil {
+		if errors.Is(err, distribution.ErrBlobUnknown) {
+			return false, nil
+		}
+		return false, fmt.Errorf("while getting blob %s: %w", dgst, err)
+	}
+	return true, nil
+}
+
+// requestRepositoryName returns the name of the repository targeted by a
+// registry API request, it returns an empty string if the request doesn't
+// target a repository.
+func (fctx *funcContext) requestRepositoryName() string {
+	route, vars := matchV2Route(fctx.req.URL.Path)
+	if route == nil || len(vars) == 0 {
+		return ""
+	}
+	return vars[0]
+}
+
+// requestRepository returns the repository targeted by a registry API
+// request, it returns nil if the request doesn't target a repository.
+func (fctx *funcContext) requestRepository(ctx context.Context) (distribution.Repository, error) {
+	name := fctx.requestRepositoryName()
+	if name == "" {
+		return nil, nil
+	}
+	namedRef, err := reference.WithName(name)
+	if err != nil {
+		return nil, fmt.Errorf("bad repository name %s", name)
+	}
+	repository, err := fctx.registry.Repository(ctx, namedRef)
+	if err != nil {
+		return nil, fmt.Errorf("while getting repository %s: %w", namedRef, err)
+	}
+	return repository, nil
+}
+
+// parseIndex parses an image index payload.
+func parseIndex(payload []byte) (*v1.IndexManifest, error) {
+	index := new(v1.IndexManifest)
+	if err := json.Unmarshal(payload, index); err != nil {
+		return nil, fmt.Errorf("while parsing image index: %w", err)
+	}
+	return index, nil
+}
+
+// imageSize returns the size of an image manifest, its config and its layers.
+func imageSize(manifestSize int64, manifest *ociManifest) int64 {
+	size := manifestSize + manifest.Config.Size
+	for _, layer := range manifest.Layers {
+		size += layer.Size
+	}
+	return size
+}
+
+// getImageSize returns the size of the repository image manifest identified by
+// its digest, its config and its layers. An index is resolved to its child
+// manifest of the default platform, the returned size is 0 if there is none.
+func (fctx *funcContext) getImageSize(ctx context.Context, repository distribution.Repository, dgst digest.Digest) (int64, error) {
+	mediaType, payload, err := fctx.getManifestPayload(ctx, repository, dgst)
+	if err != nil {
+		return 0, err
+	} else if isIndexMediaType(mediaType) {
+		index, err := parseIndex(payload)
+		if err != nil {
+			return 0, err
+		}
+		desc := findPlatformManifest(index, &defaultPlatform)
+		if desc == nil {
+			return 0, nil
+		}
+		mediaType, payload, err = fctx.getManifestPayload(ctx, repository, digest.Digest(desc.Digest.String()))
+		if err != nil {
+			return 0, err
+		} else if isIndexMediaType(mediaType) {
+			return 0, nil
+		}
+	}
+
+	// the tag descriptor size isn't set for digest references
+	manifest := new(ociManifest)
+	if err := json.Unmarshal(payload, manifest); err != nil {
+		return 0, err
+	}
+	return imageSize(int64(len(payload)), manifest), nil
+}
+
+// getIndexSize returns the aggregated size of an image index, its child
+// manifests and their blobs, blobs shared by child manifests are only
+// accounted once.
+func (fctx *funcContext) getIndexSize(ctx context.Context, repository distribution.Repository, indexPayload []byte) (int64, error) {
+	index, err := parseIndex(indexPayload)
+	if err != nil {
+		return 0, err
+	}
+
+	size := int64(len(indexPayload))
+	blobs := make(map[v1.Hash]int64)
+
+	for _, child := range index.Manifests {
+		size += child.Size
+
+		manifest, err := fctx.getManifest(ctx, repository, digest.Digest(child.Digest.String()))
+		if err != nil {
+			return 0, err
+		}
+		blobs[manifest.Config.Digest] = manifest.Config.Size
+		for _, layer := range manifest.Layers {
+			blobs[layer.Digest] = layer.Size
+		}
+	}
+
+	for _, blobSize := range blobs {
+		size += blobSize
+	}
+
+	return size, nil
+}
+
+// getUncompressedLayerSize returns the uncompressed size of a layer, the
+// size of gzip compressed layers is estimated from the gzip trailer. As the
+// trailer stores the size modulo 2^32, the estimation is only correct for
+// layers uncompressed to less than 4GiB and the size of layers compressed
+// to 4GiB or more is unknown. The boolean returned is false when the
+// uncompressed size can't be determined.
+func (fctx *funcContext) getUncompressedLayerSize(ctx context.Context, repository distribution.Repository, layer v1.Descriptor) (int64, bool, error) {
+	switch layer.MediaType {
+	case regtypes.OCIUncompressedLayer, regtypes.OCIUncompressedRestrictedLayer, regtypes.DockerUncompressedLayer:
+		return layer.Size, true, nil
+	case regtypes.OCILayer, regtypes.OCIRestrictedLayer, regtypes.DockerLayer, regtypes.DockerForeignLayer:
+	default:
+		return 0, false, nil
+	}
+
+	// the gzip trailer ends with the uncompressed size modulo 2^32
+	const (
+		trailerSize = 4
+		maxSize     = 1 << 32
+	)
+
+	// the trailer of a layer compressed to 4GiB or more has wrapped around
+	if layer.Size < trailerSize || layer.Size >= maxSize {
+		return 0, false, nil
+	} else if err := fctx.registryCall(); err != nil {
+		return 0, false, err
+	}
+
+	rc, err := repository.Blobs(ctx).Open(ctx, digest.Digest(layer.Digest.String()))
+	if err != nil {
+		if errors.Is(err, distribution.ErrBlobUnknown) {
+			return 0, false, nil
+		}
+		return 0, false, fmt.Errorf("while opening blob %s: %w", layer.Digest, err)
+	}
+	defer rc.Close()
+
+	if _, err := rc.Seek(-trailerSize, io.SeekEnd); err != nil {
+		return 0, false, fmt.Errorf("while seeking blob %s: %w", layer.Digest, err)
+	}
+	trailer := make([]byte, trailerSize)
+	if _, err := io.ReadFull(rc, trailer); err != nil {
+		return 0, false, fmt.Errorf("while reading blob %s: %w", layer.Digest, err)
+	}
+
+	return int64(binary.LittleEndian.Uint32(trailer)), true, nil
+}
+
+// getConfig returns the parsed image config referenced by the manifest, the
+// returned config is nil if the config blob is unknown or if the manifest
+// doesn't reference any, like an index parsed as an image manifest. Like
+// getConfigBlob, config blobs larger than maxConfigBlobSize are rejected.
+func (fctx *funcContext) getConfig(ctx context.Context, repository distribution.Repository, manifest *ociManifest) (*v1.ConfigFile, error) {
+	if manifest.Config.Digest == (v1.Hash{}) {
+		return nil, nil
+	}
+	data, err := fctx.getConfigBlob(ctx, repository, manifest)
+	if err != nil || data == nil {
+		return nil, err
+	}
+	config := new(v1.ConfigFile)
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("%w %s: %s", errBadConfig, manifest.Config.Digest, err)
+	}
+	return config, nil
+}
+
+// getConfigBlob returns the raw config blob referenced by the manifest, the
+// returned blob is nil if the config blob is unknown. Config blobs larger
+// than maxConfigBlobSize are rejected.
+func (fctx *funcContext) getConfigBlob(ctx context.Context, repository distribution.Repository, manifest *ociManifest) ([]byte, error) {
+	if manifest.Config.Size > maxConfigBlobSize {
+		return nil, fmt.Errorf("%w %s: %d bytes", errConfigTooLarge, manifest.Config.Digest, manifest.Config.Size)
+	}
+	if err := fctx.registryCall(); err != nil {
+		return nil, err
+	}
+	rc, err := repository.Blobs(ctx).Open(ctx, digest.Digest(manifest.Config.Digest.String()))
+	if err != nil {
+		if errors.Is(err, distribution.ErrBlobUnknown) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("while getting config blob %s: %w", manifest.Config.Digest, err)
+	}
+	defer rc.Close()
+
+	// the manifest size may lie, don't trust it
+	data, err := io.ReadAll(io.LimitReader(rc, maxConfigBlobSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("while reading config blob %s: %w", manifest.Config.Digest, err)
+	} else if len(data) > maxConfigBlobSize {
+		return nil, fmt.Errorf("%w %s: more than %d bytes", errConfigTooLarge, manifest.Config.Digest, maxConfigBlobSize)
+	}
+	return data, nil
+}
+
+// readBlob returns the repository blob described by the descriptor, the
+// returned blob is nil if the blob is unknown. Blobs larger than limit are
+// rejected with errBlobTooLarge.
+func (fctx *funcContext) readBlob(ctx context.Context, repository distribution.Repository, desc v1.Descriptor, limit int64) ([]byte, error) {
+	if desc.Size > limit {
+		return nil, fmt.Errorf("%w %s: %d bytes", errBlobTooLarge, desc.Digest, desc.Size)
+	} else if err := fctx.registryCall(); err != nil {
+		return nil, err
+	}
+	rc, err := repository.Blobs(ctx).Open(ctx, digest.Digest(desc.Digest.String()))
+	if err != nil {
+		if errors.Is(err, distribution.ErrBlobUnknown) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("while getting blob %s: %w", desc.Digest, err)
+	}
+	defer rc.Close()
+
+	// the manifest size may lie, don't trust it
+	data, err := io.ReadAll(io.LimitReader(rc, limit+1))
+	if err != nil {
+		return nil, fmt.Errorf("while reading blob %s: %w", desc.Digest, err)
+	} else if int64(len(data)) > limit {
+		return nil, fmt.Errorf("%w %s: more than %d bytes", errBlobTooLarge, desc.Digest, limit)
+	}
+	return data, nil
+}
+
+// getRepositoryBlobs returns the sorted set of blob digests referenced by the
+// repository manifests. Manifests are enumerated when the manifest service
+// supports it, otherwise they are discovered from the repository tags.
+func (fctx *funcContext) getRepositoryBlobs(ctx context.Context, repository distribution.Repository) ([]string, error) {
+	blobs, err := fctx.getRepositoryBlobSizes(ctx, repository)
+	if err != nil {
+		return nil, err
+	}
+
+	digests := make([]string, 0, len(blobs))
+	for dgst := range blobs {
+		digests = append(digests, dgst)
+	}
+	sort.Strings(digests)
+
+	return digests, nil
+}
+
+// getRepositoryBlobSizes returns the sizes of the blobs referenced by the
+// repository manifests keyed by digest, sizes are the sizes advertised by
+// the manifest descriptors.
+func (fctx *funcContext) getRepositoryBlobSizes(ctx context.Context, repository distribution.Repository) (map[string]int64, error) {
+	manifestService, err := repository.Manifests(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("while getting manifest service for %s: %w", repository.Named(), err)
+	}
+
+	blobs := make(map[string]int64)
+	visited := make(map[digest.Digest]struct{})
+
+	var walkFn func(dgst digest.Digest) error
+
+	walkFn = func(dgst digest.Digest) error {
+		if _, ok := visited[dgst]; ok {
+			return nil
+		}
+		visited[dgst] = struct{}{}
+
+		mediaType, payload, err := fctx.getManifestPayload(ctx, repository, dgst)
+		if err != nil {
+			if isUnknown(err) {
+				return nil
+			}
+			return err
+		}
+
+		if isIndexMediaType(mediaType) {
+			index, err := parseIndex(payload)
+			if err != nil {
+				return err
+			}
+			for _, child := range index.Manifests {
+				if err := walkFn(digest.Digest(child.Digest.String())); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+
+		manifest := new(ociManifest)
+		if err := json.Unmarshal(payload, manifest); err != nil {
+			return fmt.Errorf("while parsing manifest %s: %w", dgst, err)
+		}
+		if manifest.Config.Digest.Hex != "" {
+			blobs[manifest.Config.Digest.String()] = manifest.Config.Size
+		}
+		for _, layer := range manifest.Layers {
+			blobs[layer.Digest.String()] = layer.Size
+		}
+		return nil
+	}
+
+	if enumerator, ok := manifestService.(distribution.ManifestEnumerator); ok {
+		if err := fctx.registryCall(); err != nil {
+			return nil, err
+		}
+		err := enumerator.Enumerate(ctx, walkFn)
+		if err != nil && !isUnknown(err) {
+			return nil, fmt.Errorf("while enumerating manifests of %s: %w", repository.Named(), err)
+		}
+	} else {
+		tags, err := fctx.getTags(ctx, repository)
+		if err != nil {
+			return nil, err
+		}
+		for _, tag := range tags {
+			desc, err := fctx.getTag(ctx, repository, tag)
+			if err != nil {
+				return nil, err
+			} else if desc == nil {
+				continue
+			}
+			if err := walkFn(desc.Digest); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return blobs, nil
+}
+
+// getRepositorySize returns the total size of the distinct blobs referenced
+// by the repository manifests. Sizes are memoized for the lifetime of the
+// evaluation.
+func (fctx *funcContext) getRepositorySize(ctx context.Context, repository distribution.Repository) (int64, error) {
+	name := repository.Named().Name()
+	if size, ok := fctx.repositorySizes[name]; ok {
+		return size, nil
+	}
+
+	blobs, err := fctx.getRepositoryBlobSizes(ctx, repository)
+	if err != nil {
+		return 0, err
+	}
+
+	size := int64(0)
+	for _, blobSize := range blobs {
+		size += blobSize
+	}
+
+	if fctx.repositorySizes == nil {
+		fctx.repositorySizes = make(map[string]int64)
+	}
+	fctx.repositorySizes[name] = size
+
+	return size, nil
+}
+
+// findPlatformManifest returns the descriptor of the first index child
+// manifest satisfying the platform, it returns nil if there is none.
+func findPlatformManifest(index *v1.IndexManifest, platform *v1.Platform) *v1.Descriptor {
+	for i, child := range index.Manifests {
+		if child.Platform == nil {
+			continue
+		} else if child.Platform.Satisfies(*platform) {
+			return &index.Manifests[i]
+		}
+	}
+	return nil
+}
+
+// getPlatformManifest returns the parsed repository image manifest identified
+// by its digest, an index is resolved to its first child manifest satisfying the
+// platform. The returned manifest is nil if no index child satisfies the platform.
+func (fctx *funcContext) getPlatformManifest(ctx context.Context, repository distribution.Repository, dgst digest.Digest, platform *v1.Platform) (*ociManifest, error) {
+	mediaType, payload, err := fctx.getManifestPayload(ctx, repository, dgst)
+	if err != nil {
+		return nil, err
+	} else if !isIndexMediaType(mediaType) {
+		manifest := new(ociManifest)
+		if err := json.Unmarshal(payload, manifest); err != nil {
+			return nil, err
+		}
+		return manifest, nil
+	}
+
+	index, err := parseIndex(payload)
+	if err != nil {
+		return nil, err
+	}
+	desc := findPlatformManifest(index, platform)
+	if desc == nil {
+		return nil, nil
+	}
+
+	return fctx.getImageManifest(ctx, repository, digest.Digest(desc.Digest.String()))
+}
+
+// getTags returns the sorted repository tags, it returns
+// no tags if the repository is unknown.
+func (fctx *funcContext) getTags(ctx context.Context, repository distribution.Repository) ([]string, error) {
+	if err := fctx.registryCall(); err != nil {
+		return nil, err
+	}
+	tags, err := repository.Tags(ctx).All(ctx)
+	if err != nil {
+		if isUnknown(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("while listing tags of %s: %w", repository.Named(), err)
+	}
+	sort.Strings(tags)
+	return tags, nil
+}
+
+// repositoryIsEmpty returns true if the repository is unknown or has no tags.
+func (fctx *funcContext) repositoryIsEmpty(ctx context.Context, repository distribution.Repository) (bool, error) {
+	tags, err := fctx.getTags(ctx, repository)
+	if err != nil {
+		return false, err
+	}
+	return len(tags) == 0, nil
+}
+
+// resolveConfig returns the parsed image config of a name:tag reference, the
+// returned config is nil if the tag is unknown, references an image index
+// or if the config blob is unknown.
+func (fctx *funcContext) resolveConfig(ctx context.Context, ref string) (*v1.ConfigFile, error) {
+	repository, tagDesc, err := fctx.resolveTag(ctx, ref)
+	if err != nil {
+		return nil, err
+	} else if tagDesc == nil {
+		return nil, nil
+	}
+	manifest, err := fctx.getImageManifest(ctx, repository, tagDesc.Digest)
+	if err != nil || manifest == nil {
+		return nil, err
+	}
+	return fctx.getConfig(ctx, repository, manifest)
+}
+
+// resolveLabels returns the config labels of a name:tag reference merged with
+// the manifest annotations, annotations win over labels with the same key. The
+// returned labels are empty if the tag is unknown, only the annotations of an
+// index are returned.
+func (fctx *funcContext) resolveLabels(ctx context.Context, ref string) (map[string]string, error) {
+	labels := make(map[string]string)
+
+	repository, tagDesc, err := fctx.resolveTag(ctx, ref)
+	if err != nil {
+		return nil, err
+	} else if tagDesc == nil {
+		return labels, nil
+	}
+	manifest, err := fctx.getManifest(ctx, repository, tagDesc.Digest)
+	if err != nil {
+		return nil, err
+	}
+	config, err := fctx.getConfig(ctx, repository, manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	if config != nil {
+		for k, v := range config.Config.Labels {
+			labels[k] = v
+		}
+	}
+	for k, v := range manifest.Annotations {
+		labels[k] = v
+	}
+
+	return labels, nil
+}
+
+// getAttestationStatements returns the in-toto statements attached to the
+// manifest descriptor either as OCI referrers or as buildx attestation
+// manifests when the descriptor references an index. Malformed statements
+// and statements larger than maxAttestationBlobSize are ignored.
+func (fctx *funcContext) getAttestationStatements(ctx context.Context, repository distribution.Repository, desc *distribution.Descriptor) ([]*inTotoStatement, error) {
+	referrers, err := fctx.getReferrers(ctx, repository, desc.Digest, "")
+	if err != nil {
+		return nil, err
+	}
+
+	attestations := make([]digest.Digest, 0, len(referrers))
+	for _, referrer := range referrers {
+		attestations = append(attestations, digest.Digest(referrer.Digest.String()))
+	}
+
+	mediaType, payload, err := fctx.getManifestPayload(ctx, repository, desc.Digest)
+	if err != nil {
+		return nil, err
+	} else if isIndexMediaType(mediaType) {
+		index, err := parseIndex(payload)
+		if err != nil {
+			return nil, err
+		}
+		for _, child := range index.Manifests {
+			if child.Annotations[dockerReferenceTypeAnnotation] == dockerAttestationManifest {
+				attestations = append(attestations, digest.Digest(child.Digest.String()))
+			}
+		}
+	}
+
+	var statements []*inTotoStatement
+
+	for _, attestation := range attestations {
+		manifest, err := fctx.getImageManifest(ctx, repository, attestation)
+		if err != nil {
+			if isUnknown(err) {
+				continue
+			}
+			return nil, err
+		} else if manifest == nil {
+			continue
+		}
+
+		for _, layer := range manifest.Layers {
+			if !isAttestationLayer(string(layer.MediaType)) {
+				continue
+			}
+			data, err := fctx.readBlob(ctx, repository, layer, maxAttestationBlobSize)
+			if err != nil {
+				if errors.Is(err, errBlobTooLarge) {
+					continue
+				}
+				return nil, fmt.Errorf("while getting attestation blob %s: %w", layer.Digest, err)
+			} else if data == nil {
+				continue
+			}
+			statement, err := parseInTotoStatement(string(layer.MediaType), data)
+			if err != nil {
+				continue
+			}
+			statements = append(statements, statement)
+		}
+	}
+
+	return statements, nil
+}
+
+// blobSizesValid returns true if the sizes of the config and layer blobs stored
+// in the repository match the sizes declared by the manifest descriptors.
+func (fctx *funcContext) blobSizesValid(ctx context.Context, repository distribution.Repository, manifest *ociManifest) (bool, error) {
+	blobs := repository.Blobs(ctx)
+
+	descriptors := append([]v1.Descriptor{manifest.Config}, manifest.Layers...)
+
+	for _, desc := range descriptors {
+		if err := fctx.registryCall(); err != nil {
+			return false, err
+		}
+		stat, err := blobs.Stat(ctx, digest.Digest(desc.Digest.String()))
+		if err != nil {
+			if errors.Is(err, distribution.ErrBlobUnknown) {
+				return false, nil
+			}
+			return false, fmt.Errorf("while getting blob %s: %w", desc.Digest, err)
+		} else if stat.Size != desc.Size {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// catalogPageSize is the number of repositories fetched per catalog call.
+const catalogPageSize = 100
+
+// getRepositories returns the registry repository names by paginating over the catalog.
+func (fctx *funcContext) getRepositories(ctx context.Context) ([]string, error) {
+	var (
+		repositories []string
+		last         string
+	)
+
+	page := make([]string, catalogPageSize)
+
+	for {
+		if err := fctx.registryCall(); err != nil {
+			return nil, err
+		}
+		n, err := fctx.registry.Repositories(ctx, page, last)
+		repositories = append(repositories, page[:n]...)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return repositories, nil
+			}
+			return nil, fmt.Errorf("while listing repositories: %w", err)
+		} else if n == 0 {
+			return repositories, nil
+		}
+		last = page[n-1]
+	}
+}
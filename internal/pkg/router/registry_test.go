@@ -0,0 +1,73 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindPlatformManifest(t *testing.T) {
+	index := &v1.IndexManifest{
+		Manifests: []v1.Descriptor{
+			{
+				Digest: v1.Hash{Algorithm: "sha256", Hex: "amd64"},
+				Platform: &v1.Platform{
+					OS:           "linux",
+					Architecture: "amd64",
+				},
+			},
+			{
+				Digest: v1.Hash{Algorithm: "sha256", Hex: "attestation"},
+			},
+			{
+				Digest: v1.Hash{Algorithm: "sha256", Hex: "armv7"},
+				Platform: &v1.Platform{
+					OS:           "linux",
+					Architecture: "arm",
+					Variant:      "v7",
+				},
+			},
+			{
+				Digest: v1.Hash{Algorithm: "sha256", Hex: "arm64"},
+				Platform: &v1.Platform{
+					OS:           "linux",
+					Architecture: "arm64",
+					Variant:      "v8",
+				},
+			},
+		},
+	}
+
+	tests := []struct {
+		platform string
+		digest   string
+	}{
+		{platform: "linux/amd64", digest: "sha256:amd64"},
+		{platform: "linux/arm/v7", digest: "sha256:armv7"},
+		{platform: "linux/arm", digest: "sha256:armv7"},
+		{platform: "linux/arm/v6", digest: ""},
+		{platform: "linux/arm64", digest: "sha256:arm64"},
+		{platform: "linux/arm64/v8", digest: "sha256:arm64"},
+		{platform: "windows/amd64", digest: ""},
+		{platform: "linux/s390x", digest: ""},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.platform, func(t *testing.T) {
+			platform, err := v1.ParsePlatform(tc.platform)
+			require.NoError(t, err)
+
+			desc := findPlatformManifest(index, platform)
+			if tc.digest == "" {
+				require.Nil(t, desc)
+				return
+			}
+			require.NotNil(t, desc)
+			require.Equal(t, tc.digest, desc.Digest.String())
+		})
+	}
+}
@@ -0,0 +1,435 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+// Package registrytest provides an in-memory registry to test
+// the router builtins without a real registry.
+package registrytest
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/opencontainers/go-digest"
+)
+
+// Registry is an in-memory registry implementing the parts of
+// distribution.Namespace used by the router builtins. It's safe
+// for concurrent use.
+type Registry struct {
+	distribution.Namespace
+
+	mu           sync.Mutex
+	repositories map[string]*repositoryData
+	calls        int
+	errs         map[Lookup]error
+	delays       map[Lookup]time.Duration
+}
+
+// Lookup is a kind of registry lookup.
+type Lookup int
+
+const (
+	// TagLookup is a tag lookup.
+	TagLookup Lookup = iota
+	// ManifestLookup is a manifest lookup.
+	ManifestLookup
+	// BlobLookup is a blob lookup.
+	BlobLookup
+)
+
+type repositoryData struct {
+	tags      map[string]digest.Digest
+	manifests map[digest.Digest]*Manifest
+	blobs     map[digest.Digest][]byte
+}
+
+// Manifest is a registry manifest.
+type Manifest struct {
+	MediaType string
+	Data      []byte
+}
+
+// References implements distribution.Manifest, the
+// references are not used by the builtins.
+func (m *Manifest) References() []distribution.Descriptor {
+	return nil
+}
+
+// Payload implements distribution.Manifest.
+func (m *Manifest) Payload() (string, []byte, error) {
+	return m.MediaType, m.Data, nil
+}
+
+// New returns an empty registry.
+func New() *Registry {
+	return &Registry{
+		repositories: make(map[string]*repositoryData),
+		errs:         make(map[Lookup]error),
+		delays:       make(map[Lookup]time.Duration),
+	}
+}
+
+func (r *Registry) repository(name string) *repositoryData {
+	repo, ok := r.repositories[name]
+	if !ok {
+		repo = &repositoryData{
+			tags:      make(map[string]digest.Digest),
+			manifests: make(map[digest.Digest]*Manifest),
+			blobs:     make(map[digest.Digest][]byte),
+		}
+		r.repositories[name] = repo
+	}
+	return repo
+}
+
+// AddManifest adds a manifest to the repository and returns its digest.
+func (r *Registry) AddManifest(repository, mediaType, payload string) digest.Digest {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	dgst := digest.FromString(payload)
+	r.repository(repository).manifests[dgst] = &Manifest{
+		MediaType: mediaType,
+		Data:      []byte(payload),
+	}
+	return dgst
+}
+
+// TagManifest adds a manifest to the repository, tags it and returns its digest.
+func (r *Registry) TagManifest(repository, tag, mediaType, payload string) digest.Digest {
+	dgst := r.AddManifest(repository, mediaType, payload)
+	r.Tag(repository, tag, dgst)
+	return dgst
+}
+
+// Tag associates the repository tag to the digest.
+func (r *Registry) Tag(repository, tag string, dgst digest.Digest) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.repository(repository).tags[tag] = dgst
+}
+
+// TagDigest returns the digest the repository tag is associated to.
+func (r *Registry) TagDigest(repository, tag string) (digest.Digest, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	repo, ok := r.repositories[repository]
+	if !ok {
+		return "", false
+	}
+	dgst, ok := repo.tags[tag]
+	return dgst, ok
+}
+
+// AddBlob adds a blob to the repository and returns its digest.
+func (r *Registry) AddBlob(repository, data string) digest.Digest {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	dgst := digest.FromString(data)
+	r.repository(repository).blobs[dgst] = []byte(data)
+	return dgst
+}
+
+// FailWith makes the lookups fail with the error, all lookups
+// fail if none is specified. A nil error restores the lookups.
+func (r *Registry) FailWith(err error, lookups ...Lookup) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(lookups) == 0 {
+		lookups = []Lookup{TagLookup, ManifestLookup, BlobLookup}
+	}
+	for _, lookup := range lookups {
+		r.errs[lookup] = err
+	}
+}
+
+// Delay makes the lookups wait for the duration before completing, all
+// lookups are delayed if none is specified. A delayed lookup returns the
+// context error if the context is done before the end of the delay.
+func (r *Registry) Delay(d time.Duration, lookups ...Lookup) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(lookups) == 0 {
+		lookups = []Lookup{TagLookup, ManifestLookup, BlobLookup}
+	}
+	for _, lookup := range lookups {
+		r.delays[lookup] = d
+	}
+}
+
+// wait waits for the delay of the kind of lookup, it must be
+// called without the mutex held.
+func (r *Registry) wait(ctx context.Context, kind Lookup) error {
+	r.mu.Lock()
+	d := r.delays[kind]
+	r.mu.Unlock()
+
+	if d <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Calls returns the number of tag, manifest and blob lookups.
+func (r *Registry) Calls() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.calls
+}
+
+// lookup accounts for a lookup in the named repository, it returns
+// the repository data, nil if the repository doesn't exist, or the
+// error injected for this kind of lookup. It must be called with
+// the mutex held.
+func (r *Registry) lookup(kind Lookup, name string) (*repositoryData, error) {
+	r.calls++
+	if err := r.errs[kind]; err != nil {
+		return nil, err
+	}
+	return r.repositories[name], nil
+}
+
+// Repository implements distribution.Namespace.
+func (r *Registry) Repository(_ context.Context, named reference.Named) (distribution.Repository, error) {
+	return &repository{
+		registry: r,
+		named:    named,
+	}, nil
+}
+
+// Repositories implements distribution.Namespace, it returns the
+// repository names sorted and io.EOF once all names are returned.
+func (r *Registry) Repositories(_ context.Context, repos []string, last string) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	names := make([]string, 0, len(r.repositories))
+	for name := range r.repositories {
+		if name > last {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	n := copy(repos, names)
+	if n == len(names) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// BlobStatter implements distribution.Namespace, blobs
+// are looked up across all repositories.
+func (r *Registry) BlobStatter() distribution.BlobStatter {
+	return &blobStore{registry: r}
+}
+
+type repository struct {
+	distribution.Repository
+	registry *Registry
+	named    reference.Named
+}
+
+func (r *repository) Named() reference.Named {
+	return r.named
+}
+
+func (r *repository) Manifests(_ context.Context, _ ...distribution.ManifestServiceOption) (distribution.ManifestService, error) {
+	return &manifestService{repository: r}, nil
+}
+
+func (r *repository) Blobs(_ context.Context) distribution.BlobStore {
+	return &blobStore{registry: r.registry, repository: r}
+}
+
+func (r *repository) Tags(_ context.Context) distribution.TagService {
+	return &tagService{repository: r}
+}
+
+type manifestService struct {
+	distribution.ManifestService
+	repository *repository
+}
+
+func (s *manifestService) get(ctx context.Context, dgst digest.Digest) (*Manifest, error) {
+	if err := s.repository.registry.wait(ctx, ManifestLookup); err != nil {
+		return nil, err
+	}
+
+	s.repository.registry.mu.Lock()
+	defer s.repository.registry.mu.Unlock()
+
+	name := s.repository.named.Name()
+
+	repo, err := s.repository.registry.lookup(ManifestLookup, name)
+	if err != nil {
+		return nil, err
+	} else if repo == nil {
+		return nil, distribution.ErrRepositoryUnknown{Name: name}
+	}
+	manifest, ok := repo.manifests[dgst]
+	if !ok {
+		return nil, distribution.ErrManifestUnknownRevision{Name: name, Revision: dgst}
+	}
+	return manifest, nil
+}
+
+func (s *manifestService) Exists(ctx context.Context, dgst digest.Digest) (bool, error) {
+	if _, err := s.get(ctx, dgst); err != nil {
+		if _, ok := err.(distribution.ErrManifestUnknownRevision); ok {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *manifestService) Get(ctx context.Context, dgst digest.Digest, _ ...distribution.ManifestServiceOption) (distribution.Manifest, error) {
+	manifest, err := s.get(ctx, dgst)
+	if err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+type tagService struct {
+	distribution.TagService
+	repository *repository
+}
+
+func (s *tagService) Get(ctx context.Context, tag string) (distribution.Descriptor, error) {
+	if err := s.repository.registry.wait(ctx, TagLookup); err != nil {
+		return distribution.Descriptor{}, err
+	}
+
+	s.repository.registry.mu.Lock()
+	defer s.repository.registry.mu.Unlock()
+
+	repo, err := s.repository.registry.lookup(TagLookup, s.repository.named.Name())
+	if err != nil {
+		return distribution.Descriptor{}, err
+	} else if repo == nil {
+		return distribution.Descriptor{}, distribution.ErrTagUnknown{Tag: tag}
+	}
+	dgst, ok := repo.tags[tag]
+	if !ok {
+		return distribution.Descriptor{}, distribution.ErrTagUnknown{Tag: tag}
+	}
+	// like the distribution tag store, the media type isn't set
+	return distribution.Descriptor{Digest: dgst}, nil
+}
+
+func (s *tagService) All(ctx context.Context) ([]string, error) {
+	if err := s.repository.registry.wait(ctx, TagLookup); err != nil {
+		return nil, err
+	}
+
+	s.repository.registry.mu.Lock()
+	defer s.repository.registry.mu.Unlock()
+
+	name := s.repository.named.Name()
+
+	repo, err := s.repository.registry.lookup(TagLookup, name)
+	if err != nil {
+		return nil, err
+	} else if repo == nil {
+		return nil, distribution.ErrRepositoryUnknown{Name: name}
+	}
+	tags := make([]string, 0, len(repo.tags))
+	for tag := range repo.tags {
+		tags = append(tags, tag)
+	}
+	return tags, nil
+}
+
+// blobStore looks up the blobs of a repository or of all
+// repositories if repository is nil.
+type blobStore struct {
+	distribution.BlobStore
+	registry   *Registry
+	repository *repository
+}
+
+func (s *blobStore) get(ctx context.Context, dgst digest.Digest) ([]byte, error) {
+	if err := s.registry.wait(ctx, BlobLookup); err != nil {
+		return nil, err
+	}
+
+	s.registry.mu.Lock()
+	defer s.registry.mu.Unlock()
+
+	if s.repository != nil {
+		repo, err := s.registry.lookup(BlobLookup, s.repository.named.Name())
+		if err != nil {
+			return nil, err
+		} else if repo != nil {
+			if data, ok := repo.blobs[dgst]; ok {
+				return data, nil
+			}
+		}
+		return nil, distribution.ErrBlobUnknown
+	}
+
+	s.registry.calls++
+	if err := s.registry.errs[BlobLookup]; err != nil {
+		return nil, err
+	}
+	for _, repo := range s.registry.repositories {
+		if data, ok := repo.blobs[dgst]; ok {
+			return data, nil
+		}
+	}
+	return nil, distribution.ErrBlobUnknown
+}
+
+func (s *blobStore) Stat(ctx context.Context, dgst digest.Digest) (distribution.Descriptor, error) {
+	data, err := s.get(ctx, dgst)
+	if err != nil {
+		return distribution.Descriptor{}, err
+	}
+	return distribution.Descriptor{
+		Digest: dgst,
+		Size:   int64(len(data)),
+	}, nil
+}
+
+func (s *blobStore) Get(ctx context.Context, dgst digest.Digest) ([]byte, error) {
+	return s.get(ctx, dgst)
+}
+
+func (s *blobStore) Open(ctx context.Context, dgst digest.Digest) (io.ReadSeekCloser, error) {
+	data, err := s.get(ctx, dgst)
+	if err != nil {
+		return nil, err
+	}
+	return &readSeekNopCloser{Reader: bytes.NewReader(data)}, nil
+}
+
+type readSeekNopCloser struct {
+	*bytes.Reader
+}
+
+func (readSeekNopCloser) Close() error {
+	return nil
+}
@@ -8,29 +8,117 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
+	"time"
 
 	"github.com/distribution/distribution/v3"
 	"github.com/open-policy-agent/opa/rego"
 	"github.com/open-policy-agent/opa/storage/inmem"
 	"github.com/open-policy-agent/opa/topdown"
 	"github.com/open-policy-agent/opa/util"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
-var errCancelled = topdown.Error{Code: topdown.CancelErr}
+// defaultBuiltinTimeout is the default maximum duration of a builtin
+// evaluation, it's longer than the default layer scan timeout.
+const defaultBuiltinTimeout = time.Minute
+
+var (
+	errCancelled      = topdown.Error{Code: topdown.CancelErr}
+	errBuiltinTimeout = errors.New("builtin timeout")
+)
 
 type Result struct {
 	Repository  string
 	RedirectURL string
 	Found       bool
+	// Deny is the reason returned by a policy denying the request.
+	Deny string
+	// Mode is the mode the decision has been taken with.
+	Mode Mode
+	// Audited reports that the policy denied the request but
+	// the decision is not enforced because of the audit mode.
+	Audited bool
+}
+
+// Denied returns true if the request must be denied.
+func (r *Result) Denied() bool {
+	return r.Deny != "" && !r.Audited
+}
+
+// Mode defines how deny decisions are handled.
+type Mode string
+
+const (
+	// ModeEnforce enforces deny decisions.
+	ModeEnforce Mode = "enforce"
+	// ModeAudit allows all requests, deny decisions are
+	// only recorded and emitted to the audit sink.
+	ModeAudit Mode = "audit"
+)
+
+// AuditEvent is emitted to the audit sink for each deny
+// decision not enforced in audit mode.
+type AuditEvent struct {
+	Router string
+	Method string
+	Path   string
+	Result Result
 }
 
+// AuditSink receives the audit events, it's called asynchronously.
+type AuditSink func(event AuditEvent)
+
 type RegoOption = func(r *rego.Rego)
 
 type RegoRouter struct {
-	name    string
-	options []RegoOption
-	peq     rego.PreparedEvalQuery
+	name               string
+	options            []RegoOption
+	peq                rego.PreparedEvalQuery
+	registryCallBudget int
+	sourceMappings     []SourceMapping
+	mode               Mode
+	auditSink          AuditSink
+	maintenanceWindows []MaintenanceWindow
+	deprecatedTypes    map[string]struct{}
+	externalInput      ExternalInputProvider
+	attestationURLs    map[string]struct{}
+	attestationClient  *http.Client
+	scannerURLs        map[string]struct{}
+	scannerClient      *http.Client
+	floatingTags       map[string]struct{}
+	capabilitiesKey    string
+	layerScanMaxBytes  int64
+	layerScanTimeout   time.Duration
+	artifactTypes      map[string]ArtifactType
+	requestBodyMaxSize int64
+	bodyBufferSize     int
+	bodyBuffers        *bufferPool
+	metrics            *builtinMetrics
+	logger             *slog.Logger
+	trustedProxy       bool
+	signatures         bool
+	builtinTimeout     time.Duration
+}
+
+// ArtifactType is an approved artifact type checked by oci.artifact_type_registered.
+type ArtifactType struct {
+	// MediaType is the artifact type.
+	MediaType string `json:"mediaType"`
+	// RequiredAnnotations are the annotations artifacts of this type must set.
+	RequiredAnnotations []string `json:"requiredAnnotations,omitempty"`
+}
+
+// ExternalInputProvider returns the external facts injected in the policy
+// input as input.external for a request.
+type ExternalInputProvider func(req *http.Request) (map[string]interface{}, error)
+
+// SourceMapping maps source repositories whose URL starts with Source
+// to registry repositories prefixed by Repository.
+type SourceMapping struct {
+	Source     string
+	Repository string
 }
 
 type RegoRouterOption func(r *RegoRouter) error
@@ -59,15 +147,260 @@ func WithOption(option RegoOption) RegoRouterOption {
 	}
 }
 
+// WithRegistryCallBudget sets the maximum number of registry calls
+// builtins are allowed to perform during a single policy evaluation,
+// a zero or negative budget disables the limit.
+func WithRegistryCallBudget(budget int) RegoRouterOption {
+	return func(r *RegoRouter) error {
+		r.registryCallBudget = budget
+		return nil
+	}
+}
+
+// WithSourceMappings sets the rules mapping source repositories to registry
+// repositories used by oci.source_matches_target.
+func WithSourceMappings(mappings ...SourceMapping) RegoRouterOption {
+	return func(r *RegoRouter) error {
+		r.sourceMappings = append(r.sourceMappings, mappings...)
+		return nil
+	}
+}
+
+// WithMode sets the router mode, policies may override it
+// with the mode field of their output.
+func WithMode(mode Mode) RegoRouterOption {
+	return func(r *RegoRouter) error {
+		switch mode {
+		case ModeEnforce, ModeAudit:
+			r.mode = mode
+		default:
+			return fmt.Errorf("unknown mode %q", mode)
+		}
+		return nil
+	}
+}
+
+// WithAuditSink sets the sink receiving deny decisions
+// not enforced in audit mode.
+func WithAuditSink(sink AuditSink) RegoRouterOption {
+	return func(r *RegoRouter) error {
+		r.auditSink = sink
+		return nil
+	}
+}
+
+// WithMaintenanceWindows sets the maintenance windows used by
+// request.in_maintenance_window when a policy doesn't provide any.
+func WithMaintenanceWindows(windows ...MaintenanceWindow) RegoRouterOption {
+	return func(r *RegoRouter) error {
+		for _, window := range windows {
+			if _, err := window.compile(); err != nil {
+				return fmt.Errorf("bad maintenance window: %w", err)
+			}
+		}
+		r.maintenanceWindows = append(r.maintenanceWindows, windows...)
+		return nil
+	}
+}
+
+// WithDeprecatedMediaTypes sets the deprecated media types reported by
+// oci.has_deprecated_types, it replaces the default deprecated media types.
+func WithDeprecatedMediaTypes(mediaTypes ...string) RegoRouterOption {
+	return func(r *RegoRouter) error {
+		r.deprecatedTypes = make(map[string]struct{}, len(mediaTypes))
+		for _, mediaType := range mediaTypes {
+			r.deprecatedTypes[mediaType] = struct{}{}
+		}
+		return nil
+	}
+}
+
+// WithExternalInput sets the provider of the external facts
+// injected in the policy input.
+func WithExternalInput(provider ExternalInputProvider) RegoRouterOption {
+	return func(r *RegoRouter) error {
+		r.externalInput = provider
+		return nil
+	}
+}
+
+// WithAttestationServices allowlists the attestation service URLs
+// queried by oci.attestation_status, calls are bounded by timeout.
+func WithAttestationServices(timeout time.Duration, serviceURLs ...string) RegoRouterOption {
+	return func(r *RegoRouter) (err error) {
+		if timeout <= 0 {
+			timeout = defaultServiceTimeout
+		}
+		r.attestationClient = &http.Client{Timeout: timeout}
+		r.attestationURLs, err = parseServiceURLs(serviceURLs)
+		if err != nil {
+			return fmt.Errorf("bad attestation services: %w", err)
+		}
+		return nil
+	}
+}
+
+// WithScannerServices allowlists the vulnerability scanner service URLs
+// queried by oci.scan_result, calls are bounded by timeout.
+func WithScannerServices(timeout time.Duration, serviceURLs ...string) RegoRouterOption {
+	return func(r *RegoRouter) (err error) {
+		if timeout <= 0 {
+			timeout = defaultServiceTimeout
+		}
+		r.scannerClient = &http.Client{Timeout: timeout}
+		r.scannerURLs, err = parseServiceURLs(serviceURLs)
+		if err != nil {
+			return fmt.Errorf("bad scanner services: %w", err)
+		}
+		return nil
+	}
+}
+
+// WithFloatingTags sets the floating tag names reported by
+// oci.is_floating_tag, it replaces the default floating tags.
+func WithFloatingTags(tags ...string) RegoRouterOption {
+	return func(r *RegoRouter) error {
+		r.floatingTags = make(map[string]struct{}, len(tags))
+		for _, tag := range tags {
+			r.floatingTags[tag] = struct{}{}
+		}
+		return nil
+	}
+}
+
+// WithCapabilitiesAnnotation sets the annotation holding the
+// capabilities returned by oci.required_capabilities.
+func WithCapabilitiesAnnotation(key string) RegoRouterOption {
+	return func(r *RegoRouter) error {
+		if key == "" {
+			return fmt.Errorf("empty capabilities annotation")
+		}
+		r.capabilitiesKey = key
+		return nil
+	}
+}
+
+// WithLayerScanLimits sets the maximum number of compressed layer bytes
+// builtins inspecting layer contents are allowed to stream during a single
+// policy evaluation and the maximum duration of a layer scan, a zero or
+// negative value disables the corresponding limit.
+func WithLayerScanLimits(maxBytes int64, timeout time.Duration) RegoRouterOption {
+	return func(r *RegoRouter) error {
+		r.layerScanMaxBytes = maxBytes
+		r.layerScanTimeout = timeout
+		return nil
+	}
+}
+
+// WithArtifactTypes registers the approved artifact types.
+func WithArtifactTypes(artifactTypes ...ArtifactType) RegoRouterOption {
+	return func(r *RegoRouter) error {
+		if r.artifactTypes == nil {
+			r.artifactTypes = make(map[string]ArtifactType, len(artifactTypes))
+		}
+		for _, artifactType := range artifactTypes {
+			if artifactType.MediaType == "" {
+				return fmt.Errorf("artifact type without media type")
+			}
+			r.artifactTypes[artifactType.MediaType] = artifactType
+		}
+		return nil
+	}
+}
+
+// WithRequestBodyMaxSize sets the maximum size of the request body
+// read by request.body, larger bodies make the evaluation fail.
+func WithRequestBodyMaxSize(maxSize int64) RegoRouterOption {
+	return func(r *RegoRouter) error {
+		if maxSize <= 0 {
+			return fmt.Errorf("request body maximum size must be positive")
+		}
+		r.requestBodyMaxSize = maxSize
+		return nil
+	}
+}
+
+// WithRequestBodyBufferSize sets the initial size of the small buffers
+// used by request.body, bodies whose content length is larger are read
+// in buffers eight times larger.
+func WithRequestBodyBufferSize(size int) RegoRouterOption {
+	return func(r *RegoRouter) error {
+		if size <= 0 {
+			return fmt.Errorf("request body buffer size must be positive")
+		}
+		r.bodyBufferSize = size
+		return nil
+	}
+}
+
+// WithMetrics records the builtin evaluation durations and errors
+// with collectors registered by the registerer.
+func WithMetrics(registerer prometheus.Registerer) RegoRouterOption {
+	return func(r *RegoRouter) (err error) {
+		r.metrics, err = newBuiltinMetrics(registerer)
+		if err != nil {
+			return fmt.Errorf("while registering builtin metrics: %w", err)
+		}
+		return nil
+	}
+}
+
+// WithLogger logs the builtin errors with the logger, the errors reporting
+// an unknown tag, manifest or repository are logged at debug level as they
+// are usually expected by policies.
+func WithLogger(logger *slog.Logger) RegoRouterOption {
+	return func(r *RegoRouter) error {
+		r.logger = logger
+		return nil
+	}
+}
+
+// WithTrustedProxy makes request.remote_addr return the client address
+// reported by the X-Forwarded-For header, it must be set only if beskar
+// is behind a proxy appending the client address to this header.
+func WithTrustedProxy() RegoRouterOption {
+	return func(r *RegoRouter) error {
+		r.trustedProxy = true
+		return nil
+	}
+}
+
+// WithSignatureVerification enables oci.signature_verified, it's disabled by
+// default as verifying signatures requires several registry calls.
+func WithSignatureVerification() RegoRouterOption {
+	return func(r *RegoRouter) error {
+		r.signatures = true
+		return nil
+	}
+}
+
+// WithBuiltinTimeout sets the maximum duration of a builtin evaluation
+// including its registry calls, a zero or negative value disables the
+// timeout.
+func WithBuiltinTimeout(timeout time.Duration) RegoRouterOption {
+	return func(r *RegoRouter) error {
+		r.builtinTimeout = timeout
+		return nil
+	}
+}
+
 func New(name, module string, options ...RegoRouterOption) (_ *RegoRouter, err error) {
 	router := &RegoRouter{
-		name: name,
-		options: []RegoOption{
+		name:               name,
+		registryCallBudget: defaultRegistryCallBudget,
+		mode:               ModeEnforce,
+		deprecatedTypes:    defaultDeprecatedTypes(),
+		floatingTags:       defaultFloatingTags(),
+		capabilitiesKey:    defaultCapabilitiesAnnotation,
+		layerScanMaxBytes:  defaultLayerScanMaxBytes,
+		layerScanTimeout:   defaultLayerScanTimeout,
+		builtinTimeout:     defaultBuiltinTimeout,
+		requestBodyMaxSize: defaultRequestBodyMaxSize,
+		bodyBufferSize:     defaultRequestBodyBufferSize,
+		options: append([]RegoOption{
 			rego.Query("data.router.output"),
 			rego.Module("router.rego", module),
-			ociBlobDigestBuiltin,
-			requestBodyBuiltin,
-		},
+		}, builtins()...),
 	}
 
 	for _, opt := range options {
@@ -76,6 +409,8 @@ func New(name, module string, options ...RegoRouterOption) (_ *RegoRouter, err e
 		}
 	}
 
+	router.bodyBuffers = newBufferPool(router.bodyBufferSize)
+
 	router.peq, err = rego.New(router.options...).PrepareForEval(context.Background())
 	if err != nil {
 		return nil, err
@@ -84,22 +419,43 @@ func New(name, module string, options ...RegoRouterOption) (_ *RegoRouter, err e
 	return router, nil
 }
 
+// Decision evaluates the routing policy for the request, the returned error
+// is a *BuiltinError if a builtin failed during the evaluation.
 func (rr *RegoRouter) Decision(req *http.Request, registry distribution.Namespace) (*Result, error) {
 	fctx := &funcContext{
 		req:      req,
 		registry: registry,
+		router:   rr,
 	}
 	ctx := context.WithValue(req.Context(), &funcContextKey, fctx)
 
-	rs, err := rr.peq.Eval(ctx, rego.EvalInput(map[string]string{
+	input := map[string]interface{}{
 		"path":   req.URL.Path,
 		"method": req.Method,
-	}))
+	}
+
+	if rr.externalInput != nil {
+		external, err := rr.externalInput(req)
+		if err != nil {
+			return nil, fmt.Errorf("while getting external input for %s routing decision: %w", rr.name, err)
+		}
+		fctx.external = external
+		input["external"] = external
+	}
+
+	rs, err := rr.peq.Eval(ctx, rego.EvalInput(input))
 	if err != nil {
-		if errors.Is(err, &errCancelled) && fctx.builtinErr != nil {
-			return nil, fctx.builtinErr
+		if errors.Is(err, &errCancelled) {
+			if builtinErr := fctx.err(); builtinErr != nil {
+				return nil, builtinErr
+			} else if ctxErr := req.Context().Err(); ctxErr != nil {
+				return nil, fmt.Errorf("%s routing decision cancelled: %w", rr.name, ctxErr)
+			}
 		}
 		return nil, err
+	} else if builtinErr := fctx.err(); builtinErr != nil {
+		// the evaluation may complete before noticing the cancellation
+		return nil, builtinErr
 	} else if len(rs) == 0 {
 		return nil, fmt.Errorf("no output returned for %s routing decision", rr.name)
 	} else if len(rs[0].Expressions) == 0 {
@@ -107,7 +463,9 @@ func (rr *RegoRouter) Decision(req *http.Request, registry distribution.Namespac
 	}
 
 	output := rs[0].Expressions[0].Value.(map[string]interface{})
-	result := &Result{}
+	result := &Result{
+		Mode: rr.mode,
+	}
 
 	if v, ok := output["repository"].(string); ok {
 		result.Repository = v
@@ -118,6 +476,30 @@ func (rr *RegoRouter) Decision(req *http.Request, registry distribution.Namespac
 	if v, ok := output["found"].(bool); ok {
 		result.Found = v
 	}
+	if v, ok := output["deny"].(string); ok {
+		result.Deny = v
+	}
+	if v, ok := output["mode"].(string); ok {
+		switch Mode(v) {
+		case ModeEnforce, ModeAudit:
+			result.Mode = Mode(v)
+		default:
+			return nil, fmt.Errorf("unknown mode %q returned for %s routing decision", v, rr.name)
+		}
+	}
+
+	if result.Deny != "" && result.Mode == ModeAudit {
+		result.Audited = true
+
+		if rr.auditSink != nil {
+			go rr.auditSink(AuditEvent{
+				Router: rr.name,
+				Method: req.Method,
+				Path:   req.URL.Path,
+				Result: *result,
+			})
+		}
+	}
 
 	return result, nil
 }
@@ -0,0 +1,446 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/open-policy-agent/opa/rego"
+	"github.com/open-policy-agent/opa/topdown"
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.ciq.dev/beskar/internal/pkg/router/registrytest"
+)
+
+const sameTagModule = `
+package router
+
+manifest_digest := oci.manifest_digest("library/alpine:latest")
+
+layer_digest := oci.blob_digest("library/alpine:latest", "mediatype", "application/vnd.oci.image.layer.v1.tar+gzip")
+
+output := {
+	"repository": manifest_digest,
+	"redirect_url": layer_digest,
+	"found": true,
+}
+`
+
+const sameTagManifest = `{
+	"schemaVersion": 2,
+	"mediaType": "application/vnd.oci.image.manifest.v1+json",
+	"config": {
+		"mediaType": "application/vnd.oci.image.config.v1+json",
+		"digest": "sha256:44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a",
+		"size": 2
+	},
+	"layers": [
+		{
+			"mediaType": "application/vnd.oci.image.layer.v1.tar+gzip",
+			"digest": "sha256:2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae",
+			"size": 3
+		}
+	]
+}`
+
+// newTestRegistry returns a registry with the library/alpine
+// image sameTagManifest tagged latest.
+func newTestRegistry() *registrytest.Registry {
+	registry := registrytest.New()
+	registry.TagManifest("library/alpine", "latest", "application/vnd.oci.image.manifest.v1+json", sameTagManifest)
+	return registry
+}
+
+func TestDecisionMemoizesManifestLookups(t *testing.T) {
+	router, err := New("test", sameTagModule)
+	require.NoError(t, err)
+
+	registry := newTestRegistry()
+
+	req := httptest.NewRequest(http.MethodGet, "/v2/library/alpine/manifests/latest", nil)
+	result, err := router.Decision(req, registry)
+	require.NoError(t, err)
+
+	require.Equal(t, digest.FromString(sameTagManifest).Hex(), result.Repository)
+	require.Equal(t, "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae", result.RedirectURL)
+	// one tag lookup and one manifest lookup
+	require.Equal(t, 2, registry.Calls())
+
+	// the memoization doesn't outlive the evaluation
+	_, err = router.Decision(req, registry)
+	require.NoError(t, err)
+	require.Equal(t, 4, registry.Calls())
+}
+
+func BenchmarkDecisionSameTag(b *testing.B) {
+	router, err := New("bench", sameTagModule)
+	require.NoError(b, err)
+
+	registry := newTestRegistry()
+	req := httptest.NewRequest(http.MethodGet, "/v2/library/alpine/manifests/latest", nil)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := router.Decision(req, registry); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	b.ReportMetric(float64(registry.Calls())/float64(b.N), "registry-calls/op")
+}
+
+func TestDecisionBuiltinError(t *testing.T) {
+	router, err := New("test", sameTagModule)
+	require.NoError(t, err)
+
+	storageErr := errors.New("storage unavailable")
+
+	registry := newTestRegistry()
+	registry.FailWith(storageErr, registrytest.ManifestLookup)
+
+	req := httptest.NewRequest(http.MethodGet, "/v2/library/alpine/manifests/latest", nil)
+	_, err = router.Decision(req, registry)
+	require.Error(t, err)
+
+	var builtinErr *BuiltinError
+	require.ErrorAs(t, err, &builtinErr)
+	require.Equal(t, "oci.blob_digest", builtinErr.Builtin)
+	require.ErrorIs(t, err, storageErr)
+	require.NotErrorIs(t, err, context.Canceled)
+}
+
+func TestDecisionBuiltinTimeout(t *testing.T) {
+	router, err := New("test", sameTagModule, WithBuiltinTimeout(200*time.Millisecond))
+	require.NoError(t, err)
+
+	registry := newTestRegistry()
+	registry.Delay(time.Minute, registrytest.TagLookup)
+
+	req := httptest.NewRequest(http.MethodGet, "/v2/library/alpine/manifests/latest", nil)
+
+	start := time.Now()
+	_, err = router.Decision(req, registry)
+	require.Less(t, time.Since(start), time.Minute)
+
+	var builtinErr *BuiltinError
+	require.ErrorAs(t, err, &builtinErr)
+	require.ErrorIs(t, err, errBuiltinTimeout)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Contains(t, err.Error(), "builtin timeout after 200ms")
+
+	// slow lookups completing before the timeout
+	registry.Delay(20*time.Millisecond, registrytest.TagLookup, registrytest.ManifestLookup)
+
+	result, err := router.Decision(req, registry)
+	require.NoError(t, err)
+	require.Equal(t, digest.FromString(sameTagManifest).Hex(), result.Repository)
+}
+
+// recordHandler is a slog handler recording the logged records.
+type recordHandler struct {
+	records []slog.Record
+}
+
+func (h *recordHandler) Enabled(context.Context, slog.Level) bool {
+	return true
+}
+
+func (h *recordHandler) Handle(_ context.Context, record slog.Record) error {
+	h.records = append(h.records, record)
+	return nil
+}
+
+func (h *recordHandler) WithAttrs([]slog.Attr) slog.Handler {
+	return h
+}
+
+func (h *recordHandler) WithGroup(string) slog.Handler {
+	return h
+}
+
+func TestDecisionBuiltinErrorLog(t *testing.T) {
+	handler := new(recordHandler)
+
+	router, err := New("test", sameTagModule, WithLogger(slog.New(handler)))
+	require.NoError(t, err)
+
+	registry := newTestRegistry()
+	registry.FailWith(errors.New("storage unavailable"), registrytest.ManifestLookup)
+
+	req := httptest.NewRequest(http.MethodGet, "/v2/library/alpine/manifests/latest", nil)
+	_, err = router.Decision(req, registry)
+	require.Error(t, err)
+
+	require.Len(t, handler.records, 1)
+	record := handler.records[0]
+	require.Equal(t, slog.LevelError, record.Level)
+
+	attrs := make(map[string]string)
+	record.Attrs(func(attr slog.Attr) bool {
+		attrs[attr.Key] = attr.Value.String()
+		return true
+	})
+	require.Equal(t, map[string]string{
+		"router":    "test",
+		"builtin":   "oci.blob_digest",
+		"reference": "library/alpine:latest",
+		"error":     "while getting manifest for library/alpine: storage unavailable",
+	}, attrs)
+
+	// unknown manifests are expected and logged at debug level
+	handler.records = nil
+
+	registry.FailWith(distribution.ErrManifestUnknownRevision{Name: "library/alpine"}, registrytest.ManifestLookup)
+
+	_, err = router.Decision(req, registry)
+	require.Error(t, err)
+
+	require.Len(t, handler.records, 1)
+	require.Equal(t, slog.LevelDebug, handler.records[0].Level)
+}
+
+const concurrentModule = `
+package router
+
+manifest_digest := oci.manifest_digest(sprintf("library/alpine:%s", [request.body().tag]))
+
+output := {
+	"repository": manifest_digest,
+	"redirect_url": request.body().tag,
+	"found": true,
+}
+`
+
+func TestDecisionConcurrent(t *testing.T) {
+	router, err := New("test", concurrentModule)
+	require.NoError(t, err)
+
+	registry := newTestRegistry()
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"tag":"latest"}`))
+			req.Header.Set("Content-Type", "application/json")
+
+			result, err := router.Decision(req, registry)
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, digest.FromString(sameTagManifest).Hex(), result.Repository)
+			assert.Equal(t, "latest", result.RedirectURL)
+		}()
+	}
+
+	wg.Wait()
+}
+
+func TestFuncContextConcurrentBuiltins(t *testing.T) {
+	router, err := New("test", sameTagModule)
+	require.NoError(t, err)
+
+	body := `{"tag":"latest"}`
+
+	fctx := &funcContext{
+		req:      httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)),
+		registry: newTestRegistry(),
+		router:   router,
+	}
+	bctx := rego.BuiltinContext{
+		Context: context.WithValue(context.Background(), &funcContextKey, fctx),
+		Cancel:  topdown.NewCancel(),
+	}
+
+	// builtin evaluates fn like a builtin sharing the function context
+	builtin := func(name string, fn func(*funcContext) error) (errFn error) {
+		bctx := bctx
+
+		funcContext, err := getFuncContext(&bctx)
+		if err != nil {
+			return err
+		}
+		defer funcContext.cancelOnError(bctx, name, &errFn)
+
+		return fn(funcContext)
+	}
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 16; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+
+			err := builtin("request.body", func(funcContext *funcContext) error {
+				data, err := funcContext.readBody()
+				if err == nil {
+					assert.Equal(t, body, string(data))
+				}
+				return err
+			})
+			assert.NoError(t, err)
+		}()
+		go func() {
+			defer wg.Done()
+
+			err := builtin("oci.blob_digest", func(funcContext *funcContext) error {
+				_, desc, err := funcContext.resolveTag(bctx.Context, "library/alpine:latest")
+				if err == nil {
+					assert.Equal(t, digest.FromString(sameTagManifest), desc.Digest)
+				}
+				return err
+			})
+			assert.NoError(t, err)
+		}()
+	}
+
+	wg.Wait()
+
+	require.NoError(t, fctx.err())
+
+	// only the first builtin error is recorded
+	firstErr := errors.New("first")
+
+	require.Error(t, builtin("oci.blob_digest", func(*funcContext) error { return firstErr }))
+	require.Error(t, builtin("request.body", func(*funcContext) error { return errors.New("second") }))
+
+	var builtinErr *BuiltinError
+	require.ErrorAs(t, fctx.err(), &builtinErr)
+	require.Equal(t, "oci.blob_digest", builtinErr.Builtin)
+	require.ErrorIs(t, builtinErr, firstErr)
+}
+
+func TestDecisionMode(t *testing.T) {
+	tests := []struct {
+		name     string
+		mode     Mode
+		output   string
+		denied   bool
+		audited  bool
+		expected Mode
+	}{
+		{
+			name:     "enforce deny",
+			output:   `"deny": "unsigned image"`,
+			denied:   true,
+			expected: ModeEnforce,
+		},
+		{
+			name:     "audit deny",
+			mode:     ModeAudit,
+			output:   `"deny": "unsigned image"`,
+			audited:  true,
+			expected: ModeAudit,
+		},
+		{
+			name:     "policy audit override",
+			mode:     ModeEnforce,
+			output:   `"deny": "unsigned image", "mode": "audit"`,
+			audited:  true,
+			expected: ModeAudit,
+		},
+		{
+			name:     "policy enforce override",
+			mode:     ModeAudit,
+			output:   `"deny": "unsigned image", "mode": "enforce"`,
+			denied:   true,
+			expected: ModeEnforce,
+		},
+		{
+			name:     "audit allow",
+			mode:     ModeAudit,
+			output:   `"mode": "audit"`,
+			expected: ModeAudit,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events := make(chan AuditEvent, 1)
+
+			options := []RegoRouterOption{
+				WithAuditSink(func(event AuditEvent) {
+					events <- event
+				}),
+			}
+			if tt.mode != "" {
+				options = append(options, WithMode(tt.mode))
+			}
+
+			router, err := New("test", `
+package router
+
+output := {
+	"found": true,
+	`+tt.output+`
+}
+`, options...)
+			require.NoError(t, err)
+
+			req := httptest.NewRequest(http.MethodPut, "/v2/library/alpine/manifests/latest", nil)
+			result, err := router.Decision(req, newTestRegistry())
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, result.Mode)
+			require.Equal(t, tt.denied, result.Denied())
+			require.Equal(t, tt.audited, result.Audited)
+
+			if !tt.audited {
+				select {
+				case event := <-events:
+					t.Fatalf("unexpected audit event %+v", event)
+				case <-time.After(50 * time.Millisecond):
+				}
+				return
+			}
+
+			select {
+			case event := <-events:
+				require.Equal(t, AuditEvent{
+					Router: "test",
+					Method: http.MethodPut,
+					Path:   "/v2/library/alpine/manifests/latest",
+					Result: *result,
+				}, event)
+			case <-time.After(5 * time.Second):
+				t.Fatal("no audit event emitted")
+			}
+		})
+	}
+}
+
+func TestDecisionUnknownMode(t *testing.T) {
+	_, err := New("test", sameTagModule, WithMode("dry-run"))
+	require.ErrorContains(t, err, `unknown mode "dry-run"`)
+
+	router, err := New("test", `
+package router
+
+output := {
+	"found": true,
+	"deny": "unsigned image",
+	"mode": "dry-run",
+}
+`)
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodPut, "/v2/library/alpine/manifests/latest", nil)
+	_, err = router.Decision(req, newTestRegistry())
+	require.ErrorContains(t, err, `unknown mode "dry-run"`)
+}
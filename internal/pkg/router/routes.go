@@ -0,0 +1,139 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"net/http"
+	"regexp"
+
+	"github.com/distribution/distribution/v3/reference"
+)
+
+// v2Route describes a route of the registry API v2.
+type v2Route struct {
+	name     string
+	template string
+	regexp   *regexp.Regexp
+}
+
+const (
+	v2RouteBase            = "base"
+	v2RouteCatalog         = "catalog"
+	v2RouteTags            = "tags"
+	v2RouteManifest        = "manifest"
+	v2RouteReferrers       = "referrers"
+	v2RouteBlob            = "blob"
+	v2RouteBlobUpload      = "blob-upload"
+	v2RouteBlobUploadChunk = "blob-upload-chunk"
+)
+
+var (
+	v2Name      = "(" + reference.NameRegexp.String() + ")"
+	v2Digest    = "(" + reference.DigestRegexp.String() + ")"
+	v2Reference = "(" + reference.TagRegexp.String() + "|" + reference.DigestRegexp.String() + ")"
+	v2UUID      = "([a-zA-Z0-9-_.=]+)"
+)
+
+// v2Routes lists the registry API v2 routes, for each route the
+// regular expression captures the path variables in the template order.
+var v2Routes = []v2Route{
+	{
+		name:     v2RouteBase,
+		template: "/v2/",
+		regexp:   regexp.MustCompile(`^/v2/?$`),
+	},
+	{
+		name:     v2RouteCatalog,
+		template: "/v2/_catalog",
+		regexp:   regexp.MustCompile(`^/v2/_catalog$`),
+	},
+	{
+		name:     v2RouteTags,
+		template: "/v2/{name}/tags/list",
+		regexp:   regexp.MustCompile(`^/v2/` + v2Name + `/tags/list$`),
+	},
+	{
+		name:     v2RouteManifest,
+		template: "/v2/{name}/manifests/{reference}",
+		regexp:   regexp.MustCompile(`^/v2/` + v2Name + `/manifests/` + v2Reference + `$`),
+	},
+	{
+		name:     v2RouteReferrers,
+		template: "/v2/{name}/referrers/{digest}",
+		regexp:   regexp.MustCompile(`^/v2/` + v2Name + `/referrers/` + v2Digest + `$`),
+	},
+	{
+		name:     v2RouteBlobUpload,
+		template: "/v2/{name}/blobs/uploads/",
+		regexp:   regexp.MustCompile(`^/v2/` + v2Name + `/blobs/uploads/?$`),
+	},
+	{
+		name:     v2RouteBlobUploadChunk,
+		template: "/v2/{name}/blobs/uploads/{uuid}",
+		regexp:   regexp.MustCompile(`^/v2/` + v2Name + `/blobs/uploads/` + v2UUID + `$`),
+	},
+	{
+		name:     v2RouteBlob,
+		template: "/v2/{name}/blobs/{digest}",
+		regexp:   regexp.MustCompile(`^/v2/` + v2Name + `/blobs/` + v2Digest + `$`),
+	},
+}
+
+// matchV2Route returns the registry API v2 route matching the path
+// along with the captured path variables, it returns nil if no route
+// matches.
+func matchV2Route(path string) (*v2Route, []string) {
+	for i := range v2Routes {
+		matches := v2Routes[i].regexp.FindStringSubmatch(path)
+		if matches == nil {
+			continue
+		}
+		return &v2Routes[i], matches[1:]
+	}
+	return nil, nil
+}
+
+const (
+	uploadKindNone       = "none"
+	uploadKindInitiate   = "initiate"
+	uploadKindMonolithic = "monolithic"
+	uploadKindChunked    = "chunked"
+	uploadKindComplete   = "complete"
+)
+
+// uploadKind returns the kind of blob upload performed by a registry
+// API request:
+//   - "initiate" for a POST starting an upload session
+//   - "monolithic" for a POST or a PUT uploading the whole blob at once
+//   - "chunked" for a PATCH uploading a chunk of an upload session
+//   - "complete" for a PUT without body completing an upload session
+//   - "none" for requests not related to blob uploads
+func uploadKind(req *http.Request) string {
+	route, _ := matchV2Route(req.URL.Path)
+	if route == nil {
+		return uploadKindNone
+	}
+
+	switch route.name {
+	case v2RouteBlobUpload:
+		if req.Method != http.MethodPost {
+			break
+		} else if req.URL.Query().Get("digest") != "" {
+			return uploadKindMonolithic
+		}
+		return uploadKindInitiate
+	case v2RouteBlobUploadChunk:
+		switch req.Method {
+		case http.MethodPatch:
+			return uploadKindChunked
+		case http.MethodPut:
+			if req.ContentLength > 0 {
+				return uploadKindMonolithic
+			}
+			return uploadKindComplete
+		}
+	}
+
+	return uploadKindNone
+}
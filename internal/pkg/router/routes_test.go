@@ -0,0 +1,150 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatchV2Route(t *testing.T) {
+	digest := "sha256:" + "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+	tests := []struct {
+		name         string
+		path         string
+		expectedName string
+		expectedVars []string
+	}{
+		{
+			name:         "base",
+			path:         "/v2/",
+			expectedName: v2RouteBase,
+			expectedVars: []string{},
+		},
+		{
+			name:         "catalog",
+			path:         "/v2/_catalog",
+			expectedName: v2RouteCatalog,
+			expectedVars: []string{},
+		},
+		{
+			name:         "tags",
+			path:         "/v2/library/alpine/tags/list",
+			expectedName: v2RouteTags,
+			expectedVars: []string{"library/alpine"},
+		},
+		{
+			name:         "manifest tag",
+			path:         "/v2/library/alpine/manifests/3.18",
+			expectedName: v2RouteManifest,
+			expectedVars: []string{"library/alpine", "3.18"},
+		},
+		{
+			name:         "manifest digest",
+			path:         "/v2/alpine/manifests/" + digest,
+			expectedName: v2RouteManifest,
+			expectedVars: []string{"alpine", digest},
+		},
+		{
+			name:         "blob",
+			path:         "/v2/alpine/blobs/" + digest,
+			expectedName: v2RouteBlob,
+			expectedVars: []string{"alpine", digest},
+		},
+		{
+			name:         "blob upload",
+			path:         "/v2/alpine/blobs/uploads/",
+			expectedName: v2RouteBlobUpload,
+			expectedVars: []string{"alpine"},
+		},
+		{
+			name:         "blob upload chunk",
+			path:         "/v2/alpine/blobs/uploads/6b4e3a9c-2d50-4a44-a6f5-0a1b2c3d4e5f",
+			expectedName: v2RouteBlobUploadChunk,
+			expectedVars: []string{"alpine", "6b4e3a9c-2d50-4a44-a6f5-0a1b2c3d4e5f"},
+		},
+		{
+			name: "unknown",
+			path: "/artifacts/yum/repo/repodata/repomd.xml",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			route, vars := matchV2Route(tt.path)
+			if tt.expectedName == "" {
+				require.Nil(t, route)
+				return
+			}
+			require.NotNil(t, route)
+			require.Equal(t, tt.expectedName, route.name)
+			require.Equal(t, tt.expectedVars, vars)
+		})
+	}
+}
+
+func TestUploadKind(t *testing.T) {
+	digest := "sha256:" + "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+	tests := []struct {
+		name     string
+		method   string
+		target   string
+		body     string
+		expected string
+	}{
+		{
+			name:     "initiate",
+			method:   http.MethodPost,
+			target:   "/v2/alpine/blobs/uploads/",
+			expected: uploadKindInitiate,
+		},
+		{
+			name:     "monolithic post",
+			method:   http.MethodPost,
+			target:   "/v2/alpine/blobs/uploads/?digest=" + digest,
+			body:     "blob",
+			expected: uploadKindMonolithic,
+		},
+		{
+			name:     "chunked",
+			method:   http.MethodPatch,
+			target:   "/v2/alpine/blobs/uploads/uuid",
+			body:     "chunk",
+			expected: uploadKindChunked,
+		},
+		{
+			name:     "monolithic put",
+			method:   http.MethodPut,
+			target:   "/v2/alpine/blobs/uploads/uuid?digest=" + digest,
+			body:     "blob",
+			expected: uploadKindMonolithic,
+		},
+		{
+			name:     "complete",
+			method:   http.MethodPut,
+			target:   "/v2/alpine/blobs/uploads/uuid?digest=" + digest,
+			expected: uploadKindComplete,
+		},
+		{
+			name:     "manifest",
+			method:   http.MethodPut,
+			target:   "/v2/alpine/manifests/latest",
+			body:     "{}",
+			expected: uploadKindNone,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			require.Equal(t, tt.expected, uploadKind(req))
+		})
+	}
+}
@@ -0,0 +1,50 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/opencontainers/go-digest"
+)
+
+const (
+	// scanStatusKey is the key of the scan result holding the scan status.
+	scanStatusKey = "status"
+	scanStatusOK  = "ok"
+	// scanStatusUnknown is the status returned when the scanner service
+	// can't be queried or returns a malformed response.
+	scanStatusUnknown = "unknown"
+)
+
+// queryScanResult queries the scanner service for the vulnerability counts by
+// severity of the manifest digest of the reference, the service must reply
+// with a JSON object mapping severities to counts. The returned result holds
+// the lower-cased severity counts and a status key set to "ok", any failure
+// returns a result with only the status key set to "unknown".
+func queryScanResult(ctx context.Context, client *http.Client, serviceURL, ref string, dgst digest.Digest) map[string]interface{} {
+	unknown := map[string]interface{}{
+		scanStatusKey: scanStatusUnknown,
+	}
+
+	var counts map[string]int64
+
+	if err := getServiceJSON(ctx, client, serviceURL, ref, dgst, &counts); err != nil {
+		return unknown
+	}
+
+	result := make(map[string]interface{}, len(counts)+1)
+	for severity, count := range counts {
+		severity = strings.ToLower(severity)
+		if severity == scanStatusKey || count < 0 {
+			return unknown
+		}
+		result[severity] = count
+	}
+	result[scanStatusKey] = scanStatusOK
+
+	return result
+}
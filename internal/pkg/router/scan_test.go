@@ -0,0 +1,75 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueryScanResult(t *testing.T) {
+	dgst := digest.FromString("manifest")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/scan":
+			if r.URL.Query().Get("digest") != dgst.String() {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			_, _ = w.Write([]byte(`{"CRITICAL": 1, "high": 2, "medium": 0}`))
+		case "/clean":
+			_, _ = w.Write([]byte(`{}`))
+		case "/negative":
+			_, _ = w.Write([]byte(`{"critical": -1}`))
+		case "/status":
+			_, _ = w.Write([]byte(`{"status": 1}`))
+		case "/garbage":
+			_, _ = w.Write([]byte(`{"critical": "many"}`))
+		case "/slow":
+			time.Sleep(200 * time.Millisecond)
+			_, _ = w.Write([]byte(`{}`))
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	client := &http.Client{Timeout: 50 * time.Millisecond}
+	unknown := map[string]interface{}{"status": "unknown"}
+
+	tests := []struct {
+		path   string
+		result map[string]interface{}
+	}{
+		{
+			path: "/scan",
+			result: map[string]interface{}{
+				"status":   "ok",
+				"critical": int64(1),
+				"high":     int64(2),
+				"medium":   int64(0),
+			},
+		},
+		{path: "/clean", result: map[string]interface{}{"status": "ok"}},
+		{path: "/negative", result: unknown},
+		{path: "/status", result: unknown},
+		{path: "/garbage", result: unknown},
+		{path: "/slow", result: unknown},
+		{path: "/error", result: unknown},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.path, func(t *testing.T) {
+			result := queryScanResult(context.Background(), client, server.URL+tc.path, "library/alpine:3", dgst)
+			require.Equal(t, tc.result, result)
+		})
+	}
+}
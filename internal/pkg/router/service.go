@@ -0,0 +1,70 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+)
+
+const (
+	defaultServiceTimeout = 5 * time.Second
+
+	// maxServiceResponseSize bounds the external service responses.
+	maxServiceResponseSize = 64 * 1024
+)
+
+// parseServiceURLs validates external service URLs and returns them as an allowlist.
+func parseServiceURLs(serviceURLs []string) (map[string]struct{}, error) {
+	allowed := make(map[string]struct{}, len(serviceURLs))
+	for _, serviceURL := range serviceURLs {
+		u, err := url.Parse(serviceURL)
+		if err != nil {
+			return nil, fmt.Errorf("bad service URL %s: %w", serviceURL, err)
+		} else if u.Scheme != "http" && u.Scheme != "https" {
+			return nil, fmt.Errorf("bad service URL %s: unsupported scheme", serviceURL)
+		}
+		allowed[serviceURL] = struct{}{}
+	}
+	return allowed, nil
+}
+
+// getServiceJSON queries an external service about the manifest digest of the
+// reference and decodes its JSON response into v. The service is called with
+// a GET request with the reference and digest query parameters.
+func getServiceJSON(ctx context.Context, client *http.Client, serviceURL, ref string, dgst digest.Digest, v interface{}) error {
+	u, err := url.Parse(serviceURL)
+	if err != nil {
+		return err
+	}
+	query := u.Query()
+	query.Set("reference", ref)
+	query.Set("digest", dgst.String())
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	return json.NewDecoder(io.LimitReader(resp.Body, maxServiceResponseSize)).Decode(v)
+}
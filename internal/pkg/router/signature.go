@@ -0,0 +1,205 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/opencontainers/go-digest"
+)
+
+const (
+	// cosignSimpleSigningMediaType is the media type of the layers holding
+	// the payloads signed by cosign.
+	cosignSimpleSigningMediaType = "application/vnd.dev.cosign.simplesigning.v1+json"
+	// cosignSignatureArtifactType is the artifact type of the cosign
+	// signatures stored as OCI referrers.
+	cosignSignatureArtifactType = "application/vnd.dev.cosign.artifact.sig.v1+json"
+	// cosignSignatureAnnotation is the layer annotation holding the
+	// base64 encoded signature of the layer payload.
+	cosignSignatureAnnotation = "dev.cosignproject.cosign/signature"
+
+	// maxSignaturePayloadSize is the maximum size of a signed payload.
+	maxSignaturePayloadSize = 1 << 20
+)
+
+var errSignatureVerificationDisabled = errors.New("signature verification disabled")
+
+// cosignPayload is the simple signing payload signed by cosign.
+type cosignPayload struct {
+	Critical struct {
+		Image struct {
+			DockerManifestDigest string `json:"docker-manifest-digest"`
+		} `json:"image"`
+	} `json:"critical"`
+}
+
+// cosignSignatureTag returns the tag used by cosign to reference
+// the signatures of a manifest digest.
+func cosignSignatureTag(dgst digest.Digest) string {
+	return referrersTag(dgst) + ".sig"
+}
+
+// parsePublicKey parses a PEM encoded PKIX public key, only ECDSA,
+// RSA and Ed25519 keys are supported.
+func parsePublicKey(pemKey string) (crypto.PublicKey, error) {
+	block, _ := pem.Decode([]byte(pemKey))
+	if block == nil {
+		return nil, fmt.Errorf("no PEM encoded public key")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("while parsing public key: %w", err)
+	}
+	switch key.(type) {
+	case *ecdsa.PublicKey, *rsa.PublicKey, ed25519.PublicKey:
+		return key, nil
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T", key)
+	}
+}
+
+// publicKeyID returns an identifier of the public key.
+func publicKeyID(key crypto.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// verifySignature returns true if the signature of the payload is valid for
+// the public key. As with cosign, ECDSA and RSA PKCS #1 v1.5 signatures are
+// computed over the SHA-256 digest of the payload.
+func verifySignature(key crypto.PublicKey, payload, signature []byte) bool {
+	switch key := key.(type) {
+	case *ecdsa.PublicKey:
+		sum := sha256.Sum256(payload)
+		return ecdsa.VerifyASN1(key, sum[:], signature)
+	case *rsa.PublicKey:
+		sum := sha256.Sum256(payload)
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, sum[:], signature) == nil
+	case ed25519.PublicKey:
+		return ed25519.Verify(key, payload, signature)
+	default:
+		return false
+	}
+}
+
+// getSignatureManifests returns the digests of the cosign signature manifests
+// of the subject manifest digest, signatures are looked up both with the
+// cosign tag scheme and as OCI referrers.
+func (fctx *funcContext) getSignatureManifests(ctx context.Context, repository distribution.Repository, subject digest.Digest) ([]digest.Digest, error) {
+	var signatures []digest.Digest
+
+	desc, err := fctx.getTag(ctx, repository, cosignSignatureTag(subject))
+	if err != nil {
+		return nil, err
+	} else if desc != nil {
+		signatures = append(signatures, desc.Digest)
+	}
+
+	referrers, err := fctx.getReferrers(ctx, repository, subject, cosignSignatureArtifactType)
+	if err != nil {
+		return nil, err
+	}
+	for _, referrer := range referrers {
+		signatures = append(signatures, digest.Digest(referrer.Digest.String()))
+	}
+
+	return signatures, nil
+}
+
+// signatureVerified returns true if at least one cosign signature of the subject
+// manifest digest is valid for the public key. Malformed signatures and signatures
+// of another manifest are ignored. Results are memoized for the lifetime of the
+// evaluation.
+func (fctx *funcContext) signatureVerified(ctx context.Context, repository distribution.Repository, subject digest.Digest, key crypto.PublicKey) (bool, error) {
+	keyID, err := publicKeyID(key)
+	if err != nil {
+		return false, err
+	}
+	cacheKey := repository.Named().Name() + "@" + subject.String() + "/" + keyID
+	if verified, ok := fctx.signatures[cacheKey]; ok {
+		return verified, nil
+	}
+
+	verified, err := fctx.verifySignatureManifests(ctx, repository, subject, key)
+	if err != nil {
+		return false, err
+	}
+
+	if fctx.signatures == nil {
+		fctx.signatures = make(map[string]bool)
+	}
+	fctx.signatures[cacheKey] = verified
+
+	return verified, nil
+}
+
+func (fctx *funcContext) verifySignatureManifests(ctx context.Context, repository distribution.Repository, subject digest.Digest, key crypto.PublicKey) (bool, error) {
+	signatures, err := fctx.getSignatureManifests(ctx, repository, subject)
+	if err != nil {
+		return false, err
+	}
+
+	for _, signature := range signatures {
+		manifest, err := fctx.getManifest(ctx, repository, signature)
+		if err != nil {
+			if isUnknown(err) {
+				continue
+			}
+			return false, err
+		} else if manifest == nil {
+			continue
+		}
+
+		for _, layer := range manifest.Layers {
+			if string(layer.MediaType) != cosignSimpleSigningMediaType {
+				continue
+			}
+			sig, err := base64.StdEncoding.DecodeString(layer.Annotations[cosignSignatureAnnotation])
+			if err != nil || len(sig) == 0 {
+				continue
+			}
+			// oversized payloads are invalid signatures
+			payload, err := fctx.readBlob(ctx, repository, layer, maxSignaturePayloadSize)
+			if err != nil {
+				if errors.Is(err, errBlobTooLarge) {
+					continue
+				}
+				return false, fmt.Errorf("while getting signature payload %s: %w", layer.Digest, err)
+			} else if payload == nil {
+				continue
+			}
+
+			signed := new(cosignPayload)
+			if err := json.Unmarshal(payload, signed); err != nil {
+				continue
+			} else if signed.Critical.Image.DockerManifestDigest != subject.String() {
+				// the signature may have been copied from another manifest
+				continue
+			}
+			if verifySignature(key, payload, sig) {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
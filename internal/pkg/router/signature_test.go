@@ -0,0 +1,93 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// publicKeyPEM returns the PEM encoded public key.
+func publicKeyPEM(t *testing.T, key crypto.PublicKey) string {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	require.NoError(t, err)
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
+
+func TestVerifySignature(t *testing.T) {
+	payload := []byte(`{"critical":{"image":{"docker-manifest-digest":"sha256:1234"}}}`)
+	sum := sha256.Sum256(payload)
+
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	ecdsaSig, err := ecdsa.SignASN1(rand.Reader, ecdsaKey, sum[:])
+	require.NoError(t, err)
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	rsaSig, err := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, sum[:])
+	require.NoError(t, err)
+
+	ed25519Pub, ed25519Key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	ed25519Sig := ed25519.Sign(ed25519Key, payload)
+
+	tests := []struct {
+		name      string
+		key       crypto.PublicKey
+		signature []byte
+		verified  bool
+	}{
+		{
+			name:      "ecdsa",
+			key:       &ecdsaKey.PublicKey,
+			signature: ecdsaSig,
+			verified:  true,
+		},
+		{
+			name:      "rsa",
+			key:       &rsaKey.PublicKey,
+			signature: rsaSig,
+			verified:  true,
+		},
+		{
+			name:      "ed25519",
+			key:       ed25519Pub,
+			signature: ed25519Sig,
+			verified:  true,
+		},
+		{
+			name:      "wrong key",
+			key:       &ecdsaKey.PublicKey,
+			signature: rsaSig,
+			verified:  false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := parsePublicKey(publicKeyPEM(t, tt.key))
+			require.NoError(t, err)
+			require.Equal(t, tt.verified, verifySignature(key, payload, tt.signature))
+		})
+	}
+}
+
+func TestParsePublicKey(t *testing.T) {
+	_, err := parsePublicKey("not a key")
+	require.Error(t, err)
+
+	_, err = parsePublicKey(string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: []byte("garbage")})))
+	require.Error(t, err)
+}
@@ -0,0 +1,55 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"net/url"
+	"path"
+	"strings"
+)
+
+// sourceAnnotation is the annotation holding the URL of the source
+// repository an image has been built from.
+const sourceAnnotation = "org.opencontainers.image.source"
+
+// normalizeSource removes the trailing slash and the .git suffix
+// of a source repository URL.
+func normalizeSource(source string) string {
+	source = strings.TrimSpace(source)
+	source = strings.TrimSuffix(source, "/")
+	return strings.TrimSuffix(source, ".git")
+}
+
+// sourceMatchesTarget returns true if the source repository URL maps to
+// the target registry repository. Without mapping rules, the source URL
+// path must match the target repository, otherwise the first rule whose
+// source prefix matches is used to map the source URL to a repository.
+func sourceMatchesTarget(source, target string, mappings []SourceMapping) bool {
+	source = normalizeSource(source)
+	if source == "" || target == "" {
+		return false
+	}
+
+	if len(mappings) == 0 {
+		sourcePath := source
+		if u, err := url.Parse(source); err == nil && u.Host != "" {
+			sourcePath = u.Path
+		} else if idx := strings.IndexByte(source, '/'); idx >= 0 {
+			// host/path form without scheme
+			sourcePath = source[idx:]
+		}
+		return strings.EqualFold(strings.Trim(sourcePath, "/"), target)
+	}
+
+	for _, mapping := range mappings {
+		prefix := normalizeSource(mapping.Source)
+		if !strings.HasPrefix(source, prefix) {
+			continue
+		}
+		expected := path.Join(mapping.Repository, strings.Trim(source[len(prefix):], "/"))
+		return strings.EqualFold(expected, target)
+	}
+
+	return false
+}
@@ -0,0 +1,79 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSourceMatchesTarget(t *testing.T) {
+	mappings := []SourceMapping{
+		{
+			Source:     "https://github.com/acme/",
+			Repository: "acme/github",
+		},
+	}
+
+	tests := []struct {
+		name     string
+		source   string
+		target   string
+		mappings []SourceMapping
+		expected bool
+	}{
+		{
+			name:     "url path",
+			source:   "https://github.com/acme/app.git",
+			target:   "acme/app",
+			expected: true,
+		},
+		{
+			name:     "url without scheme",
+			source:   "github.com/acme/app",
+			target:   "acme/app",
+			expected: true,
+		},
+		{
+			name:     "url path mismatch",
+			source:   "https://github.com/evil/app",
+			target:   "acme/app",
+			expected: false,
+		},
+		{
+			name:     "missing source",
+			source:   "",
+			target:   "acme/app",
+			expected: false,
+		},
+		{
+			name:     "mapping",
+			source:   "https://github.com/acme/app",
+			target:   "acme/github/app",
+			mappings: mappings,
+			expected: true,
+		},
+		{
+			name:     "mapping mismatch",
+			source:   "https://github.com/acme/app",
+			target:   "acme/app",
+			mappings: mappings,
+			expected: false,
+		},
+		{
+			name:     "no mapping",
+			source:   "https://gitlab.com/acme/app",
+			target:   "acme/github/app",
+			mappings: mappings,
+			expected: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.expected, sourceMatchesTarget(tt.source, tt.target, tt.mappings))
+		})
+	}
+}
@@ -0,0 +1,138 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// licensesAnnotation is the annotation holding the SPDX license
+// expression of an image.
+const licensesAnnotation = "org.opencontainers.image.licenses"
+
+var spdxIDRegexp = regexp.MustCompile(`^(DocumentRef-[A-Za-z0-9.\-]+:)?[A-Za-z0-9.\-]+\+?$`)
+
+type spdxParser struct {
+	tokens   []string
+	pos      int
+	licenses []string
+}
+
+func tokenizeSPDX(expression string) []string {
+	expression = strings.ReplaceAll(expression, "(", " ( ")
+	expression = strings.ReplaceAll(expression, ")", " ) ")
+	return strings.Fields(expression)
+}
+
+func (p *spdxParser) next() string {
+	if p.pos >= len(p.tokens) {
+		return ""
+	}
+	token := p.tokens[p.pos]
+	p.pos++
+	return token
+}
+
+func (p *spdxParser) peek() string {
+	if p.pos >= len(p.tokens) {
+		return ""
+	}
+	return p.tokens[p.pos]
+}
+
+func isSPDXOperator(token string) bool {
+	switch strings.ToUpper(token) {
+	case "AND", "OR", "WITH":
+		return true
+	}
+	return false
+}
+
+// parseExpression parses: term (("AND" | "OR") term)*
+func (p *spdxParser) parseExpression() error {
+	if err := p.parseTerm(); err != nil {
+		return err
+	}
+	for {
+		switch strings.ToUpper(p.peek()) {
+		case "AND", "OR":
+			p.next()
+			if err := p.parseTerm(); err != nil {
+				return err
+			}
+		default:
+			return nil
+		}
+	}
+}
+
+// parseTerm parses: "(" expression ")" | license ["WITH" exception]
+func (p *spdxParser) parseTerm() error {
+	token := p.next()
+
+	switch {
+	case token == "":
+		return fmt.Errorf("unexpected end of expression")
+	case token == "(":
+		if err := p.parseExpression(); err != nil {
+			return err
+		}
+		if p.next() != ")" {
+			return fmt.Errorf("missing closing parenthesis")
+		}
+		return nil
+	case token == ")" || isSPDXOperator(token) || !spdxIDRegexp.MatchString(token):
+		return fmt.Errorf("unexpected token %q", token)
+	}
+
+	p.licenses = append(p.licenses, token)
+
+	if strings.EqualFold(p.peek(), "WITH") {
+		p.next()
+		exception := p.next()
+		if exception == "" || exception == "(" || exception == ")" || isSPDXOperator(exception) || !spdxIDRegexp.MatchString(exception) {
+			return fmt.Errorf("bad license exception %q", exception)
+		}
+	}
+
+	return nil
+}
+
+// parseSPDXLicenses returns the licenses referenced by an SPDX
+// license expression, license exceptions are ignored.
+func parseSPDXLicenses(expression string) ([]string, error) {
+	p := &spdxParser{
+		tokens: tokenizeSPDX(expression),
+	}
+	if err := p.parseExpression(); err != nil {
+		return nil, err
+	} else if p.pos != len(p.tokens) {
+		return nil, fmt.Errorf("unexpected token %q", p.peek())
+	}
+	return p.licenses, nil
+}
+
+// licensesAllowed returns true if all the licenses referenced by the SPDX
+// expression are allowed, it returns false for an empty or a bad expression.
+func licensesAllowed(expression string, allowed []string) bool {
+	licenses, err := parseSPDXLicenses(expression)
+	if err != nil {
+		return false
+	}
+
+	allowedLicenses := make(map[string]struct{}, len(allowed))
+	for _, license := range allowed {
+		allowedLicenses[strings.ToLower(license)] = struct{}{}
+	}
+
+	for _, license := range licenses {
+		if _, ok := allowedLicenses[strings.ToLower(license)]; !ok {
+			return false
+		}
+	}
+
+	return true
+}
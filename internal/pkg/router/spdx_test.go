@@ -0,0 +1,38 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLicensesAllowed(t *testing.T) {
+	allowed := []string{"Apache-2.0", "MIT", "BSD-3-Clause"}
+
+	tests := []struct {
+		name       string
+		expression string
+		expected   bool
+	}{
+		{name: "single", expression: "Apache-2.0", expected: true},
+		{name: "case insensitive", expression: "mit", expected: true},
+		{name: "and", expression: "MIT AND BSD-3-Clause", expected: true},
+		{name: "or with denied", expression: "MIT OR GPL-3.0-only", expected: false},
+		{name: "parentheses", expression: "(MIT OR Apache-2.0) AND BSD-3-Clause", expected: true},
+		{name: "with exception", expression: "Apache-2.0 WITH LLVM-exception", expected: true},
+		{name: "denied", expression: "GPL-2.0+", expected: false},
+		{name: "empty", expression: "", expected: false},
+		{name: "unbalanced", expression: "(MIT AND Apache-2.0", expected: false},
+		{name: "dangling operator", expression: "MIT AND", expected: false},
+		{name: "missing operator", expression: "MIT Apache-2.0", expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.expected, licensesAllowed(tt.expression, allowed))
+		})
+	}
+}
@@ -0,0 +1,37 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import "regexp"
+
+// versionTagRegexp matches tags looking like a version (eg: 1, v1.2, 1.2.3-rc1, 2023.10.01).
+var versionTagRegexp = regexp.MustCompile(`^[vV]?[0-9]+(\.[0-9]+)*([-_.][0-9A-Za-z.-]+)*$`)
+
+func defaultFloatingTags() map[string]struct{} {
+	return map[string]struct{}{
+		"latest": {},
+		"main":   {},
+		"edge":   {},
+		"stable": {},
+	}
+}
+
+// isFloatingTag returns true if the reference points to a mutable tag, a
+// reference is considered floating when its tag is one of the floating tags
+// or doesn't look like a version. References without tag implicitly
+// reference the latest tag while digest references are always pinned.
+func isFloatingTag(ref string, floatingTags map[string]struct{}) (bool, error) {
+	tag, ok, err := referenceTag(ref)
+	if err != nil {
+		return false, err
+	} else if !ok {
+		return false, nil
+	}
+
+	if _, ok := floatingTags[tag]; ok {
+		return true, nil
+	}
+
+	return !versionTagRegexp.MatchString(tag), nil
+}
@@ -0,0 +1,62 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsFloatingTag(t *testing.T) {
+	tests := []struct {
+		ref          string
+		floatingTags map[string]struct{}
+		floating     bool
+		err          bool
+	}{
+		{ref: "library/alpine", floating: true},
+		{ref: "library/alpine:latest", floating: true},
+		{ref: "library/alpine:edge", floating: true},
+		{ref: "library/alpine:stable", floating: true},
+		{ref: "library/alpine:main", floating: true},
+		{ref: "library/alpine:nightly", floating: true},
+		{ref: "library/alpine:3", floating: false},
+		{ref: "library/alpine:3.18.4", floating: false},
+		{ref: "library/alpine:v1.2.3-rc1", floating: false},
+		{ref: "library/alpine:2023.10.01", floating: false},
+		{ref: "library/alpine:3-alpine", floating: false},
+		{ref: "registry.example.com:5000/alpine:3.18", floating: false},
+		{ref: "registry.example.com:5000/alpine", floating: true},
+		{ref: "library/alpine@sha256:" + "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef", floating: false},
+		{ref: "library/alpine:latest@sha256:" + "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef", floating: false},
+		{
+			ref:          "library/alpine:3.18",
+			floatingTags: map[string]struct{}{"3.18": {}},
+			floating:     true,
+		},
+		{
+			ref:          "library/alpine:latest",
+			floatingTags: map[string]struct{}{"edge": {}},
+			floating:     true,
+		},
+		{ref: "Library/Alpine:3", err: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.ref, func(t *testing.T) {
+			floatingTags := tc.floatingTags
+			if floatingTags == nil {
+				floatingTags = defaultFloatingTags()
+			}
+			floating, err := isFloatingTag(tc.ref, floatingTags)
+			if tc.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.floating, floating)
+		})
+	}
+}
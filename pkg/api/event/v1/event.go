@@ -0,0 +1,208 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package eventv1
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/opencontainers/go-digest"
+	"google.golang.org/protobuf/proto"
+)
+
+// DefaultMaxPayloadSize is the default maximum size of an event payload,
+// it matches the maximum manifest size accepted by the registry.
+const DefaultMaxPayloadSize = 4 << 20
+
+// ValidateOption configures the event payload validation.
+type ValidateOption func(*validateOptions)
+
+type validateOptions struct {
+	maxPayloadSize int
+}
+
+// WithMaxPayloadSize sets the maximum size of an event payload accepted by
+// Validate and NewEventPayload instead of DefaultMaxPayloadSize.
+func WithMaxPayloadSize(size int) ValidateOption {
+	return func(o *validateOptions) {
+		o.maxPayloadSize = size
+	}
+}
+
+var (
+	ErrEmptyRepository = errors.New("event repository is required")
+	ErrUnknownAction   = errors.New("unknown event action")
+	ErrPayloadTooLarge = errors.New("event payload too large")
+)
+
+// isKnownAction returns true if the action is a known action other than ACTION_UNSPECIFIED.
+func isKnownAction(action Action) bool {
+	_, ok := Action_name[int32(action)]
+	return ok && action != Action_ACTION_UNSPECIFIED
+}
+
+// IsWrite returns true if the action mutates the registry state: PUT,
+// UPDATE and DELETE.
+func (a Action) IsWrite() bool {
+	switch a {
+	case Action_ACTION_PUT, Action_ACTION_UPDATE, Action_ACTION_DELETE:
+		return true
+	}
+	return false
+}
+
+// IsLifecycle returns true if the action is a lifecycle action: START and STOP.
+func (a Action) IsLifecycle() bool {
+	switch a {
+	case Action_ACTION_START, Action_ACTION_STOP:
+		return true
+	}
+	return false
+}
+
+// NewEventPayload returns a new validated event payload.
+func NewEventPayload(repository, digest, mediatype string, action Action, payload []byte, options ...ValidateOption) (*EventPayload, error) {
+	event := &EventPayload{
+		Repository: repository,
+		Digest:     digest,
+		Mediatype:  mediatype,
+		Payload:    payload,
+		Action:     action,
+	}
+	if err := event.Validate(options...); err != nil {
+		return nil, err
+	}
+	return event, nil
+}
+
+// Validate returns an error if the repository is empty, if the action
+// is unknown, if the payload is larger than the maximum payload size,
+// DefaultMaxPayloadSize by default, or if the digest, when set, isn't
+// a valid digest.
+func (x *EventPayload) Validate(options ...ValidateOption) error {
+	opts := validateOptions{
+		maxPayloadSize: DefaultMaxPayloadSize,
+	}
+	for _, opt := range options {
+		opt(&opts)
+	}
+
+	if x.GetRepository() == "" {
+		return fmt.Errorf("bad repository field: %w", ErrEmptyRepository)
+	} else if !isKnownAction(x.GetAction()) {
+		return fmt.Errorf("bad action field: %w %s", ErrUnknownAction, x.GetAction())
+	} else if size := x.PayloadSize(); size > opts.maxPayloadSize {
+		return fmt.Errorf("bad payload field: %w: %d bytes exceeds %d bytes", ErrPayloadTooLarge, size, opts.maxPayloadSize)
+	} else if x.GetDigest() != "" {
+		if _, err := digest.Parse(x.GetDigest()); err != nil {
+			return fmt.Errorf("bad digest field: %w", err)
+		}
+	}
+	return nil
+}
+
+// PayloadSize returns the size of the event payload in bytes.
+func (x *EventPayload) PayloadSize() int {
+	return len(x.GetPayload())
+}
+
+// eventPayloadJSON is the JSON representation of an event payload.
+type eventPayloadJSON struct {
+	Repository string `json:"repository"`
+	Digest     string `json:"digest,omitempty"`
+	Mediatype  string `json:"mediatype,omitempty"`
+	Payload    []byte `json:"payload,omitempty"`
+	Action     string `json:"action"`
+	Origin     string `json:"origin,omitempty"`
+	Sequence   uint64 `json:"sequence,omitempty"`
+	Epoch      uint64 `json:"epoch,omitempty"`
+}
+
+// MarshalJSON encodes the event payload to JSON, the action and origin
+// are encoded with their names and the payload is base64 encoded.
+func (x *EventPayload) MarshalJSON() ([]byte, error) {
+	event := eventPayloadJSON{
+		Repository: x.GetRepository(),
+		Digest:     x.GetDigest(),
+		Mediatype:  x.GetMediatype(),
+		Payload:    x.GetPayload(),
+		Action:     x.GetAction().String(),
+		Sequence:   x.GetSequence(),
+		Epoch:      x.GetEpoch(),
+	}
+	if x.GetOrigin() != Origin_ORIGIN_UNSPECIFIED {
+		event.Origin = x.GetOrigin().String()
+	}
+	return json.Marshal(event)
+}
+
+// UnmarshalJSON decodes an event payload encoded by MarshalJSON.
+func (x *EventPayload) UnmarshalJSON(data []byte) error {
+	var event eventPayloadJSON
+
+	if err := json.Unmarshal(data, &event); err != nil {
+		return err
+	}
+
+	action, ok := Action_value[event.Action]
+	if !ok {
+		return fmt.Errorf("bad action field: %w %s", ErrUnknownAction, event.Action)
+	}
+	origin := int32(Origin_ORIGIN_UNSPECIFIED)
+	if event.Origin != "" {
+		origin, ok = Origin_value[event.Origin]
+		if !ok {
+			return fmt.Errorf("bad origin field: unknown event origin %s", event.Origin)
+		}
+	}
+
+	x.Repository = event.Repository
+	x.Digest = event.Digest
+	x.Mediatype = event.Mediatype
+	x.Payload = event.Payload
+	x.Action = Action(action)
+	x.Origin = Origin(origin)
+	x.Sequence = event.Sequence
+	x.Epoch = event.Epoch
+
+	return nil
+}
+
+// Clone returns a deep copy of the event payload.
+func (x *EventPayload) Clone() *EventPayload {
+	return proto.Clone(x).(*EventPayload)
+}
+
+// Redacted returns a copy of the event payload safe to log: the payload
+// bytes are replaced by their size and digest, the other fields are left
+// intact.
+func (x *EventPayload) Redacted() *EventPayload {
+	if x == nil {
+		return nil
+	}
+	redacted := &EventPayload{
+		Repository: x.GetRepository(),
+		Digest:     x.GetDigest(),
+		Mediatype:  x.GetMediatype(),
+		Action:     x.GetAction(),
+		Origin:     x.GetOrigin(),
+		Sequence:   x.GetSequence(),
+		Epoch:      x.GetEpoch(),
+	}
+	if payload := x.GetPayload(); len(payload) > 0 {
+		redacted.Payload = []byte(fmt.Sprintf("<redacted %d bytes %s>", len(payload), digest.FromBytes(payload)))
+	}
+	return redacted
+}
+
+// Append appends an event to the batch.
+func (x *EventBatch) Append(event *EventPayload) {
+	x.Events = append(x.Events, event)
+}
+
+// Len returns the number of events in the batch.
+func (x *EventBatch) Len() int {
+	return len(x.GetEvents())
+}
@@ -0,0 +1,326 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package eventv1
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestNewEventPayload(t *testing.T) {
+	const (
+		digest    = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+		mediatype = "application/vnd.oci.image.manifest.v1+json"
+	)
+
+	tests := []struct {
+		name       string
+		repository string
+		action     Action
+		err        error
+	}{
+		{name: "put", repository: "artifacts/static/files", action: Action_ACTION_PUT},
+		{name: "delete", repository: "artifacts/static/files", action: Action_ACTION_DELETE},
+		{name: "start", repository: "artifacts/static/files", action: Action_ACTION_START},
+		{name: "stop", repository: "artifacts/static/files", action: Action_ACTION_STOP},
+		{name: "update", repository: "artifacts/static/files", action: Action_ACTION_UPDATE},
+		{name: "unspecified", repository: "artifacts/static/files", action: Action_ACTION_UNSPECIFIED, err: ErrUnknownAction},
+		{name: "unknown", repository: "artifacts/static/files", action: Action(42), err: ErrUnknownAction},
+		{name: "empty repository", repository: "", action: Action_ACTION_PUT, err: ErrEmptyRepository},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			payload := []byte("{}")
+
+			event, err := NewEventPayload(tc.repository, digest, mediatype, tc.action, payload)
+			if tc.err != nil {
+				require.ErrorIs(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.repository, event.Repository)
+			require.Equal(t, digest, event.Digest)
+			require.Equal(t, mediatype, event.Mediatype)
+			require.Equal(t, payload, event.Payload)
+			require.Equal(t, tc.action, event.Action)
+		})
+	}
+}
+
+func TestEventPayloadValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		event *EventPayload
+		err   bool
+	}{
+		{
+			name: "valid",
+			event: &EventPayload{
+				Repository: "artifacts/static/files",
+				Digest:     "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef",
+				Action:     Action_ACTION_PUT,
+			},
+		},
+		{
+			name: "valid without digest",
+			event: &EventPayload{
+				Repository: "artifacts/static/files",
+				Action:     Action_ACTION_START,
+			},
+		},
+		{
+			name: "empty repository",
+			event: &EventPayload{
+				Action: Action_ACTION_PUT,
+			},
+			err: true,
+		},
+		{
+			name: "unknown action",
+			event: &EventPayload{
+				Repository: "artifacts/static/files",
+				Action:     Action(42),
+			},
+			err: true,
+		},
+		{
+			name: "digest without algorithm",
+			event: &EventPayload{
+				Repository: "artifacts/static/files",
+				Digest:     "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef",
+				Action:     Action_ACTION_DELETE,
+			},
+			err: true,
+		},
+		{
+			name: "bad digest",
+			event: &EventPayload{
+				Repository: "artifacts/static/files",
+				Digest:     "sha256:xyz",
+				Action:     Action_ACTION_DELETE,
+			},
+			err: true,
+		},
+		{
+			name: "maximum payload size",
+			event: &EventPayload{
+				Repository: "artifacts/static/files",
+				Action:     Action_ACTION_PUT,
+				Payload:    make([]byte, DefaultMaxPayloadSize),
+			},
+		},
+		{
+			name: "payload too large",
+			event: &EventPayload{
+				Repository: "artifacts/static/files",
+				Action:     Action_ACTION_PUT,
+				Payload:    make([]byte, DefaultMaxPayloadSize+1),
+			},
+			err: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.event.Validate()
+			if tc.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestEventPayloadSize(t *testing.T) {
+	event := &EventPayload{
+		Repository: "artifacts/static/files",
+		Action:     Action_ACTION_PUT,
+		Payload:    []byte("{}"),
+	}
+	require.Equal(t, 2, event.PayloadSize())
+
+	_, err := NewEventPayload(event.Repository, "", "", event.Action, event.Payload)
+	require.NoError(t, err)
+
+	_, err = NewEventPayload(event.Repository, "", "", event.Action, event.Payload, WithMaxPayloadSize(1))
+	require.ErrorIs(t, err, ErrPayloadTooLarge)
+
+	require.NoError(t, event.Validate(WithMaxPayloadSize(2)))
+	require.ErrorIs(t, event.Validate(WithMaxPayloadSize(1)), ErrPayloadTooLarge)
+}
+
+func TestEventPayloadJSON(t *testing.T) {
+	tests := []struct {
+		name  string
+		event *EventPayload
+		json  string
+	}{
+		{
+			name: "all fields",
+			event: &EventPayload{
+				Repository: "artifacts/static/files",
+				Digest:     "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef",
+				Mediatype:  "application/vnd.oci.image.manifest.v1+json",
+				Payload:    []byte{0x00, 0xff, '{', '}'},
+				Action:     Action_ACTION_PUT,
+				Origin:     Origin_ORIGIN_PLUGIN,
+				Sequence:   42,
+				Epoch:      7,
+			},
+			json: `{
+				"repository": "artifacts/static/files",
+				"digest": "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef",
+				"mediatype": "application/vnd.oci.image.manifest.v1+json",
+				"payload": "AP97fQ==",
+				"action": "ACTION_PUT",
+				"origin": "ORIGIN_PLUGIN",
+				"sequence": 42,
+				"epoch": 7
+			}`,
+		},
+		{
+			name: "unset digest",
+			event: &EventPayload{
+				Repository: "artifacts/static/files",
+				Action:     Action_ACTION_START,
+			},
+			json: `{
+				"repository": "artifacts/static/files",
+				"action": "ACTION_START"
+			}`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			data, err := json.Marshal(tc.event)
+			require.NoError(t, err)
+			require.JSONEq(t, tc.json, string(data))
+
+			event := new(EventPayload)
+			require.NoError(t, json.Unmarshal(data, event))
+			require.True(t, proto.Equal(tc.event, event))
+		})
+	}
+
+	err := json.Unmarshal([]byte(`{"repository": "artifacts/static/files", "action": "ACTION_UNKNOWN"}`), new(EventPayload))
+	require.ErrorIs(t, err, ErrUnknownAction)
+}
+
+func TestEventBatch(t *testing.T) {
+	batch := new(EventBatch)
+	require.Equal(t, 0, batch.Len())
+
+	for _, action := range []Action{Action_ACTION_PUT, Action_ACTION_DELETE} {
+		batch.Append(&EventPayload{
+			Repository: "artifacts/static/files",
+			Action:     action,
+		})
+	}
+	require.Equal(t, 2, batch.Len())
+
+	data, err := proto.Marshal(batch)
+	require.NoError(t, err)
+
+	decoded := new(EventBatch)
+	require.NoError(t, proto.Unmarshal(data, decoded))
+	require.True(t, proto.Equal(batch, decoded))
+}
+
+func TestEventPayloadClone(t *testing.T) {
+	event := &EventPayload{
+		Repository: "artifacts/static/files",
+		Digest:     "sha256:2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae",
+		Payload:    []byte("payload"),
+		Action:     Action_ACTION_PUT,
+		Origin:     Origin_ORIGIN_PLUGIN,
+	}
+
+	clone := event.Clone()
+	require.True(t, proto.Equal(event, clone))
+
+	clone.Payload[0] = 'P'
+	clone.Repository = "artifacts/static/other"
+
+	require.Equal(t, []byte("payload"), event.Payload)
+	require.Equal(t, "artifacts/static/files", event.Repository)
+}
+
+func TestEventPayloadRedacted(t *testing.T) {
+	secret := []byte(`{"token":"s3cr3t"}`)
+
+	event := &EventPayload{
+		Repository: "artifacts/static/files",
+		Digest:     "sha256:2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae",
+		Mediatype:  "application/json",
+		Payload:    secret,
+		Action:     Action_ACTION_PUT,
+		Origin:     Origin_ORIGIN_PLUGIN,
+		Sequence:   42,
+		Epoch:      7,
+	}
+	original := event.Clone()
+
+	redacted := event.Redacted()
+	require.True(t, proto.Equal(original, event))
+
+	require.Equal(t, event.Repository, redacted.Repository)
+	require.Equal(t, event.Digest, redacted.Digest)
+	require.Equal(t, event.Mediatype, redacted.Mediatype)
+	require.Equal(t, event.Action, redacted.Action)
+	require.Equal(t, event.Origin, redacted.Origin)
+	require.Equal(t, event.Sequence, redacted.Sequence)
+	require.Equal(t, event.Epoch, redacted.Epoch)
+	require.Equal(t, "<redacted 18 bytes sha256:"+digestHex(secret)+">", string(redacted.Payload))
+
+	for _, s := range []string{redacted.String(), mustMarshalJSON(t, redacted)} {
+		require.NotContains(t, s, "s3cr3t")
+	}
+
+	require.Nil(t, (&EventPayload{Repository: "artifacts/static/files"}).Redacted().Payload)
+	require.Nil(t, (*EventPayload)(nil).Redacted())
+}
+
+func digestHex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func mustMarshalJSON(t *testing.T, event *EventPayload) string {
+	data, err := json.Marshal(event)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestActionKind(t *testing.T) {
+	tests := []struct {
+		action    Action
+		write     bool
+		lifecycle bool
+	}{
+		{action: Action_ACTION_UNSPECIFIED},
+		{action: Action_ACTION_PUT, write: true},
+		{action: Action_ACTION_DELETE, write: true},
+		{action: Action_ACTION_START, lifecycle: true},
+		{action: Action_ACTION_STOP, lifecycle: true},
+		{action: Action_ACTION_UPDATE, write: true},
+	}
+
+	// every enum value is covered
+	require.Len(t, tests, len(Action_name))
+
+	for _, tc := range tests {
+		t.Run(tc.action.String(), func(t *testing.T) {
+			require.Equal(t, tc.write, tc.action.IsWrite())
+			require.Equal(t, tc.lifecycle, tc.action.IsLifecycle())
+		})
+	}
+}
@@ -0,0 +1,48 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package eventv1
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/open-policy-agent/opa/rego"
+	"github.com/open-policy-agent/opa/util"
+)
+
+// Filter returns true if the event must be delivered.
+type Filter func(event *EventPayload) (bool, error)
+
+// CompileFilter compiles a rego expression evaluated against the JSON
+// representation of an event as input (see EventPayload.MarshalJSON),
+// e.g. input.action == "ACTION_DELETE". The returned filter accepts the
+// events for which the expression is true and is safe for concurrent use.
+func CompileFilter(expression string) (Filter, error) {
+	query, err := rego.New(
+		rego.Query(expression),
+		rego.StrictBuiltinErrors(true),
+	).PrepareForEval(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("while compiling event filter: %w", err)
+	}
+
+	return func(event *EventPayload) (bool, error) {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return false, err
+		}
+		var input interface{}
+		if err := util.UnmarshalJSON(data, &input); err != nil {
+			return false, err
+		}
+
+		rs, err := query.Eval(context.Background(), rego.EvalInput(input))
+		if err != nil {
+			return false, fmt.Errorf("while evaluating event filter: %w", err)
+		}
+
+		return rs.Allowed(), nil
+	}, nil
+}
@@ -0,0 +1,66 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package eventv1
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompileFilter(t *testing.T) {
+	put := &EventPayload{
+		Repository: "artifacts/yum/rocky",
+		Action:     Action_ACTION_PUT,
+	}
+	del := &EventPayload{
+		Repository: "artifacts/static/files",
+		Action:     Action_ACTION_DELETE,
+		Origin:     Origin_ORIGIN_PLUGIN,
+	}
+
+	tests := []struct {
+		name       string
+		expression string
+		expected   []bool
+	}{
+		{
+			name:       "action",
+			expression: `input.action == "ACTION_DELETE"`,
+			expected:   []bool{false, true},
+		},
+		{
+			name:       "repository prefix",
+			expression: `startswith(input.repository, "artifacts/yum/")`,
+			expected:   []bool{true, false},
+		},
+		{
+			name:       "unset field",
+			expression: `input.origin == "ORIGIN_PLUGIN"`,
+			expected:   []bool{false, true},
+		},
+		{
+			name:       "non boolean expression",
+			expression: `input.repository`,
+			expected:   []bool{false, false},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			filter, err := CompileFilter(tc.expression)
+			require.NoError(t, err)
+
+			// the filter is reused for all events
+			for i, event := range []*EventPayload{put, del} {
+				match, err := filter(event)
+				require.NoError(t, err)
+				require.Equal(t, tc.expected[i], match)
+			}
+		})
+	}
+
+	_, err := CompileFilter(`input.action ==`)
+	require.Error(t, err)
+}
@@ -0,0 +1,96 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package eventv1
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+type repositoryPattern struct {
+	negate bool
+	regexp *regexp.Regexp
+}
+
+// Matcher filters events by repository patterns.
+type Matcher struct {
+	patterns []repositoryPattern
+}
+
+// NewMatcher returns a matcher for the repository patterns. A pattern
+// matches a repository and all repositories below it, a "*" matches
+// any sequence of characters except "/", a "**" matches any sequence
+// of characters and a "?" matches any character except "/". A leading
+// "!" negates the pattern to exclude a subtree.
+//
+// Patterns are evaluated in order and the last matching pattern decides.
+// A matcher without pattern or starting with a negated pattern matches
+// the repositories not excluded by a negated pattern.
+func NewMatcher(patterns ...string) (*Matcher, error) {
+	m := &Matcher{
+		patterns: make([]repositoryPattern, 0, len(patterns)),
+	}
+
+	for _, pattern := range patterns {
+		rp := repositoryPattern{}
+
+		if strings.HasPrefix(pattern, "!") {
+			rp.negate = true
+			pattern = pattern[1:]
+		}
+		pattern = strings.TrimSuffix(pattern, "/")
+		if pattern == "" {
+			return nil, fmt.Errorf("empty repository pattern")
+		}
+
+		var sb strings.Builder
+
+		sb.WriteByte('^')
+		for i := 0; i < len(pattern); i++ {
+			switch c := pattern[i]; c {
+			case '*':
+				if i+1 < len(pattern) && pattern[i+1] == '*' {
+					sb.WriteString(".*")
+					i++
+				} else {
+					sb.WriteString("[^/]*")
+				}
+			case '?':
+				sb.WriteString("[^/]")
+			default:
+				sb.WriteString(regexp.QuoteMeta(string(c)))
+			}
+		}
+		sb.WriteString("(/.*)?$")
+
+		re, err := regexp.Compile(sb.String())
+		if err != nil {
+			return nil, fmt.Errorf("bad repository pattern %s: %w", pattern, err)
+		}
+		rp.regexp = re
+
+		m.patterns = append(m.patterns, rp)
+	}
+
+	return m, nil
+}
+
+// Matches returns true if the event repository is matched.
+func (m *Matcher) Matches(event *EventPayload) bool {
+	if len(m.patterns) == 0 {
+		return true
+	}
+
+	repository := event.GetRepository()
+	matched := m.patterns[0].negate
+
+	for _, rp := range m.patterns {
+		if rp.regexp.MatchString(repository) {
+			matched = !rp.negate
+		}
+	}
+
+	return matched
+}
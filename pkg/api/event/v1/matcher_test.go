@@ -0,0 +1,108 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package eventv1
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatcher(t *testing.T) {
+	tests := []struct {
+		name       string
+		patterns   []string
+		repository string
+		expected   bool
+	}{
+		{
+			name:       "no pattern",
+			repository: "artifacts/yum/rocky",
+			expected:   true,
+		},
+		{
+			name:       "exact",
+			patterns:   []string{"artifacts/yum/rocky"},
+			repository: "artifacts/yum/rocky",
+			expected:   true,
+		},
+		{
+			name:       "prefix",
+			patterns:   []string{"artifacts/yum"},
+			repository: "artifacts/yum/rocky/files",
+			expected:   true,
+		},
+		{
+			name:       "prefix with trailing slash",
+			patterns:   []string{"artifacts/yum/"},
+			repository: "artifacts/yum/rocky",
+			expected:   true,
+		},
+		{
+			name:       "prefix not at path boundary",
+			patterns:   []string{"artifacts/yum"},
+			repository: "artifacts/yummy",
+			expected:   false,
+		},
+		{
+			name:       "wildcard",
+			patterns:   []string{"artifacts/*/rocky"},
+			repository: "artifacts/yum/rocky/files",
+			expected:   true,
+		},
+		{
+			name:       "wildcard not crossing path separator",
+			patterns:   []string{"artifacts/*/files"},
+			repository: "artifacts/yum/rocky/files",
+			expected:   false,
+		},
+		{
+			name:       "double wildcard",
+			patterns:   []string{"artifacts/**/files"},
+			repository: "artifacts/yum/rocky/files",
+			expected:   true,
+		},
+		{
+			name:       "any pattern",
+			patterns:   []string{"artifacts/static", "artifacts/yum"},
+			repository: "artifacts/yum/rocky",
+			expected:   true,
+		},
+		{
+			name:       "negation excludes subtree",
+			patterns:   []string{"artifacts/yum", "!artifacts/yum/internal"},
+			repository: "artifacts/yum/internal/repo",
+			expected:   false,
+		},
+		{
+			name:       "negation keeps other repositories",
+			patterns:   []string{"artifacts/yum", "!artifacts/yum/internal"},
+			repository: "artifacts/yum/rocky",
+			expected:   true,
+		},
+		{
+			name:       "negation only",
+			patterns:   []string{"!artifacts/static"},
+			repository: "artifacts/yum/rocky",
+			expected:   true,
+		},
+		{
+			name:       "negation overridden by later pattern",
+			patterns:   []string{"!artifacts/yum", "artifacts/yum/rocky"},
+			repository: "artifacts/yum/rocky",
+			expected:   true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			matcher, err := NewMatcher(tc.patterns...)
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, matcher.Matches(&EventPayload{Repository: tc.repository}))
+		})
+	}
+
+	_, err := NewMatcher("!")
+	require.Error(t, err)
+}
@@ -0,0 +1,125 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package publisher
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	eventv1 "go.ciq.dev/beskar/pkg/api/event/v1"
+)
+
+// EventPublisher publishes events.
+type EventPublisher interface {
+	Publish(ctx context.Context, event *eventv1.EventPayload) error
+}
+
+var (
+	_ EventPublisher = (*Publisher)(nil)
+	_ EventPublisher = (*Deduper)(nil)
+)
+
+type dedupKey struct {
+	repository string
+	digest     string
+	action     eventv1.Action
+}
+
+// dedupEntry is a published event, done is closed once
+// the event is published with the publisher error.
+type dedupEntry struct {
+	seenAt time.Time
+	done   chan struct{}
+	err    error
+}
+
+// Deduper wraps a publisher and suppresses the events identical to an event
+// published within the window, events are identical if they have the same
+// repository, digest and action. It's safe for concurrent use.
+type Deduper struct {
+	publisher EventPublisher
+	window    time.Duration
+	now       func() time.Time
+
+	mu        sync.Mutex
+	seen      map[dedupKey]*dedupEntry
+	lastSweep time.Time
+}
+
+// NewDeduper returns a deduper forwarding the events to the publisher.
+func NewDeduper(publisher EventPublisher, window time.Duration) *Deduper {
+	return &Deduper{
+		publisher: publisher,
+		window:    window,
+		now:       time.Now,
+		seen:      make(map[dedupKey]*dedupEntry),
+	}
+}
+
+// Publish forwards the event to the wrapped publisher unless an identical
+// event was published within the window. An identical event still being
+// published is waited for and its error is returned. A failed event isn't
+// recorded so that it can be published again.
+func (d *Deduper) Publish(ctx context.Context, event *eventv1.EventPayload) error {
+	key := dedupKey{
+		repository: event.GetRepository(),
+		digest:     event.GetDigest(),
+		action:     event.GetAction(),
+	}
+
+	entry, ok := d.record(key)
+	if !ok {
+		select {
+		case <-entry.done:
+			return entry.err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	err := d.publisher.Publish(ctx, event)
+	if err != nil {
+		d.mu.Lock()
+		if d.seen[key] == entry {
+			delete(d.seen, key)
+		}
+		d.mu.Unlock()
+	}
+	entry.err = err
+	close(entry.done)
+
+	return err
+}
+
+// record records the event key and returns its entry, the returned
+// boolean is false if the event was already published within the
+// window, in which case the entry of the identical event is returned.
+func (d *Deduper) record(key dedupKey) (*dedupEntry, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := d.now()
+
+	// evict expired entries at most once per window to bound memory
+	if now.Sub(d.lastSweep) >= d.window {
+		for k, entry := range d.seen {
+			if now.Sub(entry.seenAt) >= d.window {
+				delete(d.seen, k)
+			}
+		}
+		d.lastSweep = now
+	}
+
+	if entry, ok := d.seen[key]; ok && now.Sub(entry.seenAt) < d.window {
+		return entry, false
+	}
+	entry := &dedupEntry{
+		seenAt: now,
+		done:   make(chan struct{}),
+	}
+	d.seen[key] = entry
+
+	return entry, true
+}
@@ -0,0 +1,176 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package publisher
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	eventv1 "go.ciq.dev/beskar/pkg/api/event/v1"
+)
+
+type countingPublisher struct {
+	published int32
+	err       error
+}
+
+func (p *countingPublisher) Publish(context.Context, *eventv1.EventPayload) error {
+	atomic.AddInt32(&p.published, 1)
+	return p.err
+}
+
+func TestDeduper(t *testing.T) {
+	publisher := new(countingPublisher)
+	deduper := NewDeduper(publisher, time.Minute)
+
+	event := &eventv1.EventPayload{
+		Repository: "artifacts/static/files",
+		Digest:     "sha256:2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae",
+		Action:     eventv1.Action_ACTION_PUT,
+	}
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			require.NoError(t, deduper.Publish(context.Background(), event))
+		}()
+	}
+	wg.Wait()
+
+	require.EqualValues(t, 1, atomic.LoadInt32(&publisher.published))
+
+	// a different action isn't suppressed
+	require.NoError(t, deduper.Publish(context.Background(), &eventv1.EventPayload{
+		Repository: event.Repository,
+		Digest:     event.Digest,
+		Action:     eventv1.Action_ACTION_DELETE,
+	}))
+	require.EqualValues(t, 2, atomic.LoadInt32(&publisher.published))
+}
+
+func TestDeduperWindow(t *testing.T) {
+	now := time.Now()
+
+	publisher := new(countingPublisher)
+	deduper := NewDeduper(publisher, time.Minute)
+	deduper.now = func() time.Time {
+		return now
+	}
+
+	event := &eventv1.EventPayload{
+		Repository: "artifacts/static/files",
+		Action:     eventv1.Action_ACTION_PUT,
+	}
+
+	require.NoError(t, deduper.Publish(context.Background(), event))
+	require.NoError(t, deduper.Publish(context.Background(), event))
+	require.EqualValues(t, 1, publisher.published)
+
+	// the entry is evicted once the window elapsed
+	now = now.Add(time.Minute)
+	require.NoError(t, deduper.Publish(context.Background(), &eventv1.EventPayload{
+		Repository: "artifacts/static/other",
+		Action:     eventv1.Action_ACTION_PUT,
+	}))
+	require.Len(t, deduper.seen, 1)
+
+	require.NoError(t, deduper.Publish(context.Background(), event))
+	require.EqualValues(t, 3, publisher.published)
+
+	// failed events are not suppressed
+	publisher.err = errors.New("unavailable")
+	failed := &eventv1.EventPayload{
+		Repository: "artifacts/static/failed",
+		Action:     eventv1.Action_ACTION_PUT,
+	}
+	require.Error(t, deduper.Publish(context.Background(), failed))
+	require.Error(t, deduper.Publish(context.Background(), failed))
+	require.EqualValues(t, 5, publisher.published)
+}
+
+// blockingPublisher blocks the published events until released.
+type blockingPublisher struct {
+	countingPublisher
+	started chan struct{}
+	release chan error
+}
+
+func (p *blockingPublisher) Publish(ctx context.Context, event *eventv1.EventPayload) error {
+	_ = p.countingPublisher.Publish(ctx, event)
+	p.started <- struct{}{}
+	return <-p.release
+}
+
+func TestDeduperFailure(t *testing.T) {
+	publisher := &blockingPublisher{
+		started: make(chan struct{}, 1),
+		release: make(chan error, 1),
+	}
+	deduper := NewDeduper(publisher, time.Minute)
+
+	event := &eventv1.EventPayload{
+		Repository: "artifacts/static/files",
+		Action:     eventv1.Action_ACTION_PUT,
+	}
+	publishErr := errors.New("unavailable")
+
+	errs := make(chan error, 10)
+	publish := func() {
+		errs <- deduper.Publish(context.Background(), event)
+	}
+
+	go publish()
+	<-publisher.started
+
+	// duplicates wait for the event in flight
+	for i := 0; i < 9; i++ {
+		go publish()
+	}
+	require.Never(t, func() bool {
+		return len(errs) > 0
+	}, 50*time.Millisecond, time.Millisecond)
+
+	publisher.release <- publishErr
+	for i := 0; i < 10; i++ {
+		require.ErrorIs(t, <-errs, publishErr)
+	}
+	require.EqualValues(t, 1, atomic.LoadInt32(&publisher.published))
+
+	// the failed event is forwarded again
+	publisher.release <- nil
+	go publish()
+	<-publisher.started
+	require.NoError(t, <-errs)
+	require.EqualValues(t, 2, atomic.LoadInt32(&publisher.published))
+
+	// the published event is suppressed
+	require.NoError(t, deduper.Publish(context.Background(), event))
+	require.EqualValues(t, 2, atomic.LoadInt32(&publisher.published))
+
+	// a duplicate waiting for the event in flight gives up with its context
+
+	other := &eventv1.EventPayload{
+		Repository: "artifacts/static/other",
+		Action:     eventv1.Action_ACTION_PUT,
+	}
+	go func() {
+		errs <- deduper.Publish(context.Background(), other)
+	}()
+	<-publisher.started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, deduper.Publish(ctx, other), context.DeadlineExceeded)
+
+	publisher.release <- nil
+	require.NoError(t, <-errs)
+}
@@ -0,0 +1,275 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package publisher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	eventv1 "go.ciq.dev/beskar/pkg/api/event/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// DefaultKafkaLinger is the default duration events are
+	// buffered before the batch is produced.
+	DefaultKafkaLinger = 10 * time.Millisecond
+	// DefaultKafkaBatchSize is the default maximum number of events per batch.
+	DefaultKafkaBatchSize = 100
+	// DefaultKafkaProduceTimeout is the default timeout of a batch production.
+	DefaultKafkaProduceTimeout = 30 * time.Second
+)
+
+// ErrKafkaPublisherClosed is returned when publishing to a closed publisher.
+var ErrKafkaPublisherClosed = errors.New("kafka publisher closed")
+
+// KafkaMessage is a message produced to a Kafka topic.
+type KafkaMessage struct {
+	Topic string
+	Key   []byte
+	Value []byte
+}
+
+// KafkaProducer produces a batch of messages to Kafka, it's usually
+// a thin adapter around the Kafka client of the application. Messages
+// sharing the same key must be produced to the same partition.
+type KafkaProducer interface {
+	Produce(ctx context.Context, messages ...KafkaMessage) error
+}
+
+type kafkaPending struct {
+	message KafkaMessage
+	done    chan error
+}
+
+// KafkaPublisher publishes protobuf encoded events to a Kafka topic, the
+// event repository is the message key so that all events of a repository
+// land in the same partition and stay ordered. Events are batched for the
+// linger duration or until the batch is full. It's safe for concurrent use.
+type KafkaPublisher struct {
+	producer       KafkaProducer
+	topic          string
+	linger         time.Duration
+	batchSize      int
+	produceTimeout time.Duration
+
+	// produceMu is held from taking a batch until it's produced
+	// so that batches are produced in order.
+	produceMu sync.Mutex
+
+	mu     sync.Mutex
+	batch  []*kafkaPending
+	timer  *time.Timer
+	closed bool
+}
+
+var _ EventPublisher = (*KafkaPublisher)(nil)
+
+type KafkaPublisherOption func(*KafkaPublisher)
+
+// WithKafkaLinger sets the duration events are buffered
+// before the batch is produced.
+func WithKafkaLinger(linger time.Duration) KafkaPublisherOption {
+	return func(p *KafkaPublisher) {
+		p.linger = linger
+	}
+}
+
+// WithKafkaBatchSize sets the maximum number of events per batch,
+// a full batch is produced without waiting for the linger duration.
+func WithKafkaBatchSize(batchSize int) KafkaPublisherOption {
+	return func(p *KafkaPublisher) {
+		p.batchSize = batchSize
+	}
+}
+
+// WithKafkaProduceTimeout sets the timeout of a batch production, batches
+// are produced independently of the context of the events they hold.
+func WithKafkaProduceTimeout(timeout time.Duration) KafkaPublisherOption {
+	return func(p *KafkaPublisher) {
+		p.produceTimeout = timeout
+	}
+}
+
+// NewKafkaPublisher returns a publisher producing events to the topic.
+func NewKafkaPublisher(producer KafkaProducer, topic string, options ...KafkaPublisherOption) *KafkaPublisher {
+	publisher := &KafkaPublisher{
+		producer:       producer,
+		topic:          topic,
+		linger:         DefaultKafkaLinger,
+		batchSize:      DefaultKafkaBatchSize,
+		produceTimeout: DefaultKafkaProduceTimeout,
+	}
+
+	for _, opt := range options {
+		opt(publisher)
+	}
+
+	return publisher
+}
+
+// Publish validates and adds the event to the current batch, it returns
+// once the batch is produced with the producer error. If the context is
+// done before, the batch is produced right away and the context error is
+// returned. Batches are produced with their own context bounded by the
+// produce timeout, a publisher giving up doesn't fail the other events
+// of its batch.
+func (p *KafkaPublisher) Publish(ctx context.Context, event *eventv1.EventPayload) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	} else if err := event.Validate(); err != nil {
+		return err
+	}
+
+	data, err := proto.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	pending := &kafkaPending{
+		message: KafkaMessage{
+			Topic: p.topic,
+			Key:   []byte(event.GetRepository()),
+			Value: data,
+		},
+		done: make(chan error, 1),
+	}
+
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return ErrKafkaPublisherClosed
+	}
+	p.batch = append(p.batch, pending)
+	full := len(p.batch) >= p.batchSize
+	if !full && p.timer == nil {
+		p.timer = time.AfterFunc(p.linger, p.flushDetached)
+	}
+	p.mu.Unlock()
+
+	if full {
+		p.flushDetached()
+		// the event may be part of a later batch if other
+		// batches were waiting to be produced
+		select {
+		case err := <-pending.done:
+			return err
+		default:
+		}
+	}
+
+	select {
+	case err := <-pending.done:
+		return err
+	case <-ctx.Done():
+		// don't keep the event waiting for the linger duration
+		go p.flushDetached()
+		return ctx.Err()
+	}
+}
+
+// Flush produces the current batches.
+func (p *KafkaPublisher) Flush(ctx context.Context) error {
+	return p.flush(true, func(batch []*kafkaPending) error {
+		return p.produce(ctx, batch)
+	})
+}
+
+// flushDetached produces the current batch with a context detached from
+// the events, the error is reported to each event of the batch.
+func (p *KafkaPublisher) flushDetached() {
+	_ = p.flush(false, p.produceDetached)
+}
+
+// Close produces the current batches and rejects the events
+// published afterwards.
+func (p *KafkaPublisher) Close(ctx context.Context) error {
+	p.mu.Lock()
+	p.closed = true
+	p.mu.Unlock()
+
+	return p.Flush(ctx)
+}
+
+// flush takes and produces the current batch, or all the current batches
+// if all is true. Batches are taken and produced under the produce lock,
+// a batch is never produced before the batches taken earlier.
+func (p *KafkaPublisher) flush(all bool, produce func([]*kafkaPending) error) error {
+	p.produceMu.Lock()
+	defer p.produceMu.Unlock()
+
+	var err error
+
+	for {
+		p.mu.Lock()
+		batch := p.takeBatch()
+		p.mu.Unlock()
+
+		if len(batch) == 0 {
+			return err
+		} else if produceErr := produce(batch); produceErr != nil {
+			err = produceErr
+		}
+		if !all {
+			return err
+		}
+	}
+}
+
+// takeBatch returns up to batch size events of the current batch, the
+// linger timer is stopped or restarted for the remaining events. It must
+// be called with the lock held.
+func (p *KafkaPublisher) takeBatch() []*kafkaPending {
+	if p.timer != nil {
+		p.timer.Stop()
+		p.timer = nil
+	}
+	n := len(p.batch)
+	if p.batchSize > 0 && n > p.batchSize {
+		n = p.batchSize
+	}
+	batch := p.batch[:n:n]
+	p.batch = p.batch[n:]
+	if len(p.batch) == 0 {
+		p.batch = nil
+	} else {
+		p.timer = time.AfterFunc(p.linger, p.flushDetached)
+	}
+	return batch
+}
+
+// produceDetached produces the batch with a context bounded by the produce
+// timeout rather than by the context of one of its events.
+func (p *KafkaPublisher) produceDetached(batch []*kafkaPending) error {
+	ctx, cancel := context.WithTimeout(context.Background(), p.produceTimeout)
+	defer cancel()
+
+	return p.produce(ctx, batch)
+}
+
+// produce produces the batch and reports the producer
+// error to the events of the batch.
+func (p *KafkaPublisher) produce(ctx context.Context, batch []*kafkaPending) error {
+	if len(batch) == 0 {
+		return nil
+	}
+
+	messages := make([]KafkaMessage, 0, len(batch))
+	for _, pending := range batch {
+		messages = append(messages, pending.message)
+	}
+
+	err := p.producer.Produce(ctx, messages...)
+	if err != nil {
+		err = fmt.Errorf("while producing events to %s: %w", p.topic, err)
+	}
+	for _, pending := range batch {
+		pending.done <- err
+	}
+
+	return err
+}
@@ -0,0 +1,243 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package publisher
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	eventv1 "go.ciq.dev/beskar/pkg/api/event/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+type mockProducer struct {
+	mu      sync.Mutex
+	batches [][]KafkaMessage
+	err     error
+}
+
+func (p *mockProducer) Produce(_ context.Context, messages ...KafkaMessage) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.batches = append(p.batches, messages)
+	return p.err
+}
+
+func (p *mockProducer) getBatches() [][]KafkaMessage {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.batches
+}
+
+func TestKafkaPublisher(t *testing.T) {
+	producer := new(mockProducer)
+	publisher := NewKafkaPublisher(producer, "beskar-events", WithKafkaBatchSize(2), WithKafkaLinger(time.Hour))
+
+	repositories := []string{"artifacts/yum/rocky", "library/alpine"}
+
+	var wg sync.WaitGroup
+
+	for _, repository := range repositories {
+		wg.Add(1)
+		go func(repository string) {
+			defer wg.Done()
+			require.NoError(t, publisher.Publish(context.Background(), &eventv1.EventPayload{
+				Repository: repository,
+				Action:     eventv1.Action_ACTION_PUT,
+			}))
+		}(repository)
+	}
+	wg.Wait()
+
+	// the full batch is produced without waiting for the linger duration
+	batches := producer.getBatches()
+	require.Len(t, batches, 1)
+	require.Len(t, batches[0], 2)
+
+	for _, message := range batches[0] {
+		require.Equal(t, "beskar-events", message.Topic)
+
+		event := new(eventv1.EventPayload)
+		require.NoError(t, proto.Unmarshal(message.Value, event))
+		require.Equal(t, event.GetRepository(), string(message.Key))
+	}
+}
+
+func TestKafkaPublisherLinger(t *testing.T) {
+	producer := new(mockProducer)
+	publisher := NewKafkaPublisher(producer, "beskar-events", WithKafkaLinger(10*time.Millisecond))
+
+	err := publisher.Publish(context.Background(), &eventv1.EventPayload{
+		Repository: "library/alpine",
+		Action:     eventv1.Action_ACTION_DELETE,
+	})
+	require.NoError(t, err)
+	require.Len(t, producer.getBatches(), 1)
+
+	producer.err = errors.New("broker unavailable")
+
+	err = publisher.Publish(context.Background(), &eventv1.EventPayload{
+		Repository: "library/alpine",
+		Action:     eventv1.Action_ACTION_PUT,
+	})
+	require.ErrorIs(t, err, producer.err)
+
+	require.NoError(t, publisher.Close(context.Background()))
+	err = publisher.Publish(context.Background(), &eventv1.EventPayload{
+		Repository: "library/alpine",
+		Action:     eventv1.Action_ACTION_PUT,
+	})
+	require.ErrorIs(t, err, ErrKafkaPublisherClosed)
+}
+
+func TestKafkaPublisherCancel(t *testing.T) {
+	producer := new(mockProducer)
+	publisher := NewKafkaPublisher(producer, "beskar-events", WithKafkaLinger(time.Hour))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	err := publisher.Publish(ctx, &eventv1.EventPayload{
+		Repository: "library/alpine",
+		Action:     eventv1.Action_ACTION_PUT,
+	})
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// the batch is flushed once the context is done
+	require.Eventually(t, func() bool {
+		return len(producer.getBatches()) == 1
+	}, time.Second, time.Millisecond)
+}
+
+// producerFunc is a KafkaProducer calling the function.
+type producerFunc func(ctx context.Context, messages ...KafkaMessage) error
+
+func (f producerFunc) Produce(ctx context.Context, messages ...KafkaMessage) error {
+	return f(ctx, messages...)
+}
+
+func TestKafkaPublisherDetachedContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// the publisher filling the batch gives up during the production
+	producer := producerFunc(func(produceCtx context.Context, _ ...KafkaMessage) error {
+		cancel()
+		return produceCtx.Err()
+	})
+	publisher := NewKafkaPublisher(producer, "beskar-events", WithKafkaBatchSize(2), WithKafkaLinger(time.Hour))
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- publisher.Publish(context.Background(), &eventv1.EventPayload{
+			Repository: "artifacts/yum/rocky",
+			Action:     eventv1.Action_ACTION_PUT,
+		})
+	}()
+	require.Eventually(t, func() bool {
+		publisher.mu.Lock()
+		defer publisher.mu.Unlock()
+		return len(publisher.batch) == 1
+	}, time.Second, time.Millisecond)
+
+	require.NoError(t, publisher.Publish(ctx, &eventv1.EventPayload{
+		Repository: "library/alpine",
+		Action:     eventv1.Action_ACTION_PUT,
+	}))
+	require.NoError(t, <-errs)
+}
+
+func TestKafkaPublisherProduceTimeout(t *testing.T) {
+	producer := producerFunc(func(ctx context.Context, _ ...KafkaMessage) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	publisher := NewKafkaPublisher(producer, "beskar-events", WithKafkaBatchSize(2), WithKafkaLinger(time.Hour), WithKafkaProduceTimeout(10*time.Millisecond))
+
+	// the production failure is reported to each event of the batch
+	errs := make(chan error, 2)
+	for _, repository := range []string{"artifacts/yum/rocky", "library/alpine"} {
+		go func(repository string) {
+			errs <- publisher.Publish(context.Background(), &eventv1.EventPayload{
+				Repository: repository,
+				Action:     eventv1.Action_ACTION_PUT,
+			})
+		}(repository)
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-errs:
+			require.ErrorIs(t, err, context.DeadlineExceeded)
+		case <-time.After(5 * time.Second):
+			t.Fatal("publish didn't return after the produce timeout")
+		}
+	}
+}
+
+func TestKafkaPublisherOrder(t *testing.T) {
+	produced := make(chan []KafkaMessage, 2)
+	release := make(chan struct{})
+
+	// the production of the first batch is slow
+	var calls int32
+	producer := producerFunc(func(_ context.Context, messages ...KafkaMessage) error {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			<-release
+		}
+		produced <- messages
+		return nil
+	})
+	publisher := NewKafkaPublisher(producer, "beskar-events", WithKafkaBatchSize(2), WithKafkaLinger(time.Hour))
+
+	var wg sync.WaitGroup
+
+	publish := func(sequence uint64) {
+		defer wg.Done()
+		require.NoError(t, publisher.Publish(context.Background(), &eventv1.EventPayload{
+			Repository: "library/alpine",
+			Action:     eventv1.Action_ACTION_PUT,
+			Sequence:   sequence,
+		}))
+	}
+
+	wg.Add(2)
+	go publish(1)
+	go publish(2)
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt32(&calls) == 1
+	}, time.Second, time.Millisecond)
+
+	wg.Add(2)
+	go publish(3)
+	go publish(4)
+
+	// the second batch waits for the production of the first one
+	require.Never(t, func() bool {
+		return atomic.LoadInt32(&calls) > 1
+	}, 50*time.Millisecond, time.Millisecond)
+
+	close(release)
+	wg.Wait()
+
+	// events of the same repository are produced in order
+	for i := 0; i < 2; i++ {
+		messages := <-produced
+		require.Len(t, messages, 2)
+
+		batch := make([]uint64, 0, len(messages))
+		for _, message := range messages {
+			event := new(eventv1.EventPayload)
+			require.NoError(t, proto.Unmarshal(message.Value, event))
+			batch = append(batch, event.GetSequence())
+		}
+		require.ElementsMatch(t, []uint64{uint64(2*i + 1), uint64(2*i + 2)}, batch)
+	}
+}
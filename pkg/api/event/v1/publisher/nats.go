@@ -0,0 +1,97 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package publisher
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+	eventv1 "go.ciq.dev/beskar/pkg/api/event/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// DefaultNatsSubjectPrefix is the default prefix of the
+// subjects events are published to.
+const DefaultNatsSubjectPrefix = "beskar.events"
+
+// natsSubjectReplacer maps repository slashes to token separators and escapes
+// the escape character "_" and the characters not allowed in subject tokens
+// with their hexadecimal code, so that distinct repositories are published
+// to distinct subjects.
+var natsSubjectReplacer = strings.NewReplacer(
+	"/", ".",
+	"_", "_5f",
+	".", "_2e",
+	"*", "_2a",
+	">", "_3e",
+	" ", "_20",
+)
+
+// NatsSubject returns the subject events of the repository are published to,
+// the repository slashes become dots while the underscores and the characters
+// not allowed in subject tokens are escaped as "_" followed by their hexadecimal
+// code: artifacts/yum/rocky is published to <prefix>.artifacts.yum.rocky and
+// ghcr.io/my_org/beskar to <prefix>.ghcr_2eio.my_5forg.beskar.
+func NatsSubject(prefix, repository string) string {
+	return prefix + "." + natsSubjectReplacer.Replace(repository)
+}
+
+// NatsPublisher publishes protobuf encoded events on a NATS connection.
+type NatsPublisher struct {
+	conn   *nats.Conn
+	prefix string
+}
+
+var _ EventPublisher = (*NatsPublisher)(nil)
+
+// NewNatsPublisher returns a publisher publishing events to the subjects
+// returned by NatsSubject, DefaultNatsSubjectPrefix is used if prefix is empty.
+func NewNatsPublisher(conn *nats.Conn, prefix string) *NatsPublisher {
+	if prefix == "" {
+		prefix = DefaultNatsSubjectPrefix
+	}
+	return &NatsPublisher{
+		conn:   conn,
+		prefix: prefix,
+	}
+}
+
+// Publish validates and publishes the event, like any NATS core message
+// the event is delivered at most once.
+func (p *NatsPublisher) Publish(ctx context.Context, event *eventv1.EventPayload) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	} else if err := event.Validate(); err != nil {
+		return err
+	}
+
+	data, err := proto.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	subject := NatsSubject(p.prefix, event.GetRepository())
+	if err := p.conn.Publish(subject, data); err != nil {
+		return fmt.Errorf("while publishing event to %s: %w", subject, err)
+	}
+
+	return nil
+}
+
+// NatsSubscribe subscribes to the subject, wildcards included, and calls
+// the handler with the decoded events. Messages which are not valid events
+// are ignored.
+func NatsSubscribe(conn *nats.Conn, subject string, handler func(event *eventv1.EventPayload)) (*nats.Subscription, error) {
+	return conn.Subscribe(subject, func(msg *nats.Msg) {
+		event := new(eventv1.EventPayload)
+		if err := proto.Unmarshal(msg.Data, event); err != nil {
+			return
+		} else if err := event.Validate(); err != nil {
+			return
+		}
+		handler(event)
+	})
+}
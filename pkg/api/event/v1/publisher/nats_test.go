@@ -0,0 +1,285 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package publisher
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/require"
+	eventv1 "go.ciq.dev/beskar/pkg/api/event/v1"
+)
+
+func TestNatsSubject(t *testing.T) {
+	tests := []struct {
+		repository string
+		expected   string
+	}{
+		{
+			repository: "artifacts/yum/rocky",
+			expected:   "beskar.events.artifacts.yum.rocky",
+		},
+		{
+			repository: "library/alpine",
+			expected:   "beskar.events.library.alpine",
+		},
+		{
+			repository: "ghcr.io/ctrliq/beskar",
+			expected:   "beskar.events.ghcr_2eio.ctrliq.beskar",
+		},
+		{
+			repository: "ghcr_io/ctrliq/beskar",
+			expected:   "beskar.events.ghcr_5fio.ctrliq.beskar",
+		},
+		{
+			repository: "artifacts/*/>",
+			expected:   "beskar.events.artifacts._2a._3e",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.repository, func(t *testing.T) {
+			require.Equal(t, tc.expected, NatsSubject(DefaultNatsSubjectPrefix, tc.repository))
+		})
+	}
+
+	// escaped characters don't collide with underscores
+	repositories := []string{"a.b", "a_b", "a_2eb", "a/b", "a*b", "a_2ab"}
+	subjects := make(map[string]string, len(repositories))
+	for _, repository := range repositories {
+		subject := NatsSubject(DefaultNatsSubjectPrefix, repository)
+		require.NotContains(t, subjects, subject, "%s and %s share the same subject", repository, subjects[subject])
+		subjects[subject] = repository
+	}
+}
+
+// natsSubscription is a subscription of a natsServer client.
+type natsSubscription struct {
+	client  *natsClient
+	sid     string
+	subject []string
+}
+
+// natsClient is a connection to a natsServer.
+type natsClient struct {
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func (c *natsClient) write(format string, args ...interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	_, _ = fmt.Fprintf(c.conn, format, args...)
+}
+
+// natsServer is a minimal in-process NATS server implementing the
+// core protocol operations used by the NATS client: CONNECT, PING,
+// PONG, SUB, UNSUB and PUB without queue groups nor headers.
+type natsServer struct {
+	listener net.Listener
+
+	mu            sync.Mutex
+	subscriptions []*natsSubscription
+}
+
+// newNatsServer starts a NATS server stopped at the end of the
+// test and returns its URL.
+func newNatsServer(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	server := &natsServer{listener: listener}
+
+	var wg sync.WaitGroup
+	t.Cleanup(func() {
+		_ = listener.Close()
+		wg.Wait()
+	})
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				server.serve(conn)
+			}()
+		}
+	}()
+
+	return "nats://" + listener.Addr().String()
+}
+
+func (s *natsServer) serve(conn net.Conn) {
+	client := &natsClient{conn: conn}
+	defer func() {
+		_ = conn.Close()
+		s.unsubscribe(client, "")
+	}()
+
+	addr := s.listener.Addr().(*net.TCPAddr)
+	client.write("INFO {\"server_id\":\"test\",\"version\":\"2.2.0\",\"host\":%q,\"port\":%d,\"max_payload\":1048576,\"proto\":1}\r\n", addr.IP.String(), addr.Port)
+
+	r := bufio.NewReader(conn)
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		args := strings.Fields(line)
+		if len(args) == 0 {
+			continue
+		}
+
+		switch strings.ToUpper(args[0]) {
+		case "PING":
+			client.write("PONG\r\n")
+		case "SUB":
+			// SUB <subject> [queue group] <sid>
+			s.subscribe(&natsSubscription{
+				client:  client,
+				sid:     args[len(args)-1],
+				subject: strings.Split(args[1], "."),
+			})
+		case "UNSUB":
+			// UNSUB <sid> [max msgs]
+			s.unsubscribe(client, args[1])
+		case "PUB":
+			// PUB <subject> [reply-to] <#bytes>
+			size, err := strconv.Atoi(args[len(args)-1])
+			if err != nil {
+				return
+			}
+			payload := make([]byte, size+2)
+			if _, err := io.ReadFull(r, payload); err != nil {
+				return
+			}
+			s.publish(args[1], payload[:size])
+		}
+	}
+}
+
+func (s *natsServer) subscribe(subscription *natsSubscription) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.subscriptions = append(s.subscriptions, subscription)
+}
+
+// unsubscribe removes the client subscription with the sid,
+// all the client subscriptions are removed if sid is empty.
+func (s *natsServer) unsubscribe(client *natsClient, sid string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	subscriptions := s.subscriptions[:0]
+	for _, subscription := range s.subscriptions {
+		if subscription.client != client || (sid != "" && subscription.sid != sid) {
+			subscriptions = append(subscriptions, subscription)
+		}
+	}
+	s.subscriptions = subscriptions
+}
+
+func (s *natsServer) publish(subject string, payload []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tokens := strings.Split(subject, ".")
+
+	for _, subscription := range s.subscriptions {
+		if natsSubjectMatch(subscription.subject, tokens) {
+			subscription.client.write("MSG %s %s %d\r\n%s\r\n", subject, subscription.sid, len(payload), payload)
+		}
+	}
+}
+
+// natsSubjectMatch returns true if the subject tokens match the
+// pattern tokens, "*" matches a token and a trailing ">" matches
+// one or more tokens.
+func natsSubjectMatch(pattern, tokens []string) bool {
+	for i, token := range pattern {
+		if token == ">" {
+			return len(tokens) > i
+		} else if i >= len(tokens) || (token != "*" && token != tokens[i]) {
+			return false
+		}
+	}
+	return len(pattern) == len(tokens)
+}
+
+func TestNatsPublisher(t *testing.T) {
+	conn, err := nats.Connect(newNatsServer(t))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	events := make(chan *eventv1.EventPayload, 8)
+
+	_, err = NatsSubscribe(conn, DefaultNatsSubjectPrefix+".artifacts.>", func(event *eventv1.EventPayload) {
+		events <- event
+	})
+	require.NoError(t, err)
+	require.NoError(t, conn.Flush())
+
+	publisher := NewNatsPublisher(conn, "")
+	ctx := context.Background()
+
+	// messages which are not valid events are ignored
+	require.NoError(t, conn.Publish(NatsSubject(DefaultNatsSubjectPrefix, "artifacts/static/files"), []byte("not an event")))
+	require.NoError(t, conn.Publish(NatsSubject(DefaultNatsSubjectPrefix, "artifacts/static/files"), nil))
+
+	for _, repository := range []string{"artifacts/yum/rocky", "library/alpine", "artifacts/static/files"} {
+		require.NoError(t, publisher.Publish(ctx, &eventv1.EventPayload{
+			Repository: repository,
+			Digest:     "sha256:2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae",
+			Payload:    []byte("payload"),
+			Action:     eventv1.Action_ACTION_PUT,
+		}))
+	}
+	require.NoError(t, conn.Flush())
+
+	// only the events of the artifacts repositories are received, in order
+	for _, repository := range []string{"artifacts/yum/rocky", "artifacts/static/files"} {
+		select {
+		case event := <-events:
+			require.Equal(t, repository, event.GetRepository())
+			require.Equal(t, "sha256:2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae", event.GetDigest())
+			require.Equal(t, []byte("payload"), event.GetPayload())
+			require.Equal(t, eventv1.Action_ACTION_PUT, event.GetAction())
+		case <-time.After(5 * time.Second):
+			t.Fatalf("event of %s not received", repository)
+		}
+	}
+	select {
+	case event := <-events:
+		t.Fatalf("unexpected event of %s", event.GetRepository())
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	require.ErrorIs(t, publisher.Publish(ctx, &eventv1.EventPayload{}), eventv1.ErrEmptyRepository)
+
+	cancelledCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	require.ErrorIs(t, publisher.Publish(cancelledCtx, &eventv1.EventPayload{
+		Repository: "artifacts/yum/rocky",
+		Action:     eventv1.Action_ACTION_PUT,
+	}), context.Canceled)
+}
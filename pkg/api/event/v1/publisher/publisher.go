@@ -0,0 +1,178 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package publisher
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	eventv1 "go.ciq.dev/beskar/pkg/api/event/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// DefaultMaxAttempts is the default maximum number of delivery attempts.
+	DefaultMaxAttempts = 5
+	// DefaultBaseDelay is the default delay before the first retry.
+	DefaultBaseDelay = 100 * time.Millisecond
+	// DefaultMaxDelay is the default maximum delay between two retries.
+	DefaultMaxDelay = 5 * time.Second
+)
+
+// Transport delivers events, errors returned by Send are considered
+// transient unless they are wrapped with Permanent.
+type Transport interface {
+	Send(ctx context.Context, event *eventv1.EventPayload) error
+}
+
+// TransportFunc is a function implementing Transport.
+type TransportFunc func(ctx context.Context, event *eventv1.EventPayload) error
+
+// Send calls f(ctx, event).
+func (f TransportFunc) Send(ctx context.Context, event *eventv1.EventPayload) error {
+	return f(ctx, event)
+}
+
+// Permanent wraps an error returned by a transport to
+// report a failure which must not be retried.
+func Permanent(err error) error {
+	return backoff.Permanent(err)
+}
+
+type httpTransport struct {
+	client *http.Client
+	url    string
+}
+
+// NewHTTPTransport returns a transport posting protobuf encoded events to
+// the URL, like the plugin /event endpoint. Client errors other than
+// timeouts and rate limiting are permanent failures.
+func NewHTTPTransport(client *http.Client, url string) Transport {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &httpTransport{
+		client: client,
+		url:    url,
+	}
+}
+
+func (t *httpTransport) Send(ctx context.Context, event *eventv1.EventPayload) error {
+	data, err := proto.Marshal(event)
+	if err != nil {
+		return Permanent(err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.url, bytes.NewReader(data))
+	if err != nil {
+		return Permanent(err)
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	return statusError(resp.StatusCode)
+}
+
+// statusError returns the error reported for an unexpected response status,
+// client errors other than timeouts and rate limiting are permanent failures.
+func statusError(statusCode int) error {
+	err := fmt.Errorf("event endpoint has returned status %d", statusCode)
+
+	switch statusCode {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return err
+	}
+	if statusCode >= 400 && statusCode < 500 {
+		return Permanent(err)
+	}
+
+	return err
+}
+
+// Publisher publishes events through a transport and retries transient
+// failures with an exponential backoff and jitter.
+type Publisher struct {
+	transport   Transport
+	maxAttempts int
+	baseDelay   time.Duration
+	maxDelay    time.Duration
+}
+
+type PublisherOption func(*Publisher)
+
+// WithMaxAttempts sets the maximum number of delivery attempts,
+// a zero or negative value retries until the context is done.
+func WithMaxAttempts(maxAttempts int) PublisherOption {
+	return func(p *Publisher) {
+		p.maxAttempts = maxAttempts
+	}
+}
+
+// WithBaseDelay sets the delay before the first retry,
+// the delay is doubled after each retry.
+func WithBaseDelay(delay time.Duration) PublisherOption {
+	return func(p *Publisher) {
+		p.baseDelay = delay
+	}
+}
+
+// WithMaxDelay sets the maximum delay between two retries.
+func WithMaxDelay(delay time.Duration) PublisherOption {
+	return func(p *Publisher) {
+		p.maxDelay = delay
+	}
+}
+
+// New returns a publisher delivering events through the transport.
+func New(transport Transport, options ...PublisherOption) *Publisher {
+	publisher := &Publisher{
+		transport:   transport,
+		maxAttempts: DefaultMaxAttempts,
+		baseDelay:   DefaultBaseDelay,
+		maxDelay:    DefaultMaxDelay,
+	}
+
+	for _, opt := range options {
+		opt(publisher)
+	}
+
+	return publisher
+}
+
+// Publish validates and delivers the event, it returns the last
+// transport error once all attempts failed or a permanent failure
+// occurred, and the context error if the context is done.
+func (p *Publisher) Publish(ctx context.Context, event *eventv1.EventPayload) error {
+	if err := event.Validate(); err != nil {
+		return err
+	}
+
+	eb := backoff.NewExponentialBackOff()
+	eb.InitialInterval = p.baseDelay
+	eb.MaxInterval = p.maxDelay
+	eb.Multiplier = 2
+	eb.MaxElapsedTime = 0
+
+	var b backoff.BackOff = eb
+	if p.maxAttempts > 0 {
+		b = backoff.WithMaxRetries(b, uint64(p.maxAttempts-1))
+	}
+
+	return backoff.Retry(func() error {
+		return p.transport.Send(ctx, event)
+	}, backoff.WithContext(b, ctx))
+}
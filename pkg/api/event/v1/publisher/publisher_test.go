@@ -0,0 +1,136 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package publisher
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	eventv1 "go.ciq.dev/beskar/pkg/api/event/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// flakyServer returns a server failing the first failures requests
+// with the status code and recording the received events.
+func flakyServer(t *testing.T, failures int32, statusCode int) (*httptest.Server, *int32, chan *eventv1.EventPayload) {
+	var requests int32
+
+	events := make(chan *eventv1.EventPayload, 1)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) <= failures {
+			w.WriteHeader(statusCode)
+			return
+		}
+
+		data, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+
+		event := new(eventv1.EventPayload)
+		require.NoError(t, proto.Unmarshal(data, event))
+		events <- event
+	}))
+	t.Cleanup(server.Close)
+
+	return server, &requests, events
+}
+
+func TestPublish(t *testing.T) {
+	event := &eventv1.EventPayload{
+		Repository: "artifacts/static/files",
+		Action:     eventv1.Action_ACTION_PUT,
+	}
+
+	tests := []struct {
+		name             string
+		failures         int32
+		statusCode       int
+		options          []PublisherOption
+		expectedErr      bool
+		expectedRequests int32
+	}{
+		{
+			name:             "fails twice then succeeds",
+			failures:         2,
+			statusCode:       http.StatusServiceUnavailable,
+			expectedRequests: 3,
+		},
+		{
+			name:             "rate limited",
+			failures:         1,
+			statusCode:       http.StatusTooManyRequests,
+			expectedRequests: 2,
+		},
+		{
+			name:             "attempts exhausted",
+			failures:         2,
+			statusCode:       http.StatusInternalServerError,
+			options:          []PublisherOption{WithMaxAttempts(2)},
+			expectedErr:      true,
+			expectedRequests: 2,
+		},
+		{
+			name:             "permanent failure",
+			failures:         2,
+			statusCode:       http.StatusBadRequest,
+			expectedErr:      true,
+			expectedRequests: 1,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			server, requests, events := flakyServer(t, tc.failures, tc.statusCode)
+
+			options := append([]PublisherOption{WithBaseDelay(time.Millisecond)}, tc.options...)
+			publisher := New(NewHTTPTransport(server.Client(), server.URL), options...)
+
+			err := publisher.Publish(context.Background(), event)
+			if tc.expectedErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+				require.True(t, proto.Equal(event, <-events))
+			}
+			require.Equal(t, tc.expectedRequests, atomic.LoadInt32(requests))
+		})
+	}
+}
+
+func TestPublishContextCancelled(t *testing.T) {
+	server, _, _ := flakyServer(t, 100, http.StatusServiceUnavailable)
+
+	publisher := New(
+		NewHTTPTransport(server.Client(), server.URL),
+		WithMaxAttempts(0),
+		WithBaseDelay(10*time.Millisecond),
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err := publisher.Publish(ctx, &eventv1.EventPayload{
+		Repository: "artifacts/static/files",
+		Action:     eventv1.Action_ACTION_PUT,
+	})
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestPublishInvalidEvent(t *testing.T) {
+	publisher := New(TransportFunc(func(context.Context, *eventv1.EventPayload) error {
+		t.Fatal("unexpected send")
+		return nil
+	}))
+
+	err := publisher.Publish(context.Background(), &eventv1.EventPayload{
+		Action: eventv1.Action_ACTION_PUT,
+	})
+	require.ErrorIs(t, err, eventv1.ErrEmptyRepository)
+}
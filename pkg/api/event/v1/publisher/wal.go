@@ -0,0 +1,482 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package publisher
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	eventv1 "go.ciq.dev/beskar/pkg/api/event/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// DefaultWALSegmentSize is the default size after which
+// a new write-ahead log segment is created.
+const DefaultWALSegmentSize = 64 << 20
+
+const (
+	walSegmentExt = ".wal"
+
+	// a record is a header followed by the record data, the header
+	// holds the record kind, the entry ID, the data length and the
+	// CRC-32 checksum of the data.
+	walHeaderSize = 1 + 8 + 4 + 4
+	// walRecordOverhead bounds the size of the event fields
+	// other than the payload in an entry record.
+	walRecordOverhead = 64 << 10
+
+	walEntryRecord byte = 'E'
+	walAckRecord   byte = 'A'
+)
+
+var (
+	// ErrWALPublisherClosed is returned when publishing to a closed publisher.
+	ErrWALPublisherClosed = errors.New("wal publisher closed")
+	// ErrWALCorrupted is returned when a write-ahead log segment
+	// other than the last one has a malformed record.
+	ErrWALCorrupted = errors.New("wal segment corrupted")
+)
+
+var walCRCTable = crc32.MakeTable(crc32.Castagnoli)
+
+type walSegment struct {
+	first   uint64
+	path    string
+	unacked int
+}
+
+type walEntry struct {
+	id       uint64
+	event    *eventv1.EventPayload
+	segment  *walSegment
+	inflight bool
+}
+
+// WALPublisher persists the events in an on-disk write-ahead log before
+// forwarding them to a publisher, so that events are not lost if the
+// process stops before their delivery. The log is made of segments holding
+// the protobuf encoded events and the delivery acknowledgments, segments
+// whose events are all delivered are removed. Events left unacknowledged
+// by a failed delivery or a crash are delivered again by Replay, which
+// should be called on startup. Delivery is at least once: an event may be
+// delivered twice if the process stops between its delivery and the write
+// of its acknowledgment. It's safe for concurrent use.
+type WALPublisher struct {
+	publisher      EventPublisher
+	dir            string
+	segmentSize    int64
+	maxPayloadSize int
+
+	mu       sync.Mutex
+	segments []*walSegment
+	file     *os.File
+	size     int64
+	nextID   uint64
+	pending  map[uint64]*walEntry
+	closed   bool
+}
+
+var _ EventPublisher = (*WALPublisher)(nil)
+
+type WALPublisherOption func(*WALPublisher)
+
+// WithWALSegmentSize sets the size after which a new segment is created.
+func WithWALSegmentSize(size int64) WALPublisherOption {
+	return func(w *WALPublisher) {
+		w.segmentSize = size
+	}
+}
+
+// WithWALMaxPayloadSize sets the maximum size of the event payloads accepted
+// by the log, it defaults to eventv1.DefaultMaxPayloadSize. Logs must be
+// reopened with a limit not lower than the one they were written with.
+func WithWALMaxPayloadSize(size int) WALPublisherOption {
+	return func(w *WALPublisher) {
+		w.maxPayloadSize = size
+	}
+}
+
+// NewWALPublisher opens or creates the write-ahead log in the directory and
+// returns a publisher forwarding the events to publisher. The unacknowledged
+// events of the log are loaded and delivered by Replay. A record partially
+// written by a crash at the end of the log is discarded.
+func NewWALPublisher(dir string, publisher EventPublisher, options ...WALPublisherOption) (*WALPublisher, error) {
+	w := &WALPublisher{
+		publisher:      publisher,
+		dir:            dir,
+		segmentSize:    DefaultWALSegmentSize,
+		maxPayloadSize: eventv1.DefaultMaxPayloadSize,
+		nextID:         1,
+		pending:        make(map[uint64]*walEntry),
+	}
+
+	for _, opt := range options {
+		opt(w)
+	}
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("while creating wal directory: %w", err)
+	}
+	if err := w.load(); err != nil {
+		return nil, err
+	}
+	if len(w.segments) == 0 {
+		if err := w.createSegment(); err != nil {
+			return nil, err
+		}
+	} else if err := w.openSegment(w.segments[len(w.segments)-1]); err != nil {
+		return nil, err
+	}
+	if err := w.truncate(); err != nil {
+		_ = w.file.Close()
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// Publish validates the event and appends it to the log before forwarding
+// it to the wrapped publisher. The event is acknowledged once delivered, if
+// the delivery fails the event stays in the log for the next Replay.
+func (w *WALPublisher) Publish(ctx context.Context, event *eventv1.EventPayload) error {
+	if err := event.Validate(eventv1.WithMaxPayloadSize(w.maxPayloadSize)); err != nil {
+		return err
+	}
+
+	data, err := proto.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	entry, err := w.appendEntry(event, data)
+	w.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	return w.deliver(ctx, entry)
+}
+
+// Replay delivers the unacknowledged events in the order they were
+// appended to the log, events currently being delivered by Publish
+// are skipped. It stops at the first delivery failure.
+func (w *WALPublisher) Replay(ctx context.Context) error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return ErrWALPublisherClosed
+	}
+	entries := make([]*walEntry, 0, len(w.pending))
+	for _, entry := range w.pending {
+		if !entry.inflight {
+			entry.inflight = true
+			entries = append(entries, entry)
+		}
+	}
+	w.mu.Unlock()
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].id < entries[j].id
+	})
+
+	for i, entry := range entries {
+		if err := w.deliver(ctx, entry); err != nil {
+			w.mu.Lock()
+			for _, entry := range entries[i+1:] {
+				entry.inflight = false
+			}
+			w.mu.Unlock()
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Pending returns the number of unacknowledged events.
+func (w *WALPublisher) Pending() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return len(w.pending)
+}
+
+// Close closes the log, the unacknowledged events are
+// kept for the next publisher opening the log.
+func (w *WALPublisher) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+
+	return w.file.Close()
+}
+
+// deliver forwards the entry event and acknowledges it on success.
+func (w *WALPublisher) deliver(ctx context.Context, entry *walEntry) error {
+	if err := w.publisher.Publish(ctx, entry.event); err != nil {
+		w.mu.Lock()
+		entry.inflight = false
+		w.mu.Unlock()
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		// acknowledged on the next replay
+		return nil
+	} else if err := w.writeRecord(walAckRecord, entry.id, nil); err != nil {
+		entry.inflight = false
+		return fmt.Errorf("while acknowledging wal entry %d: %w", entry.id, err)
+	}
+	w.ack(entry.id)
+
+	return w.truncate()
+}
+
+// appendEntry appends the event to the log, a new segment is created if
+// the current segment is full. It must be called with the lock held.
+func (w *WALPublisher) appendEntry(event *eventv1.EventPayload, data []byte) (*walEntry, error) {
+	if w.closed {
+		return nil, ErrWALPublisherClosed
+	}
+	if w.size >= w.segmentSize {
+		// the current segment stays open if the next one can't be created
+		previous := w.file
+		if err := w.createSegment(); err != nil {
+			return nil, err
+		}
+		// records are synced once written, there is nothing left to flush
+		_ = previous.Close()
+	}
+
+	id := w.nextID
+	if err := w.writeRecord(walEntryRecord, id, data); err != nil {
+		return nil, fmt.Errorf("while appending wal entry %d: %w", id, err)
+	}
+	w.nextID++
+
+	segment := w.segments[len(w.segments)-1]
+	segment.unacked++
+
+	entry := &walEntry{
+		id:       id,
+		event:    event.Clone(),
+		segment:  segment,
+		inflight: true,
+	}
+	w.pending[id] = entry
+
+	return entry, nil
+}
+
+// writeRecord writes and syncs a record to the current segment. It must
+// be called with the lock held.
+func (w *WALPublisher) writeRecord(kind byte, id uint64, data []byte) error {
+	record := make([]byte, walHeaderSize+len(data))
+	record[0] = kind
+	binary.BigEndian.PutUint64(record[1:9], id)
+	binary.BigEndian.PutUint32(record[9:13], uint32(len(data)))
+	binary.BigEndian.PutUint32(record[13:17], crc32.Checksum(data, walCRCTable))
+	copy(record[walHeaderSize:], data)
+
+	if _, err := w.file.Write(record); err != nil {
+		// don't leave a partially written record before the next ones
+		_ = w.file.Truncate(w.size)
+		return err
+	}
+	w.size += int64(len(record))
+
+	return w.file.Sync()
+}
+
+// ack removes an acknowledged entry. It must be called with the lock held.
+func (w *WALPublisher) ack(id uint64) {
+	entry, ok := w.pending[id]
+	if !ok {
+		return
+	}
+	delete(w.pending, id)
+	entry.segment.unacked--
+}
+
+// truncate removes the oldest segments whose entries are all acknowledged,
+// the current segment is never removed. Segments are removed in order as
+// a segment may hold the acknowledgments of the entries of the previous
+// segments. It must be called with the lock held.
+func (w *WALPublisher) truncate() error {
+	for len(w.segments) > 1 && w.segments[0].unacked == 0 {
+		if err := os.Remove(w.segments[0].path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("while removing wal segment: %w", err)
+		}
+		w.segments = w.segments[1:]
+	}
+	return nil
+}
+
+// createSegment creates a new segment named after the ID
+// of its first entry and makes it the current segment.
+func (w *WALPublisher) createSegment() error {
+	segment := &walSegment{
+		first: w.nextID,
+		path:  filepath.Join(w.dir, fmt.Sprintf("%016x%s", w.nextID, walSegmentExt)),
+	}
+	file, err := os.OpenFile(segment.path, os.O_WRONLY|os.O_CREATE|os.O_EXCL|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("while creating wal segment: %w", err)
+	}
+	w.segments = append(w.segments, segment)
+	w.file = file
+	w.size = 0
+	return nil
+}
+
+// openSegment opens an existing segment as the current segment.
+func (w *WALPublisher) openSegment(segment *walSegment) error {
+	file, err := os.OpenFile(segment.path, os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("while opening wal segment: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return fmt.Errorf("while opening wal segment: %w", err)
+	}
+	w.file = file
+	w.size = info.Size()
+	return nil
+}
+
+// load reads the segments of the log directory in order.
+func (w *WALPublisher) load() error {
+	entries, err := os.ReadDir(w.dir)
+	if err != nil {
+		return fmt.Errorf("while reading wal directory: %w", err)
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, walSegmentExt) {
+			continue
+		}
+		first, err := strconv.ParseUint(strings.TrimSuffix(name, walSegmentExt), 16, 64)
+		if err != nil {
+			continue
+		}
+		w.segments = append(w.segments, &walSegment{
+			first: first,
+			path:  filepath.Join(w.dir, name),
+		})
+	}
+
+	sort.Slice(w.segments, func(i, j int) bool {
+		return w.segments[i].first < w.segments[j].first
+	})
+
+	for i, segment := range w.segments {
+		if err := w.loadSegment(segment, i == len(w.segments)-1); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// loadSegment reads the records of a segment. A malformed record ends the
+// last segment which is truncated to its last valid record.
+func (w *WALPublisher) loadSegment(segment *walSegment, last bool) error {
+	file, err := os.Open(segment.path)
+	if err != nil {
+		return fmt.Errorf("while opening wal segment: %w", err)
+	}
+	defer file.Close()
+
+	r := bufio.NewReader(file)
+	offset := int64(0)
+	header := make([]byte, walHeaderSize)
+
+	for {
+		kind, id, data, err := readRecord(r, header, int64(w.maxPayloadSize)+walRecordOverhead)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			if !last {
+				return fmt.Errorf("%w: %s at offset %d: %w", ErrWALCorrupted, segment.path, offset, err)
+			}
+			// partially written record
+			if err := os.Truncate(segment.path, offset); err != nil {
+				return fmt.Errorf("while truncating wal segment: %w", err)
+			}
+			return nil
+		}
+		offset += int64(walHeaderSize + len(data))
+
+		switch kind {
+		case walEntryRecord:
+			event := new(eventv1.EventPayload)
+			if err := proto.Unmarshal(data, event); err != nil {
+				return fmt.Errorf("%w: %s entry %d: %w", ErrWALCorrupted, segment.path, id, err)
+			}
+			w.pending[id] = &walEntry{
+				id:      id,
+				event:   event,
+				segment: segment,
+			}
+			segment.unacked++
+			if id >= w.nextID {
+				w.nextID = id + 1
+			}
+		case walAckRecord:
+			w.ack(id)
+		}
+	}
+}
+
+// readRecord reads a record and verifies its checksum, records larger than
+// maxSize are rejected. It returns io.EOF if there are no more records.
+func readRecord(r io.Reader, header []byte, maxSize int64) (byte, uint64, []byte, error) {
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, 0, nil, err
+	}
+
+	kind := header[0]
+	if kind != walEntryRecord && kind != walAckRecord {
+		return 0, 0, nil, fmt.Errorf("unknown record kind %q", kind)
+	}
+	id := binary.BigEndian.Uint64(header[1:9])
+	length := binary.BigEndian.Uint32(header[9:13])
+	if int64(length) > maxSize {
+		return 0, 0, nil, fmt.Errorf("record of %d bytes too large", length)
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, 0, nil, err
+	} else if crc32.Checksum(data, walCRCTable) != binary.BigEndian.Uint32(header[13:17]) {
+		return 0, 0, nil, fmt.Errorf("record checksum mismatch")
+	}
+
+	return kind, id, data, nil
+}
@@ -0,0 +1,235 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package publisher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	eventv1 "go.ciq.dev/beskar/pkg/api/event/v1"
+)
+
+type recordingPublisher struct {
+	mu     sync.Mutex
+	events []string
+	err    error
+}
+
+func (p *recordingPublisher) Publish(_ context.Context, event *eventv1.EventPayload) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.err != nil {
+		return p.err
+	}
+	p.events = append(p.events, event.GetRepository())
+	return nil
+}
+
+func (p *recordingPublisher) setErr(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.err = err
+}
+
+func (p *recordingPublisher) getEvents() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.events
+}
+
+func walEvent(i int) *eventv1.EventPayload {
+	return &eventv1.EventPayload{
+		Repository: fmt.Sprintf("artifacts/static/files%d", i),
+		Digest:     "sha256:2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae",
+		Payload:    []byte("payload"),
+		Action:     eventv1.Action_ACTION_PUT,
+	}
+}
+
+func walSegments(t *testing.T, dir string) []string {
+	segments, err := filepath.Glob(filepath.Join(dir, "*.wal"))
+	require.NoError(t, err)
+	return segments
+}
+
+func TestWALPublisher(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+
+	publisher := new(recordingPublisher)
+	wal, err := NewWALPublisher(dir, publisher)
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, wal.Publish(ctx, walEvent(i)))
+	}
+	require.Equal(t, []string{"artifacts/static/files0", "artifacts/static/files1", "artifacts/static/files2"}, publisher.getEvents())
+	require.Zero(t, wal.Pending())
+
+	require.ErrorIs(t, wal.Publish(ctx, &eventv1.EventPayload{}), eventv1.ErrEmptyRepository)
+
+	require.NoError(t, wal.Close())
+	require.ErrorIs(t, wal.Publish(ctx, walEvent(3)), ErrWALPublisherClosed)
+
+	// delivered events are not replayed
+	publisher = new(recordingPublisher)
+	wal, err = NewWALPublisher(dir, publisher)
+	require.NoError(t, err)
+	defer wal.Close()
+
+	require.Zero(t, wal.Pending())
+	require.NoError(t, wal.Replay(ctx))
+	require.Empty(t, publisher.getEvents())
+}
+
+func TestWALPublisherMaxPayloadSize(t *testing.T) {
+	ctx := context.Background()
+
+	publisher := new(recordingPublisher)
+	wal, err := NewWALPublisher(t.TempDir(), publisher, WithWALMaxPayloadSize(4))
+	require.NoError(t, err)
+	defer wal.Close()
+
+	require.ErrorIs(t, wal.Publish(ctx, walEvent(0)), eventv1.ErrPayloadTooLarge)
+	require.Zero(t, wal.Pending())
+	require.Empty(t, publisher.getEvents())
+}
+
+func TestWALPublisherSegmentFailure(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+
+	publisher := new(recordingPublisher)
+	wal, err := NewWALPublisher(dir, publisher, WithWALSegmentSize(1))
+	require.NoError(t, err)
+
+	require.NoError(t, wal.Publish(ctx, walEvent(0)))
+
+	// the next segment can't be created
+	next := filepath.Join(dir, fmt.Sprintf("%016x%s", wal.nextID, walSegmentExt))
+	require.NoError(t, os.Mkdir(next, 0o700))
+	require.Error(t, wal.Publish(ctx, walEvent(1)))
+
+	// the current segment is still usable
+	require.NoError(t, os.Remove(next))
+	require.NoError(t, wal.Publish(ctx, walEvent(2)))
+	require.NoError(t, wal.Close())
+	require.Equal(t, []string{"artifacts/static/files0", "artifacts/static/files2"}, publisher.getEvents())
+}
+
+func TestWALPublisherReplay(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+	deliveryErr := errors.New("broker unavailable")
+
+	publisher := new(recordingPublisher)
+	wal, err := NewWALPublisher(dir, publisher, WithWALSegmentSize(64))
+	require.NoError(t, err)
+
+	require.NoError(t, wal.Publish(ctx, walEvent(0)))
+
+	publisher.setErr(deliveryErr)
+	for i := 1; i < 4; i++ {
+		require.ErrorIs(t, wal.Publish(ctx, walEvent(i)), deliveryErr)
+	}
+	require.Equal(t, 3, wal.Pending())
+
+	// the process is killed while appending an event: the log isn't
+	// closed and the last segment ends with a partial record
+	segments := walSegments(t, dir)
+	f, err := os.OpenFile(segments[len(segments)-1], os.O_WRONLY|os.O_APPEND, 0)
+	require.NoError(t, err)
+	_, err = f.Write([]byte{'E', 0, 0, 0, 0, 0, 0, 0, 9, 0, 0})
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	// the pending events are replayed once and in order after a restart
+	for restart := 0; restart < 2; restart++ {
+		publisher := new(recordingPublisher)
+		wal, err := NewWALPublisher(dir, publisher, WithWALSegmentSize(64))
+		require.NoError(t, err)
+
+		require.NoError(t, wal.Replay(ctx))
+		require.NoError(t, wal.Replay(ctx))
+		require.Zero(t, wal.Pending())
+
+		if restart == 0 {
+			require.Equal(t, []string{"artifacts/static/files1", "artifacts/static/files2", "artifacts/static/files3"}, publisher.getEvents())
+		} else {
+			require.Empty(t, publisher.getEvents())
+		}
+
+		// new events are appended after the replayed ones
+		require.NoError(t, wal.Publish(ctx, walEvent(4+restart)))
+		require.NoError(t, wal.Close())
+	}
+
+	// the delivered segments are removed
+	require.Len(t, walSegments(t, dir), 1)
+}
+
+func TestWALPublisherReplayFailure(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+	deliveryErr := errors.New("broker unavailable")
+
+	publisher := new(recordingPublisher)
+	publisher.setErr(deliveryErr)
+
+	wal, err := NewWALPublisher(dir, publisher, WithWALSegmentSize(1))
+	require.NoError(t, err)
+	defer wal.Close()
+
+	for i := 0; i < 3; i++ {
+		require.ErrorIs(t, wal.Publish(ctx, walEvent(i)), deliveryErr)
+	}
+	require.Len(t, walSegments(t, dir), 3)
+
+	require.ErrorIs(t, wal.Replay(ctx), deliveryErr)
+	require.Equal(t, 3, wal.Pending())
+
+	publisher.setErr(nil)
+
+	require.NoError(t, wal.Replay(ctx))
+	require.Equal(t, []string{"artifacts/static/files0", "artifacts/static/files1", "artifacts/static/files2"}, publisher.getEvents())
+	require.Zero(t, wal.Pending())
+	require.Len(t, walSegments(t, dir), 1)
+}
+
+func TestWALPublisherCorrupted(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+
+	publisher := new(recordingPublisher)
+	publisher.setErr(errors.New("broker unavailable"))
+
+	wal, err := NewWALPublisher(dir, publisher, WithWALSegmentSize(1))
+	require.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		require.Error(t, wal.Publish(ctx, walEvent(i)))
+	}
+	require.NoError(t, wal.Close())
+
+	// only a partial record at the end of the last segment is expected
+	segments := walSegments(t, dir)
+	require.Len(t, segments, 2)
+
+	data, err := os.ReadFile(segments[0])
+	require.NoError(t, err)
+	data[len(data)-1] ^= 0xff
+	require.NoError(t, os.WriteFile(segments[0], data, 0o600))
+
+	_, err = NewWALPublisher(dir, publisher)
+	require.ErrorIs(t, err, ErrWALCorrupted)
+}
@@ -0,0 +1,188 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package publisher
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	eventv1 "go.ciq.dev/beskar/pkg/api/event/v1"
+)
+
+const (
+	// WebhookSignatureHeader is the header carrying the HMAC-SHA256
+	// signature of the webhook request body as sha256=<hex>.
+	WebhookSignatureHeader = "X-Beskar-Signature"
+	// DefaultWebhookTimeout is the default timeout of a webhook request.
+	DefaultWebhookTimeout = 10 * time.Second
+)
+
+// WebhookSignature returns the signature of the body sent in the
+// WebhookSignatureHeader header for the secret.
+func WebhookSignature(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	_, _ = mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+type webhookTransport struct {
+	client  *http.Client
+	url     string
+	secret  []byte
+	timeout time.Duration
+}
+
+func (t *webhookTransport) Send(ctx context.Context, event *eventv1.EventPayload) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return Permanent(err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.url, bytes.NewReader(body))
+	if err != nil {
+		return Permanent(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(t.secret) > 0 {
+		req.Header.Set(WebhookSignatureHeader, WebhookSignature(t.secret, body))
+	}
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+
+	return statusError(resp.StatusCode)
+}
+
+// WebhookDispatcher posts the JSON encoded events to webhook URLs, failed
+// deliveries are retried with an exponential backoff and logged once all
+// attempts failed.
+type WebhookDispatcher struct {
+	urls             []string
+	client           *http.Client
+	secret           []byte
+	timeout          time.Duration
+	logger           *slog.Logger
+	publisherOptions []PublisherOption
+	publishers       []*Publisher
+}
+
+var _ EventPublisher = (*WebhookDispatcher)(nil)
+
+type WebhookOption func(*WebhookDispatcher)
+
+// WithWebhookClient sets the HTTP client used to post the events.
+func WithWebhookClient(client *http.Client) WebhookOption {
+	return func(d *WebhookDispatcher) {
+		d.client = client
+	}
+}
+
+// WithWebhookSecret signs the requests with the secret, the signature is
+// sent in the WebhookSignatureHeader header.
+func WithWebhookSecret(secret []byte) WebhookOption {
+	return func(d *WebhookDispatcher) {
+		d.secret = secret
+	}
+}
+
+// WithWebhookTimeout sets the timeout of a webhook request.
+func WithWebhookTimeout(timeout time.Duration) WebhookOption {
+	return func(d *WebhookDispatcher) {
+		d.timeout = timeout
+	}
+}
+
+// WithWebhookLogger sets the logger reporting the failed deliveries.
+func WithWebhookLogger(logger *slog.Logger) WebhookOption {
+	return func(d *WebhookDispatcher) {
+		d.logger = logger
+	}
+}
+
+// WithWebhookRetry sets the retry options of the deliveries.
+func WithWebhookRetry(options ...PublisherOption) WebhookOption {
+	return func(d *WebhookDispatcher) {
+		d.publisherOptions = append(d.publisherOptions, options...)
+	}
+}
+
+// NewWebhookDispatcher returns a dispatcher posting events to the URLs.
+func NewWebhookDispatcher(urls []string, options ...WebhookOption) *WebhookDispatcher {
+	dispatcher := &WebhookDispatcher{
+		urls:    urls,
+		client:  http.DefaultClient,
+		timeout: DefaultWebhookTimeout,
+		logger:  slog.Default(),
+	}
+
+	for _, opt := range options {
+		opt(dispatcher)
+	}
+
+	dispatcher.publishers = make([]*Publisher, 0, len(urls))
+	for _, url := range urls {
+		transport := &webhookTransport{
+			client:  dispatcher.client,
+			url:     url,
+			secret:  dispatcher.secret,
+			timeout: dispatcher.timeout,
+		}
+		dispatcher.publishers = append(dispatcher.publishers, New(transport, dispatcher.publisherOptions...))
+	}
+
+	return dispatcher
+}
+
+// Publish validates and posts the event to all URLs concurrently, it returns
+// the delivery errors once all deliveries succeeded or failed.
+func (d *WebhookDispatcher) Publish(ctx context.Context, event *eventv1.EventPayload) error {
+	if err := event.Validate(); err != nil {
+		return err
+	}
+
+	errs := make([]error, len(d.publishers))
+
+	var wg sync.WaitGroup
+
+	for i, publisher := range d.publishers {
+		wg.Add(1)
+		go func(i int, publisher *Publisher) {
+			defer wg.Done()
+
+			if err := publisher.Publish(ctx, event); err != nil {
+				d.logger.ErrorContext(
+					ctx, "webhook delivery failed",
+					slog.String("url", d.urls[i]),
+					slog.String("repository", event.GetRepository()),
+					slog.String("action", event.GetAction().String()),
+					slog.String("error", err.Error()),
+				)
+				errs[i] = fmt.Errorf("while posting event to %s: %w", d.urls[i], err)
+			}
+		}(i, publisher)
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,102 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package publisher
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	eventv1 "go.ciq.dev/beskar/pkg/api/event/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestWebhookDispatcher(t *testing.T) {
+	secret := []byte("webhook-secret")
+
+	event := &eventv1.EventPayload{
+		Repository: "artifacts/static/files",
+		Digest:     "sha256:2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae",
+		Action:     eventv1.Action_ACTION_PUT,
+	}
+
+	var requests int32
+
+	events := make(chan *eventv1.EventPayload, 1)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the first delivery attempt fails
+		if atomic.AddInt32(&requests, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+
+		require.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		require.Equal(t, WebhookSignature(secret, body), r.Header.Get(WebhookSignatureHeader))
+
+		received := new(eventv1.EventPayload)
+		require.NoError(t, json.Unmarshal(body, received))
+		events <- received
+	}))
+	defer server.Close()
+
+	dispatcher := NewWebhookDispatcher(
+		[]string{server.URL},
+		WithWebhookSecret(secret),
+		WithWebhookTimeout(time.Second),
+		WithWebhookRetry(WithBaseDelay(time.Millisecond)),
+	)
+
+	require.NoError(t, dispatcher.Publish(context.Background(), event))
+	require.True(t, proto.Equal(event, <-events))
+	require.EqualValues(t, 2, atomic.LoadInt32(&requests))
+}
+
+func TestWebhookSignature(t *testing.T) {
+	// echo -n '{}' | openssl dgst -sha256 -hmac secret
+	require.Equal(t, "sha256=77325902caca812dc259733aacd046b73817372c777b8d95b402647474516e13", WebhookSignature([]byte("secret"), []byte("{}")))
+}
+
+func TestWebhookDispatcherFailure(t *testing.T) {
+	var requests int32
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+
+	succeeding := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	defer succeeding.Close()
+
+	logs := new(bytes.Buffer)
+
+	dispatcher := NewWebhookDispatcher(
+		[]string{failing.URL, succeeding.URL},
+		WithWebhookLogger(slog.New(slog.NewTextHandler(logs, nil))),
+		WithWebhookRetry(WithMaxAttempts(3), WithBaseDelay(time.Millisecond)),
+	)
+
+	err := dispatcher.Publish(context.Background(), &eventv1.EventPayload{
+		Repository: "artifacts/static/files",
+		Action:     eventv1.Action_ACTION_DELETE,
+	})
+	require.ErrorContains(t, err, failing.URL)
+	require.NotContains(t, err.Error(), succeeding.URL)
+	require.EqualValues(t, 3, atomic.LoadInt32(&requests))
+
+	require.Contains(t, logs.String(), "webhook delivery failed")
+	require.Contains(t, logs.String(), "url="+failing.URL)
+}
@@ -0,0 +1,75 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package eventv1
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// Handler handles an event.
+type Handler func(ctx context.Context, event *EventPayload) error
+
+type route struct {
+	action  Action
+	any     bool
+	handler Handler
+}
+
+// Router dispatches events to the handlers registered for their action.
+// It's safe for concurrent use.
+type Router struct {
+	mu     sync.RWMutex
+	routes []route
+}
+
+// NewRouter returns an event router without handlers.
+func NewRouter() *Router {
+	return &Router{}
+}
+
+// On registers the handler for the events of the action.
+func (r *Router) On(action Action, handler Handler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.routes = append(r.routes, route{
+		action:  action,
+		handler: handler,
+	})
+}
+
+// OnAny registers the handler for the events of all actions.
+func (r *Router) OnAny(handler Handler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.routes = append(r.routes, route{
+		any:     true,
+		handler: handler,
+	})
+}
+
+// Dispatch calls the handlers registered for the event action in their
+// registration order, all handlers are called even if one of them fails.
+// The returned error joins the errors returned by the handlers.
+func (r *Router) Dispatch(ctx context.Context, event *EventPayload) error {
+	r.mu.RLock()
+	routes := r.routes
+	r.mu.RUnlock()
+
+	var errs []error
+
+	for _, route := range routes {
+		if !route.any && route.action != event.GetAction() {
+			continue
+		}
+		if err := route.handler(ctx, event); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
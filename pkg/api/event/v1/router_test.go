@@ -0,0 +1,95 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package eventv1
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRouter(t *testing.T) {
+	router := NewRouter()
+
+	var calls []string
+
+	record := func(name string) Handler {
+		return func(_ context.Context, event *EventPayload) error {
+			calls = append(calls, name+":"+event.GetAction().String())
+			return nil
+		}
+	}
+
+	router.On(Action_ACTION_PUT, record("put"))
+	router.OnAny(record("any"))
+	router.On(Action_ACTION_DELETE, record("delete"))
+	router.On(Action_ACTION_PUT, record("put2"))
+
+	tests := []struct {
+		action   Action
+		expected []string
+	}{
+		{
+			action:   Action_ACTION_PUT,
+			expected: []string{"put:ACTION_PUT", "any:ACTION_PUT", "put2:ACTION_PUT"},
+		},
+		{
+			action:   Action_ACTION_DELETE,
+			expected: []string{"any:ACTION_DELETE", "delete:ACTION_DELETE"},
+		},
+		{
+			action:   Action_ACTION_START,
+			expected: []string{"any:ACTION_START"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.action.String(), func(t *testing.T) {
+			calls = nil
+
+			err := router.Dispatch(context.Background(), &EventPayload{
+				Repository: "artifacts/static/files",
+				Action:     tc.action,
+			})
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, calls)
+		})
+	}
+}
+
+func TestRouterErrors(t *testing.T) {
+	router := NewRouter()
+
+	errPut := errors.New("put failed")
+	errAny := errors.New("any failed")
+
+	called := false
+
+	router.On(Action_ACTION_PUT, func(context.Context, *EventPayload) error {
+		return errPut
+	})
+	router.On(Action_ACTION_PUT, func(context.Context, *EventPayload) error {
+		called = true
+		return nil
+	})
+	router.OnAny(func(context.Context, *EventPayload) error {
+		return errAny
+	})
+
+	err := router.Dispatch(context.Background(), &EventPayload{
+		Repository: "artifacts/static/files",
+		Action:     Action_ACTION_PUT,
+	})
+	require.ErrorIs(t, err, errPut)
+	require.ErrorIs(t, err, errAny)
+	require.True(t, called)
+
+	// an event without handler isn't an error
+	require.NoError(t, NewRouter().Dispatch(context.Background(), &EventPayload{
+		Repository: "artifacts/static/files",
+		Action:     Action_ACTION_STOP,
+	}))
+}
@@ -0,0 +1,238 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package eventv1
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// SequenceGenerator generates strictly increasing per-repository
+// sequence numbers starting at 1. Sequences are not persisted, each
+// generator has its own epoch so that consumers can tell the sequences
+// of a restarted producer apart. It's safe for concurrent use.
+type SequenceGenerator struct {
+	mu        sync.Mutex
+	epoch     uint64
+	sequences map[string]uint64
+}
+
+// NewSequenceGenerator returns a sequence generator, its epoch is the
+// creation time so that the epoch of a restarted producer is greater.
+func NewSequenceGenerator() *SequenceGenerator {
+	return &SequenceGenerator{
+		epoch:     uint64(time.Now().UnixNano()),
+		sequences: make(map[string]uint64),
+	}
+}
+
+// Epoch returns the epoch of the generator.
+func (g *SequenceGenerator) Epoch() uint64 {
+	return g.epoch
+}
+
+// Next returns the next sequence number of the repository.
+func (g *SequenceGenerator) Next(repository string) uint64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.sequences[repository]++
+	return g.sequences[repository]
+}
+
+// Stamp sets the event sequence to the next sequence number
+// of the event repository and the event epoch to the generator epoch.
+func (g *SequenceGenerator) Stamp(event *EventPayload) {
+	event.Sequence = g.Next(event.GetRepository())
+	event.Epoch = g.epoch
+}
+
+// repositoryOrder tracks the pending events of a repository.
+type repositoryOrder struct {
+	epoch   uint64
+	next    uint64
+	pending map[uint64]*EventPayload
+	timer   *time.Timer
+}
+
+// Reorderer releases events in their repository sequence order, out of
+// order events are buffered until the missing events are pushed or until
+// the gap timeout expires, in which case the missing events are skipped.
+// Events without sequence are released immediately and events older than
+// the last released event of their repository are dropped.
+//
+// Sequences restart at 1 when a producer restarts with a greater epoch,
+// the pending events of the previous epoch are released in order and
+// the order of the repository restarts. Events of a previous epoch
+// pushed after a restart, like events replayed from a write-ahead log,
+// are released immediately since their order can't be restored.
+//
+// The first expected sequence number of a repository is 1, a consumer
+// joining a stream in progress waits for the gap timeout before the
+// first events of each repository are released.
+type Reorderer struct {
+	mu           sync.Mutex
+	gapTimeout   time.Duration
+	release      func(*EventPayload)
+	repositories map[string]*repositoryOrder
+	stopped      bool
+}
+
+// NewReorderer returns a reorderer calling release for each event in
+// order. The release function is called with the reorderer lock held
+// and must not call the reorderer.
+func NewReorderer(gapTimeout time.Duration, release func(*EventPayload)) *Reorderer {
+	return &Reorderer{
+		gapTimeout:   gapTimeout,
+		release:      release,
+		repositories: make(map[string]*repositoryOrder),
+	}
+}
+
+// Push pushes an event to the reorderer, the event is released
+// immediately if it's the next event of its repository.
+func (r *Reorderer) Push(event *EventPayload) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.stopped {
+		return
+	}
+
+	sequence := event.GetSequence()
+	if sequence == 0 {
+		r.release(event)
+		return
+	}
+
+	repository := event.GetRepository()
+	epoch := event.GetEpoch()
+
+	order, ok := r.repositories[repository]
+	if !ok {
+		order = &repositoryOrder{
+			epoch:   epoch,
+			next:    1,
+			pending: make(map[uint64]*EventPayload),
+		}
+		r.repositories[repository] = order
+	}
+
+	if epoch < order.epoch {
+		r.release(event)
+		return
+	} else if epoch > order.epoch {
+		r.restart(order, epoch)
+	}
+
+	if sequence < order.next {
+		return
+	}
+	order.pending[sequence] = event
+
+	r.releasePending(repository, order)
+}
+
+// releasePending releases the pending events of the repository following
+// the last released event and arms the gap timer if events are still
+// pending. It must be called with the lock held.
+func (r *Reorderer) releasePending(repository string, order *repositoryOrder) {
+	for {
+		event, ok := order.pending[order.next]
+		if !ok {
+			break
+		}
+		delete(order.pending, order.next)
+		order.next++
+		r.release(event)
+	}
+
+	if len(order.pending) == 0 {
+		if order.timer != nil {
+			order.timer.Stop()
+			order.timer = nil
+		}
+		return
+	} else if order.timer != nil {
+		return
+	}
+
+	epoch, expected := order.epoch, order.next
+	order.timer = time.AfterFunc(r.gapTimeout, func() {
+		r.skipGap(repository, epoch, expected)
+	})
+}
+
+// restart releases the pending events of the repository in sequence order
+// and restarts the repository order at the first sequence of the epoch. It
+// must be called with the lock held.
+func (r *Reorderer) restart(order *repositoryOrder, epoch uint64) {
+	if order.timer != nil {
+		order.timer.Stop()
+		order.timer = nil
+	}
+
+	sequences := make([]uint64, 0, len(order.pending))
+	for sequence := range order.pending {
+		sequences = append(sequences, sequence)
+	}
+	sort.Slice(sequences, func(i, j int) bool {
+		return sequences[i] < sequences[j]
+	})
+	for _, sequence := range sequences {
+		r.release(order.pending[sequence])
+		delete(order.pending, sequence)
+	}
+
+	order.epoch = epoch
+	order.next = 1
+}
+
+// skipGap skips the missing events of the repository if the repository
+// is still waiting for the expected event of the epoch once the gap
+// timeout expired.
+func (r *Reorderer) skipGap(repository string, epoch, expected uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	order, ok := r.repositories[repository]
+	if r.stopped || !ok || order.epoch != epoch {
+		// the timer of a previous epoch was stopped by restart
+		return
+	}
+	order.timer = nil
+
+	if order.next != expected || len(order.pending) == 0 {
+		// the gap was filled in the meantime, wait for the next one
+		r.releasePending(repository, order)
+		return
+	}
+
+	// resume at the oldest pending event
+	order.next = 0
+	for sequence := range order.pending {
+		if order.next == 0 || sequence < order.next {
+			order.next = sequence
+		}
+	}
+
+	r.releasePending(repository, order)
+}
+
+// Stop stops the reorderer, pending events are discarded
+// and pushed events are ignored.
+func (r *Reorderer) Stop() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.stopped = true
+
+	for _, order := range r.repositories {
+		if order.timer != nil {
+			order.timer.Stop()
+		}
+	}
+	r.repositories = nil
+}
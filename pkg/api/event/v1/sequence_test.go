@@ -0,0 +1,153 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package eventv1
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSequenceGenerator(t *testing.T) {
+	generator := NewSequenceGenerator()
+
+	put := &EventPayload{Repository: "artifacts/static/files", Action: Action_ACTION_PUT}
+	generator.Stamp(put)
+	require.EqualValues(t, 1, put.GetSequence())
+
+	require.EqualValues(t, 2, generator.Next("artifacts/static/files"))
+	require.EqualValues(t, 1, generator.Next("artifacts/yum/rocky"))
+	require.Equal(t, generator.Epoch(), put.GetEpoch())
+
+	// a restarted producer has a greater epoch
+	require.Greater(t, NewSequenceGenerator().Epoch(), generator.Epoch())
+}
+
+// releasedEvents collects the events released by a reorderer.
+type releasedEvents struct {
+	mu     sync.Mutex
+	events []*EventPayload
+}
+
+func (r *releasedEvents) release(event *EventPayload) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.events = append(r.events, event)
+}
+
+func (r *releasedEvents) actions() []Action {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	actions := make([]Action, 0, len(r.events))
+	for _, event := range r.events {
+		actions = append(actions, event.GetAction())
+	}
+	return actions
+}
+
+// sequences returns the released events as pairs of the index of the
+// generator which stamped them and their sequence.
+func (r *releasedEvents) sequences(generators ...*SequenceGenerator) [][2]uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	sequences := make([][2]uint64, 0, len(r.events))
+	for _, event := range r.events {
+		for i, generator := range generators {
+			if generator.Epoch() == event.GetEpoch() {
+				sequences = append(sequences, [2]uint64{uint64(i), event.GetSequence()})
+			}
+		}
+	}
+	return sequences
+}
+
+func TestReorderer(t *testing.T) {
+	const repository = "artifacts/static/files"
+
+	t.Run("swapped events", func(t *testing.T) {
+		released := new(releasedEvents)
+
+		reorderer := NewReorderer(time.Hour, released.release)
+		defer reorderer.Stop()
+
+		reorderer.Push(&EventPayload{Repository: repository, Action: Action_ACTION_DELETE, Sequence: 2})
+		require.Empty(t, released.actions())
+
+		reorderer.Push(&EventPayload{Repository: repository, Action: Action_ACTION_PUT, Sequence: 1})
+		require.Equal(t, []Action{Action_ACTION_PUT, Action_ACTION_DELETE}, released.actions())
+
+		// late events are dropped
+		reorderer.Push(&EventPayload{Repository: repository, Action: Action_ACTION_PUT, Sequence: 1})
+		require.Len(t, released.actions(), 2)
+	})
+
+	t.Run("unsequenced events", func(t *testing.T) {
+		released := new(releasedEvents)
+
+		reorderer := NewReorderer(time.Hour, released.release)
+		defer reorderer.Stop()
+
+		reorderer.Push(&EventPayload{Repository: repository, Action: Action_ACTION_START})
+		require.Equal(t, []Action{Action_ACTION_START}, released.actions())
+	})
+
+	t.Run("gap timeout", func(t *testing.T) {
+		released := new(releasedEvents)
+
+		reorderer := NewReorderer(10*time.Millisecond, released.release)
+		defer reorderer.Stop()
+
+		reorderer.Push(&EventPayload{Repository: repository, Action: Action_ACTION_DELETE, Sequence: 3})
+		reorderer.Push(&EventPayload{Repository: repository, Action: Action_ACTION_UPDATE, Sequence: 2})
+		require.Empty(t, released.actions())
+
+		require.Eventually(t, func() bool {
+			return len(released.actions()) == 2
+		}, time.Second, time.Millisecond)
+		require.Equal(t, []Action{Action_ACTION_UPDATE, Action_ACTION_DELETE}, released.actions())
+
+		// the skipped event is dropped if it shows up later
+		reorderer.Push(&EventPayload{Repository: repository, Action: Action_ACTION_PUT, Sequence: 1})
+		require.Len(t, released.actions(), 2)
+	})
+	t.Run("producer restart", func(t *testing.T) {
+		released := new(releasedEvents)
+
+		reorderer := NewReorderer(time.Hour, released.release)
+		defer reorderer.Stop()
+
+		push := func(generator *SequenceGenerator) *EventPayload {
+			event := &EventPayload{Repository: repository, Action: Action_ACTION_PUT}
+			generator.Stamp(event)
+			return event
+		}
+
+		before := NewSequenceGenerator()
+		events := []*EventPayload{push(before), push(before), push(before)}
+		reorderer.Push(events[0])
+		// the second event is delayed past the restart
+		reorderer.Push(events[2])
+		require.Equal(t, [][2]uint64{{0, 1}}, released.sequences(before))
+
+		// the sequences of the restarted producer start at 1 again, the
+		// pending event of the previous epoch is released first
+		after := NewSequenceGenerator()
+		reorderer.Push(push(after))
+		reorderer.Push(push(after))
+		require.Equal(t, [][2]uint64{{0, 1}, {0, 3}, {1, 1}, {1, 2}}, released.sequences(before, after))
+
+		// the delayed event of the previous epoch is not dropped
+		reorderer.Push(events[1])
+		require.Equal(t, [][2]uint64{{0, 1}, {0, 3}, {1, 1}, {1, 2}, {0, 2}}, released.sequences(before, after))
+
+		// duplicates of the current epoch are still dropped
+		reorderer.Push(&EventPayload{Repository: repository, Action: Action_ACTION_PUT, Sequence: 1, Epoch: after.Epoch()})
+		require.Len(t, released.actions(), 5)
+	})
+}